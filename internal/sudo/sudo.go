@@ -0,0 +1,40 @@
+// Package sudo finds the user who started the process with sudo, so that commands reaching into the user
+// session, e.g. the clipboard, the keyring or desktop notifications, are run as them instead of root.
+package sudo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ErrNoSudo is returned when the process was not started with sudo.
+var ErrNoSudo = errors.New("SUDO_UID and SUDO_GID are not set")
+
+// User returns the user who started the process with sudo.
+func User() (uid, gid uint32, err error) {
+	uidEnv, gidEnv := os.Getenv("SUDO_UID"), os.Getenv("SUDO_GID")
+	if uidEnv == "" || gidEnv == "" {
+		return 0, 0, ErrNoSudo
+	}
+	u, err := strconv.ParseUint(uidEnv, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse SUDO_UID: %w", err)
+	}
+	g, err := strconv.ParseUint(gidEnv, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse SUDO_GID: %w", err)
+	}
+
+	return uint32(u), uint32(g), nil
+}
+
+// SessionBusEnv returns DBUS_SESSION_BUS_ADDRESS of the session of user uid, the address kept by sudo -E is preferred.
+func SessionBusEnv(uid uint32) string {
+	if addr := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); addr != "" {
+		return "DBUS_SESSION_BUS_ADDRESS=" + addr
+	}
+
+	return fmt.Sprintf("DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/%d/bus", uid)
+}
@@ -0,0 +1,29 @@
+package sudo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUser(t *testing.T) {
+	t.Setenv("SUDO_UID", "1000")
+	t.Setenv("SUDO_GID", "1001")
+	uid, gid, err := User()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1000), uid)
+	require.Equal(t, uint32(1001), gid)
+
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+	require.Equal(t, "DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus", SessionBusEnv(uid))
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/tmp/bus")
+	require.Equal(t, "DBUS_SESSION_BUS_ADDRESS=unix:path=/tmp/bus", SessionBusEnv(uid))
+
+	t.Setenv("SUDO_GID", "root")
+	_, _, err = User()
+	require.ErrorContains(t, err, "SUDO_GID")
+
+	t.Setenv("SUDO_UID", "")
+	_, _, err = User()
+	require.ErrorIs(t, err, ErrNoSudo)
+}
@@ -0,0 +1,29 @@
+//go:build darwin || linux
+
+package sudo
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// AsUser makes cmd run as the user who started the process with sudo, with HOME and USER of the user
+// and the rest of the environment of the process. It returns uid of the user, or ErrNoSudo.
+func AsUser(cmd *exec.Cmd) (uint32, error) {
+	uid, gid, err := User()
+	if err != nil {
+		return 0, err
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uid, Gid: gid}}
+	cmd.Env = os.Environ()
+	if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+		// X11 clients find ~/.Xauthority of the user by HOME, sudo keeps DISPLAY.
+		cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username)
+	}
+
+	return uid, nil
+}
@@ -0,0 +1,26 @@
+//go:build darwin || linux
+
+package sudo
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsUser(t *testing.T) {
+	t.Setenv("SUDO_UID", "")
+	cmd := exec.Command("true")
+	_, err := AsUser(cmd)
+	require.ErrorIs(t, err, ErrNoSudo)
+	require.Nil(t, cmd.SysProcAttr, "the command is left as it is")
+
+	t.Setenv("SUDO_UID", "0")
+	t.Setenv("SUDO_GID", "0")
+	uid, err := AsUser(cmd)
+	require.NoError(t, err)
+	require.Zero(t, uid)
+	require.Equal(t, uint32(0), cmd.SysProcAttr.Credential.Gid)
+	require.Contains(t, cmd.Env, "USER=root")
+}
@@ -0,0 +1,216 @@
+/*
+Package alert evaluates alert rules against client events and metrics and delivers fired alerts to notifiers,
+so that problems surface without watching the logs.
+*/
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// checkInterval is how often metric based rules are evaluated.
+const checkInterval = 10 * time.Second
+
+// Rule names used in Alert.
+const (
+	RuleLowThroughput = "low_throughput"
+	RuleReconnects    = "reconnects"
+	RuleQuota         = "quota"
+)
+
+// quotaThreshold is the share of Rules.Quota used at which RuleQuota fires.
+const quotaThreshold = 0.9
+
+// Rules configures when alerts fire, zero value of a rule disables it.
+type Rules struct {
+	// MinThroughput fires when the average TUN traffic in both directions stays below MinThroughput
+	// bytes per second for ThroughputWindow while connected.
+	MinThroughput    float64
+	ThroughputWindow time.Duration
+	// MaxConnectsPerHour fires when the client connects more often than this within an hour.
+	MaxConnectsPerHour int
+	// Quota fires when 90% of Quota bytes of TUN traffic in both directions are used within QuotaPeriod,
+	// the usage starts over every QuotaPeriod. Zero QuotaPeriod never starts over.
+	Quota       int
+	QuotaPeriod time.Duration
+}
+
+// Alert is a fired rule.
+type Alert struct {
+	Rule    string    `json:"rule"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier delivers alerts.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Watcher evaluates Rules. It must receive client events as observe.Observer and be started with Run.
+//
+// Each rule fires once and is rearmed after the condition clears.
+type Watcher struct {
+	rules     Rules
+	notifiers []Notifier
+	logger    *slog.Logger
+
+	mu        sync.Mutex
+	connected bool
+	connects  []time.Time // Connect times within the last hour.
+	firing    map[string]bool
+	samples   []sample
+	quota     sample // Traffic total at the start of the quota period.
+}
+
+// sample is the total of TUN traffic at a point in time.
+type sample struct {
+	at    time.Time
+	bytes int
+}
+
+// NewWatcher creates Watcher delivering alerts to notifiers, failures to deliver are logged with logger.
+func NewWatcher(rules Rules, logger *slog.Logger, notifiers ...Notifier) *Watcher {
+	return &Watcher{rules: rules, notifiers: notifiers, logger: logger, firing: make(map[string]bool)}
+}
+
+// Observe implements observe.Observer.
+func (w *Watcher) Observe(e observe.Event) {
+	switch e.Type {
+	case observe.EventConnected:
+		w.mu.Lock()
+		w.connected = true
+		w.samples = nil
+		w.connects = append(w.connects, e.Time)
+		a, ok := w.checkConnects(e.Time)
+		w.mu.Unlock()
+		if ok {
+			go w.notify(a) // Observe must not block.
+		}
+	case observe.EventDisconnected:
+		w.mu.Lock()
+		w.connected = false
+		w.samples = nil
+		w.mu.Unlock()
+	}
+}
+
+// Run evaluates metric based rules on stats of src till ctx is done.
+func (w *Watcher) Run(ctx context.Context, src observe.StatsSource) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stats := src.Stats()
+			w.mu.Lock()
+			var alerts []Alert
+			if a, ok := w.checkThroughput(now, stats.BytesRead+stats.BytesWritten); ok {
+				alerts = append(alerts, a)
+			}
+			if a, ok := w.checkQuota(now, stats.BytesRead+stats.BytesWritten); ok {
+				alerts = append(alerts, a)
+			}
+			w.mu.Unlock()
+			for _, a := range alerts {
+				w.notify(a)
+			}
+		}
+	}
+}
+
+// checkConnects evaluates RuleReconnects, w.mu must be held.
+func (w *Watcher) checkConnects(now time.Time) (Alert, bool) {
+	if w.rules.MaxConnectsPerHour <= 0 {
+		return Alert{}, false
+	}
+
+	recent := w.connects[:0]
+	for _, t := range w.connects {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	w.connects = recent
+
+	return w.fire(RuleReconnects, len(recent) > w.rules.MaxConnectsPerHour, now,
+		fmt.Sprintf("connected %d times within the last hour", len(recent)))
+}
+
+// checkThroughput records new traffic sample and evaluates RuleLowThroughput, w.mu must be held.
+func (w *Watcher) checkThroughput(now time.Time, total int) (Alert, bool) {
+	if w.rules.MinThroughput <= 0 || w.rules.ThroughputWindow <= 0 || !w.connected {
+		return Alert{}, false
+	}
+
+	w.samples = append(w.samples, sample{at: now, bytes: total})
+	// Keep a single sample older than the window to measure the whole window.
+	for len(w.samples) > 1 && now.Sub(w.samples[1].at) >= w.rules.ThroughputWindow {
+		w.samples = w.samples[1:]
+	}
+	first := w.samples[0]
+	elapsed := now.Sub(first.at)
+	if elapsed < w.rules.ThroughputWindow {
+		return Alert{}, false
+	}
+
+	rate := float64(total-first.bytes) / elapsed.Seconds()
+
+	return w.fire(RuleLowThroughput, rate < w.rules.MinThroughput, now,
+		fmt.Sprintf("throughput %.0f B/s stayed below %.0f B/s for %s", rate, w.rules.MinThroughput, w.rules.ThroughputWindow))
+}
+
+// checkQuota evaluates RuleQuota against the traffic total, w.mu must be held.
+func (w *Watcher) checkQuota(now time.Time, total int) (Alert, bool) {
+	if w.rules.Quota <= 0 {
+		return Alert{}, false
+	}
+
+	switch {
+	case w.quota.at.IsZero():
+		w.quota = sample{at: now} // The first period counts the traffic since the client was created.
+	case w.rules.QuotaPeriod > 0 && now.Sub(w.quota.at) >= w.rules.QuotaPeriod:
+		w.quota = sample{at: now, bytes: total}
+	}
+	used := total - w.quota.bytes
+
+	return w.fire(RuleQuota, float64(used) >= quotaThreshold*float64(w.rules.Quota), now,
+		fmt.Sprintf("%d of %d bytes quota used", used, w.rules.Quota))
+}
+
+// fire returns the alert of rule when the condition becomes true, and rearms the rule when it clears.
+func (w *Watcher) fire(rule string, condition bool, now time.Time, msg string) (Alert, bool) {
+	if !condition {
+		w.firing[rule] = false
+
+		return Alert{}, false
+	}
+	if w.firing[rule] {
+		return Alert{}, false
+	}
+	w.firing[rule] = true
+
+	return Alert{Rule: rule, Message: msg, Time: now}, true
+}
+
+// notify delivers a to every notifier.
+func (w *Watcher) notify(a Alert) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkInterval)
+	defer cancel()
+
+	w.logger.Warn("alert fired", "rule", a.Rule, "message", a.Message)
+	for _, n := range w.notifiers {
+		if err := n.Notify(ctx, a); err != nil {
+			w.logger.Warn("alert delivery failed", "rule", a.Rule, "err", err)
+		}
+	}
+}
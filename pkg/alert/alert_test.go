@@ -0,0 +1,100 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestWatcher_Throughput(t *testing.T) {
+	w := NewWatcher(Rules{MinThroughput: 100, ThroughputWindow: time.Minute}, slog.Default())
+	start := time.Now()
+
+	// Disconnected client is not checked.
+	_, ok := w.checkThroughput(start, 0)
+	require.False(t, ok)
+
+	w.Observe(observe.NewEvent(observe.EventConnected))
+	_, ok = w.checkThroughput(start, 0)
+	require.False(t, ok)
+	_, ok = w.checkThroughput(start.Add(30*time.Second), 1000)
+	require.False(t, ok, "window is not complete yet")
+
+	a, ok := w.checkThroughput(start.Add(time.Minute), 1000)
+	require.True(t, ok)
+	require.Equal(t, RuleLowThroughput, a.Rule)
+
+	_, ok = w.checkThroughput(start.Add(70*time.Second), 1000)
+	require.False(t, ok, "fired rule stays quiet")
+
+	_, ok = w.checkThroughput(start.Add(100*time.Second), 100000)
+	require.False(t, ok, "condition cleared")
+	_, ok = w.checkThroughput(start.Add(200*time.Second), 100000)
+	require.True(t, ok, "rule fires again after being rearmed")
+}
+
+func TestWatcher_Connects(t *testing.T) {
+	w := NewWatcher(Rules{MaxConnectsPerHour: 2}, slog.Default())
+	start := time.Now()
+
+	for i := range 2 {
+		w.connects = append(w.connects, start.Add(time.Duration(i)*time.Minute))
+		_, ok := w.checkConnects(start.Add(time.Duration(i) * time.Minute))
+		require.False(t, ok)
+	}
+
+	w.connects = append(w.connects, start.Add(2*time.Minute))
+	a, ok := w.checkConnects(start.Add(2 * time.Minute))
+	require.True(t, ok)
+	require.Equal(t, "connected 3 times within the last hour", a.Message)
+
+	// Connects older than an hour are forgotten.
+	w.connects = append(w.connects, start.Add(90*time.Minute))
+	_, ok = w.checkConnects(start.Add(90 * time.Minute))
+	require.False(t, ok)
+	require.Len(t, w.connects, 1)
+}
+
+func TestWatcher_Quota(t *testing.T) {
+	w := NewWatcher(Rules{Quota: 1000, QuotaPeriod: time.Hour}, slog.Default())
+	start := time.Now()
+
+	_, ok := w.checkQuota(start, 500)
+	require.False(t, ok)
+	a, ok := w.checkQuota(start.Add(time.Minute), 900)
+	require.True(t, ok)
+	require.Equal(t, RuleQuota, a.Rule)
+	require.Equal(t, "900 of 1000 bytes quota used", a.Message)
+	_, ok = w.checkQuota(start.Add(2*time.Minute), 950)
+	require.False(t, ok, "fired rule stays quiet")
+
+	// The usage starts over with the new period.
+	_, ok = w.checkQuota(start.Add(time.Hour), 1000)
+	require.False(t, ok)
+	_, ok = w.checkQuota(start.Add(time.Hour+time.Minute), 1899)
+	require.False(t, ok)
+	_, ok = w.checkQuota(start.Add(time.Hour+2*time.Minute), 1900)
+	require.True(t, ok, "rule fires again after being rearmed")
+}
+
+func TestWebhook(t *testing.T) {
+	got := make(chan Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var a Alert
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&a))
+		got <- a
+	}))
+	defer srv.Close()
+
+	a := Alert{Rule: RuleReconnects, Message: "test", Time: time.Unix(1, 0).UTC()}
+	require.NoError(t, Webhook{URL: srv.URL}.Notify(context.Background(), a))
+	require.Equal(t, a, <-got)
+}
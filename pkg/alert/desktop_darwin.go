@@ -0,0 +1,14 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+func desktopCommand(ctx context.Context, title, msg string) (*exec.Cmd, error) {
+	script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(msg), strconv.Quote(title))
+
+	return exec.CommandContext(ctx, "osascript", "-e", script), nil
+}
@@ -0,0 +1,25 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/goxray/tun/internal/sudo"
+)
+
+func desktopCommand(ctx context.Context, title, msg string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, "notify-send", title, msg)
+	if os.Geteuid() != 0 {
+		return cmd, nil
+	}
+
+	uid, err := sudo.AsUser(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("no user session to notify: %w", err)
+	}
+	cmd.Env = append(cmd.Env, sudo.SessionBusEnv(uid))
+
+	return cmd, nil
+}
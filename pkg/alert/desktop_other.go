@@ -0,0 +1,14 @@
+//go:build !darwin && !linux
+
+package alert
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func desktopCommand(context.Context, string, string) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+}
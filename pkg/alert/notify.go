@@ -0,0 +1,75 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// Webhook posts alerts as JSON to URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client // Default: http.DefaultClient.
+}
+
+func (n Webhook) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := n.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("post alert: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Desktop shows alerts as desktop notifications, with notify-send (D-Bus) on Linux and osascript on macOS.
+//
+// On Linux the client usually runs as root which has no session bus, the notification is then sent
+// to the session of the user who started it with sudo.
+type Desktop struct{}
+
+func (Desktop) Notify(ctx context.Context, a Alert) error {
+	cmd, err := desktopCommand(ctx, "goxray-tun", a.Message)
+	if err != nil {
+		return err
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Path, err, out)
+	}
+
+	return nil
+}
+
+// Events passes alerts to Observer as observe.EventAlert.
+type Events struct {
+	Observer observe.Observer
+}
+
+func (n Events) Notify(_ context.Context, a Alert) error {
+	n.Observer.Observe(observe.NewEvent(observe.EventAlert, "rule", a.Rule, "message", a.Message))
+
+	return nil
+}
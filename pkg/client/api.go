@@ -0,0 +1,361 @@
+package client
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+const (
+	// minAPITokenLength keeps tokens of the management API from being guessed.
+	minAPITokenLength = 16
+	// maxAPIBody bounds bodies of management API requests, they carry a link or a route.
+	maxAPIBody = 64 << 10
+	// apiStreamInterval is the period of throughput updates of the management API event stream.
+	apiStreamInterval = time.Second
+	// apiStreamBuffer is the number of client events kept for a slow event stream before they are dropped.
+	apiStreamBuffer = 64
+)
+
+// dashboardHTML is the page of the management API showing the state of the Client, see ServeAPI.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// validateAPI checks that the management API is protected by a token, see Config.APIAddr.
+func validateAPI(cfg Config) error {
+	if cfg.APIAddr == "" {
+		return nil
+	}
+	if len(cfg.APIToken) < minAPITokenLength {
+		return fmt.Errorf("management API requires a token of at least %d characters", minAPITokenLength)
+	}
+	if _, _, err := net.SplitHostPort(cfg.APIAddr); err != nil {
+		return fmt.Errorf("management API address: %w", err)
+	}
+
+	return nil
+}
+
+// ServeAPI serves the management API on Config.APIAddr till ctx is done, e.g. for web dashboards and
+// remote management of headless gateways. Requests must carry Config.APIToken as "Authorization: Bearer" header,
+// /events also accepts it as "access_token" query parameter since browsers can't set headers of EventSource.
+// The API is plain HTTP, bind it to loopback or a trusted network, or put it behind a TLS terminating proxy.
+//
+//	GET    /status      - Status as JSON.
+//	GET    /stats       - observe.Stats as JSON.
+//	GET    /connections - list of observe.FlowInfo of open connections as JSON.
+//	POST   /connect     - connects to the link of JSON body {"link": "vless://..."}, see Connect.
+//	POST   /disconnect  - disconnects, see Disconnect.
+//	POST   /reconnect   - connects again to the current server, see Reconnect.
+//	GET    /routes      - list of routes pointed to the TUN device as JSON, see Routes.
+//	POST   /routes      - points the route of JSON body {"cidr": "10.0.0.0/8"} to the TUN device, see AddRoute.
+//	DELETE /routes?cidr=10.0.0.0/8 - stops pointing the route to the TUN device, see RemoveRoute.
+//	GET    /events      - stream of server-sent events, see streamAPIEvents.
+//
+// GET / serves a dashboard page without the token, e.g. a status page for a home gateway. The page asks for
+// the token or takes it from the URL fragment as /#token=..., and gets everything else through the API.
+// Health checks of containers don't need the token either, they get no details of the connection:
+//
+//	GET /healthz - 200 while the process serves requests.
+//	GET /readyz  - 200 if connected and passing traffic, 503 otherwise, see Ready.
+func (c *Client) ServeAPI(ctx context.Context) error {
+	if c.cfg.APIAddr == "" {
+		return errors.New("management API address is not set")
+	}
+	ln, err := net.Listen("tcp", c.cfg.APIAddr)
+	if err != nil {
+		return fmt.Errorf("listen management API: %w", err)
+	}
+	c.cfg.Logger.Info("management API listening", "addr", ln.Addr())
+
+	srv := &http.Server{Handler: c.apiHandler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if err = srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve management API: %w", err)
+	}
+
+	return nil
+}
+
+// apiHandler returns the handler of the management API, see ServeAPI.
+func (c *Client) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		writeAPIJSON(w, c.Status())
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, _ *http.Request) {
+		writeAPIJSON(w, c.Stats())
+	})
+	mux.HandleFunc("GET /connections", func(w http.ResponseWriter, _ *http.Request) {
+		flows := c.Flows()
+		if flows == nil {
+			flows = []observe.FlowInfo{}
+		}
+		writeAPIJSON(w, flows)
+	})
+	mux.HandleFunc("POST /connect", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Link string `json:"link"`
+		}
+		if !readAPIJSON(w, r, &body) {
+			return
+		}
+		if err := c.ConnectContext(r.Context(), body.Link); err != nil {
+			c.cfg.Logger.Warn("connecting through management API failed", "err", redactErr(err, body.Link))
+			http.Error(w, redactErr(err, body.Link), apiStatus(err))
+
+			return
+		}
+		writeAPIJSON(w, c.Status())
+	})
+	mux.HandleFunc("POST /disconnect", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Disconnect(r.Context()); err != nil {
+			http.Error(w, err.Error(), apiStatus(err))
+
+			return
+		}
+		writeAPIJSON(w, c.Status())
+	})
+	mux.HandleFunc("POST /reconnect", func(w http.ResponseWriter, _ *http.Request) {
+		if err := c.Reconnect(); err != nil {
+			http.Error(w, err.Error(), apiStatus(err))
+
+			return
+		}
+		writeAPIJSON(w, c.Status())
+	})
+	mux.HandleFunc("GET /routes", func(w http.ResponseWriter, _ *http.Request) {
+		routes := make([]string, 0)
+		for _, r := range c.Routes() {
+			routes = append(routes, r.String())
+		}
+		writeAPIJSON(w, routes)
+	})
+	mux.HandleFunc("POST /routes", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			CIDR string `json:"cidr"`
+		}
+		if !readAPIJSON(w, r, &body) {
+			return
+		}
+		if _, err := parseCIDR(body.CIDR); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		if err := c.AddRoute(body.CIDR); err != nil {
+			http.Error(w, err.Error(), apiStatus(err))
+
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /routes", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := parseCIDR(r.URL.Query().Get("cidr"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		if !slices.ContainsFunc(c.Routes(), addr.equal) {
+			http.Error(w, fmt.Sprintf("route %s is not pointed to TUN", addr), http.StatusNotFound)
+
+			return
+		}
+		if err = c.RemoveRoute(r.URL.Query().Get("cidr")); err != nil {
+			http.Error(w, err.Error(), apiStatus(err))
+
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /events", c.streamAPIEvents)
+
+	root := http.NewServeMux()
+	root.HandleFunc("GET /{$}", serveDashboard)
+	root.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok\n")
+	})
+	root.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch err := c.Ready(r.Context()); {
+		case err == nil:
+			_, _ = io.WriteString(w, "ok\n")
+		case errors.Is(err, ErrNotConnected):
+			http.Error(w, "not connected", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, errNotPassingTraffic.Error(), http.StatusServiceUnavailable)
+		}
+	})
+	root.Handle("/", c.apiAuth(mux))
+
+	return root
+}
+
+// serveDashboard serves dashboardHTML, the page carries no data of the Client.
+func serveDashboard(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy",
+		"default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	_, _ = w.Write(dashboardHTML)
+}
+
+// apiEvent is observe.Event as sent by the management API event stream.
+type apiEvent struct {
+	Type  observe.EventType `json:"type"`
+	Time  time.Time         `json:"time"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// apiThroughput is the traffic sent by the management API event stream every apiStreamInterval.
+type apiThroughput struct {
+	Time         time.Time `json:"time"`
+	BytesRead    int       `json:"bytes_read"`
+	BytesWritten int       `json:"bytes_written"`
+	ReadRate     float64   `json:"read_rate"`  // Bytes per second read from TUN device.
+	WriteRate    float64   `json:"write_rate"` // Bytes per second written to TUN device.
+}
+
+// streamAPIEvents pushes server-sent events till the request is done, so that dashboards don't have to poll:
+//
+//	state      - Status, sent first and on every change of the state or the server.
+//	throughput - apiThroughput, sent every apiStreamInterval.
+//	event      - observe.Event emitted by the client, e.g. reconnects and failed flows.
+//	log        - observe.LogRecord, sent only if the handler of Config.Logger is observe.LogRing.
+func (c *Client) streamAPIEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	events, unsubscribe := c.events.Subscribe(apiStreamBuffer)
+	defer unsubscribe()
+	ring, _ := c.cfg.Logger.Handler().(*observe.LogRing)
+	var logSeq uint64
+	if ring != nil {
+		_, logSeq = ring.Since(math.MaxUint64) // Only records logged from now on.
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	send := func(event string, v any) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return false
+		}
+
+		return rc.Flush() == nil
+	}
+
+	status := c.Status()
+	if !send("state", status) {
+		return
+	}
+	last := apiThroughput{Time: time.Now(), BytesRead: c.BytesRead(), BytesWritten: c.BytesWritten()}
+	ticker := time.NewTicker(apiStreamInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			ev := apiEvent{Type: e.Type, Time: e.Time, Attrs: make(map[string]string, len(e.Attrs))}
+			for k, v := range e.Attrs {
+				ev.Attrs[k] = fmt.Sprint(v)
+			}
+			if !send("event", ev) {
+				return
+			}
+		case now := <-ticker.C:
+			next := apiThroughput{Time: now, BytesRead: c.BytesRead(), BytesWritten: c.BytesWritten()}
+			if elapsed := now.Sub(last.Time).Seconds(); elapsed > 0 {
+				// Counters don't go back, but clamp anyway so that a dashboard never shows negative rates.
+				next.ReadRate = float64(max(next.BytesRead-last.BytesRead, 0)) / elapsed
+				next.WriteRate = float64(max(next.BytesWritten-last.BytesWritten, 0)) / elapsed
+			}
+			last = next
+			if !send("throughput", next) {
+				return
+			}
+			if s := c.Status(); s.State != status.State || s.Server != status.Server {
+				status = s
+				if !send("state", s) {
+					return
+				}
+			}
+			if ring == nil {
+				continue
+			}
+			var records []observe.LogRecord
+			records, logSeq = ring.Since(logSeq)
+			for _, rec := range records {
+				if !send("log", rec) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// apiAuth passes requests carrying Config.APIToken to next, others are refused.
+func (c *Client) apiAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok && r.Method == http.MethodGet && r.URL.Path == "/events" {
+			token, ok = r.URL.Query().Get("access_token"), r.URL.Query().Has("access_token")
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(c.cfg.APIToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiStatus returns the status code of the management API reply to err.
+func apiStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrAlreadyConnected), errors.Is(err, ErrNotConnected):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidLink):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrServerUnresolvable):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// readAPIJSON decodes JSON body of r into v, it replies with an error if the body is invalid.
+func readAPIJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody)).Decode(v); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+
+		return false
+	}
+
+	return true
+}
+
+func writeAPIJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
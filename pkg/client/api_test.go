@@ -0,0 +1,181 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/goxray/tun/pkg/client/mocks"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestValidateAPI(t *testing.T) {
+	require.NoError(t, validateAPI(Config{}))
+	require.NoError(t, validateAPI(Config{APIAddr: "127.0.0.1:8089", APIToken: "0123456789abcdef"}))
+	require.ErrorContains(t, validateAPI(Config{APIAddr: "127.0.0.1:8089"}), "token")
+	require.ErrorContains(t, validateAPI(Config{APIAddr: "127.0.0.1:8089", APIToken: "short"}), "token")
+	require.ErrorContains(t, validateAPI(Config{APIAddr: "8089", APIToken: "0123456789abcdef"}), "address")
+}
+
+func TestAPI(t *testing.T) {
+	const token = "0123456789abcdef"
+	cl := &Client{cfg: Config{
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		RoutesToTUN: DefaultRoutesToTUN,
+		APIToken:    token,
+	}, router: newRouter(mocks.NewMockipTable(gomock.NewController(t)), net.IPv4(192, 168, 1, 1))}
+	srv := httptest.NewServer(cl.apiHandler())
+	defer srv.Close()
+
+	do := func(method, path, body, auth string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(b)
+	}
+
+	// Requests without the token are refused.
+	code, _ := do(http.MethodGet, "/status", "", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+	code, _ = do(http.MethodGet, "/status", "", "wrong-token-0123")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	code, body := do(http.MethodGet, "/status", "", token)
+	require.Equal(t, http.StatusOK, code)
+	var status Status
+	require.NoError(t, json.Unmarshal([]byte(body), &status))
+	require.Equal(t, StateDisconnected, status.State)
+
+	code, body = do(http.MethodGet, "/connections", "", token)
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, "[]", body)
+
+	// Routes are changed in the config while disconnected.
+	code, _ = do(http.MethodPost, "/routes", `{"cidr": "10.0.0.0/8"}`, token)
+	require.Equal(t, http.StatusNoContent, code)
+	code, body = do(http.MethodGet, "/routes", "", token)
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `["0.0.0.0/1", "128.0.0.0/1", "10.0.0.0/8"]`, body)
+	code, _ = do(http.MethodPost, "/routes", `{"cidr": "10.0.0.0"}`, token)
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = do(http.MethodDelete, "/routes?cidr=10.0.0.0/8", "", token)
+	require.Equal(t, http.StatusNoContent, code)
+	code, _ = do(http.MethodDelete, "/routes?cidr=10.0.0.0/8", "", token)
+	require.Equal(t, http.StatusNotFound, code)
+
+	code, _ = do(http.MethodPost, "/connect", `{"link": `, token)
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = do(http.MethodPost, "/disconnect", "", token)
+	require.Equal(t, http.StatusOK, code, "disconnecting a client which is not connected does nothing")
+	code, _ = do(http.MethodPost, "/reconnect", "", token)
+	require.Equal(t, http.StatusConflict, code)
+
+	// The dashboard is served without the token, it carries no data.
+	code, body = do(http.MethodGet, "/", "", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "<title>goxray</title>")
+	code, _ = do(http.MethodGet, "/missing", "", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	// Health checks don't need the token.
+	code, _ = do(http.MethodGet, "/healthz", "", "")
+	require.Equal(t, http.StatusOK, code)
+	code, body = do(http.MethodGet, "/readyz", "", "")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "not connected\n", body)
+	cl.connectedAt = time.Now()
+	cl.ready.at = time.Now() // The result of the probe is reused.
+	code, _ = do(http.MethodGet, "/readyz", "", "")
+	require.Equal(t, http.StatusOK, code)
+	cl.ready.err = fmt.Errorf("%w: probe.example: timeout", errNotPassingTraffic)
+	code, body = do(http.MethodGet, "/readyz", "", "")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.NotContains(t, body, "probe.example", "details are not served without the token")
+	cl.connectedAt = time.Time{}
+
+	cl.stopTunnel = func() {}
+	code, body = do(http.MethodPost, "/connect", `{"link": "vless://secret@127.0.0.4:443"}`, token)
+	require.Equal(t, http.StatusConflict, code)
+	require.NotContains(t, body, "secret")
+}
+
+func TestAPIEvents(t *testing.T) {
+	const token = "0123456789abcdef"
+	cl := &Client{cfg: Config{
+		Logger:   slog.New(observe.NewLogRing(slog.NewTextHandler(io.Discard, nil), 10)),
+		APIToken: token,
+	}}
+	cl.cfg.Logger.Info("logged before the stream")
+	srv := httptest.NewServer(cl.apiHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events?access_token=" + token)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	// next returns the data of the following event of type typ, skipping the others.
+	next := func(typ string) string {
+		t.Helper()
+		for lines.Scan() {
+			if lines.Text() != "event: "+typ {
+				continue
+			}
+			require.True(t, lines.Scan())
+			data, ok := strings.CutPrefix(lines.Text(), "data: ")
+			require.True(t, ok)
+
+			return data
+		}
+		require.NoError(t, lines.Err())
+		require.FailNow(t, "stream ended")
+
+		return ""
+	}
+
+	var status Status
+	require.NoError(t, json.Unmarshal([]byte(next("state")), &status))
+	require.Equal(t, StateDisconnected, status.State)
+
+	cl.emit(observe.EventAlert, "rule", "high_rtt", "value", 250)
+	var ev apiEvent
+	require.NoError(t, json.Unmarshal([]byte(next("event")), &ev))
+	require.Equal(t, observe.EventAlert, ev.Type)
+	require.Equal(t, map[string]string{"rule": "high_rtt", "value": "250"}, ev.Attrs)
+
+	cl.cfg.Logger.Info("logged while streaming")
+	var tp apiThroughput
+	require.NoError(t, json.Unmarshal([]byte(next("throughput")), &tp))
+	require.Zero(t, tp.ReadRate)
+	var rec observe.LogRecord
+	require.NoError(t, json.Unmarshal([]byte(next("log")), &rec))
+	require.Equal(t, "logged while streaming", rec.Message, "records logged before the stream are not sent")
+
+	// The token is accepted as query parameter only by the stream.
+	resp, err = http.Get(srv.URL + "/status?access_token=" + token)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
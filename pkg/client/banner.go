@@ -0,0 +1,66 @@
+package client
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// logSettings logs the effective settings of the established connection as a single record,
+// so that the whole setup can be seen from one log line.
+func (c *Client) logSettings(server net.IP) {
+	tun := []any{slog.String("name", c.tunName), slog.Int("mtu", c.mtu)}
+	tunRoutes := c.Routes()
+	routes := make([]string, 0, len(tunRoutes)+1)
+	if c.externalTUN {
+		tun = append(tun, slog.Bool("external", true))
+	} else {
+		tun = append(tun, slog.String("address", c.cfg.TUNAddress.String()))
+		if c.cfg.PolicyRouting {
+			tun = append(tun, slog.Int("table", policyTable))
+		}
+		for _, r := range tunRoutes {
+			routes = append(routes, r.String()+" via tun")
+		}
+		if r, ok := c.router.ServerRoute(); ok {
+			routes = append(routes, r.Routes[0].String()+" via "+r.Gateway.String())
+		}
+	}
+
+	// Without DNSServers system resolver settings are not changed, queries follow the routes.
+	dns := []string{"system"}
+	if c.dnsSet {
+		dns = dns[:0]
+		for _, ip := range c.cfg.DNSServers {
+			dns = append(dns, ip.String())
+		}
+	}
+
+	if s := c.cfg.Shaping; s.enabled() {
+		tun = append(tun, slog.Group("shaping",
+			slog.Duration("latency", s.Latency), slog.Duration("jitter", s.Jitter), slog.Int("bandwidth", s.Bandwidth)))
+	}
+
+	if ch := c.cfg.Chaos; ch.enabled() {
+		c.cfg.Logger.Warn("chaos mode injects faults into the tunnel", "dial_failure", ch.DialFailure,
+			"packet_loss", ch.PacketLoss, "gateway_change", ch.GatewayChange)
+	}
+
+	inbound := c.cfg.InboundProxy.String()
+	if c.exposesInbound() {
+		allow := make([]string, 0, len(c.cfg.InboundAllow))
+		for _, n := range c.cfg.InboundAllow {
+			allow = append(allow, n.String())
+		}
+		inbound += " allow " + strings.Join(allow, ",")
+	}
+
+	c.cfg.Logger.Info("effective settings",
+		slog.Group("tun", tun...),
+		slog.Any("routes", routes),
+		slog.Any("dns", dns),
+		slog.String("inbound", inbound),
+		slog.String("server", server.String()),
+		slog.String("on_down", c.cfg.OnDownPolicy.String()),
+	)
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSettings(t *testing.T) {
+	var buf bytes.Buffer
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	cl.cfg.TUNAddress = defaultTUNAddress
+	cl.cfg.RoutesToTUN = DefaultRoutesToTUN
+	cl.tunName = "utun5"
+
+	cl.logSettings(net.ParseIP("127.0.0.3"))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	require.Equal(t, "effective settings", record["msg"])
+	require.Equal(t, map[string]any{"name": "utun5", "mtu": float64(defaultMTU), "address": "192.18.0.1/32"}, record["tun"])
+	require.Equal(t, []any{"0.0.0.0/1 via tun", "128.0.0.0/1 via tun", "127.0.0.3/32 via 127.0.0.2"}, record["routes"])
+	require.Equal(t, "127.0.0.1:10234", record["inbound"])
+	require.Equal(t, "restore", record["on_down"])
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultBreakerCooldown is how long new flows are refused once the breaker trips,
+// if Config.BreakerCooldown is not set.
+const defaultBreakerCooldown = 10 * time.Second
+
+// errBreakerOpen is returned for new flows while the server is considered unreachable, see Config.BreakerThreshold.
+var errBreakerOpen = errors.New("server is unreachable, new flows are refused")
+
+// breaker refuses new flows for a cooldown once threshold flows in a row failed to reach the server,
+// so that an unreachable server does not cost a dial per flow. After the cooldown flows are let through
+// again: the first one reaching the server closes the breaker, the next failure trips it right away.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int       // Flows failed in a row.
+	openUntil time.Time // New flows are refused till then.
+	degraded  bool      // The breaker tripped and no flow reached the server since.
+}
+
+// newBreaker returns the breaker tripping after threshold failures, it is disabled if threshold is not positive.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if cooldown == 0 {
+		cooldown = defaultBreakerCooldown
+	}
+
+	return &breaker{threshold: max(threshold, 0), cooldown: cooldown, now: time.Now}
+}
+
+// Allow reports whether a new flow may be dialed.
+func (b *breaker) Allow() bool {
+	if b.threshold == 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.now().Before(b.openUntil)
+}
+
+// Failure records a flow which did not reach the server. It returns true if the breaker tripped
+// while the server was considered reachable.
+func (b *breaker) Failure() bool {
+	if b.threshold == 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures < b.threshold || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.openUntil = b.now().Add(b.cooldown)
+	tripped := !b.degraded
+	b.degraded = true
+
+	return tripped
+}
+
+// Success records a flow which reached the server. It returns true if the breaker was tripped before.
+func (b *breaker) Success() bool {
+	if b.threshold == 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures, b.openUntil = 0, time.Time{}
+	recovered := b.degraded
+	b.degraded = false
+
+	return recovered
+}
+
+// Reset forgets recorded flows, e.g. once the connection is moved to another server.
+func (b *breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures, b.openUntil, b.degraded = 0, time.Time{}, false
+}
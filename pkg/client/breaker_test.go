@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	require.False(t, b.Failure())
+	require.True(t, b.Allow())
+	require.True(t, b.Failure(), "tripped")
+	require.False(t, b.Allow())
+
+	now = now.Add(time.Minute)
+	require.True(t, b.Allow(), "cooldown is over")
+	require.False(t, b.Failure(), "tripped again, already degraded")
+	require.False(t, b.Allow())
+
+	now = now.Add(time.Minute)
+	require.True(t, b.Success(), "recovered")
+	require.False(t, b.Success())
+	require.False(t, b.Failure(), "failures are counted from zero")
+	require.True(t, b.Allow())
+
+	b.Reset()
+	require.False(t, b.Failure())
+
+	disabled := newBreaker(0, 0)
+	for range 10 {
+		require.False(t, disabled.Failure())
+	}
+	require.True(t, disabled.Allow())
+}
+
+// closingDialer returns connections closed by the remote side with no data, like XRay does
+// when the server is unreachable.
+type closingDialer struct{ stubDialer }
+
+func (closingDialer) DialContext(context.Context, *M.Metadata) (net.Conn, error) {
+	local, remote := net.Pipe()
+	_ = remote.Close()
+
+	return local, nil
+}
+
+func TestFlowDialer_Breaker(t *testing.T) {
+	var events []observe.Event
+	observer := observe.ObserverFunc(func(e observe.Event) { events = append(events, e) })
+	p := newFlowPipe(pipeOpts{BreakerThreshold: 2, BreakerCooldown: time.Hour}, observe.NewFlowTable(), observer,
+		slog.New(slog.DiscardHandler))
+	d := &flowDialer{Dialer: closingDialer{}, pipe: p}
+	meta := &M.Metadata{Network: M.TCP, DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: 443}
+
+	for range 2 {
+		c, err := d.DialContext(context.Background(), meta)
+		require.NoError(t, err)
+		_, err = c.Read(make([]byte, 1))
+		require.Error(t, err)
+		require.NoError(t, c.Close())
+	}
+	require.Len(t, events, 1)
+	require.Equal(t, observe.EventDegraded, events[0].Type)
+
+	_, err := d.DialContext(context.Background(), meta)
+	require.ErrorIs(t, err, errBreakerOpen)
+	_, err = d.DialUDP(&M.Metadata{Network: M.UDP, DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: 53})
+	require.ErrorIs(t, err, errBreakerOpen)
+	require.Zero(t, p.flows.Count(observe.TCP))
+
+	p.resetBreaker()
+	d.Dialer = stubDialer{}
+	c, err := d.DialContext(context.Background(), meta)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	_, err = c.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.ErrClosedPipe)
+	require.Len(t, events, 1, "connections closed on this side are not counted")
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/goxray/core/network/route"
+)
+
+// bridgePrefixes are name prefixes of container, VM and mesh VPN interfaces. Their networks are local
+// to the host, so they are kept off the TUN device, see Config.BypassBridges.
+var bridgePrefixes = []string{
+	"docker", "br-", "virbr", "vboxnet", "vmnet", "lxcbr", "lxdbr", "podman", "cni", "bridge", "tailscale",
+}
+
+// tailnetRoute is the range Tailscale assigns node addresses from, its interface carries only a /32 address.
+var tailnetRoute = route.MustParseAddr("100.64.0.0/10")
+
+// link is a network interface with its addresses.
+type link struct {
+	Name  string
+	Addrs []net.Addr
+}
+
+// upLinks returns interfaces of the system which are up.
+func upLinks() ([]link, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var links []link
+	for _, ifc := range ifaces {
+		if ifc.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link{Name: ifc.Name, Addrs: addrs})
+	}
+
+	return links, nil
+}
+
+// bridgeRoutes returns IPv4 networks of bridge interfaces among links by interface name.
+// The TUN device tunName is skipped.
+func bridgeRoutes(links []link, tunName string) map[string][]*route.Addr {
+	routes := make(map[string][]*route.Addr)
+	for _, l := range links {
+		if l.Name == tunName || !slices.ContainsFunc(bridgePrefixes, func(p string) bool {
+			return strings.HasPrefix(l.Name, p)
+		}) {
+			continue
+		}
+		if strings.HasPrefix(l.Name, "tailscale") {
+			routes[l.Name] = append(routes[l.Name], tailnetRoute)
+
+			continue
+		}
+		for _, a := range l.Addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			subnet := &route.Addr{IP: ipNet.IP.To4().Mask(ipNet.Mask), Mask: ipNet.Mask}
+			if ones, _ := subnet.Mask.Size(); ones == 32 {
+				continue // A point to point address has no network behind it.
+			}
+			routes[l.Name] = append(routes[l.Name], subnet)
+		}
+	}
+
+	return routes
+}
+
+// bypassBridges routes networks of Docker, VM and Tailscale interfaces through their interfaces instead of
+// the TUN device. Failures are logged, containers are then left to RoutesToTUN like the rest of the traffic.
+func (c *Client) bypassBridges() {
+	links, err := upLinks()
+	if err != nil {
+		c.cfg.Logger.Warn("listing network interfaces failed, bridge networks are routed to TUN", "err", err)
+
+		return
+	}
+	for ifName, addrs := range bridgeRoutes(links, c.tunName) {
+		if err = c.router.AddLinkRoutes(ifName, addrs); err != nil {
+			c.cfg.Logger.Warn("bridge network bypass failed", "err", err, "interface", ifName, "routes", addrs)
+
+			continue
+		}
+		c.cfg.Logger.Debug("bridge network routed outside of TUN", "interface", ifName, "routes", addrs)
+	}
+}
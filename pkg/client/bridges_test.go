@@ -0,0 +1,37 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeRoutes(t *testing.T) {
+	ipNet := func(s string) *net.IPNet {
+		ip, n, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		n.IP = ip
+
+		return n
+	}
+	links := []link{
+		{Name: "lo", Addrs: []net.Addr{ipNet("127.0.0.1/8")}},
+		{Name: "eth0", Addrs: []net.Addr{ipNet("192.168.1.10/24")}},
+		{Name: "docker0", Addrs: []net.Addr{ipNet("172.17.0.1/16"), ipNet("fe80::1/64")}},
+		{Name: "br-1a2b3c", Addrs: []net.Addr{ipNet("172.18.0.1/16")}},
+		{Name: "virbr0", Addrs: []net.Addr{ipNet("192.168.122.1/24")}},
+		{Name: "tailscale0", Addrs: []net.Addr{ipNet("100.101.102.103/32")}},
+		{Name: "vmnet8", Addrs: []net.Addr{ipNet("10.0.0.1/32")}},
+		{Name: "bridge100", Addrs: []net.Addr{ipNet("192.168.64.1/24")}},
+	}
+
+	routes := bridgeRoutes(links, "bridge100")
+	require.Equal(t, map[string][]*route.Addr{
+		"docker0":    {route.MustParseAddr("172.17.0.0/16")},
+		"br-1a2b3c":  {route.MustParseAddr("172.18.0.0/16")},
+		"virbr0":     {route.MustParseAddr("192.168.122.0/24")},
+		"tailscale0": {route.MustParseAddr("100.64.0.0/10")},
+	}, routes)
+}
@@ -0,0 +1,336 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultCaptureMaxSize is the size a capture stops at if CaptureLimits.MaxSize is not set.
+	defaultCaptureMaxSize = 100 << 20
+	// maxSnapLen is the most bytes of a packet captured, also the default of CaptureLimits.SnapLen.
+	maxSnapLen = 65535
+	// captureQueue is the number of packets waiting to be written, newer ones are dropped when it is full,
+	// so that a slow disk or reader never delays the traffic.
+	captureQueue = 1024
+
+	// pcapHeaderSize and pcapRecordHeaderSize are the sizes of the pcap file and packet record headers.
+	pcapHeaderSize       = 24
+	pcapRecordHeaderSize = 16
+	// linkTypeRaw is the pcap link type of packets starting with IPv4 or IPv6 header, as the TUN device passes them.
+	linkTypeRaw = 101
+)
+
+var (
+	errCaptureRunning = errors.New("a capture is already running")
+	errNoCapture      = errors.New("no capture is running")
+)
+
+// CaptureLimits bound a capture of the TUN device traffic, see Client.StartCapture.
+type CaptureLimits struct {
+	// MaxSize is the size in bytes of the capture it stops at (default: 100 MiB).
+	MaxSize int64
+	// Duration stops the capture once it passes (default: zero, the capture runs till it is stopped).
+	Duration time.Duration
+	// SnapLen is the most bytes of each packet captured (default: 65535, whole packets).
+	SnapLen int
+}
+
+func (l CaptureLimits) validate() error {
+	if l.MaxSize < 0 || l.Duration < 0 || l.SnapLen < 0 || l.SnapLen > maxSnapLen {
+		return fmt.Errorf("capture limits must not be negative and snap length must not exceed %d", maxSnapLen)
+	}
+	if l.MaxSize > 0 && l.MaxSize < pcapHeaderSize+pcapRecordHeaderSize {
+		return errors.New("capture max size is too small for any packet")
+	}
+
+	return nil
+}
+
+// CaptureInfo describes a finished capture.
+type CaptureInfo struct {
+	Filter  string    `json:"filter,omitempty"`
+	Started time.Time `json:"started"`
+	Packets int64     `json:"packets"` // Packets written.
+	Bytes   int64     `json:"bytes"`   // Size of the capture, headers included.
+	// Dropped are packets matching the filter which were not written, the writer was not keeping up.
+	Dropped int64 `json:"dropped"`
+}
+
+// capturedPacket is a packet waiting to be written.
+type capturedPacket struct {
+	at   time.Time
+	data []byte // Data of the packet up to the snap length.
+	size int    // Size of the whole packet.
+}
+
+// packetCapture writes packets crossing the TUN device matching its filter in pcap format.
+type packetCapture struct {
+	filter  captureFilter
+	snapLen int
+	maxSize int64
+	w       *bufio.Writer
+	closer  io.Closer // Closed once the capture ends, may be nil.
+	logger  *slog.Logger
+	detach  func() // Stops packets from being passed to the capture.
+
+	packets chan capturedPacket
+	dropped atomic.Int64
+	stop    chan struct{}
+	once    sync.Once
+	done    chan struct{}
+
+	// Written by run only, read once done is closed.
+	info CaptureInfo
+	err  error
+}
+
+// offer queues pkt to be written if it matches the filter. It never blocks, the packet is dropped if the queue is full.
+func (pc *packetCapture) offer(pkt []byte) {
+	h, ok := parseHeaders(pkt)
+	if !ok || !pc.filter(h) {
+		return
+	}
+
+	p := capturedPacket{at: time.Now(), data: append([]byte(nil), pkt[:min(len(pkt), pc.snapLen)]...), size: len(pkt)}
+	select {
+	case pc.packets <- p:
+	default:
+		pc.dropped.Add(1)
+	}
+}
+
+// end stops the capture, if it is not stopped yet, and waits for the queued packets to be written.
+func (pc *packetCapture) end() (CaptureInfo, error) {
+	pc.halt()
+	<-pc.done
+
+	return pc.info, pc.err
+}
+
+// halt detaches the capture from the TUN device and tells run to finish.
+func (pc *packetCapture) halt() {
+	pc.once.Do(func() {
+		pc.detach()
+		close(pc.stop)
+	})
+}
+
+// run writes queued packets till the capture is stopped, a limit is reached or writing fails.
+func (pc *packetCapture) run(duration time.Duration) {
+	defer close(pc.done)
+
+	var timeout <-chan time.Time
+	if duration > 0 {
+		t := time.NewTimer(duration)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	for {
+		select {
+		case p := <-pc.packets:
+			pc.write(p)
+			if len(pc.packets) == 0 && pc.err == nil {
+				pc.err = pc.w.Flush()
+			}
+		case <-timeout:
+			pc.halt()
+		case <-pc.stop:
+			for len(pc.packets) > 0 {
+				pc.write(<-pc.packets)
+			}
+			if pc.err == nil {
+				pc.err = pc.w.Flush()
+			}
+			if pc.closer != nil {
+				pc.err = errors.Join(pc.err, pc.closer.Close())
+			}
+			pc.info.Dropped = pc.dropped.Load()
+			attrs := []any{"packets", pc.info.Packets, "bytes", pc.info.Bytes, "dropped", pc.info.Dropped}
+			if pc.err != nil {
+				attrs = append(attrs, "err", pc.err)
+			}
+			pc.logger.Info("capture finished", attrs...)
+
+			return
+		}
+		if pc.err != nil {
+			pc.halt()
+		}
+	}
+}
+
+// write appends the record of p, the capture is halted instead if it would grow over the max size.
+func (pc *packetCapture) write(p capturedPacket) {
+	if pc.err != nil {
+		return
+	}
+	size := int64(pcapRecordHeaderSize + len(p.data))
+	if pc.info.Bytes+size > pc.maxSize {
+		pc.halt()
+		pc.dropped.Add(1)
+
+		return
+	}
+
+	var hdr [pcapRecordHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(p.at.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(p.at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(p.data)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(p.size))
+	if _, pc.err = pc.w.Write(hdr[:]); pc.err == nil {
+		_, pc.err = pc.w.Write(p.data)
+	}
+	if pc.err != nil {
+		return
+	}
+	pc.info.Packets++
+	pc.info.Bytes += size
+}
+
+// startCapture starts capturing packets of the TUN device matching filter into w, see StartCapture.
+// closer, if any, is closed once the capture ends.
+func (c *Client) startCapture(w io.Writer, closer io.Closer, filter string, limits CaptureLimits) (*packetCapture, error) {
+	if c.cfg.Engine == EngineTProxy {
+		return nil, errors.New("capture requires a TUN device, it is not available with EngineTProxy")
+	}
+	if err := limits.validate(); err != nil {
+		return nil, err
+	}
+	if c.capture.Load() != nil {
+		return nil, errCaptureRunning
+	}
+	match, err := parseCaptureFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if limits.MaxSize == 0 {
+		limits.MaxSize = defaultCaptureMaxSize
+	}
+	if limits.SnapLen == 0 {
+		limits.SnapLen = maxSnapLen
+	}
+
+	pc := &packetCapture{
+		filter:  match,
+		snapLen: limits.SnapLen,
+		maxSize: limits.MaxSize,
+		w:       bufio.NewWriter(w),
+		closer:  closer,
+		logger:  c.cfg.Logger,
+		packets: make(chan capturedPacket, captureQueue),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		info:    CaptureInfo{Filter: filter, Started: time.Now(), Bytes: pcapHeaderSize},
+	}
+	pc.detach = func() { c.capture.CompareAndSwap(pc, nil) }
+
+	var hdr [pcapHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // Microsecond timestamps.
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(limits.SnapLen))
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+	if _, err = pc.w.Write(hdr[:]); err == nil {
+		err = pc.w.Flush()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write pcap header: %w", err)
+	}
+
+	if !c.capture.CompareAndSwap(nil, pc) {
+		return nil, errCaptureRunning
+	}
+	c.cfg.Logger.Info("capture started", "filter", filter, "max_size", limits.MaxSize, "duration", limits.Duration)
+	go pc.run(limits.Duration)
+
+	return pc, nil
+}
+
+// StartCapture writes packets crossing the TUN device which match filter to the pcap file at path,
+// e.g. to attach to a bug report, till StopCapture is called or one of limits is reached.
+// The filter is a subset of tcpdump expressions, e.g. "tcp port 443 and host 1.1.1.1", empty captures everything.
+// The capture may be started before connecting, it keeps running over reconnects and device replacements.
+// Only one capture runs at a time.
+func (c *Client) StartCapture(path, filter string, limits CaptureLimits) error {
+	if c.capture.Load() != nil {
+		return errCaptureRunning
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create capture file: %w", err)
+	}
+	if _, err = c.startCapture(f, f, filter, limits); err != nil {
+		return errors.Join(err, f.Close(), os.Remove(path))
+	}
+
+	return nil
+}
+
+// StopCapture stops the capture started by StartCapture and returns what was captured. It fails if no capture
+// is running, e.g. it ended by its limits already, which is logged.
+func (c *Client) StopCapture() (CaptureInfo, error) {
+	pc := c.capture.Load()
+	if pc == nil {
+		return CaptureInfo{}, errNoCapture
+	}
+
+	return pc.end()
+}
+
+// Capture writes packets crossing the TUN device which match filter to w in pcap format till ctx is done
+// or one of limits is reached, see StartCapture. Packets are dropped rather than waiting for a slow w.
+func (c *Client) Capture(ctx context.Context, w io.Writer, filter string, limits CaptureLimits) (CaptureInfo, error) {
+	pc, err := c.startCapture(w, nil, filter, limits)
+	if err != nil {
+		return CaptureInfo{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-pc.done:
+	}
+
+	return pc.end()
+}
+
+// captureTunnel passes packets read from and written to tun to the running capture, if any.
+func (c *Client) captureTunnel(tun io.ReadWriteCloser) io.ReadWriteCloser {
+	return &capturedTunnel{ReadWriteCloser: tun, capture: &c.capture}
+}
+
+// capturedTunnel passes packets of the TUN device to the running capture. It is always in place, so that
+// captures start and stop without replacing the device of the pipe.
+type capturedTunnel struct {
+	io.ReadWriteCloser
+
+	capture *atomic.Pointer[packetCapture]
+}
+
+func (t *capturedTunnel) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	if pc := t.capture.Load(); pc != nil && n > 0 {
+		pc.offer(p[:n])
+	}
+
+	return n, err
+}
+
+func (t *capturedTunnel) Write(p []byte) (int, error) {
+	if pc := t.capture.Load(); pc != nil {
+		pc.offer(p)
+	}
+
+	return t.ReadWriteCloser.Write(p)
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// packetDevice is a TUN device reading pkt over and over, written packets are discarded.
+type packetDevice struct {
+	pkt []byte
+}
+
+func (d packetDevice) Read(p []byte) (int, error) {
+	return copy(p, d.pkt), nil
+}
+
+func (d packetDevice) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (d packetDevice) Close() error {
+	return nil
+}
+
+// pcapRecords returns the packets of pcap file data after checking its header.
+func pcapRecords(t *testing.T, data []byte, snapLen int) [][]byte {
+	t.Helper()
+	require.GreaterOrEqual(t, len(data), pcapHeaderSize)
+	require.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(data))
+	require.Equal(t, uint32(snapLen), binary.LittleEndian.Uint32(data[16:]))
+	require.Equal(t, uint32(linkTypeRaw), binary.LittleEndian.Uint32(data[20:]))
+
+	var records [][]byte
+	for data = data[pcapHeaderSize:]; len(data) > 0; {
+		require.GreaterOrEqual(t, len(data), pcapRecordHeaderSize)
+		incl := int(binary.LittleEndian.Uint32(data[8:]))
+		require.LessOrEqual(t, incl, int(binary.LittleEndian.Uint32(data[12:])))
+		records = append(records, data[pcapRecordHeaderSize:pcapRecordHeaderSize+incl])
+		data = data[pcapRecordHeaderSize+incl:]
+	}
+
+	return records
+}
+
+func TestClient_StartCapture(t *testing.T) {
+	cl := &Client{cfg: Config{Logger: slog.New(slog.DiscardHandler)}}
+	v4src, v4dst := net.IPv4(192, 168, 1, 10), net.IPv4(1, 2, 3, 4)
+	out := tcpPacket(v4src, v4dst, 0x02, nil)
+	in := tcpPacket(v4dst, v4src, 0x12, nil)
+	tun := cl.captureTunnel(packetDevice{pkt: in})
+	path := filepath.Join(t.TempDir(), "tun.pcap")
+
+	_, err := tun.Write(out) // Not captured yet.
+	require.NoError(t, err)
+	_, err = cl.StopCapture()
+	require.ErrorIs(t, err, errNoCapture)
+	require.Error(t, cl.StartCapture(path, "tcp port", CaptureLimits{}))
+	require.NoFileExists(t, path)
+
+	require.NoError(t, cl.StartCapture(path, "dst host 1.2.3.4", CaptureLimits{}))
+	require.ErrorIs(t, cl.StartCapture(path, "", CaptureLimits{}), errCaptureRunning)
+	_, err = tun.Write(out)
+	require.NoError(t, err)
+	_, err = tun.Read(make([]byte, 1500)) // Filtered out.
+	require.NoError(t, err)
+	_, err = tun.Write(out)
+	require.NoError(t, err)
+
+	info, err := cl.StopCapture()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), info.Packets)
+	require.Equal(t, "dst host 1.2.3.4", info.Filter)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, info.Bytes, int64(len(data)))
+	require.Equal(t, [][]byte{out, out}, pcapRecords(t, data, maxSnapLen))
+
+	_, err = tun.Write(out) // Not captured anymore.
+	require.NoError(t, err)
+	_, err = cl.StopCapture()
+	require.ErrorIs(t, err, errNoCapture)
+}
+
+func TestClient_Capture(t *testing.T) {
+	cl := &Client{cfg: Config{Logger: slog.New(slog.DiscardHandler)}}
+	pkt := tcpPacket(net.ParseIP("fd00::10"), net.ParseIP("2001:db8::1"), 0x02, nil)
+	tun := cl.captureTunnel(packetDevice{pkt: pkt})
+
+	t.Run("max size", func(t *testing.T) {
+		var buf bytes.Buffer
+		limits := CaptureLimits{SnapLen: 48, MaxSize: pcapHeaderSize + 2*(pcapRecordHeaderSize+48)}
+		captured := make(chan CaptureInfo)
+		go func() {
+			info, err := cl.Capture(context.Background(), &buf, "ip6", limits)
+			require.NoError(t, err)
+			captured <- info
+		}()
+		require.Eventually(t, func() bool { return cl.capture.Load() != nil }, time.Second, time.Millisecond)
+
+		for range 3 {
+			_, err := tun.Read(make([]byte, 1500))
+			require.NoError(t, err)
+		}
+		info := <-captured
+		require.Equal(t, int64(2), info.Packets)
+		require.Equal(t, int64(1), info.Dropped)
+		require.Equal(t, [][]byte{pkt[:48], pkt[:48]}, pcapRecords(t, buf.Bytes(), 48))
+		require.Nil(t, cl.capture.Load())
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		var buf bytes.Buffer
+		info, err := cl.Capture(context.Background(), &buf, "", CaptureLimits{Duration: 10 * time.Millisecond})
+		require.NoError(t, err)
+		require.Zero(t, info.Packets)
+		require.Empty(t, pcapRecords(t, buf.Bytes(), maxSnapLen))
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := cl.Capture(ctx, &bytes.Buffer{}, "", CaptureLimits{})
+		require.NoError(t, err)
+		require.Nil(t, cl.capture.Load())
+	})
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// IP protocol numbers matched by capture filters.
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// packetHeaders are the fields of an IP packet capture filters match.
+type packetHeaders struct {
+	version  int
+	protocol uint8
+	src, dst netip.Addr
+	// ports are set for TCP and UDP packets only, IPv4 fragments and IPv6 packets with extension headers have none.
+	hasPorts         bool
+	srcPort, dstPort uint16
+}
+
+// parseHeaders returns the headers of IPv4 or IPv6 packet pkt, false if it is neither.
+func parseHeaders(pkt []byte) (packetHeaders, bool) {
+	var h packetHeaders
+	var payload []byte
+	switch {
+	case len(pkt) >= 20 && pkt[0]>>4 == 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		if ihl < 20 || ihl > len(pkt) {
+			return h, false
+		}
+		h.version, h.protocol = 4, pkt[9]
+		h.src, h.dst = netip.AddrFrom4([4]byte(pkt[12:16])), netip.AddrFrom4([4]byte(pkt[16:20]))
+		if binary.BigEndian.Uint16(pkt[6:8])&0x1fff == 0 {
+			payload = pkt[ihl:]
+		}
+	case len(pkt) >= 40 && pkt[0]>>4 == 6:
+		h.version, h.protocol = 6, pkt[6]
+		h.src, h.dst = netip.AddrFrom16([16]byte(pkt[8:24])), netip.AddrFrom16([16]byte(pkt[24:40]))
+		payload = pkt[40:]
+	default:
+		return h, false
+	}
+	if (h.protocol == protoTCP || h.protocol == protoUDP) && len(payload) >= 4 {
+		h.hasPorts = true
+		h.srcPort, h.dstPort = binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])
+	}
+
+	return h, true
+}
+
+// captureFilter reports whether a packet is captured.
+type captureFilter func(h packetHeaders) bool
+
+// parseCaptureFilter compiles a subset of tcpdump filter expressions:
+//
+//	ip, ip6, tcp, udp, icmp
+//	[src|dst] host 1.1.1.1
+//	[src|dst] net 10.0.0.0/8
+//	[src|dst] port 443
+//	tcp port 443, udp dst port 53 - shorthands of "tcp and port 443" and "udp and dst port 53"
+//
+// combined with "and" (&&), "or" (||), "not" (!) and parentheses. Empty expr captures all packets.
+func parseCaptureFilter(expr string) (captureFilter, error) {
+	expr = strings.NewReplacer("(", " ( ", ")", " ) ", "!", " ! ").Replace(expr)
+	p := &filterParser{tokens: strings.Fields(expr)}
+	if len(p.tokens) == 0 {
+		return func(packetHeaders) bool { return true }, nil
+	}
+
+	f, err := p.or()
+	if err == nil && len(p.tokens) > 0 {
+		err = fmt.Errorf("unexpected %q", p.tokens[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("capture filter: %w", err)
+	}
+
+	return f, nil
+}
+
+// filterParser is a recursive descent parser of capture filters, see parseCaptureFilter.
+type filterParser struct {
+	tokens []string
+}
+
+// peek returns the next token, empty at the end.
+func (p *filterParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+
+	return p.tokens[0]
+}
+
+// next consumes the next token, empty at the end.
+func (p *filterParser) next() string {
+	t := p.peek()
+	if t != "" {
+		p.tokens = p.tokens[1:]
+	}
+
+	return t
+}
+
+func (p *filterParser) or() (captureFilter, error) {
+	left, err := p.and()
+	for err == nil && (p.peek() == "or" || p.peek() == "||") {
+		p.next()
+		var right captureFilter
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(h packetHeaders) bool { return l(h) || right(h) }
+		}
+	}
+
+	return left, err
+}
+
+func (p *filterParser) and() (captureFilter, error) {
+	left, err := p.not()
+	for err == nil && (p.peek() == "and" || p.peek() == "&&") {
+		p.next()
+		var right captureFilter
+		if right, err = p.not(); err == nil {
+			l := left
+			left = func(h packetHeaders) bool { return l(h) && right(h) }
+		}
+	}
+
+	return left, err
+}
+
+func (p *filterParser) not() (captureFilter, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.next()
+		f, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(h packetHeaders) bool { return !f(h) }, nil
+	case "(":
+		p.next()
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t != ")" {
+			return nil, fmt.Errorf("expected ) instead of %q", t)
+		}
+
+		return f, nil
+	}
+
+	return p.primitive()
+}
+
+// primitive parses a protocol, optionally followed by a host, net or port primitive, or one of the latter.
+func (p *filterParser) primitive() (captureFilter, error) {
+	var proto captureFilter
+	switch p.peek() {
+	case "ip":
+		proto = func(h packetHeaders) bool { return h.version == 4 }
+	case "ip6":
+		proto = func(h packetHeaders) bool { return h.version == 6 }
+	case "tcp":
+		proto = func(h packetHeaders) bool { return h.protocol == protoTCP }
+	case "udp":
+		proto = func(h packetHeaders) bool { return h.protocol == protoUDP }
+	case "icmp":
+		proto = func(h packetHeaders) bool { return h.protocol == protoICMP || h.protocol == protoICMPv6 }
+	}
+	if proto != nil {
+		p.next()
+		switch p.peek() {
+		case "src", "dst", "host", "net", "port":
+		default:
+			return proto, nil
+		}
+	}
+
+	f, err := p.address()
+	if err != nil || proto == nil {
+		return f, err
+	}
+
+	return func(h packetHeaders) bool { return proto(h) && f(h) }, nil
+}
+
+// address parses [src|dst] host, net or port primitive.
+func (p *filterParser) address() (captureFilter, error) {
+	src, dst := true, true
+	switch p.peek() {
+	case "src":
+		dst = false
+		p.next()
+	case "dst":
+		src = false
+		p.next()
+	}
+
+	kind := p.next()
+	if kind != "host" && kind != "net" && kind != "port" {
+		return nil, fmt.Errorf("expected host, net or port instead of %q", kind)
+	}
+	arg := p.next()
+	if arg == "" {
+		return nil, fmt.Errorf("%s without value", kind)
+	}
+	var match func(h packetHeaders, src bool) bool
+	switch kind {
+	case "host":
+		addr, err := netip.ParseAddr(arg)
+		if err != nil {
+			return nil, fmt.Errorf("host: %w", err)
+		}
+		addr = addr.Unmap()
+		match = func(h packetHeaders, src bool) bool {
+			if src {
+				return h.src == addr
+			}
+
+			return h.dst == addr
+		}
+	case "net":
+		prefix, err := netip.ParsePrefix(arg)
+		if err != nil {
+			return nil, fmt.Errorf("net: %w", err)
+		}
+		prefix = prefix.Masked()
+		match = func(h packetHeaders, src bool) bool {
+			if src {
+				return prefix.Contains(h.src)
+			}
+
+			return prefix.Contains(h.dst)
+		}
+	case "port":
+		port, err := strconv.ParseUint(arg, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("port: %w", err)
+		}
+		match = func(h packetHeaders, src bool) bool {
+			if src {
+				return h.hasPorts && h.srcPort == uint16(port)
+			}
+
+			return h.hasPorts && h.dstPort == uint16(port)
+		}
+	}
+
+	return func(h packetHeaders) bool { return src && match(h, true) || dst && match(h, false) }, nil
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCaptureFilter(t *testing.T) {
+	v4 := tcpPacket(net.IPv4(192, 168, 1, 10), net.IPv4(1, 2, 3, 4), 0x02, nil) // 50000 -> 443
+	v6 := tcpPacket(net.ParseIP("fd00::10"), net.ParseIP("2001:db8::1"), 0x02, nil)
+	udp := append([]byte(nil), v4...)
+	udp[9] = protoUDP
+
+	tests := []struct {
+		expr  string
+		pkt   []byte
+		match bool
+	}{
+		{expr: "", pkt: v4, match: true},
+		{expr: "tcp", pkt: v4, match: true},
+		{expr: "udp", pkt: v4, match: false},
+		{expr: "udp port 443", pkt: udp, match: true},
+		{expr: "ip6", pkt: v6, match: true},
+		{expr: "ip and not ip6", pkt: v4, match: true},
+		{expr: "host 1.2.3.4", pkt: v4, match: true},
+		{expr: "src host 1.2.3.4", pkt: v4, match: false},
+		{expr: "dst net 1.2.0.0/16", pkt: v4, match: true},
+		{expr: "net 2001:db8::/32", pkt: v6, match: true},
+		{expr: "tcp dst port 443", pkt: v4, match: true},
+		{expr: "tcp src port 443", pkt: v4, match: false},
+		{expr: "port 50000 && !host 1.1.1.1", pkt: v4, match: true},
+		{expr: "icmp or (tcp and port 80)", pkt: v4, match: false},
+		{expr: "(icmp or tcp) and port 443", pkt: v6, match: true},
+		{expr: "not (icmp or tcp)", pkt: v6, match: false},
+		{expr: "tcp", pkt: []byte{0, 1, 2}, match: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := parseCaptureFilter(tt.expr)
+			require.NoError(t, err)
+			h, ok := parseHeaders(tt.pkt)
+			require.Equal(t, tt.match, ok && f(h))
+		})
+	}
+
+	for _, expr := range []string{"host", "host example.com", "port 70000", "net 10.0.0.1", "tcp and", "(tcp", "tcp)", "proto 6"} {
+		_, err := parseCaptureFilter(expr)
+		require.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+)
+
+// validateChain checks that links of Config.Chain parse, their servers are resolved on Connect.
+func validateChain(cfg Config) error {
+	if len(cfg.Chain) > 0 && len(cfg.ServerPorts) > 0 {
+		return errors.New("ServerPorts can not be used with Chain, the exit server is not reached directly")
+	}
+	svc := xray.NewXrayService(false, false)
+	for i, link := range cfg.Chain {
+		if _, _, err := parseLink(svc, link); err != nil {
+			return fmt.Errorf("chain link %d: %s", i+1, redactErr(err, link))
+		}
+	}
+
+	return nil
+}
+
+// chainHopTag is the tag of the outbound of the hop of Config.Chain with index i.
+func chainHopTag(i int) string {
+	return "chain-hop-" + strconv.Itoa(i)
+}
+
+// createChainProxy creates XRay instance for the exit protocol reached through the hops of Config.Chain.
+// It returns the address of the first hop, which is the only server connected directly.
+func (c *Client) createChainProxy(ctx context.Context, svc *xray.Core, exit xrayproto.Protocol) (xrayproto.Instance, net.IP, error) {
+	hops := make([]xrayproto.Protocol, 0, len(c.cfg.Chain)+1)
+	var first, pin net.IP
+	for i, link := range c.cfg.Chain {
+		protocol, cfg, err := parseLink(svc, link)
+		if err != nil {
+			return nil, nil, withKind(ErrInvalidLink, fmt.Errorf("chain link %d: %s", i+1, redactErr(err, link)))
+		}
+		if i == 0 {
+			if first, pin, err = c.resolveFirst(ctx, cfg); err != nil {
+				return nil, nil, withKind(ErrServerUnresolvable, fmt.Errorf("chain link 1 address not resolvable: %w", err))
+			}
+		}
+		hops = append(hops, protocol)
+	}
+	hops = append(hops, exit)
+
+	inst, err := c.makeXrayInstance(svc, hops, pin)
+	if err != nil {
+		return nil, nil, withKind(ErrXrayStart, fmt.Errorf("make instance: %w", err))
+	}
+
+	return inst, first, nil
+}
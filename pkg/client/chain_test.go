@@ -0,0 +1,31 @@
+package client
+
+import (
+	"testing"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/core"
+)
+
+func TestValidateChain(t *testing.T) {
+	require.NoError(t, validateChain(Config{}))
+	require.NoError(t, validateChain(Config{Chain: []string{"trojan://password@5.6.7.8:443"}}))
+	require.ErrorContains(t, validateChain(Config{Chain: []string{"trojan://password@5.6.7.8:443", "trojan://"}}),
+		"chain link 2")
+	require.Error(t, validateChain(Config{Chain: []string{"trojan://password@5.6.7.8:443"}, ServerPorts: []int{443}}))
+}
+
+func TestMakeChainInstance(t *testing.T) {
+	svc := xray.NewXrayService(false, false)
+	relay, _, err := parseLink(svc, "trojan://password@5.6.7.8:443")
+	require.NoError(t, err)
+	exit, _, err := parseLink(svc, "trojan://password@1.2.3.4:8443")
+	require.NoError(t, err)
+
+	inst, err := (&Client{}).makeXrayInstance(svc, []xrayproto.Protocol{relay, exit}, nil)
+	require.NoError(t, err)
+	require.NoError(t, inst.Close())
+	require.IsType(t, &core.Instance{}, inst)
+}
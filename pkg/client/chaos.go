@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
+)
+
+// errChaosDial is returned for flows failed on purpose by Config.Chaos.
+var errChaosDial = errors.New("chaos: dial failed on purpose")
+
+// Chaos injects faults into the tunnel, so that soak runs exercise the paths recovering from them,
+// e.g. while watching open descriptors and goroutines for leaks. Zero value disables it.
+type Chaos struct {
+	DialFailure float64 // Probability of a flow failing to be dialed through XRay, from 0 to 1.
+	PacketLoss  float64 // Probability of a packet read from or written to the TUN device being dropped, from 0 to 1.
+	// GatewayChange is how often a network change is simulated: the route exception for XRay server is removed,
+	// as the system does on roaming, and the client follows the gateway, 0 never.
+	GatewayChange time.Duration
+}
+
+func (c Chaos) enabled() bool {
+	return c.DialFailure > 0 || c.PacketLoss > 0 || c.GatewayChange > 0
+}
+
+func (c Chaos) validate() error {
+	if c.DialFailure < 0 || c.DialFailure > 1 {
+		return fmt.Errorf("chaos dial failure %v is out of 0-1", c.DialFailure)
+	}
+	if c.PacketLoss < 0 || c.PacketLoss > 1 {
+		return fmt.Errorf("chaos packet loss %v is out of 0-1", c.PacketLoss)
+	}
+	if c.GatewayChange < 0 {
+		return errors.New("chaos gateway change interval must not be negative")
+	}
+
+	return nil
+}
+
+// chaosTunnel wraps tun with Config.Chaos packet loss, if enabled.
+func (c *Client) chaosTunnel(tun io.ReadWriteCloser) io.ReadWriteCloser {
+	if c.cfg.Chaos.PacketLoss <= 0 {
+		return tun
+	}
+
+	return &lossyTunnel{ReadWriteCloser: tun, loss: c.cfg.Chaos.PacketLoss}
+}
+
+// lossyTunnel drops packets read from and written to the TUN device at random.
+type lossyTunnel struct {
+	io.ReadWriteCloser
+
+	loss float64
+}
+
+func (t *lossyTunnel) Read(p []byte) (int, error) {
+	for {
+		n, err := t.ReadWriteCloser.Read(p)
+		if err != nil || rand.Float64() >= t.loss {
+			return n, err
+		}
+	}
+}
+
+func (t *lossyTunnel) Write(p []byte) (int, error) {
+	if rand.Float64() < t.loss {
+		return len(p), nil
+	}
+
+	return t.ReadWriteCloser.Write(p)
+}
+
+// chaosDialer fails dials of flows at random with errChaosDial.
+type chaosDialer struct {
+	proxy.Dialer
+
+	failure float64
+}
+
+func (d *chaosDialer) DialContext(ctx context.Context, m *M.Metadata) (net.Conn, error) {
+	if rand.Float64() < d.failure {
+		return nil, errChaosDial
+	}
+
+	return d.Dialer.DialContext(ctx, m)
+}
+
+func (d *chaosDialer) DialUDP(m *M.Metadata) (net.PacketConn, error) {
+	if rand.Float64() < d.failure {
+		return nil, errChaosDial
+	}
+
+	return d.Dialer.DialUDP(m)
+}
+
+// watchChaos simulates a network change every Config.Chaos.GatewayChange, see followNetworkChange.
+// It returns when ctx is done.
+func (c *Client) watchChaos(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Chaos.GatewayChange)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.cfg.Logger.Warn("chaos: simulating network change")
+		if err := c.router.DropServerRoute(); err != nil {
+			c.cfg.Logger.Warn("chaos: removing xray server route failed", "err", err)
+		}
+		c.followNetworkChange()
+	}
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+)
+
+func TestChaos_Validate(t *testing.T) {
+	require.NoError(t, Chaos{}.validate())
+	require.NoError(t, Chaos{DialFailure: 1, PacketLoss: 0.5, GatewayChange: time.Minute}.validate())
+	require.ErrorContains(t, Chaos{DialFailure: 1.5}.validate(), "out of 0-1")
+	require.ErrorContains(t, Chaos{PacketLoss: -0.1}.validate(), "out of 0-1")
+	require.ErrorContains(t, Chaos{GatewayChange: -time.Second}.validate(), "negative")
+}
+
+func TestLossyTunnel(t *testing.T) {
+	dev := &packetPipe{in: make(chan []byte, 2), out: make(chan []byte, 1)}
+	tun := &lossyTunnel{ReadWriteCloser: dev, loss: 1}
+
+	n, err := tun.Write([]byte("down"))
+	require.NoError(t, err)
+	require.Equal(t, 4, n, "dropped packets look written")
+	require.Empty(t, dev.out)
+
+	tun.loss = 0
+	_, err = tun.Write([]byte("down"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("down"), <-dev.out)
+
+	dev.in <- []byte("up")
+	buf := make([]byte, defaultMTU)
+	n, err = tun.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("up"), buf[:n])
+
+	tun.loss = 1
+	dev.in <- []byte("lost")
+	close(dev.in)
+	_, err = tun.Read(buf)
+	require.Error(t, err, "dropped packets are skipped till the device fails")
+}
+
+func TestChaosDialer(t *testing.T) {
+	dialer := &chaosDialer{Dialer: stubDialer{}, failure: 1}
+	_, err := dialer.DialContext(context.Background(), &M.Metadata{})
+	require.ErrorIs(t, err, errChaosDial)
+	_, err = dialer.DialUDP(&M.Metadata{})
+	require.ErrorIs(t, err, errChaosDial)
+
+	dialer.failure = 0
+	conn, err := dialer.DialContext(context.Background(), &M.Metadata{})
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	pc, err := dialer.DialUDP(&M.Metadata{})
+	require.NoError(t, err)
+	require.NoError(t, pc.Close())
+}
@@ -2,38 +2,48 @@ package client
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/url"
 	"os"
+	"runtime"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/goxray/core/network/route"
 	"github.com/goxray/core/network/tun"
-	"github.com/goxray/core/pipe2socks"
-	"github.com/jackpal/gateway"
 
 	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
 	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
 	xapplog "github.com/xtls/xray-core/app/log"
 	xcommlog "github.com/xtls/xray-core/common/log"
+
+	"github.com/goxray/tun/pkg/metrics"
+	"github.com/goxray/tun/pkg/observe"
+	"github.com/goxray/tun/pkg/tracing"
 )
 
-const disconnectTimeout = 30 * time.Second
+const (
+	disconnectTimeout = 30 * time.Second
+	// defaultMTU is the MTU of the TUN device and the pipe.
+	defaultMTU = 1500
+	// defaultUDPTimeout is the time after which UDP session with no traffic is closed.
+	defaultUDPTimeout = 30 * time.Second
+)
 
 var (
-	// defaultTUNAddress is the address new TUN device will be set up with.
+	// defaultTUNAddress is the address TUN device of the first Client is set up with, see newTUNAddress.
 	defaultTUNAddress = &net.IPNet{IP: net.IPv4(192, 18, 0, 1), Mask: net.IPv4Mask(255, 255, 255, 255)}
-	// defaultInboundProxy default proxy will be set up for listening on 127.0.0.1.
-	defaultInboundProxy = &Proxy{
-		IP:   net.IPv4(127, 0, 0, 1),
-		Port: getFreePort(),
-	}
+	// tunAddresses counts default TUN addresses given out by newTUNAddress.
+	tunAddresses atomic.Uint32
 
 	// DefaultRoutesToTUN will route all system traffic through the TUN.
 	DefaultRoutesToTUN = []*route.Addr{
@@ -54,20 +64,208 @@ type Config struct {
 	// Client will determine the system gateway IP automatically,
 	// and you don't have to set this field explicitly.
 	GatewayIP *net.IP
-	// Socks proxy address on which XRay creates inbound proxy (default: 127.0.0.1:10808).
+	// Socks proxy address on which XRay creates inbound proxy (default: a free port of 127.0.0.1,
+	// picked for every Client).
+	//
+	// It may be a LAN address or 0.0.0.0 to let other devices of the LAN use the proxy, InboundAllow is then
+	// required. XRay keeps listening on loopback and only TCP connections of allowed clients are passed to it,
+	// so UDP ASSOCIATE is available to local clients only.
 	InboundProxy *Proxy
-	// TUN device address (default: 192.18.0.1).
+	// InboundAllow lists subnets of clients allowed to use InboundProxy bound to a non-loopback address,
+	// e.g. 192.168.1.0/24 (default: nil). Loopback clients are always allowed. Subnets not directly connected
+	// to the host are routed through the gateway, so that replies do not go to the TUN device.
+	InboundAllow []*net.IPNet
+	// HTTPInbound is an address on which XRay creates an HTTP proxy inbound next to the socks one, e.g. for
+	// applications without socks support (default: nil, none). It must be a loopback address.
+	HTTPInbound *Proxy
+	// TUN device address (default: 192.18.0.1, next Clients of the process get 192.18.0.2 and so on).
 	TUNAddress *net.IPNet
 	// List of routes to be pointed to TUN device (default: DefaultRoutesToTUN).
+	// They can be changed while connected with Client.AddRoute and Client.RemoveRoute.
 	//
 	// One exception is explicitly added for XRay remote server IP and can not be altered.
 	RoutesToTUN []*route.Addr
+	// ExcludeRoutes are routed through the gateway outside of the TUN device, even if they are covered
+	// by RoutesToTUN, e.g. a local network (default: nil).
+	ExcludeRoutes []*route.Addr
 	// Whether to allow self-signed certificates or not.
 	TLSAllowInsecure bool
 	// Pass logger with debug level to observe debug logs (default: slog.TextHandler).
 	Logger *slog.Logger
+	// LogLevel of the default logger, ignored if Logger is set (default: slog.LevelInfo).
+	LogLevel slog.Leveler
 	// XRayLogType is used to redefine xray core log type (default: LogType_None).
 	XRayLogType xapplog.LogType
+	// XRayMux multiplexes connections to the server over fewer underlying ones (default: disabled).
+	// It cuts handshakes on high latency links, but a stalled underlying connection stalls all of its streams.
+	XRayMux XRayMux
+	// TLSOverrides replace SNI, ALPN and certificate verification of the link (default: settings of the link).
+	TLSOverrides TLSOverrides
+	// TLSFingerprint overrides uTLS fingerprint of the link, e.g. "chrome", "firefox", "ios" or "random"
+	// (default: the one of the link). Like TLSFragment, it applies to the server connected directly.
+	TLSFingerprint string
+	// TLSFragment fragments the handshake with the server to evade DPI (default: disabled).
+	TLSFragment TLSFragment
+	// UpstreamProxy is a socks5:// or http:// proxy the server is dialed through, e.g. a corporate proxy
+	// required to reach anything (default: nil, the server is dialed directly). The route exception is then
+	// added for the proxy instead of the server. An http proxy carries TCP only, so transports over UDP,
+	// e.g. mKCP, fail with it.
+	UpstreamProxy *url.URL
+	// XRayExtra is a JSON object of XRay config sections merged into the generated config: "routing", "dns",
+	// "observatory", "burstObservatory" and "outbounds" added after the generated ones (default: nil).
+	// The outbound of the server is tagged "proxy" and stays the default one.
+	XRayExtra json.RawMessage
+	// Netstack tunes TCP of the userspace network stack passing connections of the TUN device to XRay, e.g. buffer sizes for high bandwidth links (default: zero, tun2socks defaults).
+	Netstack NetstackOptions
+	// UDPTimeout closes UDP sessions with no traffic for this long (default: 30s).
+	UDPTimeout time.Duration
+	// MaxUDPSessions limits the number of concurrent UDP sessions (default: 0, no limit).
+	//
+	// When the limit is reached the most idle session is evicted to make room for a new one.
+	MaxUDPSessions int
+	// MaxTCPConnections limits the number of concurrent TCP connections (default: 0, no limit).
+	//
+	// New connections over the limit are rejected and logged instead of exhausting file descriptors.
+	MaxTCPConnections int
+	// TCPIdleTimeout closes TCP connections with no traffic in either direction for this long
+	// (default: 0, idle connections are kept open).
+	TCPIdleTimeout time.Duration
+	// BreakerThreshold is the number of connections in a row which failed to reach the server, e.g. closed
+	// by XRay with no data, after which new connections are refused right away for BreakerCooldown and
+	// observe.EventDegraded is emitted (default: 0, connections are always dialed). It saves descriptors
+	// and CPU while the server is unreachable. The first connection reaching the server afterwards emits
+	// observe.EventRecovered.
+	BreakerThreshold int
+	// BreakerCooldown is how long new connections are refused once BreakerThreshold is reached (default: 10s).
+	BreakerCooldown time.Duration
+	// Observer receives client events (default: events are discarded).
+	//
+	// Use observe.Observers to pass events to several observers.
+	Observer observe.Observer
+	// FlowRecorder receives every connection and UDP session of the tunnel once it is closed, with its traffic,
+	// e.g. ipfix.Exporter (default: nil).
+	FlowRecorder observe.FlowRecorder
+	// FDLimit is the soft RLIMIT_NOFILE set on Connect, capped by the hard limit
+	// (default: 0, raised to the hard limit).
+	FDLimit uint64
+	// FDWarnRatio is the share of FDLimit in use after which a warning is logged
+	// and observe.EventFDPressure is emitted (default: 0.8).
+	FDWarnRatio float64
+	// OnDownPolicy defines what happens to the traffic after Disconnect (default: DownPolicyRestore).
+	OnDownPolicy DownPolicy
+	// ServerRouteCheckInterval is how often the route exception for XRay server and routes to the TUN device
+	// are verified and restored if removed by other software, e.g. a DHCP client (default: 30s).
+	// On Linux they are also verified on routing table changes.
+	ServerRouteCheckInterval time.Duration
+	// HealthCheckInterval is how often the tunnel is probed (default: 0, disabled). ProbeTargets are requested
+	// through XRay and, if the client manages system DNS, their names are resolved with the system resolver.
+	// When only one of them keeps failing, only its subsystem is restarted: XRay instance or DNS settings
+	// with the forwarder.
+	HealthCheckInterval time.Duration
+	// ProbeTargets are HTTP(S) URLs requested through the tunnel by health checks and when probing server ports,
+	// e.g. endpoints reachable from a corporate network (default: a 204 endpoint of Google).
+	// Health checks also resolve their host names.
+	ProbeTargets []string
+	// ProbeQuorum is how many of ProbeTargets have to answer for a health check to pass (default: 1).
+	// It is capped by the number of targets.
+	ProbeQuorum int
+	// QualityProbeInterval is how often the round trip through the tunnel is measured by a small request
+	// to QualityProbeURL, RTT, jitter and loss of the latest probes are reported by Stats (default: 0, disabled).
+	QualityProbeInterval time.Duration
+	// QualityProbeURL is the HTTP(S) URL requested by quality probes through XRay, a nearby endpoint answering
+	// with no body is best (default: the first of ProbeTargets).
+	QualityProbeURL string
+	// ConnectTimeout limits Connect, see ConnectContext (default: 0, no limit).
+	ConnectTimeout time.Duration
+	// Retry repeats resolving and dialing the server and adding the TUN device and routes when they fail
+	// while connecting (default: zero, a single attempt is made).
+	Retry RetryPolicy
+	// TUNStallTimeout is how long packets may keep being written to the TUN device while nothing
+	// is read from it before the device is considered wedged and reopened (default: 0, disabled).
+	TUNStallTimeout time.Duration
+	// TunnelStallTimeout is how long data may keep being sent through the tunnel while nothing is received
+	// before the tunnel is considered stalled and observe.EventTunnelStalled is emitted (default: 0, disabled),
+	// e.g. when the server went away silently. A single upload with no other traffic looks the same,
+	// so it should be well above the time apps wait for replies.
+	TunnelStallTimeout time.Duration
+	// ReconnectOnStall reconnects to the server when the tunnel is stalled, see TunnelStallTimeout (default: false).
+	ReconnectOnStall bool
+	// ConnectionLog logs every new connection and UDP session of the tunnel at Info level with its destination,
+	// TLS SNI or HTTP Host sent by the app, XRay outbound and routing rule tag it is sent by, see Config.XRayExtra,
+	// and outcome: whether the server answered (default: nil, connections are not logged).
+	ConnectionLog *slog.Logger
+	// HostNames resolves flow destinations to host names in Flows (default: nil, hosts are left empty).
+	// It must answer from memory, e.g. from a cache of DNS responses, no lookups are made on its behalf.
+	HostNames observe.NameCache
+	// ResolveProcesses fills the owning local process of flows returned by Flows, only supported on Linux
+	// (default: false). Lookup scans /proc, it is done once per flow.
+	ResolveProcesses bool
+	// TUNFileDescriptor is the descriptor of a TUN device created by someone else, e.g. a privileged helper,
+	// which Connect uses instead of creating its own device (default: 0, device is created by Connect).
+	// See ConnectWithTUN for details, the descriptor is closed on Disconnect.
+	TUNFileDescriptor int
+	// DNSServers are set as system resolvers while connected, supported on macOS and Linux
+	// (default: nil, system DNS is not changed and queries follow RoutesToTUN like other traffic).
+	DNSServers []net.IP
+	// DNSRules send queries for selected domains to other resolvers than DNSServers, e.g. to keep corporate
+	// names resolving while connected (default: nil). They require DNSServers.
+	DNSRules []DNSRule
+	// DNSQueryLog records DNS queries with their answers, latency and resolver, see Client.DNSQueries
+	// (default: disabled). It requires DNSServers.
+	DNSQueryLog DNSQueryLog
+	// RouteTable changes the system routing table (default: route.Route of goxray/core).
+	// Set it to delegate route changes, e.g. to a privileged helper process. Only routes are delegated,
+	// DNSServers, ShareLAN and TUNStallTimeout still need the privileges of the client.
+	RouteTable ipTable
+	// NetworkManager integrates the client with NetworkManager on Linux (default: false): the TUN device
+	// is marked unmanaged, and the route for XRay server follows the default gateway when the network changes.
+	NetworkManager bool
+	// Engine selects how the traffic is captured (default: EngineTUN). EngineTProxy needs no TUN device,
+	// e.g. on servers where creating one is not allowed, but it captures TCP connections of the host only,
+	// so it can not be used with DNSServers, PolicyRouting, ShareLAN and TUNFileDescriptor.
+	Engine Engine
+	// PolicyRouting points RoutesToTUN to the TUN device in a dedicated routing table selected by rules,
+	// like wg-quick does, instead of overriding the default route of the main table (default: false, Linux only).
+	// Routes of the main table more specific than the default one, e.g. of the LAN or other VPNs, keep working,
+	// and sockets marked with fwmark 0x7867 bypass the tunnel. The rules are not changed through RouteTable.
+	PolicyRouting bool
+	// PathMTUProbe sets MTU of the TUN device to the path MTU to XRay server found with pings which must not be
+	// fragmented on Connect, and lowers it when the tunnel fails health checks because bigger packets are
+	// blackholed (default: false, Linux only). It is kept when the server does not answer pings.
+	PathMTUProbe bool
+	// ShareLAN lets other devices of the LAN use the host as their gateway through the tunnel (default: false).
+	// IPv4 forwarding is enabled and the forwarded traffic is translated to the TUN address, with iptables
+	// on Linux and pf on macOS, all of it is reverted on Disconnect.
+	ShareLAN bool
+	// BypassBridges keeps networks of Docker, libvirt, VirtualBox, VMware and Tailscale interfaces found on Connect
+	// off the TUN device (default: false), so that containers and VMs on the host stay reachable.
+	BypassBridges bool
+	// ServerPorts are alternative ports the server is published on (default: nil). If set, the server is probed
+	// on Connect and, when the port of the link is blocked, the next reachable one is used.
+	ServerPorts []int
+	// Chain lists links of relay servers the traffic goes through, in order, before the server of the link
+	// passed to Connect, which is the exit (default: nil). Only the first relay is connected directly,
+	// e.g. a domestic relay in front of a foreign exit node. It can not be used with ServerPorts.
+	Chain []string
+	// PortStore remembers the working server port per network, so that it is tried first (default: nil).
+	PortStore PortStore
+	// Shaping adds latency, jitter and bandwidth limit to the TUN path, e.g. to test apps on a slow network
+	// (default: zero, traffic is not shaped).
+	Shaping Shaping
+	// Chaos injects dial failures, packet loss and network changes for soak testing, never use it otherwise
+	// (default: zero, no faults).
+	Chaos Chaos
+	// APIAddr is the TCP address the management API is served on by ServeAPI, e.g. "127.0.0.1:8089"
+	// (default: empty, no API).
+	APIAddr string
+	// APIToken is the bearer token requests to the management API must carry, it is required with APIAddr.
+	APIToken string
+	// Tracer records spans of connecting and disconnecting, with the steps of XRay start, TUN creation and
+	// route installation, and of every flow dialed through the tunnel (default: nil, nothing is recorded).
+	Tracer *tracing.Tracer
+	// Metrics pushes the tunnel metrics to monitoring systems while connected, e.g. StatsD or OTLP
+	// (default: nothing is pushed).
+	Metrics MetricsConfig
 }
 
 func (c *Config) apply(new *Config) {
@@ -77,18 +275,242 @@ func (c *Config) apply(new *Config) {
 	if new.InboundProxy != nil {
 		c.InboundProxy = new.InboundProxy
 	}
+	if new.InboundAllow != nil {
+		c.InboundAllow = new.InboundAllow
+	}
+	if new.HTTPInbound != nil {
+		c.HTTPInbound = new.HTTPInbound
+	}
 	if new.TUNAddress != nil {
 		c.TUNAddress = new.TUNAddress
 	}
 	if new.Logger != nil {
 		c.Logger = new.Logger
 	}
+	if new.ConnectionLog != nil {
+		c.ConnectionLog = new.ConnectionLog
+	}
+	if new.FlowRecorder != nil {
+		c.FlowRecorder = new.FlowRecorder
+	}
+	if new.LogLevel != nil {
+		c.LogLevel = new.LogLevel
+	}
 	if new.RoutesToTUN != nil {
 		c.RoutesToTUN = new.RoutesToTUN
 	}
+	if new.ExcludeRoutes != nil {
+		c.ExcludeRoutes = new.ExcludeRoutes
+	}
 	if new.XRayLogType != xapplog.LogType_None {
 		c.XRayLogType = new.XRayLogType
 	}
+	if new.XRayMux.Enabled {
+		c.XRayMux = new.XRayMux
+	}
+	if new.TLSOverrides.SNI != "" || new.TLSOverrides.ALPN != nil || new.TLSOverrides.AllowInsecure != nil {
+		c.TLSOverrides = new.TLSOverrides
+	}
+	if new.TLSFingerprint != "" {
+		c.TLSFingerprint = new.TLSFingerprint
+	}
+	if new.TLSFragment.enabled() {
+		c.TLSFragment = new.TLSFragment
+	}
+	if new.UpstreamProxy != nil {
+		c.UpstreamProxy = new.UpstreamProxy
+	}
+	if new.XRayExtra != nil {
+		c.XRayExtra = new.XRayExtra
+	}
+	if new.Netstack != (NetstackOptions{}) {
+		c.Netstack = new.Netstack
+	}
+	if new.UDPTimeout != 0 {
+		c.UDPTimeout = new.UDPTimeout
+	}
+	if new.MaxUDPSessions != 0 {
+		c.MaxUDPSessions = new.MaxUDPSessions
+	}
+	if new.MaxTCPConnections != 0 {
+		c.MaxTCPConnections = new.MaxTCPConnections
+	}
+	if new.BreakerThreshold != 0 {
+		c.BreakerThreshold = new.BreakerThreshold
+	}
+	if new.BreakerCooldown != 0 {
+		c.BreakerCooldown = new.BreakerCooldown
+	}
+	if new.TCPIdleTimeout != 0 {
+		c.TCPIdleTimeout = new.TCPIdleTimeout
+	}
+	if new.Observer != nil {
+		c.Observer = new.Observer
+	}
+	if new.FDLimit != 0 {
+		c.FDLimit = new.FDLimit
+	}
+	if new.FDWarnRatio != 0 {
+		c.FDWarnRatio = new.FDWarnRatio
+	}
+	if new.HealthCheckInterval != 0 {
+		c.HealthCheckInterval = new.HealthCheckInterval
+	}
+	if new.ProbeTargets != nil {
+		c.ProbeTargets = new.ProbeTargets
+	}
+	if new.ProbeQuorum != 0 {
+		c.ProbeQuorum = new.ProbeQuorum
+	}
+	if new.QualityProbeInterval != 0 {
+		c.QualityProbeInterval = new.QualityProbeInterval
+	}
+	if new.QualityProbeURL != "" {
+		c.QualityProbeURL = new.QualityProbeURL
+	}
+	if new.ConnectTimeout != 0 {
+		c.ConnectTimeout = new.ConnectTimeout
+	}
+	if new.Retry != (RetryPolicy{}) {
+		c.Retry = new.Retry
+	}
+	if new.TUNStallTimeout != 0 {
+		c.TUNStallTimeout = new.TUNStallTimeout
+	}
+	if new.TunnelStallTimeout != 0 {
+		c.TunnelStallTimeout = new.TunnelStallTimeout
+	}
+	if new.ReconnectOnStall {
+		c.ReconnectOnStall = true
+	}
+	if new.ServerRouteCheckInterval != 0 {
+		c.ServerRouteCheckInterval = new.ServerRouteCheckInterval
+	}
+	if new.OnDownPolicy != DownPolicyRestore {
+		c.OnDownPolicy = new.OnDownPolicy
+	}
+	if new.HostNames != nil {
+		c.HostNames = new.HostNames
+	}
+	if new.ResolveProcesses {
+		c.ResolveProcesses = true
+	}
+	if new.TUNFileDescriptor != 0 {
+		c.TUNFileDescriptor = new.TUNFileDescriptor
+	}
+	if new.DNSServers != nil {
+		c.DNSServers = new.DNSServers
+	}
+	if new.DNSRules != nil {
+		c.DNSRules = new.DNSRules
+	}
+	if new.DNSQueryLog.Enabled {
+		c.DNSQueryLog = new.DNSQueryLog
+	}
+	if new.RouteTable != nil {
+		c.RouteTable = new.RouteTable
+	}
+	if new.NetworkManager {
+		c.NetworkManager = true
+	}
+	if new.Engine != "" {
+		c.Engine = new.Engine
+	}
+	if new.PolicyRouting {
+		c.PolicyRouting = true
+	}
+	if new.PathMTUProbe {
+		c.PathMTUProbe = true
+	}
+	if new.ShareLAN {
+		c.ShareLAN = true
+	}
+	if new.BypassBridges {
+		c.BypassBridges = true
+	}
+	if new.ServerPorts != nil {
+		c.ServerPorts = new.ServerPorts
+	}
+	if new.Chain != nil {
+		c.Chain = new.Chain
+	}
+	if new.PortStore != nil {
+		c.PortStore = new.PortStore
+	}
+	if new.Shaping.enabled() {
+		c.Shaping = new.Shaping
+	}
+	if new.Chaos.enabled() {
+		c.Chaos = new.Chaos
+	}
+	if new.APIAddr != "" {
+		c.APIAddr = new.APIAddr
+	}
+	if new.APIToken != "" {
+		c.APIToken = new.APIToken
+	}
+	if new.Tracer != nil {
+		c.Tracer = new.Tracer
+	}
+	if new.Metrics.Exporters != nil {
+		c.Metrics.Exporters = new.Metrics.Exporters
+	}
+	if new.Metrics.Interval != 0 {
+		c.Metrics.Interval = new.Metrics.Interval
+	}
+}
+
+// clone returns a deep copy of the config. Loggers, Observer, FlowRecorder, Tracer, metrics exporters
+// and HostNames are shared.
+func (c *Config) clone() Config {
+	cp := *c
+	if c.GatewayIP != nil {
+		ip := slices.Clone(*c.GatewayIP)
+		cp.GatewayIP = &ip
+	}
+	if c.InboundProxy != nil {
+		cp.InboundProxy = &Proxy{IP: slices.Clone(c.InboundProxy.IP), Port: c.InboundProxy.Port}
+	}
+	if c.InboundAllow != nil {
+		cp.InboundAllow = make([]*net.IPNet, len(c.InboundAllow))
+		for i, n := range c.InboundAllow {
+			cp.InboundAllow[i] = &net.IPNet{IP: slices.Clone(n.IP), Mask: slices.Clone(n.Mask)}
+		}
+	}
+	if c.TUNAddress != nil {
+		cp.TUNAddress = &net.IPNet{IP: slices.Clone(c.TUNAddress.IP), Mask: slices.Clone(c.TUNAddress.Mask)}
+	}
+	if c.DNSServers != nil {
+		cp.DNSServers = make([]net.IP, len(c.DNSServers))
+		for i, ip := range c.DNSServers {
+			cp.DNSServers[i] = slices.Clone(ip)
+		}
+	}
+	if c.RoutesToTUN != nil {
+		cp.RoutesToTUN = make([]*route.Addr, len(c.RoutesToTUN))
+		for i, r := range c.RoutesToTUN {
+			cp.RoutesToTUN[i] = &route.Addr{IP: slices.Clone(r.IP), Mask: slices.Clone(r.Mask)}
+		}
+	}
+	if c.ExcludeRoutes != nil {
+		cp.ExcludeRoutes = make([]*route.Addr, len(c.ExcludeRoutes))
+		for i, r := range c.ExcludeRoutes {
+			cp.ExcludeRoutes[i] = &route.Addr{IP: slices.Clone(r.IP), Mask: slices.Clone(r.Mask)}
+		}
+	}
+	cp.ServerPorts = slices.Clone(c.ServerPorts)
+	cp.ProbeTargets = slices.Clone(c.ProbeTargets)
+	if c.DNSRules != nil {
+		cp.DNSRules = make([]DNSRule, len(c.DNSRules))
+		for i, r := range c.DNSRules {
+			cp.DNSRules[i] = DNSRule{Domain: r.Domain, Direct: r.Direct}
+			for _, ip := range r.Servers {
+				cp.DNSRules[i].Servers = append(cp.DNSRules[i].Servers, slices.Clone(ip))
+			}
+		}
+	}
+
+	return cp
 }
 
 // Client is the actual VPN cl. It manages connections, routing and tunneling of the requests.
@@ -97,15 +519,65 @@ func (c *Config) apply(new *Config) {
 type Client struct {
 	cfg Config
 
-	xInst  runnable
-	xCfg   *xrayproto.GeneralConfig
-	xSrvIP *net.IPAddr
-	tunnel io.ReadWriteCloser
-	pipe   pipe
-	routes ipTable
+	xInst runnable
+	// xRoute is xrayRoute of xInst, flows are routed by it for the connection log without taking tunMu.
+	xRoute atomic.Value
+	// inbound is the address XRay inbound listens on, it differs from Config.InboundProxy exposed to the LAN.
+	inbound Proxy
+	// inboundPicked is set if the port of inbound was picked by the Client, not by the user.
+	inboundPicked bool
+	xCfg          *xrayproto.GeneralConfig
+	link          string // Link of the server XRay is connected to.
+	tunnel        io.ReadWriteCloser
+	pipe          pipe
+	flows         *observe.FlowTable
+	events        observe.Broadcaster // Events passed to streams of the management API, see ServeAPI.
+	router        *router
+	dns           dnsConfigurator
+	sharer        lanSharer
+	// gate passes LAN clients to XRay inbound while connected, see Config.InboundAllow.
+	gate       *inboundGate
+	gateRoutes []*route.Addr // Routes of allowed subnets not connected to the host, kept off the TUN device.
+	dnsSet     bool          // System DNS was changed by setDNS.
+	policySet  bool          // Policy rules are installed, see Config.PolicyRouting.
+	// transparent passes redirected connections to XRay while connected with EngineTProxy.
+	transparent *transparentProxy
+	redirector  redirector
+	// forwarder splits DNS queries according to Config.DNSRules while connected.
+	forwarder *dnsForwarder
+	directDNS []*route.Addr // Routes of direct resolvers of Config.DNSRules outside of the TUN device.
+	dnsLog    *dnsQueryLog  // Queries recorded by the forwarder, nil unless Config.DNSQueryLog is enabled.
+	// capture receives packets of the TUN device while a capture runs, see StartCapture.
+	capture atomic.Pointer[packetCapture]
 
 	tunnelStopped chan error
 	stopTunnel    func()
+	stopMonitors  func()
+	monitors      sync.WaitGroup // Goroutines started by startMonitor, Disconnect waits for them.
+	tunName       string
+	externalTUN   bool // TUN device was passed by the caller, see ConnectWithTUN.
+	proxyOnly     bool // Only XRay proxies run, see StartProxyOnly.
+	mtu           int
+	// ping sends echo requests which must not be fragmented, see Config.PathMTUProbe.
+	ping        func(ctx context.Context, ip net.IP, size int) (bool, error)
+	wsl         wslMode
+	connectedAt time.Time
+	startupMem  uint64 // Memory obtained from the OS once connected, see observe.Footprint.
+	rates       rateMeter
+	// traffic counts bytes of the TUN devices, it carries totals over device replacements and reconnects.
+	traffic observe.Traffic
+	// quality keeps the probes of the round trip through the tunnel, see Config.QualityProbeInterval.
+	quality observe.QualityWindow
+	ready   readiness // Latest result of Ready.
+
+	// blocking is the TUN device kept open after Disconnect to block the traffic, see DownPolicyBlock.
+	blocking io.Closer
+	// connMu serializes connecting and disconnecting, so that the Client may be used from several goroutines.
+	connMu sync.Mutex
+	// tunMu guards replacing the TUN device while connected.
+	tunMu sync.Mutex
+	// cfgMu guards cfg fields changed at runtime, see CurrentConfig.
+	cfgMu sync.RWMutex
 }
 
 // Proxy will set up XRay inbound.
@@ -121,14 +593,84 @@ func (p *Proxy) String() string {
 // NewClient initializes default Client with default proxy address.
 // If you want more options use Client struct.
 func NewClient() (*Client, error) {
-	gatewayIP, err := gateway.DiscoverGateway()
-	if err != nil {
-		return nil, fmt.Errorf("discover gateway: %w", err)
+	return NewClientWithOpts(Config{})
+}
+
+// NewClientWithOpts initializes Client with specified Config. It is recommended to just use NewClient().
+func NewClientWithOpts(cfg Config) (*Client, error) {
+	if cfg.InboundProxy != nil && !cfg.InboundProxy.IP.IsLoopback() && len(cfg.InboundAllow) == 0 {
+		return nil, errors.New("inbound proxy on a non-loopback address requires InboundAllow")
+	}
+	if cfg.PolicyRouting && !policyRoutingSupported {
+		return nil, fmt.Errorf("policy routing is not supported on %s", runtime.GOOS)
+	}
+	if cfg.PathMTUProbe && !pathMTUSupported {
+		return nil, fmt.Errorf("path MTU probing is not supported on %s", runtime.GOOS)
+	}
+	if err := validateHTTPInbound(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Netstack.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateEngine(cfg); err != nil {
+		return nil, err
+	}
+	if err := validateChain(cfg); err != nil {
+		return nil, err
+	}
+	if err := validateUpstream(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.XRayMux.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateFingerprint(cfg.TLSFingerprint); err != nil {
+		return nil, err
+	}
+	if err := cfg.TLSFragment.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Metrics.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Retry.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Chaos.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.DNSQueryLog.validate(); err != nil {
+		return nil, err
+	}
+	if _, _, err := parseXRayExtra(cfg.XRayExtra); err != nil {
+		return nil, err
+	}
+	for _, target := range cfg.ProbeTargets {
+		if err := validateProbeTarget(target); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.QualityProbeInterval < 0 {
+		return nil, errors.New("quality probe interval must not be negative")
+	}
+	if cfg.QualityProbeURL != "" {
+		if err := validateProbeTarget(cfg.QualityProbeURL); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateAPI(cfg); err != nil {
+		return nil, err
 	}
 
-	p, err := pipe2socks.NewPipe(pipe2socks.DefaultOpts)
-	if err != nil {
-		return nil, fmt.Errorf("tun2socks new pipe: %w", err)
+	wsl := detectWSL()
+	// Gateway may be not discoverable, e.g. on mobile platforms, where it is not needed with ConnectWithTUN.
+	gatewayIP := net.IPv4zero
+	if cfg.GatewayIP == nil {
+		var err error
+		if gatewayIP, err = discoverGateway(wsl); err != nil {
+			return nil, fmt.Errorf("discover gateway: %w", err)
+		}
 	}
 
 	r, err := route.New()
@@ -136,28 +678,68 @@ func NewClient() (*Client, error) {
 		return nil, fmt.Errorf("route new: %w", err)
 	}
 
-	return &Client{
+	client := &Client{
 		cfg: Config{
-			GatewayIP:    &gatewayIP,
-			InboundProxy: defaultInboundProxy,
-			TUNAddress:   defaultTUNAddress,
-			RoutesToTUN:  DefaultRoutesToTUN,
-			Logger:       slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			GatewayIP:   &gatewayIP,
+			RoutesToTUN: DefaultRoutesToTUN,
+			Engine:      EngineTUN,
+			UDPTimeout:  defaultUDPTimeout,
+			Observer:    observe.Observers(nil),
+			FDWarnRatio: defaultFDWarnRatio,
+			RouteTable:  r,
+
+			ServerRouteCheckInterval: defaultServerRouteCheckInterval,
 		},
 		tunnelStopped: make(chan error),
-		pipe:          p,
-		routes:        r,
-	}, nil
-}
-
-// NewClientWithOpts initializes Client with specified Config. It is recommended to just use NewClient().
-func NewClientWithOpts(cfg Config) (*Client, error) {
-	client, err := NewClient()
-	if err != nil {
-		return nil, err
+		flows:         observe.NewFlowTable(),
+		wsl:           wsl,
+		ping:          pingDF,
 	}
-
 	client.cfg.apply(&cfg)
+	// Defaults are picked per Client, so that several of them may run in one process.
+	if client.cfg.InboundProxy == nil {
+		client.cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+		client.inboundPicked = true
+	}
+	if client.cfg.TUNAddress == nil {
+		client.cfg.TUNAddress = newTUNAddress()
+	}
+	client.inbound = *client.cfg.InboundProxy
+	if !client.inbound.IP.IsLoopback() {
+		client.inbound = Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+		client.inboundPicked = true
+	}
+	client.mtu = tunMTU(wsl, *client.cfg.GatewayIP)
+	client.router = newRouter(client.cfg.RouteTable, *client.cfg.GatewayIP)
+	if client.cfg.PolicyRouting {
+		client.router.tunTable = policyRoutes{}
+	}
+	client.dns = newDNSConfigurator()
+	client.sharer = newLANSharer()
+	client.redirector = newRedirector()
+	if client.cfg.Logger == nil {
+		client.cfg.Logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: client.cfg.LogLevel}))
+	}
+	client.dnsLog = newDNSQueryLog(client.cfg.DNSQueryLog, client.cfg.Logger)
+	client.flows.SetRecorder(client.cfg.FlowRecorder)
+	if wsl != wslNone {
+		client.cfg.Logger.Debug("running in WSL2", "networking", wsl, "mtu", client.mtu)
+	}
+
+	client.pipe = newFlowPipe(pipeOpts{
+		MTU:              client.mtu,
+		UDPTimeout:       client.cfg.UDPTimeout,
+		MaxUDPSessions:   client.cfg.MaxUDPSessions,
+		MaxTCPConns:      client.cfg.MaxTCPConnections,
+		TCPIdleTimeout:   client.cfg.TCPIdleTimeout,
+		Netstack:         client.cfg.Netstack,
+		BreakerThreshold: client.cfg.BreakerThreshold,
+		BreakerCooldown:  client.cfg.BreakerCooldown,
+		ConnLog:          client.cfg.ConnectionLog,
+		Route:            client.pickRoute,
+		Tracer:           client.cfg.Tracer,
+		DialFailure:      client.cfg.Chaos.DialFailure,
+	}, client.flows, observe.Observers{client.cfg.Observer, &client.events}, client.cfg.Logger)
 
 	return client, nil
 }
@@ -165,93 +747,366 @@ func NewClientWithOpts(cfg Config) (*Client, error) {
 // GatewayIP returns gateway IP used to route outbound traffic through.
 // It is used to route packets destined to XRay remote server.
 func (c *Client) GatewayIP() net.IP {
-	return *c.cfg.GatewayIP
+	return c.router.Gateway()
 }
 
 // TUNAddress returns address the TUN device is set up on.
 // Traffic is routed to this TUN device.
 func (c *Client) TUNAddress() net.IP {
+	c.cfgMu.RLock()
+	defer c.cfgMu.RUnlock()
+
 	return c.cfg.TUNAddress.IP
 }
 
+// CurrentConfig returns a copy of the effective configuration, with defaults applied.
+// It is safe to call concurrently with the running Client, modifying the copy does not affect the Client.
+func (c *Client) CurrentConfig() Config {
+	c.cfgMu.RLock()
+	cfg := c.cfg.clone()
+	c.cfgMu.RUnlock()
+
+	if c.router != nil {
+		gateway := c.router.Gateway()
+		cfg.GatewayIP = &gateway
+	}
+
+	return cfg
+}
+
 // InboundProxy returns proxy address initialized by XRay core.
 // Traffic from TUN device is routed to this proxy.
 func (c *Client) InboundProxy() Proxy {
+	c.cfgMu.RLock()
+	defer c.cfgMu.RUnlock()
+
 	return *c.cfg.InboundProxy
 }
 
 // Connect creates a global tunnel and routes all incoming connections (or traffic specified in Config.RoutesToTUN)
-// to the VPN server via newly created defaultInboundProxy.
+// to the VPN server via newly created defaultInboundProxy. ErrAlreadyConnected is returned if the client is
+// connected already, Disconnect it first or use SwitchLink. Other failures match one of ErrInvalidLink,
+// ErrServerUnresolvable, ErrXrayStart, ErrTUNCreate, ErrRouteInstall and ErrPermission with errors.Is.
+//
+// If Config.TUNFileDescriptor is set, the device is not created and no routes are added, see ConnectWithTUN.
 func (c *Client) Connect(link string) error {
-	var err error
-	c.cfg.Logger.Debug("Connecting to tunnel", "cfg", c.cfg)
+	return c.ConnectContext(context.Background(), link)
+}
+
+// ConnectContext is Connect which gives up once ctx is done or after Config.ConnectTimeout, whichever comes first.
+// XRay instance, the TUN device and routes set up by then are removed, and the error of ctx is returned.
+func (c *Client) ConnectContext(ctx context.Context, link string) error {
+	if c.cfg.TUNFileDescriptor > 0 {
+		return c.connect(ctx, link, newFDTunnel(c.cfg.TUNFileDescriptor))
+	}
 
-	c.xInst, c.xCfg, err = c.createXrayProxy(link)
+	return c.connect(ctx, link, nil)
+}
+
+// connect connects to the server of the link and pipes traffic of the TUN device through it.
+// If external is nil, the device and its routes are set up by the Client.
+func (c *Client) connect(ctx context.Context, link string, external io.ReadWriteCloser) (err error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.running() {
+		return ErrAlreadyConnected
+	}
+
+	span := c.cfg.Tracer.Start("connect", "engine", c.cfg.Engine, "external_tun", external != nil)
+	defer func() { span.End(err) }()
+	c.cfg.Logger.Debug("Connecting to tunnel", "cfg", redactClientConfig(c.cfg))
+	if c.cfg.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.ConnectTimeout)
+		defer cancel()
+	}
+
+	step := span.Start("preflight")
+	err = c.preflight(external != nil)
+	step.End(err)
 	if err != nil {
-		c.cfg.Logger.Error("xray core creation failed", "err", err, "xray_config", c.xCfg)
+		return fmt.Errorf("preflight: %w", err)
+	}
 
-		return fmt.Errorf("create xray core instance: %w", err)
+	fdLimit, err := raiseFDLimit(c.cfg.FDLimit)
+	if err != nil {
+		c.cfg.Logger.Warn("raising open files limit failed", "err", err)
 	}
-	c.cfg.Logger.Debug("xray core instance created", "xray_config", c.xCfg)
+	c.cfg.Logger.Debug("open files limit set", "limit", fdLimit)
+	c.recoverDNS()
 
-	c.cfg.Logger.Debug("starting xray core instance")
-	if err = c.xInst.Start(); err != nil {
+	// Whatever is set up is undone if a later step fails or ctx is done.
+	var undo undoStack
+	fail := func(err error) error {
+		if rollbackErr := undo.rollback(); rollbackErr != nil {
+			c.cfg.Logger.Warn("undoing partial connection failed", "err", rollbackErr)
+		}
+
+		return err
+	}
+
+	var server net.IP
+	step = span.Start("xray.start")
+	if c.xInst, c.xCfg, server, err = c.startXray(ctx, link); err != nil {
+		step.End(err)
+
+		return err
+	}
+	step.Set("server", server.String(), "protocol", c.xCfg.Protocol)
+	c.setXrayRoute(c.xInst)
+	undo.push(c.xInst.Close)
+	err = c.waitInbound(ctx)
+	step.End(err)
+	if err != nil {
 		c.cfg.Logger.Error("xray core instance startup failed", "err", err)
 
-		return fmt.Errorf("start xray core instance: %w", err)
+		return fail(fmt.Errorf("start xray core instance: %w", err))
 	}
-	time.Sleep(100 * time.Millisecond) // Sometimes XRay instance should have a bit more time to set up.
 	c.cfg.Logger.Debug("xray core instance started")
+	if c.exposesInbound() {
+		if c.gate, err = listenInboundGate(c.cfg.InboundProxy.String(), c.xrayInbound().String(),
+			c.cfg.InboundAllow, c.cfg.Logger); err != nil {
+			c.cfg.Logger.Error("exposing inbound proxy failed", "err", err)
+
+			return fail(fmt.Errorf("expose inbound proxy: %w", err))
+		}
+		undo.push(c.closeInboundGate)
+	}
+
+	c.externalTUN = external != nil
+	switch {
+	case c.externalTUN:
+		c.tunnel, c.tunName = external, ""
+		c.cfg.Logger.Debug("using external TUN device")
+	case c.cfg.Engine == EngineTProxy:
+		if err = c.setupTransparent(server); err == nil {
+			transparent := c.transparent
+			undo.push(func() error {
+				c.tunMu.Lock()
+				defer c.tunMu.Unlock()
+
+				return errors.Join(transparent.ln.Close(), c.closeTransparent())
+			})
+		}
+	default:
+		err = c.setupTunnelRoutes(ctx, server, &undo, span)
+	}
+	if err == nil && ctx.Err() != nil {
+		err = fmt.Errorf("set up routes: %w", ctx.Err())
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if c.transparent != nil {
+		c.startTransparent()
+	} else {
+		c.tunnel = c.traffic.Wrap(c.shapeTunnel(c.chaosTunnel(c.clampTunnel(c.captureTunnel(c.tunnel)))))
+		c.setDNS()
+		if c.cfg.ShareLAN && !c.externalTUN {
+			c.shareLAN()
+		}
+		c.startPipe()
+	}
 
+	var monitorCtx context.Context
+	monitorCtx, c.stopMonitors = context.WithCancel(context.Background())
+	c.startMonitor(monitorCtx, c.monitorFDs)
+	if c.cfg.HealthCheckInterval > 0 {
+		c.startMonitor(monitorCtx, c.watchHealth)
+	}
+	if c.cfg.QualityProbeInterval > 0 {
+		c.startMonitor(monitorCtx, c.watchQuality)
+	}
+	if c.cfg.TunnelStallTimeout > 0 {
+		c.startMonitor(monitorCtx, c.watchStall)
+	}
+	if len(c.cfg.Metrics.Exporters) > 0 {
+		c.startMonitor(monitorCtx, func(ctx context.Context) {
+			metrics.Run(ctx, c, c.cfg.Metrics.Interval, c.cfg.Metrics.Exporters, c.cfg.Logger)
+		})
+	}
+	// Routing of the external device is managed by its owner, and it can not be reopened by the Client.
+	if !c.externalTUN && c.transparent == nil {
+		c.startMonitor(monitorCtx, c.watchRoutes)
+		if c.cfg.TUNStallTimeout > 0 {
+			c.startMonitor(monitorCtx, c.watchTunnel)
+		}
+		if c.cfg.NetworkManager && nmAvailable() {
+			c.startMonitor(monitorCtx, c.watchNetworkManager)
+		}
+		if c.cfg.Chaos.GatewayChange > 0 {
+			c.startMonitor(monitorCtx, c.watchChaos)
+		}
+	}
+	startupMem := memSys()
+	c.tunMu.Lock()
+	c.connectedAt = time.Now()
+	c.startupMem = startupMem
+	c.link = link
+	c.tunMu.Unlock()
+	c.cfg.Logger.Debug("client connected")
+	c.logSettings(server)
+	c.emit(observe.EventConnected, "server", server.String())
+	if p, ok := c.pipe.(interface{ traceFirstFlow(*tracing.Span) }); ok && c.transparent == nil {
+		p.traceFirstFlow(span.Start("first_flow"))
+	}
+
+	return nil
+}
+
+// setupTunnelRoutes creates the TUN device, routes traffic to it and adds the route exception for XRay server.
+// Each change is pushed to undo, the steps are traced as children of span.
+func (c *Client) setupTunnelRoutes(ctx context.Context, server net.IP, undo *undoStack, span *tracing.Span) (err error) {
 	c.cfg.Logger.Debug("Setting up TUN device")
+	// The new TUN takes the same routes, so the block left by the previous connection must go first.
+	c.tunMu.Lock()
+	err = c.release()
+	c.tunMu.Unlock()
+	if err != nil {
+		c.cfg.Logger.Warn("releasing traffic block failed", "err", err)
+	}
+	step := span.Start("mtu.adjust")
+	c.adjustMTU(ctx, server)
+	step.Set("mtu", c.mtu)
+	step.End(nil)
 	// Create TUN and route all traffic to it.
-	c.tunnel, err = c.setupTunnel()
+	// Failed attempt leaves nothing behind, the routes added to the device go away with it.
+	var ifc *tun.Interface
+	step = span.Start("tun.create")
+	err = c.cfg.Retry.do(ctx, func() (err error) {
+		ifc, err = c.setupTunnel()
+
+		return err
+	})
+	if err == nil {
+		step.Set("name", ifc.Name())
+	}
+	step.End(err)
 	if err != nil {
 		c.cfg.Logger.Error("TUN creation failed", "err", err)
 
 		return fmt.Errorf("setup TUN device: %w", err)
 	}
-	c.tunnel = newReaderMetrics(c.tunnel)
+	c.tunnel = ifc
+	undo.push(func() error {
+		c.router.ReleaseTUN()
+
+		return ifc.Close() // Routes to the device go away with it.
+	})
 	c.cfg.Logger.Debug("TUN device created")
 
+	step = span.Start("routes.install")
+	defer func() { step.End(err) }()
 	c.cfg.Logger.Debug("adding routes for TUN device")
 	// Set XRay remote address to be routed through the default gateway, so that we don't get a loop.
-	_ = c.routes.Delete(c.xrayToGatewayRoute()) // In case previous run failed.
-	c.cfg.Logger.Debug("deleted dangling routes")
-	err = c.routes.Add(c.xrayToGatewayRoute())
+	err = c.cfg.Retry.do(ctx, func() error { return c.router.AddServerRoute(server) })
 	if err != nil {
-		c.cfg.Logger.Error("routing xray server IP to default route failed", "err", err, "route", c.xrayToGatewayRoute())
+		c.cfg.Logger.Error("routing xray server IP to default route failed", "err", err, "server", server)
 
-		return fmt.Errorf("add xray server route exception: %w", err)
+		return withKind(ErrRouteInstall, fmt.Errorf("add xray server route exception: %w", err))
 	}
+	undo.push(c.router.DeleteServerRoute)
 	c.cfg.Logger.Debug("routing xray server IP to default route")
+	if err = c.addPolicyRules(); err != nil {
+		c.cfg.Logger.Error("adding policy routing rules failed", "err", err)
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	var ctx context.Context
-	ctx, c.stopTunnel = context.WithCancel(context.Background())
-	go func() {
-		wg.Done()
-		c.tunnelStopped <- c.pipe.Copy(ctx, c.tunnel, c.cfg.InboundProxy.String())
-		c.cfg.Logger.Debug("tunnel pipe closed", "err", err)
-	}()
-	wg.Wait()
-	c.cfg.Logger.Debug("client connected")
+		return withKind(ErrRouteInstall, fmt.Errorf("add policy routing rules: %w", err))
+	}
+	undo.push(func() error {
+		c.tunMu.Lock()
+		defer c.tunMu.Unlock()
+
+		return c.deletePolicyRules()
+	})
+	if len(c.cfg.ExcludeRoutes) > 0 {
+		if err = c.cfg.Retry.do(ctx, func() error { return c.router.AddBypassRoutes(c.cfg.ExcludeRoutes) }); err != nil {
+			c.cfg.Logger.Error("routing excluded routes to default route failed", "err", err, "routes", c.cfg.ExcludeRoutes)
+
+			return withKind(ErrRouteInstall, fmt.Errorf("add excluded routes: %w", err))
+		}
+		undo.push(func() error { return c.router.DeleteBypassRoutes(c.cfg.ExcludeRoutes) })
+	}
+	if c.cfg.BypassBridges {
+		c.bypassBridges()
+		undo.push(c.router.DeleteLinkRoutes)
+	}
+	if c.exposesInbound() {
+		c.bypassInboundClients()
+		undo.push(func() error {
+			err := c.router.DeleteBypassRoutes(c.gateRoutes)
+			c.gateRoutes = nil
+
+			return err
+		})
+	}
 
 	return nil
 }
 
+// running reports whether the client is connected or started with StartProxyOnly.
+func (c *Client) running() bool {
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
+
+	return c.stopTunnel != nil
+}
+
+// startMonitor runs monitor in a goroutine till ctx is done, Disconnect waits for it to return.
+func (c *Client) startMonitor(ctx context.Context, monitor func(context.Context)) {
+	c.monitors.Add(1)
+	go func() {
+		defer c.monitors.Done()
+		monitor(ctx)
+	}()
+}
+
 // Disconnect stops all listeners and cleans up route for XRay server.
 //
 // It will block till all resources are done processing or
-// context is cancelled (method also enforces timeout of disconnectTimeout)
-func (c *Client) Disconnect(ctx context.Context) error {
-	if c.stopTunnel == nil {
-		return nil // not connected
+// context is cancelled (method also enforces timeout of disconnectTimeout).
+// Calling it on the client which is not connected does nothing. Failed cleanup steps are not retried
+// by the next call, the client may be connected again right away.
+func (c *Client) Disconnect(ctx context.Context) (err error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if !c.running() {
+		return nil
+	}
+
+	span := c.cfg.Tracer.Start("disconnect")
+	defer func() { span.End(err) }()
+
+	if c.stopMonitors != nil {
+		c.stopMonitors()
 	}
+	// Monitors may be restarting XRay or reopening the TUN device, which must not outlive the teardown.
+	c.monitors.Wait()
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
 
+	c.connectedAt = time.Time{}
 	c.stopTunnel()
-	err := errors.Join(c.xInst.Close(), c.tunnel.Close(), c.routes.Delete(c.xrayToGatewayRoute()))
+	c.stopTunnel, c.stopMonitors = nil, nil
+	err = errors.Join(c.restoreDNS(), c.closeInboundGate(), c.xInst.Close())
+	switch {
+	case c.proxyOnly:
+		c.proxyOnly = false
+	case c.transparent != nil:
+		err = errors.Join(err, c.closeTransparent())
+	case c.externalTUN:
+		err = errors.Join(err, c.closeTunnel())
+	default:
+		err = errors.Join(err, c.closeTunnel(), c.router.DeleteServerRoute(), c.router.DeleteBypassRoutes(c.cfg.ExcludeRoutes),
+			c.router.DeleteBypassRoutes(c.gateRoutes), c.router.DeleteLinkRoutes())
+		c.gateRoutes = nil
+		if c.cfg.ShareLAN {
+			err = errors.Join(err, c.sharer.Unshare())
+		}
+		if c.blocking == nil {
+			err = errors.Join(err, c.deletePolicyRules()) // Blocking TUN routes keep working by the rules.
+		}
+		c.router.ReleaseTUN()
+	}
 
 	// Waiting till the tunnel actually done with processing connections.
 	ctx, cancel := context.WithTimeout(ctx, disconnectTimeout)
@@ -270,43 +1125,109 @@ func (c *Client) Disconnect(ctx context.Context) error {
 	}
 
 	c.cfg.Logger.Debug("client disconnected")
+	c.emit(observe.EventDisconnected)
 
 	return nil
 }
 
-// BytesRead returns number of bytes read from TUN device.
+// BytesRead returns number of bytes read from TUN device. The count is kept over reconnects and
+// reopening of the device, it is safe to call at any time.
 func (c *Client) BytesRead() int {
-	if c.tunnel == nil {
-		return 0
+	return c.traffic.BytesRead()
+}
+
+// BytesWritten returns number of bytes written to TUN device. The count is kept over reconnects and
+// reopening of the device, it is safe to call at any time.
+func (c *Client) BytesWritten() int {
+	return c.traffic.BytesWritten()
+}
+
+// Stats returns a snapshot of the tunnel metrics.
+func (c *Client) Stats() observe.Stats {
+	s := observe.Stats{
+		BytesRead:    c.BytesRead(),
+		BytesWritten: c.BytesWritten(),
+	}
+	if c.flows != nil {
+		s.ActiveTCP, s.PeakTCP = c.flows.Count(observe.TCP), c.flows.Peak(observe.TCP)
+		s.ActiveUDP, s.PeakUDP = c.flows.Count(observe.UDP), c.flows.Peak(observe.UDP)
+		s.FirstPacket = c.flows.FirstPacket()
 	}
+	s.Quality = c.quality.Summary()
+	s.Footprint = c.footprint(s)
 
-	return c.tunnel.(*readerMetrics).BytesRead()
+	return s
 }
 
-// BytesWritten returns number of bytes written to TUN device.
-func (c *Client) BytesWritten() int {
-	if c.tunnel == nil {
-		return 0
+// Flows returns all TCP connections and UDP sessions currently going through the tunnel.
+func (c *Client) Flows() []observe.FlowInfo {
+	if c.flows == nil {
+		return nil
+	}
+
+	if c.cfg.ResolveProcesses {
+		c.flows.ResolveProcesses()
+	}
+	flows := c.flows.Snapshot()
+	if c.cfg.HostNames != nil {
+		for i := range flows {
+			flows[i].Host, _ = c.cfg.HostNames.Lookup(flows[i].Dst.Addr())
+		}
 	}
 
-	return c.tunnel.(*readerMetrics).BytesWritten()
+	return flows
 }
 
-// xrayToGatewayRoute is a setup to route VPN requests to gateway.
-// Used as exception to not interfere with traffic going to remote XRay instance.
-func (c *Client) xrayToGatewayRoute() route.Opts {
-	// Append "/32" to match only the XRay server route.
-	return route.Opts{Gateway: *c.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr(c.xSrvIP.String() + "/32")}}
+// TopDestinations returns up to n destinations which exchanged the most bytes through the tunnel,
+// by host name if Config.HostNames knows it. n <= 0 returns all of them.
+func (c *Client) TopDestinations(n int) []observe.Destination {
+	if c.flows == nil {
+		return nil
+	}
+
+	return c.flows.TopDestinations(n, c.cfg.HostNames)
+}
+
+// emit passes new event of type t with key-value attributes kv to Config.Observer.
+func (c *Client) emit(t observe.EventType, kv ...any) {
+	e := observe.NewEvent(t, kv...)
+	c.events.Observe(e)
+	if c.cfg.Observer != nil {
+		c.cfg.Observer.Observe(e)
+	}
+}
+
+// startPipe starts routing packets between TUN device and the inbound proxy in background.
+// The pipe is stopped with c.stopTunnel and its result is sent to c.tunnelStopped.
+func (c *Client) startPipe() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var ctx context.Context
+	ctx, c.stopTunnel = context.WithCancel(context.Background())
+	tunnel := c.tunnel // The device may be replaced while the pipe is stopping, see reopenTunnel.
+	go func() {
+		wg.Done()
+		err := c.pipe.Copy(ctx, tunnel, c.xrayInbound().String())
+		c.tunnelStopped <- err
+		c.cfg.Logger.Debug("tunnel pipe closed", "err", err)
+	}()
+	wg.Wait()
 }
 
 // createXrayProxy creates XRay instance from connection link with additional proxy listening on {addr}:{port}.
-func (c *Client) createXrayProxy(link string) (xrayproto.Instance, *xrayproto.GeneralConfig, error) {
+// It returns resolved address of XRay server along with the instance.
+func (c *Client) createXrayProxy(ctx context.Context, link string) (xrayproto.Instance, *xrayproto.GeneralConfig, net.IP, error) {
+	return c.createXrayProxyOn(ctx, link, c.xrayInbound())
+}
+
+// createXrayProxyOn is createXrayProxy with the inbound listening on addr instead of the current XRay inbound.
+func (c *Client) createXrayProxyOn(ctx context.Context, link string, addr *Proxy) (xrayproto.Instance, *xrayproto.GeneralConfig, net.IP, error) {
 	// Make the inbound for local proxy.
 	// We will later use it to redirect all traffic from TUN device to this proxy.
 	inbound := &xray.Socks{
 		Remark:  "GoXRay-TUN-Listener",
-		Address: c.cfg.InboundProxy.IP.String(),
-		Port:    strconv.Itoa(c.cfg.InboundProxy.Port),
+		Address: addr.IP.String(),
+		Port:    strconv.Itoa(addr.Port),
 	}
 
 	svc := xray.NewXrayService(true,
@@ -315,31 +1236,56 @@ func (c *Client) createXrayProxy(link string) (xrayproto.Instance, *xrayproto.Ge
 		xray.WithInbound(inbound),
 	)
 
-	link = strings.TrimSpace(link)
-	protocol, err := svc.CreateProtocol(link)
+	protocol, cfg, err := parseLink(svc, link)
 	if err != nil {
-		return nil, nil, fmt.Errorf("invalid config: protocol create: %w", err)
+		return nil, nil, nil, err
 	}
 
-	if err := protocol.Parse(); err != nil {
-		return nil, nil, fmt.Errorf("invalid config: parse: %w", err)
+	// Validate xray proto addr, the exit server is connected through the relays of the chain.
+	var ip, pin net.IP
+	if len(c.cfg.Chain) > 0 {
+		ip, err = resolveIP(ctx, cfg.Address, c.cfg.Retry)
+	} else {
+		ip, pin, err = c.resolveFirst(ctx, cfg)
+	}
+	if err != nil {
+		return nil, nil, nil, withKind(ErrServerUnresolvable, fmt.Errorf("xray address not resolvable: %w", err))
+	}
+	// Only the upstream proxy is connected directly, if it is set.
+	var upstream net.IP
+	if c.cfg.UpstreamProxy != nil {
+		if upstream, err = upstreamIP(c.cfg.UpstreamProxy); err != nil {
+			return nil, nil, nil, err
+		}
 	}
 
-	cfg := protocol.ConvertToGeneralConfig()
+	if len(c.cfg.Chain) > 0 {
+		inst, first, err := c.createChainProxy(ctx, svc, protocol)
+		if err != nil {
+			return nil, nil, nil, err
+		}
 
-	inst, err := svc.MakeInstance(protocol)
-	if err != nil {
-		return nil, nil, fmt.Errorf("make instance: %w", err)
+		if upstream != nil {
+			first = upstream
+		}
+
+		return inst, &cfg, first, nil
+	}
+
+	if err = c.selectPort(ctx, protocol, cfg.Port, ip); err != nil {
+		return nil, nil, nil, err
 	}
+	cfg = protocol.ConvertToGeneralConfig()
 
-	// Validate xray proto addr.
-	ip, err := net.ResolveIPAddr("ip", cfg.Address)
+	inst, err := c.makeXrayInstance(svc, []xrayproto.Protocol{protocol}, pin)
 	if err != nil {
-		return nil, nil, fmt.Errorf("xray address not resolvable: %w", err)
+		return nil, nil, nil, withKind(ErrXrayStart, fmt.Errorf("make instance: %w", err))
+	}
+	if upstream != nil {
+		return inst, &cfg, upstream, nil
 	}
-	c.xSrvIP = ip
 
-	return inst, &cfg, nil
+	return inst, &cfg, ip, nil
 }
 
 // xRayLogLevel maps slog.Level to xray core log level (xcommlog.Severity) by checking Config.Logger level.
@@ -361,22 +1307,57 @@ func xRayLogLevel(h slog.Handler) xcommlog.Severity {
 
 // setupTunnel creates new TUN interface in the system and routes all traffic to it.
 func (c *Client) setupTunnel() (*tun.Interface, error) {
-	ifc, err := tun.New("", 1500)
+	ifc, err := c.createTunnel()
+	if err != nil {
+		return nil, err
+	}
+
+	// Routes may be changed at runtime, see AddRoute.
+	c.cfgMu.RLock()
+	err = c.router.AddTUNRoutes(ifc.Name(), c.cfg.RoutesToTUN)
+	c.cfgMu.RUnlock()
+	if err != nil {
+		_ = ifc.Close() // Routes added before the failure go away with it.
+
+		return nil, withKind(ErrRouteInstall, fmt.Errorf("add route: %w", err))
+	}
+	c.tunName = ifc.Name()
+
+	return ifc, nil
+}
+
+// createTunnel creates the TUN device and brings it up without routing anything to it.
+func (c *Client) createTunnel() (*tun.Interface, error) {
+	ifc, err := tun.New("", c.mtu)
 	if err != nil {
-		return nil, fmt.Errorf("create tun: %w", err)
+		return nil, withKind(ErrTUNCreate, fmt.Errorf("create tun: %w", err))
 	}
 
 	if err = ifc.Up(c.cfg.TUNAddress, c.cfg.TUNAddress.IP); err != nil {
-		return nil, fmt.Errorf("setup interface: %w", err)
+		_ = ifc.Close()
+
+		return nil, withKind(ErrTUNCreate, fmt.Errorf("setup interface: %w", err))
 	}
 
-	if err = c.routes.Add(route.Opts{IfName: ifc.Name(), Routes: c.cfg.RoutesToTUN}); err != nil {
-		return nil, fmt.Errorf("add route: %w", err)
+	if c.cfg.NetworkManager && nmAvailable() {
+		if err = nmSetUnmanaged(ifc.Name()); err != nil {
+			c.cfg.Logger.Warn("marking TUN device unmanaged by NetworkManager failed", "err", err)
+		}
 	}
 
 	return ifc, nil
 }
 
+// newTUNAddress returns the next default TUN address, starting with defaultTUNAddress, so that TUN devices
+// of Clients in one process and the DNS forwarders listening on them do not clash.
+func newTUNAddress() *net.IPNet {
+	n := tunAddresses.Add(1) - 1
+	ip := binary.BigEndian.Uint32(defaultTUNAddress.IP.To4()) + n%(1<<17-2) // Within 192.18.0.0/15.
+
+	return &net.IPNet{IP: binary.BigEndian.AppendUint32(nil, ip), Mask: slices.Clone(defaultTUNAddress.Mask)}
+}
+
+// getFreePort returns a port of localhost which is free at the moment, it may be taken before it is bound.
 func getFreePort() int {
 	ln, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
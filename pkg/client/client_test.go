@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 	"go.uber.org/mock/gomock"
 
 	"github.com/goxray/tun/pkg/client/mocks"
+	"github.com/goxray/tun/pkg/observe"
 )
 
 func TestConnect_InvalidLink(t *testing.T) {
@@ -28,9 +31,65 @@ func TestConnect_InvalidLink(t *testing.T) {
 
 	err := cl.Connect("invalid_link")
 	require.ErrorContains(t, err, "invalid config: protocol create:")
+	require.ErrorIs(t, err, ErrInvalidLink)
 
 	err = cl.Connect("vless://example.com") // no port
 	require.ErrorContains(t, err, "invalid config: parse:")
+	require.ErrorIs(t, err, ErrInvalidLink)
+	require.NotErrorIs(t, err, ErrServerUnresolvable)
+}
+
+func TestConnect_Timeout(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	socks := &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: freePort(t)}
+	cl, err := NewClientWithOpts(Config{
+		GatewayIP:      &gw,
+		InboundProxy:   socks,
+		ConnectTimeout: time.Nanosecond,
+		Logger:         slog.New(slog.DiscardHandler),
+	})
+	require.NoError(t, err)
+	tunMock := mocks.NewMockioReadWriteCloser(gomock.NewController(t))
+
+	link := "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:9?type=tcp"
+	require.ErrorIs(t, cl.connect(context.Background(), link, tunMock), context.DeadlineExceeded)
+	require.Equal(t, StateDisconnected, cl.Status().State)
+	_, err = net.Dial("tcp", socks.String())
+	require.Error(t, err, "xray is not left running")
+
+	cl.cfg.ConnectTimeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, cl.connect(ctx, link, tunMock), context.Canceled)
+}
+
+func TestNewClientWithOpts_Instances(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	link := "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:9?type=tcp"
+	var clients []*Client
+	for range 2 {
+		cl, err := NewClientWithOpts(Config{GatewayIP: &gw, Logger: slog.New(slog.DiscardHandler)})
+		require.NoError(t, err)
+		require.NoError(t, cl.StartProxyOnly(link))
+		clients = append(clients, cl)
+	}
+
+	first, second := clients[0].cfg, clients[1].cfg
+	require.NotEqual(t, first.InboundProxy.Port, second.InboundProxy.Port)
+	require.False(t, first.TUNAddress.IP.Equal(second.TUNAddress.IP))
+	for _, cl := range clients {
+		conn, err := net.Dial("tcp", cl.cfg.InboundProxy.String())
+		require.NoError(t, err, "every client runs its own XRay instance")
+		require.NoError(t, conn.Close())
+		require.NoError(t, cl.Disconnect(context.Background()))
+	}
+}
+
+func TestNewTUNAddress(t *testing.T) {
+	first, second := newTUNAddress(), newTUNAddress()
+	require.Equal(t, net.IPv4len, len(first.IP))
+	require.Equal(t, defaultTUNAddress.Mask, first.Mask)
+	require.Equal(t, second.IP.To4()[3], first.IP.To4()[3]+1)
 }
 
 func TestDisconnect_NonConnected(t *testing.T) {
@@ -138,6 +197,81 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 	}
 }
 
+func TestDisconnect_Concurrent(t *testing.T) {
+	xInstMock := mocks.NewMockrunnable(gomock.NewController(t))
+	routesMock := mocks.NewMockipTable(gomock.NewController(t))
+	tunMock := mocks.NewMockioReadWriteCloser(gomock.NewController(t))
+
+	cl := newTestClient(xInstMock, tunMock, routesMock, nil, func(stopped chan error) { stopped <- nil })
+
+	// Resources are released once however many times Disconnect is called.
+	xInstMock.EXPECT().Close().Return(nil)
+	tunMock.EXPECT().Close().Return(nil)
+	mockSuccessDisconnectIP(t, cl, routesMock)
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, cl.Disconnect(context.Background()))
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, cl.Disconnect(context.Background()))
+}
+
+func TestDisconnect_WaitsForMonitors(t *testing.T) {
+	xInstMock := mocks.NewMockrunnable(gomock.NewController(t))
+	routesMock := mocks.NewMockipTable(gomock.NewController(t))
+	tunMock := mocks.NewMockioReadWriteCloser(gomock.NewController(t))
+
+	cl := newTestClient(xInstMock, tunMock, routesMock, nil, func(stopped chan error) { stopped <- nil })
+	var monitorCtx context.Context
+	monitorCtx, cl.stopMonitors = context.WithCancel(context.Background())
+	var done atomic.Bool
+	cl.startMonitor(monitorCtx, func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond) // E.g. restarting XRay when cancelled.
+		done.Store(true)
+	})
+
+	xInstMock.EXPECT().Close().DoAndReturn(func() error {
+		require.True(t, done.Load(), "XRay is closed once monitors are done")
+
+		return nil
+	})
+	tunMock.EXPECT().Close().Return(nil)
+	mockSuccessDisconnectIP(t, cl, routesMock)
+	require.NoError(t, cl.Disconnect(context.Background()))
+}
+
+func TestReplaceXray_NotConnected(t *testing.T) {
+	// No routes are touched once the client is disconnected.
+	cl := newTestClient(nil, nil, mocks.NewMockipTable(gomock.NewController(t)), nil, nil)
+	_, err := cl.replaceXray("vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.4:443?type=tcp")
+	require.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestDisconnect_BlockPolicy(t *testing.T) {
+	xInstMock := mocks.NewMockrunnable(gomock.NewController(t))
+	routesMock := mocks.NewMockipTable(gomock.NewController(t))
+	tunMock := mocks.NewMockioReadWriteCloser(gomock.NewController(t))
+
+	cl := newTestClient(xInstMock, tunMock, routesMock, nil, func(stopped chan error) { stopped <- nil })
+	cl.cfg.OnDownPolicy = DownPolicyBlock
+
+	xInstMock.EXPECT().Close().Return(nil)
+	mockSuccessDisconnectIP(t, cl, routesMock)
+	require.NoError(t, cl.Disconnect(context.Background()))
+	require.True(t, cl.Blocking())
+
+	// TUN device is closed only once released.
+	tunMock.EXPECT().Close().Return(nil)
+	require.NoError(t, cl.Release())
+	require.False(t, cl.Blocking())
+	require.NoError(t, cl.Release())
+}
+
 func newTestClient(xInst runnable, tun io.ReadWriteCloser, routes ipTable, pipe pipe, stopTunnel func(chan error)) *Client {
 	expGateway := &net.IP{127, 0, 0, 2}
 	expProxy := &Proxy{IP: net.IP{127, 0, 0, 1}, Port: 10234}
@@ -152,11 +286,12 @@ func newTestClient(xInst runnable, tun io.ReadWriteCloser, routes ipTable, pipe
 		tunnelStopped: make(chan error),
 		xInst:         xInst,
 		tunnel:        tun,
-		routes:        routes,
+		router:        newRouter(routes, *expGateway),
 		pipe:          pipe,
 		xCfg:          expGeneralConfig,
-		xSrvIP:        &net.IPAddr{IP: net.ParseIP("127.0.0.3")},
+		mtu:           defaultMTU,
 	}
+	cl.router.server = net.ParseIP(expGeneralConfig.Address)
 	if stopTunnel != nil {
 		cl.stopTunnel = func() {
 			go func() {
@@ -178,3 +313,67 @@ func mockSuccessDisconnectIP(t *testing.T, cl *Client, ip *mocks.MockipTable) {
 		return nil
 	})
 }
+
+func TestCurrentConfig(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	cl := &Client{cfg: Config{
+		GatewayIP:    &gw,
+		InboundProxy: &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 10808},
+		TUNAddress:   defaultTUNAddress,
+		RoutesToTUN:  DefaultRoutesToTUN,
+		UDPTimeout:   defaultUDPTimeout,
+	}, router: newRouter(nil, gw)}
+
+	cfg := cl.CurrentConfig()
+	require.Equal(t, cl.cfg, cfg)
+
+	(*cfg.GatewayIP)[15] = 2
+	cfg.InboundProxy.Port = 1
+	cfg.TUNAddress.IP[15] = 2
+	cfg.RoutesToTUN[0].IP[0] = 10
+	require.Equal(t, "192.168.1.1", cl.GatewayIP().String())
+	require.Equal(t, 10808, cl.InboundProxy().Port)
+	require.Equal(t, "192.18.0.1", cl.TUNAddress().String())
+	require.Equal(t, "0.0.0.0/1", DefaultRoutesToTUN[0].String())
+}
+
+func TestBytesCounters_TunnelReplaced(t *testing.T) {
+	cl := &Client{}
+	done := make(chan struct{})
+	go func() { // Stats readers run concurrently with the data plane being rebuilt.
+		defer close(done)
+		for range 100 {
+			_ = cl.Stats()
+		}
+	}()
+
+	for range 2 {
+		tunMock := mocks.NewMockioReadWriteCloser(gomock.NewController(t))
+		tunMock.EXPECT().Read(gomock.Any()).Return(10, nil).Times(3)
+		tunMock.EXPECT().Write(gomock.Any()).Return(20, nil)
+
+		cl.tunMu.Lock()
+		cl.tunnel = cl.traffic.Wrap(tunMock)
+		cl.tunMu.Unlock()
+		for range 3 {
+			_, _ = cl.tunnel.Read(nil)
+		}
+		_, _ = cl.tunnel.Write(nil)
+	}
+	<-done
+
+	require.Equal(t, 60, cl.BytesRead())
+	require.Equal(t, 40, cl.BytesWritten())
+}
+
+func TestClient_Logs(t *testing.T) {
+	cl := &Client{cfg: Config{Logger: slog.New(slog.DiscardHandler)}}
+	require.Nil(t, cl.Logs(0))
+
+	cl.cfg.Logger = slog.New(observe.NewLogRing(slog.DiscardHandler, 10))
+	cl.cfg.Logger.With("server", "example.com").Info("connected")
+	logs := cl.Logs(0)
+	require.Len(t, logs, 1)
+	require.Equal(t, "connected", logs[0].Message)
+	require.Equal(t, "server=example.com", logs[0].Attrs)
+}
@@ -0,0 +1,288 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features"
+	"github.com/xtls/xray-core/features/routing"
+	routingsession "github.com/xtls/xray-core/features/routing/session"
+
+	"github.com/goxray/tun/pkg/observe"
+	"github.com/goxray/tun/pkg/tracing"
+)
+
+const (
+	// maxSniffSize is the most data of a flow the host name is looked for in.
+	maxSniffSize = 4096
+	// maxSniffWrites is the most writes of a flow the host name is looked for in.
+	maxSniffWrites = 4
+)
+
+// Outcomes of flows in the connection log, see Config.ConnectionLog.
+const (
+	outcomeReached     = "reached"     // The server sent data.
+	outcomeUnreachable = "unreachable" // XRay closed the flow with no data.
+	outcomeClosed      = "closed"      // The app closed the flow before any data was received.
+	outcomeOpened      = "opened"      // UDP session was dialed, UDP has no replies to wait for.
+	outcomeRejected    = "rejected"    // The flow was refused, e.g. over the limit or while the server is unreachable.
+	outcomeFailed      = "failed"      // Dialing XRay failed.
+)
+
+// xrayRoute holds the router of the running XRay instance, it is nil before the first one starts.
+type xrayRoute struct {
+	routing.Router
+}
+
+// setXrayRoute keeps the router of inst for the connection log, flows are routed by it from now on.
+func (c *Client) setXrayRoute(inst runnable) {
+	var r xrayRoute
+	if f, ok := inst.(interface{ GetFeature(any) features.Feature }); ok {
+		r.Router, _ = f.GetFeature(routing.RouterType()).(routing.Router)
+	}
+	c.xRoute.Store(r)
+}
+
+// pickRoute returns the XRay outbound the flow to dst is sent to and the tag of the routing rule which picked it,
+// see Config.XRayExtra. The rule is empty for the default outbound or a rule with no tag.
+func (c *Client) pickRoute(network observe.Network, dst netip.AddrPort) (outbound, rule string) {
+	r, _ := c.xRoute.Load().(xrayRoute)
+	if r.Router == nil {
+		return "", ""
+	}
+
+	target := xnet.TCPDestination(xnet.IPAddress(dst.Addr().AsSlice()), xnet.Port(dst.Port()))
+	if network == observe.UDP {
+		target.Network = xnet.Network_UDP
+	}
+	// XRay routes flows of the pipe by the address, the socks inbound does not sniff domains.
+	route, err := r.PickRoute(&routingsession.Context{Outbound: &session.Outbound{Target: target}})
+	if err != nil {
+		return exitTag, ""
+	}
+
+	return route.GetOutboundTag(), route.GetRuleTag()
+}
+
+// flowLog is an entry of the connection log, it is written once the outcome of the flow is known.
+// The flow is traced the same way with Config.Tracer.
+type flowLog struct {
+	logger  *slog.Logger // nil if only traced.
+	span    *tracing.Span
+	first   *tracing.Span // Span of the first flow after connecting, see flowPipe.traceFirstFlow.
+	network observe.Network
+	src     netip.AddrPort
+	dst     netip.AddrPort
+	started time.Time
+	route   func(observe.Network, netip.AddrPort) (string, string)
+
+	once sync.Once
+	mu   sync.Mutex // mu guards host.
+	host string     // TLS SNI or HTTP Host sent by the app.
+}
+
+// newFlowLog returns the log entry of the flow, or nil if the connection log and tracing are disabled.
+func (p *flowPipe) newFlowLog(network observe.Network, src, dst netip.AddrPort) *flowLog {
+	if p.opts.ConnLog == nil && p.opts.Tracer == nil {
+		return nil
+	}
+
+	return &flowLog{logger: p.opts.ConnLog, span: p.opts.Tracer.Start("flow"), first: p.firstFlow.Swap(nil),
+		network: network, src: src, dst: dst, started: time.Now(), route: p.opts.Route}
+}
+
+// done writes the entry with outcome, once, err may be nil. It does nothing on nil entry.
+func (l *flowLog) done(outcome string, err error) {
+	if l == nil {
+		return
+	}
+
+	l.once.Do(func() {
+		l.mu.Lock()
+		host := l.host
+		l.mu.Unlock()
+		attrs := []any{"network", l.network, "src", l.src, "dst", l.dst}
+		if host != "" {
+			attrs = append(attrs, "host", host)
+		}
+		if l.route != nil {
+			outbound, rule := l.route(l.network, l.dst)
+			attrs = append(attrs, "outbound", outbound)
+			if rule != "" {
+				attrs = append(attrs, "rule", rule)
+			}
+		}
+		attrs = append(attrs, "outcome", outcome)
+		l.span.Set(attrs...)
+		l.span.End(err)
+		l.first.Set(attrs...)
+		l.first.End(err)
+		if l.logger == nil {
+			return
+		}
+		attrs = append(attrs, "after", time.Since(l.started).Round(time.Millisecond))
+		if err != nil {
+			attrs = append(attrs, "err", err)
+		}
+		l.logger.Info("connection", attrs...)
+	})
+}
+
+// sniffedConn takes the host name of the flow from the first data the app writes and logs
+// the flow closed if it is closed before its outcome is known.
+type sniffedConn struct {
+	net.Conn
+
+	log     *flowLog
+	buf     []byte // Data written so far, the host may be split across writes, e.g. a ClientHello over segments.
+	writes  int
+	sniffed bool
+}
+
+func (c *sniffedConn) Write(p []byte) (int, error) {
+	if !c.sniffed {
+		c.buf = append(c.buf, p[:min(len(p), maxSniffSize-len(c.buf))]...)
+		c.writes++
+		if host := sniffHost(c.buf); host != "" || len(c.buf) >= maxSniffSize || c.writes >= maxSniffWrites {
+			c.log.mu.Lock()
+			c.log.host = host
+			c.log.mu.Unlock()
+			c.sniffed, c.buf = true, nil
+		}
+	}
+
+	return c.Conn.Write(p)
+}
+
+func (c *sniffedConn) Close() error {
+	c.log.done(outcomeClosed, nil)
+
+	return c.Conn.Close()
+}
+
+func (c *sniffedConn) CloseWrite() error { return halfCloseWrite(c.Conn) }
+
+// sniffHost returns the server name of TLS ClientHello or the Host header of HTTP request at the start of b,
+// or empty string if b is neither or they are not complete.
+func sniffHost(b []byte) string {
+	if host := sniffSNI(b); host != "" {
+		return host
+	}
+
+	return sniffHTTPHost(b)
+}
+
+// sniffSNI returns the server_name extension of TLS ClientHello record at the start of b.
+func sniffSNI(b []byte) string {
+	// Record header: type 22 (handshake), version, length. Handshake header: type 1 (ClientHello), length.
+	if len(b) < 9 || b[0] != 0x16 || b[5] != 0x01 {
+		return ""
+	}
+	r := tlsReader(b[9:])
+	r.skip(2 + 32)          // Client version and random.
+	r.skip(int(r.uint8()))  // Session ID.
+	r.skip(int(r.uint16())) // Cipher suites.
+	r.skip(int(r.uint8()))  // Compression methods.
+	exts := r.next(int(r.uint16()))
+	for len(exts) >= 4 {
+		typ, size := binary.BigEndian.Uint16(exts), int(binary.BigEndian.Uint16(exts[2:]))
+		exts = exts[4:]
+		if size > len(exts) {
+			return ""
+		}
+		if typ != 0 { // Not server_name.
+			exts = exts[size:]
+
+			continue
+		}
+		names := tlsReader(exts[:size])
+		names.skip(2) // List length.
+		for len(names) >= 3 {
+			nameType, name := names.uint8(), names.next(int(names.uint16()))
+			if nameType == 0 { // host_name
+				return string(name)
+			}
+		}
+
+		return ""
+	}
+
+	return ""
+}
+
+// tlsReader reads fields of TLS handshake, reading past the end yields zeros and empty slices.
+type tlsReader []byte
+
+func (r *tlsReader) next(n int) []byte {
+	if n > len(*r) {
+		*r = nil
+
+		return nil
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+
+	return b
+}
+
+func (r *tlsReader) skip(n int) {
+	r.next(n)
+}
+
+func (r *tlsReader) uint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (r *tlsReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+
+	return 0
+}
+
+// httpMethods are the methods HTTP requests sniffed by sniffHTTPHost start with.
+var httpMethods = []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT "}
+
+// sniffHTTPHost returns the Host header, without the port, of HTTP/1 request at the start of b.
+func sniffHTTPHost(b []byte) string {
+	isHTTP := false
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(b, []byte(m)) {
+			isHTTP = true
+
+			break
+		}
+	}
+	if !isHTTP {
+		return ""
+	}
+
+	head, _, _ := bytes.Cut(b, []byte("\r\n\r\n"))
+	for _, line := range strings.Split(string(head), "\r\n")[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(name, "Host") {
+			continue
+		}
+		host := strings.TrimSpace(value)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		return host
+	}
+
+	return ""
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+
+	"github.com/goxray/tun/pkg/observe"
+	"github.com/goxray/tun/pkg/tracing"
+)
+
+// clientHello returns TLS ClientHello record sent by crypto/tls for serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go func() {
+		_ = tls.Client(local, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	}()
+
+	header := make([]byte, 5)
+	_, err := io.ReadFull(remote, header)
+	require.NoError(t, err)
+	body := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	_, err = io.ReadFull(remote, body)
+	require.NoError(t, err)
+	_ = local.Close()
+
+	return append(header, body...)
+}
+
+func TestSniffHost(t *testing.T) {
+	hello := clientHello(t, "example.com")
+	require.Equal(t, "example.com", sniffHost(hello))
+	require.Empty(t, sniffHost(hello[:len(hello)/2]), "truncated ClientHello")
+	require.Empty(t, sniffHost(clientHello(t, "")))
+
+	require.Equal(t, "example.org", sniffHost([]byte("GET / HTTP/1.1\r\nUser-Agent: curl\r\nhost: example.org:8080\r\n\r\n")))
+	require.Empty(t, sniffHost([]byte("GET / HTTP/1.1\r\nUser-Agent: curl\r\n\r\nHost: body.example")))
+	require.Empty(t, sniffHost([]byte("SSH-2.0-OpenSSH_9.6\r\n")))
+	require.Empty(t, sniffHost(nil))
+}
+
+// sinkDialer returns connections to a server which reads everything and never answers.
+type sinkDialer struct{ stubDialer }
+
+func (sinkDialer) DialContext(context.Context, *M.Metadata) (net.Conn, error) {
+	local, remote := net.Pipe()
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+
+	return local, nil
+}
+
+func TestFlowDialer_ConnectionLog(t *testing.T) {
+	var buf bytes.Buffer
+	route := func(observe.Network, netip.AddrPort) (string, string) { return "direct", "lan" }
+	p := newFlowPipe(pipeOpts{ConnLog: slog.New(slog.NewJSONHandler(&buf, nil)), Route: route}, observe.NewFlowTable(),
+		nopObserver, slog.New(slog.DiscardHandler))
+	d := &flowDialer{Dialer: sinkDialer{}, pipe: p}
+	meta := &M.Metadata{Network: M.TCP, DstIP: netip.MustParseAddr("93.184.215.14"), DstPort: 443}
+
+	// ClientHello split across writes, like over several segments.
+	c, err := d.DialContext(context.Background(), meta)
+	require.NoError(t, err)
+	hello := clientHello(t, "example.com")
+	for _, part := range [][]byte{hello[:100], hello[100:]} {
+		_, err = c.Write(part)
+		require.NoError(t, err)
+	}
+	require.NoError(t, c.Close())
+
+	d.Dialer = closingDialer{}
+	c, err = d.DialContext(context.Background(), meta)
+	require.NoError(t, err)
+	_, err = c.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.NoError(t, c.Close())
+
+	var entries []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var e map[string]any
+		require.NoError(t, json.Unmarshal(line, &e))
+		entries = append(entries, e)
+	}
+	require.Len(t, entries, 2, "every flow is logged once")
+	require.Equal(t, "connection", entries[0]["msg"])
+	require.Equal(t, "93.184.215.14:443", entries[0]["dst"])
+	require.Equal(t, "example.com", entries[0]["host"])
+	require.Equal(t, "direct", entries[0]["outbound"])
+	require.Equal(t, "lan", entries[0]["rule"])
+	require.Equal(t, outcomeClosed, entries[0]["outcome"])
+	require.NotContains(t, entries[1], "host")
+	require.Equal(t, outcomeUnreachable, entries[1]["outcome"])
+}
+
+func TestFlowDialer_Tracing(t *testing.T) {
+	spans := make(chan []string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name       string
+						Attributes []struct{ Key string }
+					}
+				}
+			}
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil {
+			return
+		}
+		var got []string
+		for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+			got = append(got, s.Name)
+			for _, a := range s.Attributes {
+				got = append(got, s.Name+"."+a.Key)
+			}
+		}
+		spans <- got
+	}))
+	defer collector.Close()
+	tracer, err := tracing.NewTracer(tracing.Options{Endpoint: collector.URL}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	// Flows are traced without the connection log.
+	p := newFlowPipe(pipeOpts{Tracer: tracer}, observe.NewFlowTable(), nopObserver, slog.New(slog.DiscardHandler))
+	connect := tracer.Start("connect")
+	p.traceFirstFlow(connect.Start("first_flow"))
+	connect.End(nil)
+	d := &flowDialer{Dialer: sinkDialer{}, pipe: p}
+	meta := &M.Metadata{Network: M.TCP, DstIP: netip.MustParseAddr("93.184.215.14"), DstPort: 443}
+	for range 2 {
+		c, err := d.DialContext(context.Background(), meta)
+		require.NoError(t, err)
+		require.NoError(t, c.Close())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tracer.Run(ctx)
+	got := <-spans
+	require.Contains(t, got, "first_flow.outcome")
+	require.Contains(t, got, "flow.dst")
+	count := func(name string) int {
+		return len(slices.DeleteFunc(slices.Clone(got), func(s string) bool { return s != name }))
+	}
+	require.Equal(t, 1, count("connect"))
+	require.Equal(t, 1, count("first_flow"), "only the first flow ends it")
+	require.Equal(t, 2, count("flow"))
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/goxray/core/network/route"
+)
+
+// errDNSUnsupported is returned when system DNS can not be configured on the platform.
+var errDNSUnsupported = errors.New("configuring system DNS is not supported on this platform")
+
+// dnsConfigurator sets system resolvers while the Client is connected.
+type dnsConfigurator interface {
+	// Set points system DNS to servers, ifName is the TUN device name.
+	Set(servers []net.IP, ifName string) error
+	// Restore returns DNS settings changed by Set to the original state.
+	Restore() error
+}
+
+// dnsRecoverer is implemented by dnsConfigurator which can restore the original settings left changed
+// by a killed process.
+type dnsRecoverer interface {
+	// Recover restores the original settings, it reports whether there was anything to restore.
+	// Settings of another running instance must be left alone.
+	Recover() (bool, error)
+}
+
+// recoverDNS restores system DNS left changed by a previous run of the client which did not disconnect,
+// it is called on connect, so that merely creating Client changes nothing.
+func (c *Client) recoverDNS() {
+	r, ok := c.dns.(dnsRecoverer)
+	if !ok {
+		return
+	}
+
+	recovered, err := r.Recover()
+	switch {
+	case err != nil:
+		c.cfg.Logger.Warn("restoring system DNS left by previous run failed", "err", err)
+	case recovered:
+		c.cfg.Logger.Info("restored system DNS left by previous run")
+	}
+}
+
+// setDNS points system DNS to Config.DNSServers, if any. Failure is logged, queries keep going
+// to the original resolvers through the tunnel.
+//
+// With Config.DNSRules or Config.DNSQueryLog system DNS points to a forwarder on the TUN address instead,
+// which splits and records the queries.
+func (c *Client) setDNS() {
+	if len(c.cfg.DNSServers) == 0 && c.wsl != wslNone {
+		c.cfg.Logger.Warn("WSL2 resolves DNS queries by Windows outside of the tunnel, " +
+			"set DNSServers or set nameserver in /etc/resolv.conf and generateResolvConf=false in /etc/wsl.conf")
+	}
+	if len(c.cfg.DNSServers) == 0 || c.externalTUN {
+		if len(c.cfg.DNSRules) > 0 || c.dnsLog != nil {
+			c.cfg.Logger.Warn("DNS rules and query log are ignored, they require DNSServers and the TUN device created by the client")
+		}
+
+		return
+	}
+
+	servers := c.cfg.DNSServers
+	if len(c.cfg.DNSRules) > 0 || c.dnsLog != nil {
+		if err := c.startSplitDNS(); err != nil {
+			c.cfg.Logger.Warn("starting split DNS failed, DNS rules and query log are ignored", "err", err)
+		} else {
+			servers = []net.IP{c.cfg.TUNAddress.IP}
+		}
+	}
+
+	if err := c.dns.Set(servers, c.tunName); err != nil {
+		c.cfg.Logger.Warn("setting system DNS failed, original resolvers are used", "err", err)
+		if err = c.stopSplitDNS(); err != nil {
+			c.cfg.Logger.Debug("stopping split DNS failed", "err", err)
+		}
+
+		return
+	}
+	c.dnsSet = true
+	c.cfg.Logger.Debug("system DNS set", "servers", servers)
+}
+
+// moveDNS points system DNS set by setDNS to the TUN device reopened under a new name, see reopenTunnel.
+// Settings of the previous device may be gone with it, e.g. systemd-resolved drops them with the link.
+func (c *Client) moveDNS() {
+	if !c.dnsSet {
+		return
+	}
+
+	if err := c.dns.Restore(); err != nil {
+		c.cfg.Logger.Debug("reverting DNS of the previous TUN device failed", "err", err)
+	}
+	servers := c.cfg.DNSServers
+	if c.forwarder != nil {
+		servers = []net.IP{c.cfg.TUNAddress.IP}
+	}
+	if err := c.dns.Set(servers, c.tunName); err != nil {
+		c.cfg.Logger.Warn("setting system DNS for the new TUN device failed, original resolvers are used", "err", err)
+		c.dnsSet = false
+		if err = c.stopSplitDNS(); err != nil {
+			c.cfg.Logger.Debug("stopping split DNS failed", "err", err)
+		}
+	}
+}
+
+// restoreDNS restores system DNS changed by setDNS.
+func (c *Client) restoreDNS() error {
+	var err error
+	if c.dnsSet {
+		c.dnsSet = false
+		err = c.dns.Restore()
+	}
+
+	return errors.Join(err, c.stopSplitDNS())
+}
+
+// startSplitDNS routes direct resolvers of Config.DNSRules through the gateway and starts the forwarder
+// on the TUN address.
+func (c *Client) startSplitDNS() error {
+	var direct []*route.Addr
+	for _, rule := range c.cfg.DNSRules {
+		if !rule.Direct {
+			continue
+		}
+		if err := rule.validate(); err != nil {
+			return err
+		}
+		for _, ip := range rule.Servers {
+			direct = append(direct, &route.Addr{IP: ip.To4(), Mask: net.CIDRMask(32, 32)})
+		}
+	}
+	if len(direct) > 0 {
+		if err := c.router.AddBypassRoutes(direct); err != nil {
+			return fmt.Errorf("route direct resolvers: %w", err)
+		}
+	}
+
+	fwd, err := newDNSForwarder(c.cfg.TUNAddress.IP, c.cfg.DNSRules, c.cfg.DNSServers, c.dnsLog, c.cfg.Logger)
+	if err != nil {
+		return errors.Join(fmt.Errorf("start dns forwarder: %w", err), c.router.DeleteBypassRoutes(direct))
+	}
+	c.forwarder, c.directDNS = fwd, direct
+
+	return nil
+}
+
+// stopSplitDNS stops the forwarder and removes the routes added by startSplitDNS.
+func (c *Client) stopSplitDNS() error {
+	if c.forwarder == nil {
+		return nil
+	}
+
+	err := c.forwarder.Close()
+	err = errors.Join(err, c.router.DeleteBypassRoutes(c.directDNS))
+	c.forwarder, c.directDNS = nil, nil
+
+	return err
+}
+
+// unsupportedDNS is the dnsConfigurator of platforms without DNS integration.
+type unsupportedDNS struct{}
+
+func (unsupportedDNS) Set([]net.IP, string) error {
+	return errDNSUnsupported
+}
+
+func (unsupportedDNS) Restore() error {
+	return nil
+}
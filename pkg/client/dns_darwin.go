@@ -0,0 +1,120 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+const (
+	// scutilSavedDNSKey keeps the original DNS of the primary service while it is overridden.
+	// It lives in the dynamic store, so the original survives a crash of the process till reboot.
+	scutilSavedDNSKey = "State:/Network/Service/goxray-tun/SavedDNS"
+)
+
+var primaryServiceRe = regexp.MustCompile(`PrimaryService\s*:\s*(\S+)`)
+
+func newDNSConfigurator() dnsConfigurator {
+	return &scutilDNS{run: runScutil}
+}
+
+// scutilDNS overrides DNS of the primary network service in SystemConfiguration dynamic store,
+// the same way native VPN apps do, and restores it afterwards.
+type scutilDNS struct {
+	run func(script string) (string, error)
+
+	service string // ID of the overridden network service.
+}
+
+func (s *scutilDNS) Set(servers []net.IP, _ string) error {
+	out, err := s.run("show State:/Network/Global/IPv4\n")
+	if err != nil {
+		return fmt.Errorf("get primary service: %w", err)
+	}
+	m := primaryServiceRe.FindStringSubmatch(out)
+	if m == nil {
+		return errors.New("primary network service not found")
+	}
+	service := m[1]
+	key := serviceDNSKey(service)
+
+	// Original settings are saved only once, a leftover from a crashed run is the real original.
+	saved, err := s.exists(scutilSavedDNSKey)
+	if err != nil {
+		return err
+	}
+	original, err := s.exists(key)
+	if err != nil {
+		return err
+	}
+	if original && !saved {
+		if _, err = s.run(fmt.Sprintf("get %s\nset %s\n", key, scutilSavedDNSKey)); err != nil {
+			return fmt.Errorf("save original DNS: %w", err)
+		}
+	}
+
+	addrs := make([]string, 0, len(servers))
+	for _, ip := range servers {
+		addrs = append(addrs, ip.String())
+	}
+	script := fmt.Sprintf("d.init\nd.add ServerAddresses * %s\nset %s\n", strings.Join(addrs, " "), key)
+	if _, err = s.run(script); err != nil {
+		return fmt.Errorf("set DNS of service %s: %w", service, err)
+	}
+	s.service = service
+
+	return nil
+}
+
+func (s *scutilDNS) Restore() error {
+	if s.service == "" {
+		return nil
+	}
+	key := serviceDNSKey(s.service)
+
+	saved, err := s.exists(scutilSavedDNSKey)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("remove %s\n", key)
+	if saved {
+		script = fmt.Sprintf("get %s\nset %s\nremove %s\n", scutilSavedDNSKey, key, scutilSavedDNSKey)
+	}
+	if _, err = s.run(script); err != nil {
+		return fmt.Errorf("restore DNS of service %s: %w", s.service, err)
+	}
+	s.service = ""
+
+	return nil
+}
+
+// exists reports whether key is present in the dynamic store.
+func (s *scutilDNS) exists(key string) (bool, error) {
+	out, err := s.run(fmt.Sprintf("show %s\n", key))
+	if err != nil {
+		return false, fmt.Errorf("show %s: %w", key, err)
+	}
+
+	return !strings.Contains(out, "No such key"), nil
+}
+
+func serviceDNSKey(service string) string {
+	return "State:/Network/Service/" + service + "/DNS"
+}
+
+// runScutil runs scutil commands from script and returns the output.
+func runScutil(script string) (string, error) {
+	cmd := exec.Command("scutil")
+	cmd.Stdin = strings.NewReader(script)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("scutil: %w: %s", err, strings.TrimSpace(out.String()))
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScutil imitates dynamic store for the subset of scutil commands used by scutilDNS.
+type fakeScutil struct {
+	store   map[string]string
+	scripts []string
+}
+
+func (f *fakeScutil) run(script string) (string, error) {
+	f.scripts = append(f.scripts, script)
+
+	var dict string
+	for _, line := range strings.Split(strings.TrimSpace(script), "\n") {
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "show":
+			v, ok := f.store[arg]
+			if !ok {
+				return "  No such key\n", nil
+			}
+			return v, nil
+		case "get":
+			dict = f.store[arg]
+		case "d.init":
+			dict = ""
+		case "d.add":
+			dict = arg
+		case "set":
+			f.store[arg] = dict
+		case "remove":
+			delete(f.store, arg)
+		}
+	}
+
+	return "", nil
+}
+
+func TestScutilDNS(t *testing.T) {
+	key := serviceDNSKey("ABC")
+	fake := &fakeScutil{store: map[string]string{
+		"State:/Network/Global/IPv4": "<dictionary> {\n  PrimaryService : ABC\n}\n",
+		key:                          "ServerAddresses * 192.168.1.1",
+	}}
+	dns := &scutilDNS{run: fake.run}
+
+	require.NoError(t, dns.Set([]net.IP{net.IPv4(1, 1, 1, 1), net.IPv4(8, 8, 8, 8)}, "utun5"))
+	require.Equal(t, "ServerAddresses * 1.1.1.1 8.8.8.8", fake.store[key])
+	require.Equal(t, "ServerAddresses * 192.168.1.1", fake.store[scutilSavedDNSKey])
+
+	require.NoError(t, dns.Restore())
+	require.Equal(t, "ServerAddresses * 192.168.1.1", fake.store[key])
+	require.NotContains(t, fake.store, scutilSavedDNSKey)
+
+	// Service without DNS of its own gets the key removed on restore.
+	delete(fake.store, key)
+	require.NoError(t, dns.Set([]net.IP{net.IPv4(1, 1, 1, 1)}, "utun5"))
+	require.NoError(t, dns.Restore())
+	require.NotContains(t, fake.store, key)
+}
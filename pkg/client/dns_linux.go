@@ -0,0 +1,101 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// resolvedStubResolvConf exists while systemd-resolved is running.
+	resolvedStubResolvConf = "/run/systemd/resolve/stub-resolv.conf"
+
+	resolvedDest      = "org.freedesktop.resolve1"
+	resolvedPath      = "/org/freedesktop/resolve1"
+	resolvedInterface = "org.freedesktop.resolve1.Manager"
+)
+
+func newDNSConfigurator() dnsConfigurator {
+	if _, err := os.Stat(resolvedStubResolvConf); err == nil {
+		return &resolvedDNS{run: runBusctl}
+	}
+	if _, err := exec.LookPath("resolvconf"); err == nil {
+		return &resolvconfDNS{}
+	}
+
+	return &resolvConfFile{path: resolvConfPath, backup: resolvConfBackupPath, lock: resolvConfLockPath}
+}
+
+// resolvedDNS sets DNS servers of the TUN link in systemd-resolved over its D-Bus API, with "~." routing domain,
+// so that all queries go to them. Link settings are dropped by resolved together with the link,
+// Restore reverts them earlier.
+type resolvedDNS struct {
+	run func(args ...string) (string, error)
+
+	ifIndex int // Index of the configured link.
+}
+
+func (r *resolvedDNS) Set(servers []net.IP, ifName string) error {
+	ifc, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return fmt.Errorf("get interface %s: %w", ifName, err)
+	}
+	link := strconv.Itoa(ifc.Index)
+
+	args := []string{"ia(iay)", link, strconv.Itoa(len(servers))}
+	for _, ip := range servers {
+		family, addr := syscall.AF_INET6, ip.To16()
+		if ip4 := ip.To4(); ip4 != nil {
+			family, addr = syscall.AF_INET, ip4
+		}
+		args = append(args, strconv.Itoa(family), strconv.Itoa(len(addr)))
+		for _, b := range addr {
+			args = append(args, strconv.Itoa(int(b)))
+		}
+	}
+	if _, err = r.call("SetLinkDNS", args...); err != nil {
+		return fmt.Errorf("set DNS of link %s: %w", ifName, err)
+	}
+	r.ifIndex = ifc.Index
+
+	// Routing-only domain "." makes the link preferred for all names.
+	if _, err = r.call("SetLinkDomains", "ia(sb)", link, "1", ".", "true"); err != nil {
+		return fmt.Errorf("set DNS domains of link %s: %w", ifName, err)
+	}
+
+	return nil
+}
+
+func (r *resolvedDNS) Restore() error {
+	if r.ifIndex == 0 {
+		return nil
+	}
+	if _, err := r.call("RevertLink", "i", strconv.Itoa(r.ifIndex)); err != nil {
+		return fmt.Errorf("revert DNS of link %d: %w", r.ifIndex, err)
+	}
+	r.ifIndex = 0
+
+	return nil
+}
+
+// call calls method with args of resolved manager object.
+func (r *resolvedDNS) call(method string, args ...string) (string, error) {
+	return r.run(append([]string{"call", resolvedDest, resolvedPath, resolvedInterface, method}, args...)...)
+}
+
+// runBusctl runs busctl with args and returns the output.
+func runBusctl(args ...string) (string, error) {
+	cmd := exec.Command("busctl", args...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("busctl: %w: %s", err, strings.TrimSpace(out.String()))
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvedDNS(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	link := " " + strconv.Itoa(lo.Index) + " "
+
+	var calls []string
+	dns := &resolvedDNS{run: func(args ...string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return "", nil
+	}}
+	call := "call org.freedesktop.resolve1 /org/freedesktop/resolve1 org.freedesktop.resolve1.Manager "
+
+	require.NoError(t, dns.Set([]net.IP{net.IPv4(1, 1, 1, 1), net.ParseIP("2606:4700::1111")}, "lo"))
+	require.NoError(t, dns.Restore())
+	require.NoError(t, dns.Restore()) // Nothing to revert anymore.
+	require.Equal(t, []string{
+		call + "SetLinkDNS ia(iay)" + link + "2 2 4 1 1 1 1 10 16 38 6 71 0 0 0 0 0 0 0 0 0 0 0 17 17",
+		call + "SetLinkDomains ia(sb)" + link + "1 . true",
+		call + "RevertLink i" + strings.TrimSuffix(link, " "),
+	}, calls)
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+	// resolvConfBackupPath keeps the original resolv.conf while it is replaced. It outlives a killed process,
+	// so that the original is restored by the next run.
+	resolvConfBackupPath = "/etc/resolv.conf.goxray-tun"
+	// resolvConfLockPath is locked by the instance changing resolv.conf, the backup of a running instance
+	// must not be taken for one left by a killed run.
+	resolvConfLockPath = "/run/goxray-tun.lock"
+)
+
+// errResolvConfLocked is returned when resolv.conf is changed by another running instance.
+var errResolvConfLocked = errors.New("resolv.conf is managed by another running instance")
+
+// resolvconfDNS registers DNS servers of the TUN device with resolvconf (Debian resolvconf or openresolv),
+// which merges them into resolv.conf with other interfaces.
+type resolvconfDNS struct {
+	ifName string // Registered interface.
+}
+
+func (r *resolvconfDNS) Set(servers []net.IP, ifName string) error {
+	cmd := exec.Command("resolvconf", "-a", ifName)
+	cmd.Stdin = strings.NewReader(resolvConf(servers))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("resolvconf: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	r.ifName = ifName
+
+	return nil
+}
+
+func (r *resolvconfDNS) Restore() error {
+	if r.ifName == "" {
+		return nil
+	}
+	if out, err := exec.Command("resolvconf", "-d", r.ifName).CombinedOutput(); err != nil {
+		return fmt.Errorf("resolvconf: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	r.ifName = ""
+
+	return nil
+}
+
+// resolvConfFile replaces resolv.conf file atomically, the original is kept in the backup file till Restore.
+// The changes are made holding the lock file, if set, see resolvConfLockPath.
+type resolvConfFile struct {
+	path   string
+	backup string
+	lock   string
+
+	held *os.File // Locked lock file, it is kept till Restore.
+}
+
+func (r *resolvConfFile) Set(servers []net.IP, _ string) error {
+	if err := r.acquire(); err != nil {
+		return err
+	}
+	// Original is backed up only once, a backup left by a killed run is the real original.
+	// Hard link keeps the original as is, even if it is a symlink, e.g. to resolver managed by NetworkManager.
+	if _, err := os.Lstat(r.backup); errors.Is(err, os.ErrNotExist) {
+		if err = os.Link(r.path, r.backup); err != nil {
+			return fmt.Errorf("back up %s: %w", r.path, err)
+		}
+	}
+
+	if err := replaceFile(r.path, []byte(resolvConf(servers))); err != nil {
+		return fmt.Errorf("replace %s: %w", r.path, err)
+	}
+
+	return nil
+}
+
+func (r *resolvConfFile) Restore() error {
+	if err := os.Rename(r.backup, r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("restore %s: %w", r.path, err)
+	}
+	r.release()
+
+	return nil
+}
+
+// Recover restores the original resolv.conf left replaced by a killed run, it reports whether it did.
+// The backup is left alone while another instance is running.
+func (r *resolvConfFile) Recover() (bool, error) {
+	if err := r.acquire(); errors.Is(err, errResolvConfLocked) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if _, err := os.Lstat(r.backup); err != nil {
+		r.release()
+
+		return false, nil
+	}
+
+	return true, r.Restore()
+}
+
+// acquire locks the lock file without waiting, it returns errResolvConfLocked if another process holds it.
+func (r *resolvConfFile) acquire() error {
+	if r.lock == "" || r.held != nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(r.lock, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("open lock: %w", err)
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return errResolvConfLocked
+		}
+
+		return fmt.Errorf("lock %s: %w", r.lock, err)
+	}
+	r.held = f
+
+	return nil
+}
+
+// release unlocks the lock file, the lock is released together with the file.
+func (r *resolvConfFile) release() {
+	if r.held != nil {
+		_ = r.held.Close()
+		r.held = nil
+	}
+}
+
+// replaceFile atomically replaces the file at path with data, readers see either the old or the new content.
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after successful rename.
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// resolvConf returns resolv.conf contents pointing to servers.
+func resolvConf(servers []net.IP) string {
+	var b bytes.Buffer
+	b.WriteString("# Generated by goxray-tun, the original is restored on disconnect.\n")
+	for _, ip := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", ip)
+	}
+
+	return b.String()
+}
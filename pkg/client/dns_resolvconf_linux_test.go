@@ -0,0 +1,90 @@
+package client
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvConfFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "resolv.conf")
+	original := "nameserver 192.168.1.1\n"
+	require.NoError(t, os.WriteFile(path, []byte(original), 0o644))
+	servers := []net.IP{net.IPv4(1, 1, 1, 1)}
+
+	dns := &resolvConfFile{path: path, backup: path + ".goxray-tun"}
+	require.NoError(t, dns.Set(servers, "tun0"))
+	require.Contains(t, readFile(t, path), "nameserver 1.1.1.1\n")
+
+	// Killed process leaves the backup, the next run keeps it as the original and restores it.
+	dns = &resolvConfFile{path: path, backup: path + ".goxray-tun"}
+	require.NoError(t, dns.Set(servers, "tun0"))
+	require.NoError(t, dns.Restore())
+	require.Equal(t, original, readFile(t, path))
+
+	require.NoError(t, dns.Set(servers, "tun0"))
+	recovered, err := dns.Recover()
+	require.NoError(t, err)
+	require.True(t, recovered)
+	require.Equal(t, original, readFile(t, path))
+
+	recovered, err = dns.Recover()
+	require.NoError(t, err)
+	require.False(t, recovered)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1) // No temporary files are left.
+}
+
+func TestResolvConfFile_Lock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "resolv.conf")
+	original := "nameserver 192.168.1.1\n"
+	require.NoError(t, os.WriteFile(path, []byte(original), 0o644))
+	servers := []net.IP{net.IPv4(1, 1, 1, 1)}
+	lock := filepath.Join(dir, "goxray-tun.lock")
+
+	running := &resolvConfFile{path: path, backup: path + ".goxray-tun", lock: lock}
+	require.NoError(t, running.Set(servers, "tun0"))
+
+	// The backup of the running instance is not taken for one left by a killed run.
+	other := &resolvConfFile{path: path, backup: path + ".goxray-tun", lock: lock}
+	recovered, err := other.Recover()
+	require.NoError(t, err)
+	require.False(t, recovered)
+	require.ErrorIs(t, other.Set(servers, "tun1"), errResolvConfLocked)
+
+	require.NoError(t, running.Restore())
+	require.Equal(t, original, readFile(t, path))
+	require.NoError(t, other.Set(servers, "tun1"))
+	require.NoError(t, other.Restore())
+}
+
+func TestResolvConfFile_Symlink(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "resolv.conf")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stub.conf"), []byte("nameserver 127.0.0.53\n"), 0o644))
+	require.NoError(t, os.Symlink("stub.conf", path))
+
+	dns := &resolvConfFile{path: path, backup: path + ".goxray-tun"}
+	require.NoError(t, dns.Set([]net.IP{net.IPv4(1, 1, 1, 1)}, "tun0"))
+	require.NoError(t, dns.Restore())
+
+	target, err := os.Readlink(path)
+	require.NoError(t, err)
+	require.Equal(t, "stub.conf", target)
+	require.Equal(t, "nameserver 127.0.0.53\n", readFile(t, filepath.Join(dir, "stub.conf")))
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return string(data)
+}
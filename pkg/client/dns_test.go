@@ -0,0 +1,79 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDNS struct {
+	servers []net.IP
+	ifName  string
+	err     error
+}
+
+func (f *fakeDNS) Set(servers []net.IP, ifName string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.servers, f.ifName = servers, ifName
+
+	return nil
+}
+
+func (f *fakeDNS) Restore() error {
+	f.servers, f.ifName = nil, ""
+
+	return nil
+}
+
+func TestSetDNS(t *testing.T) {
+	dns := &fakeDNS{}
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.dns = dns
+
+	// Nothing is changed without servers.
+	cl.setDNS()
+	require.False(t, cl.dnsSet)
+
+	cl.cfg.DNSServers = []net.IP{net.IPv4(1, 1, 1, 1)}
+	cl.setDNS()
+	require.True(t, cl.dnsSet)
+	require.Equal(t, cl.cfg.DNSServers, dns.servers)
+
+	require.NoError(t, cl.restoreDNS())
+	require.False(t, cl.dnsSet)
+	require.Nil(t, dns.servers)
+
+	// Failure leaves the system resolvers in place.
+	dns.err = errors.New("denied")
+	cl.setDNS()
+	require.False(t, cl.dnsSet)
+	require.NoError(t, cl.restoreDNS())
+}
+
+func TestMoveDNS(t *testing.T) {
+	dns := &fakeDNS{}
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.dns = dns
+
+	// Nothing is moved when system DNS was not set.
+	cl.tunName = "tun1"
+	cl.moveDNS()
+	require.Empty(t, dns.ifName)
+
+	cl.cfg.DNSServers = []net.IP{net.IPv4(1, 1, 1, 1)}
+	cl.tunName = "tun0"
+	cl.setDNS()
+	cl.tunName = "tun1"
+	cl.moveDNS()
+	require.True(t, cl.dnsSet)
+	require.Equal(t, "tun1", dns.ifName)
+	require.Equal(t, cl.cfg.DNSServers, dns.servers)
+
+	dns.err = errors.New("no such link")
+	cl.moveDNS()
+	require.False(t, cl.dnsSet, "there is nothing to restore on Disconnect")
+}
@@ -0,0 +1,309 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnsPort is the port the forwarder listens on and sends queries to.
+	dnsPort = 53
+	// dnsExchangeTimeout limits waiting for an answer of a single upstream resolver.
+	dnsExchangeTimeout = 3 * time.Second
+	// maxDNSMessage is the largest DNS message over UDP with EDNS.
+	maxDNSMessage = 65535
+)
+
+// DNSRule sends DNS queries for Domain and its subdomains to Servers instead of Config.DNSServers.
+type DNSRule struct {
+	Domain  string   // Domain name, e.g. corp.local. Leading "*." is allowed, subdomains always match.
+	Servers []net.IP // Resolvers answering the queries, tried in order.
+	// Direct reaches Servers through the gateway outside of the tunnel, e.g. corporate resolvers on the LAN.
+	Direct bool
+}
+
+// ParseDNSRule parses rule in form "domain=server[,server...][ direct]", e.g. "*.corp.local=10.0.0.53 direct".
+func ParseDNSRule(s string) (DNSRule, error) {
+	domain, rest, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok || domain == "" {
+		return DNSRule{}, fmt.Errorf("invalid DNS rule %q: want domain=server[,server...][ direct]", s)
+	}
+
+	rule := DNSRule{Domain: strings.TrimSpace(domain)}
+	fields := strings.Fields(rest)
+	if len(fields) == 2 && fields[1] == "direct" {
+		rule.Direct = true
+		fields = fields[:1]
+	}
+	if len(fields) != 1 {
+		return DNSRule{}, fmt.Errorf("invalid DNS rule %q: want domain=server[,server...][ direct]", s)
+	}
+	for _, server := range strings.Split(fields[0], ",") {
+		ip := net.ParseIP(server)
+		if ip == nil {
+			return DNSRule{}, fmt.Errorf("invalid DNS rule %q: invalid IP address %q", s, server)
+		}
+		rule.Servers = append(rule.Servers, ip)
+	}
+	if err := rule.validate(); err != nil {
+		return DNSRule{}, fmt.Errorf("invalid DNS rule %q: %w", s, err)
+	}
+
+	return rule, nil
+}
+
+// validate checks that direct servers can be routed through the gateway, only IPv4 routes are supported.
+func (r DNSRule) validate() error {
+	if !r.Direct {
+		return nil
+	}
+	for _, ip := range r.Servers {
+		if ip.To4() == nil {
+			return fmt.Errorf("direct server %s is not an IPv4 address", ip)
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether name, fully qualified or not, is Domain or its subdomain.
+func (r DNSRule) matches(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(r.Domain, "*."), "."))
+
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+func (r DNSRule) String() string {
+	servers := make([]string, 0, len(r.Servers))
+	for _, ip := range r.Servers {
+		servers = append(servers, ip.String())
+	}
+	s := r.Domain + "=" + strings.Join(servers, ",")
+	if r.Direct {
+		s += " direct"
+	}
+
+	return s
+}
+
+// dnsForwarder is a DNS forwarder for split DNS, it sends queries matching DNSRule to its servers
+// and all other queries to the default servers. UDP and TCP are served on the same address.
+type dnsForwarder struct {
+	rules    []DNSRule
+	upstream []net.IP
+	port     int          // Port of upstream resolvers.
+	log      *dnsQueryLog // Records answered queries, nil if disabled.
+	logger   *slog.Logger
+
+	udp net.PacketConn
+	tcp net.Listener
+	wg  sync.WaitGroup
+}
+
+// newDNSForwarder starts forwarder on ip:53, queries are recorded to log unless it is nil.
+func newDNSForwarder(ip net.IP, rules []DNSRule, upstream []net.IP, log *dnsQueryLog, logger *slog.Logger) (*dnsForwarder, error) {
+	return startDNSForwarder(net.JoinHostPort(ip.String(), strconv.Itoa(dnsPort)), rules, upstream, dnsPort, log, logger)
+}
+
+func startDNSForwarder(addr string, rules []DNSRule, upstream []net.IP, port int, log *dnsQueryLog,
+	logger *slog.Logger,
+) (*dnsForwarder, error) {
+	f := &dnsForwarder{rules: rules, upstream: upstream, port: port, log: log, logger: logger}
+
+	var err error
+	if f.udp, err = net.ListenPacket("udp", addr); err != nil {
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+	if f.tcp, err = net.Listen("tcp", addr); err != nil {
+		_ = f.udp.Close()
+
+		return nil, fmt.Errorf("listen tcp: %w", err)
+	}
+
+	f.wg.Add(2)
+	go f.serveUDP()
+	go f.serveTCP()
+
+	return f, nil
+}
+
+// Addr returns the UDP address the forwarder listens on.
+func (f *dnsForwarder) Addr() net.Addr {
+	return f.udp.LocalAddr()
+}
+
+// Close stops the forwarder and waits for the listeners to stop, the file of the query log is closed.
+func (f *dnsForwarder) Close() error {
+	err := errors.Join(f.udp.Close(), f.tcp.Close())
+	f.wg.Wait()
+	if f.log != nil {
+		err = errors.Join(err, f.log.close())
+	}
+
+	return err
+}
+
+func (f *dnsForwarder) serveUDP() {
+	defer f.wg.Done()
+
+	buf := make([]byte, maxDNSMessage)
+	for {
+		n, addr, err := f.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if answer, err := f.exchange(query, "udp"); err == nil {
+				_, _ = f.udp.WriteTo(answer, addr)
+			}
+		}()
+	}
+}
+
+func (f *dnsForwarder) serveTCP() {
+	defer f.wg.Done()
+
+	for {
+		conn, err := f.tcp.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(2 * dnsExchangeTimeout))
+			query, err := readTCPMessage(conn)
+			if err != nil {
+				return
+			}
+			if answer, err := f.exchange(query, "tcp"); err == nil {
+				_ = writeTCPMessage(conn, answer)
+			}
+		}()
+	}
+}
+
+// exchange sends query to the resolvers responsible for its name and returns the first answer.
+func (f *dnsForwarder) exchange(query []byte, network string) ([]byte, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(query); err != nil {
+		return nil, fmt.Errorf("parse query: %w", err)
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, fmt.Errorf("parse question: %w", err)
+	}
+
+	servers := f.upstream
+	for _, rule := range f.rules {
+		if rule.matches(q.Name.String()) {
+			servers = rule.Servers
+
+			break
+		}
+	}
+
+	var (
+		errs     []error
+		upstream string
+		start    = time.Now()
+	)
+	for _, server := range servers {
+		upstream = net.JoinHostPort(server.String(), strconv.Itoa(f.port))
+		answer, err := exchangeDNS(query, network, upstream)
+		if err == nil {
+			f.record(q, network, upstream, start, answer, nil)
+
+			return answer, nil
+		}
+		errs = append(errs, err)
+	}
+	err = errors.Join(errs...)
+	f.logger.Debug("dns query failed", "name", q.Name.String(), "err", err)
+	f.record(q, network, upstream, start, nil, err)
+
+	return nil, err
+}
+
+// record adds the query for q started at start to the query log, if enabled.
+func (f *dnsForwarder) record(q dnsmessage.Question, network, upstream string, start time.Time, answer []byte, err error) {
+	if f.log == nil {
+		return
+	}
+
+	query := DNSQuery{
+		Time:     start,
+		Name:     q.Name.String(),
+		Type:     strings.TrimPrefix(q.Type.String(), "Type"),
+		Network:  network,
+		Upstream: upstream,
+		Latency:  time.Since(start),
+	}
+	if err != nil {
+		query.Error = err.Error()
+	} else {
+		query.parseAnswer(answer)
+	}
+	f.log.add(query)
+}
+
+// exchangeDNS sends query to server and returns the answer.
+func exchangeDNS(query []byte, network, server string) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, dnsExchangeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(dnsExchangeTimeout))
+
+	if network == "tcp" {
+		if err = writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+
+		return readTCPMessage(conn)
+	}
+
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxDNSMessage)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+// readTCPMessage reads DNS message prefixed with its length.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, size)
+	_, err := io.ReadFull(r, msg)
+
+	return msg, err
+}
+
+// writeTCPMessage writes DNS message prefixed with its length.
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+
+	return err
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseDNSRule(t *testing.T) {
+	rule, err := ParseDNSRule("*.corp.local=10.0.0.53,10.0.0.54 direct")
+	require.NoError(t, err)
+	require.Equal(t, DNSRule{Domain: "*.corp.local", Servers: []net.IP{net.ParseIP("10.0.0.53"), net.ParseIP("10.0.0.54")}, Direct: true}, rule)
+	require.Equal(t, "*.corp.local=10.0.0.53,10.0.0.54 direct", rule.String())
+
+	require.True(t, rule.matches("corp.local."))
+	require.True(t, rule.matches("git.CORP.local."))
+	require.False(t, rule.matches("notcorp.local."))
+
+	for _, s := range []string{"corp.local", "=10.0.0.53", "corp.local=host", "corp.local=10.0.0.53 tunnel", "corp.local=fd00::53 direct"} {
+		_, err = ParseDNSRule(s)
+		require.Error(t, err, s)
+	}
+}
+
+// fakeResolver answers every query over UDP and TCP with a single A record of ip.
+func fakeResolver(t *testing.T, addr string, ip net.IP) {
+	t.Helper()
+
+	answer := func(query []byte) []byte {
+		var msg dnsmessage.Message
+		require.NoError(t, msg.Unpack(query))
+		msg.Header.Response = true
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.AResource{A: [4]byte(ip.To4())},
+		}}
+		packed, err := msg.Pack()
+		require.NoError(t, err)
+
+		return packed
+	}
+
+	udp, err := net.ListenPacket("udp", addr)
+	require.NoError(t, err)
+	tcp, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = udp.Close(); _ = tcp.Close() })
+
+	go func() {
+		buf := make([]byte, maxDNSMessage)
+		for {
+			n, from, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteTo(answer(buf[:n]), from)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			if query, err := readTCPMessage(conn); err == nil {
+				_ = writeTCPMessage(conn, answer(query))
+			}
+			_ = conn.Close()
+		}
+	}()
+}
+
+func TestDNSForwarder(t *testing.T) {
+	// Resolvers share a port on different loopback addresses, like real ones share port 53.
+	probe, err := net.ListenPacket("udp", "127.0.0.2:0")
+	require.NoError(t, err)
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	require.NoError(t, probe.Close())
+	fakeResolver(t, net.JoinHostPort("127.0.0.2", strconv.Itoa(port)), net.IPv4(1, 1, 1, 1))
+	fakeResolver(t, net.JoinHostPort("127.0.0.3", strconv.Itoa(port)), net.IPv4(10, 0, 0, 1))
+
+	rules := []DNSRule{{Domain: "corp.local", Servers: []net.IP{net.IPv4(127, 0, 0, 3)}}}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	log := newDNSQueryLog(DNSQueryLog{Enabled: true}, logger)
+	fwd, err := startDNSForwarder("127.0.0.1:0", rules, []net.IP{net.IPv4(127, 0, 0, 2)}, port, log, logger)
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	resolve := func(network, name string) net.IP {
+		query, err := (&dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 1, RecursionDesired: true},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		}).Pack()
+		require.NoError(t, err)
+
+		addr := fwd.Addr().String()
+		if network == "tcp" {
+			addr = fwd.tcp.Addr().String()
+		}
+		answer, err := exchangeDNS(query, network, addr)
+		require.NoError(t, err)
+
+		var msg dnsmessage.Message
+		require.NoError(t, msg.Unpack(answer))
+		require.Len(t, msg.Answers, 1)
+		a := msg.Answers[0].Body.(*dnsmessage.AResource).A
+
+		return net.IP(a[:])
+	}
+
+	require.Equal(t, "1.1.1.1", resolve("udp", "example.com.").String())
+	require.Equal(t, "10.0.0.1", resolve("udp", "git.corp.local.").String())
+	require.Equal(t, "10.0.0.1", resolve("tcp", "corp.local.").String())
+	require.Equal(t, "1.1.1.1", resolve("tcp", "example.com.").String())
+
+	queries := log.recent(0)
+	require.Len(t, queries, 4)
+	q := queries[1]
+	require.Equal(t, "git.corp.local.", q.Name)
+	require.Equal(t, "A", q.Type)
+	require.Equal(t, "udp", q.Network)
+	require.Equal(t, net.JoinHostPort("127.0.0.3", strconv.Itoa(port)), q.Upstream)
+	require.Equal(t, "Success", q.RCode)
+	require.Equal(t, []string{"10.0.0.1"}, q.Answers)
+	require.Empty(t, q.Error)
+	require.Equal(t, "tcp", queries[3].Network)
+}
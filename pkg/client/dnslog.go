@@ -0,0 +1,215 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// defaultDNSQueryLogSize is the number of queries kept in memory if DNSQueryLog.Size is not set.
+	defaultDNSQueryLogSize = 256
+	// defaultDNSQueryLogMaxFileSize is the size DNSQueryLog.File is rotated at if DNSQueryLog.MaxFileSize is not set.
+	defaultDNSQueryLogMaxFileSize = 10 << 20
+)
+
+// DNSQueryLog records DNS queries answered by the forwarder on the TUN address, to debug resolution problems,
+// e.g. an upstream resolver failing. With the log enabled system DNS points to the forwarder even without
+// DNSRules, so it requires DNSServers as well.
+type DNSQueryLog struct {
+	Enabled bool
+	// Size is the number of the most recent queries kept in memory for Client.DNSQueries (default: 256).
+	Size int
+	// File is the path queries are appended to as JSON lines (default: empty, queries are kept in memory only).
+	// Once it grows over MaxFileSize it is renamed with ".1" suffix, replacing the previous one, and started over.
+	File string
+	// MaxFileSize is the size in bytes File is rotated at (default: 10 MiB).
+	MaxFileSize int64
+}
+
+func (l DNSQueryLog) validate() error {
+	if l.Size < 0 || l.MaxFileSize < 0 {
+		return errors.New("dns query log size must not be negative")
+	}
+
+	return nil
+}
+
+// DNSQuery is a DNS query answered by the forwarder, see Config.DNSQueryLog.
+type DNSQuery struct {
+	Time    time.Time `json:"time"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`    // Type of the question, e.g. "A" or "AAAA".
+	Network string    `json:"network"` // "udp" or "tcp".
+	// Upstream is the resolver which answered the query, or the last one tried if none did.
+	Upstream string `json:"upstream"`
+	// Latency is the time till the answer, failed attempts of other resolvers included.
+	Latency time.Duration `json:"latency"`
+	RCode   string        `json:"rcode,omitempty"`   // Response code of the answer, e.g. "Success" or "NameError".
+	Answers []string      `json:"answers,omitempty"` // Addresses and canonical names of the answer.
+	Error   string        `json:"error,omitempty"`   // Why no resolver answered.
+}
+
+func (q DNSQuery) String() string {
+	s := fmt.Sprintf("%s %s %s %s via %s in %s", q.Time.Format(time.TimeOnly), q.Network, q.Type, q.Name, q.Upstream,
+		q.Latency.Round(time.Millisecond))
+	if q.Error != "" {
+		return s + ": " + q.Error
+	}
+	s += ": " + q.RCode
+	if len(q.Answers) > 0 {
+		s += " " + strings.Join(q.Answers, ",")
+	}
+
+	return s
+}
+
+// parseAnswer fills RCode and Answers of q from answer, unparsable parts are left out.
+func (q *DNSQuery) parseAnswer(answer []byte) {
+	var p dnsmessage.Parser
+	h, err := p.Start(answer)
+	if err != nil {
+		return
+	}
+	q.RCode = strings.TrimPrefix(h.RCode.String(), "RCode")
+	if err = p.SkipAllQuestions(); err != nil {
+		return
+	}
+	resources, _ := p.AllAnswers()
+	for _, r := range resources {
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			q.Answers = append(q.Answers, netip.AddrFrom4(body.A).String())
+		case *dnsmessage.AAAAResource:
+			q.Answers = append(q.Answers, netip.AddrFrom16(body.AAAA).String())
+		case *dnsmessage.CNAMEResource:
+			q.Answers = append(q.Answers, body.CNAME.String())
+		}
+	}
+}
+
+// dnsQueryLog keeps the most recent queries in a ring and appends all of them to the file, if any.
+type dnsQueryLog struct {
+	path    string
+	maxSize int64
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	queries []DNSQuery
+	next    int  // Index of queries the next query is stored at.
+	full    bool // All of queries are filled.
+	file    *os.File
+	size    int64 // Size of file.
+}
+
+// newDNSQueryLog returns the log configured by cfg, or nil if it is disabled.
+func newDNSQueryLog(cfg DNSQueryLog, logger *slog.Logger) *dnsQueryLog {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Size == 0 {
+		cfg.Size = defaultDNSQueryLogSize
+	}
+	if cfg.MaxFileSize == 0 {
+		cfg.MaxFileSize = defaultDNSQueryLogMaxFileSize
+	}
+
+	return &dnsQueryLog{path: cfg.File, maxSize: cfg.MaxFileSize, logger: logger, queries: make([]DNSQuery, cfg.Size)}
+}
+
+// add records q. Failure to write the file is logged, the query is kept in memory anyway.
+func (l *dnsQueryLog) add(q DNSQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.queries[l.next] = q
+	l.next = (l.next + 1) % len(l.queries)
+	l.full = l.full || l.next == 0
+
+	if l.path == "" {
+		return
+	}
+	if err := l.write(q); err != nil {
+		l.logger.Warn("writing dns query log failed", "file", l.path, "err", err)
+	}
+}
+
+// write appends q to the file, rotating it if it grows over maxSize, l.mu must be held.
+func (l *dnsQueryLog) write(q DNSQuery) error {
+	line, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.file != nil && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		err = l.file.Close()
+		l.file = nil
+		if err = errors.Join(err, os.Rename(l.path, l.path+".1")); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+	if l.file == nil {
+		if l.file, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err != nil {
+			return err
+		}
+		info, err := l.file.Stat()
+		if err != nil {
+			return err
+		}
+		l.size = info.Size()
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+
+	return err
+}
+
+// recent returns up to n of the most recent queries, oldest first. n <= 0 returns all of them.
+func (l *dnsQueryLog) recent(n int) []DNSQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	queries := append([]DNSQuery(nil), l.queries[:l.next]...)
+	if l.full {
+		queries = append(append([]DNSQuery(nil), l.queries[l.next:]...), queries...)
+	}
+	if n > 0 && len(queries) > n {
+		queries = queries[len(queries)-n:]
+	}
+
+	return queries
+}
+
+// close closes the file, it is opened again by the next query.
+func (l *dnsQueryLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
+
+// DNSQueries returns up to n of the most recent DNS queries, oldest first, n <= 0 returns all that are kept.
+// It returns nil unless Config.DNSQueryLog is enabled.
+func (c *Client) DNSQueries(n int) []DNSQuery {
+	if c.dnsLog == nil {
+		return nil
+	}
+
+	return c.dnsLog.recent(n)
+}
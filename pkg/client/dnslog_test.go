@@ -0,0 +1,64 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSQueryLog(t *testing.T) {
+	require.Nil(t, newDNSQueryLog(DNSQueryLog{}, slog.Default()))
+	require.Error(t, DNSQueryLog{Enabled: true, Size: -1}.validate())
+
+	path := filepath.Join(t.TempDir(), "dns.log")
+	log := newDNSQueryLog(DNSQueryLog{Enabled: true, Size: 3, File: path, MaxFileSize: 300}, slog.Default())
+	defer log.close()
+
+	names := []string{"a.com.", "b.com.", "c.com.", "d.com.", "e.com."}
+	for i, name := range names {
+		log.add(DNSQuery{Time: time.Unix(int64(i), 0).UTC(), Name: name, Type: "A", Network: "udp", Upstream: "1.1.1.1:53"})
+	}
+
+	var kept []string
+	for _, q := range log.recent(0) {
+		kept = append(kept, q.Name)
+	}
+	require.Equal(t, names[2:], kept)
+	require.Len(t, log.recent(2), 2)
+	require.Equal(t, "e.com.", log.recent(1)[0].Name)
+
+	// Older queries are in the rotated file, the ones rotated out before it are dropped.
+	var written []string
+	for _, p := range []string{path + ".1", path} {
+		f, err := os.Open(p)
+		require.NoError(t, err)
+		info, err := f.Stat()
+		require.NoError(t, err)
+		require.LessOrEqual(t, info.Size(), int64(300))
+		for scanner := bufio.NewScanner(f); scanner.Scan(); {
+			var q DNSQuery
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &q))
+			written = append(written, q.Name)
+		}
+		require.NoError(t, f.Close())
+	}
+	require.Greater(t, len(written), 2)
+	require.Equal(t, names[len(names)-len(written):], written)
+}
+
+func TestDNSQuery_String(t *testing.T) {
+	q := DNSQuery{
+		Time: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), Name: "example.com.", Type: "A", Network: "udp",
+		Upstream: "9.9.9.9:53", Latency: 42 * time.Millisecond, RCode: "Success", Answers: []string{"1.2.3.4"},
+	}
+	require.Equal(t, "10:00:00 udp A example.com. via 9.9.9.9:53 in 42ms: Success 1.2.3.4", q.String())
+
+	q.Error, q.RCode, q.Answers = "i/o timeout", "", nil
+	require.Equal(t, "10:00:00 udp A example.com. via 9.9.9.9:53 in 42ms: i/o timeout", q.String())
+}
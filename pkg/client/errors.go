@@ -0,0 +1,60 @@
+package client
+
+import (
+	"errors"
+	"os"
+)
+
+// Errors returned by the Client. Failures of Connect and the methods changing the connection are
+// marked with one of the kinds below, match them with errors.Is to tell the user what went wrong or
+// to decide whether to retry. The message of the error is kept as is.
+var (
+	// ErrAlreadyConnected is returned by Connect and StartProxyOnly if the client is connected already.
+	ErrAlreadyConnected = errors.New("client is already connected")
+	// ErrNotConnected is returned by methods that require established connection.
+	ErrNotConnected = errors.New("client is not connected")
+
+	// ErrInvalidLink is returned for a link which can not be parsed or is not supported.
+	// Retrying does not help, the link has to be fixed.
+	ErrInvalidLink = errors.New("invalid link")
+	// ErrServerUnresolvable is returned if the address of the server, a relay of Config.Chain
+	// or Config.UpstreamProxy can not be resolved. It may pass once the network is up.
+	ErrServerUnresolvable = errors.New("server address not resolvable")
+	// ErrXrayStart is returned if XRay core instance can not be made or started, e.g. the inbound port is taken.
+	ErrXrayStart = errors.New("xray core start failed")
+	// ErrTUNCreate is returned if the TUN device can not be created or set up.
+	ErrTUNCreate = errors.New("TUN device creation failed")
+	// ErrRouteInstall is returned if routes or policy rules of the tunnel can not be added.
+	ErrRouteInstall = errors.New("route installation failed")
+	// ErrPermission is returned if the process lacks privileges, see Preflight. Errors of the kinds above
+	// match it as well when they were caused by the system denying the operation.
+	ErrPermission = errors.New("permission denied")
+)
+
+// kindError marks err with one of the kinds of errors, see ErrInvalidLink.
+type kindError struct {
+	kind error
+	err  error
+}
+
+// withKind marks err with kind, keeping its message. It returns nil if err is nil.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// Is matches ErrPermission for errors caused by EPERM or EACCES.
+func (e *kindError) Is(target error) bool {
+	return target == ErrPermission && errors.Is(e.err, os.ErrPermission)
+}
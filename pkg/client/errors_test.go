@@ -0,0 +1,31 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithKind(t *testing.T) {
+	require.NoError(t, withKind(ErrRouteInstall, nil))
+
+	cause := errors.New("network is unreachable")
+	err := fmt.Errorf("connect: %w", withKind(ErrRouteInstall, fmt.Errorf("add route: %w", cause)))
+	require.EqualError(t, err, "connect: add route: network is unreachable")
+	require.ErrorIs(t, err, ErrRouteInstall)
+	require.ErrorIs(t, err, cause)
+	require.NotErrorIs(t, err, ErrTUNCreate)
+	require.NotErrorIs(t, err, ErrPermission)
+
+	err = withKind(ErrTUNCreate, fmt.Errorf("create tun: %w", syscall.EPERM))
+	require.ErrorIs(t, err, ErrTUNCreate)
+	require.ErrorIs(t, err, ErrPermission, "denied by the system")
+
+	err = withKind(ErrPermission, &PreflightError{Problem: netAdminProblem, Fix: "run as root"})
+	var perr *PreflightError
+	require.ErrorAs(t, err, &perr)
+	require.ErrorIs(t, err, ErrPermission)
+}
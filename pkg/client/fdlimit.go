@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+const (
+	// fdMonitorInterval is how often open file descriptors are counted.
+	fdMonitorInterval = 10 * time.Second
+	// defaultFDWarnRatio is the share of RLIMIT_NOFILE in use which triggers a warning.
+	defaultFDWarnRatio = 0.8
+)
+
+// raiseFDLimit sets soft RLIMIT_NOFILE to limit (capped by the hard limit), or to the hard limit if limit is 0.
+// It returns the resulting soft limit.
+func raiseFDLimit(limit uint64) (uint64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, fmt.Errorf("get rlimit: %w", err)
+	}
+
+	want := rl.Max
+	if limit != 0 {
+		want = min(limit, rl.Max)
+	}
+	if want == rl.Cur {
+		return rl.Cur, nil
+	}
+
+	rl.Cur = want
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, fmt.Errorf("set rlimit: %w", err)
+	}
+
+	return want, nil
+}
+
+// fdUsage returns number of open file descriptors of the process and the soft RLIMIT_NOFILE.
+func fdUsage() (open int, limit uint64, err error) {
+	var rl syscall.Rlimit
+	if err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, fmt.Errorf("get rlimit: %w", err)
+	}
+
+	// Both Linux and macOS expose the descriptors of the current process in /dev/fd.
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		return 0, 0, fmt.Errorf("read fd dir: %w", err)
+	}
+
+	return len(entries), rl.Cur, nil
+}
+
+// monitorFDs warns and emits observe.EventFDPressure every time file descriptor usage
+// crosses Config.FDWarnRatio of the limit. It returns when ctx is done.
+func (c *Client) monitorFDs(ctx context.Context) {
+	ticker := time.NewTicker(fdMonitorInterval)
+	defer ticker.Stop()
+
+	var warned bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		open, limit, err := fdUsage()
+		if err != nil {
+			c.cfg.Logger.Debug("fd usage check failed", "err", err)
+
+			continue
+		}
+
+		high := float64(open) >= float64(limit)*c.cfg.FDWarnRatio
+		if high && !warned {
+			c.cfg.Logger.Warn("file descriptor usage is high, new connections may fail soon", "open", open, "limit", limit)
+			c.emit(observe.EventFDPressure, "open", open, "limit", limit)
+		}
+		warned = high
+	}
+}
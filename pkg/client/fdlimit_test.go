@@ -0,0 +1,22 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFDLimit(t *testing.T) {
+	open, limit, err := fdUsage()
+	require.NoError(t, err)
+	require.Positive(t, open)
+
+	// Lowering the limit to the current value is always permitted.
+	got, err := raiseFDLimit(limit)
+	require.NoError(t, err)
+	require.Equal(t, limit, got)
+
+	_, after, err := fdUsage()
+	require.NoError(t, err)
+	require.Equal(t, limit, after)
+}
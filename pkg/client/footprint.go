@@ -0,0 +1,75 @@
+package client
+
+import (
+	"math/bits"
+	"runtime"
+
+	"github.com/xjasonlyu/tun2socks/v2/buffer"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// Per flow memory estimates. Relay buffers are exact, the rest is measured on typical traffic and
+// grows with the load: gVisor endpoint buffers start at their minimum and expand up to 1MB each.
+const (
+	relayGoroutineStack = 8 << 10  // Stack of each of the two relay goroutines of a flow.
+	tcpEndpointState    = 16 << 10 // gVisor endpoint with its minimal buffers and the socks connection.
+	udpEndpointState    = 8 << 10  // gVisor endpoint and the socks UDP association.
+)
+
+var (
+	// tcpRelayBuffers are taken from the pool for both directions of a TCP flow.
+	tcpRelayBuffers = 2 * pooledSize(buffer.RelayBufferSize)
+	// udpRelayBuffers are taken from the pool for both directions of a UDP flow.
+	udpRelayBuffers = 2 * pooledSize(buffer.MaxSegmentSize)
+
+	tcpFlowFootprint = tcpRelayBuffers + 2*relayGoroutineStack + tcpEndpointState
+	udpFlowFootprint = udpRelayBuffers + 2*relayGoroutineStack + udpEndpointState
+)
+
+// pooledSize returns capacity of the buffer the tun2socks pool hands out for size bytes,
+// the pool keeps buffers of power of two sizes.
+func pooledSize(size int) int {
+	return 1 << bits.Len(uint(size-1))
+}
+
+// footprint reports memory of the process and estimates for the flows in s and the configured limits.
+func (c *Client) footprint(s observe.Stats) observe.Footprint {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	c.tunMu.Lock()
+	startup := c.startupMem
+	c.tunMu.Unlock()
+
+	f := observe.Footprint{
+		Startup:    startup,
+		Sys:        mem.Sys,
+		HeapInUse:  mem.HeapInuse,
+		Goroutines: runtime.NumGoroutine(),
+		PerTCPFlow: tcpFlowFootprint,
+		PerUDPFlow: udpFlowFootprint,
+		BufferPool: s.ActiveTCP*tcpRelayBuffers + s.ActiveUDP*udpRelayBuffers,
+	}
+	if c.cfg.Shaping.enabled() {
+		f.ShapingQueues = 2 * shapingQueueLen * c.mtu
+	}
+	if c.cfg.MaxTCPConnections > 0 && c.cfg.MaxUDPSessions > 0 {
+		base := startup
+		if base == 0 {
+			base = mem.Sys
+		}
+		flows := c.cfg.MaxTCPConnections*tcpFlowFootprint + c.cfg.MaxUDPSessions*udpFlowFootprint
+		f.Budget = base + uint64(flows+f.ShapingQueues)
+	}
+
+	return f
+}
+
+// memSys returns memory obtained from the OS by the Go runtime.
+func memSys() uint64 {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return mem.Sys
+}
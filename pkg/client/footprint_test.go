@@ -0,0 +1,32 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestPooledSize(t *testing.T) {
+	require.Equal(t, 32<<10, pooledSize(20<<10))
+	require.Equal(t, 64<<10, pooledSize(1<<16-1))
+	require.Equal(t, 4096, pooledSize(4096))
+}
+
+func TestFootprint(t *testing.T) {
+	cl := &Client{cfg: Config{MaxTCPConnections: 10, MaxUDPSessions: 5}, mtu: defaultMTU, startupMem: 20 << 20}
+
+	f := cl.footprint(observe.Stats{ActiveTCP: 3, ActiveUDP: 1})
+	require.Equal(t, uint64(20<<20), f.Startup)
+	require.NotZero(t, f.Sys)
+	require.Equal(t, 3*64<<10+128<<10, f.BufferPool)
+	require.Zero(t, f.ShapingQueues)
+	require.Equal(t, uint64(20<<20+10*f.PerTCPFlow+5*f.PerUDPFlow), f.Budget)
+
+	cl.cfg.MaxUDPSessions = 0
+	cl.cfg.Shaping = Shaping{Bandwidth: 1000}
+	f = cl.footprint(observe.Stats{})
+	require.Equal(t, 2*shapingQueueLen*defaultMTU, f.ShapingQueues)
+	require.Zero(t, f.Budget)
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+)
+
+// linkSeeds are valid and almost valid links of the supported protocols.
+var linkSeeds = []string{
+	"vless://0c5b1e6a-1111-2222-3333-444455556666@1.2.3.4:443?type=tcp",
+	"vless://0c5b1e6a-1111-2222-3333-444455556666@example.com:443?type=ws&security=tls&sni=example.com&path=%2Fws&host=example.com#remark",
+	"vless://0c5b1e6a-1111-2222-3333-444455556666@1.2.3.4:443?type=tcp&security=reality&sni=example.com&fp=chrome&pbk=SbVKOEMjK0sIlbwg4akyBg5mL5KZwwB-ed4eEE7YnRc&sid=6ba85179e30d4fc2&flow=xtls-rprx-vision",
+	"vless://0c5b1e6a-1111-2222-3333-444455556666@[2001:db8::1]:443?type=grpc&serviceName=svc&security=tls",
+	"vmess://eyJ2IjoiMiIsInBzIjoicmVtYXJrIiwiYWRkIjoiMS4yLjMuNCIsInBvcnQiOiI0NDMiLCJpZCI6IjBjNWIxZTZhLTExMTEtMjIyMi0zMzMzLTQ0NDQ1NTU1NjY2NiIsImFpZCI6IjAiLCJuZXQiOiJ3cyIsInR5cGUiOiJub25lIiwiaG9zdCI6ImV4YW1wbGUuY29tIiwicGF0aCI6Ii93cyIsInRscyI6InRscyJ9",
+	"trojan://password@example.com:443?security=tls&sni=example.com&type=tcp#remark",
+	"ss://YWVzLTI1Ni1nY206cGFzc3dvcmQ@1.2.3.4:8388#remark",
+	"ss://MjAyMi1ibGFrZTMtYWVzLTI1Ni1nY206WVdKalpHVm1aMmhwYW10c2JXNXZjSEZ5YzNSMWRuZDRlWG93TVRJek5EVT0@1.2.3.4:8388",
+	"vless://@:?",
+	"vmess://",
+	"trojan://@1.2.3.4:99999",
+	"hysteria2://password@1.2.3.4:443",
+}
+
+// FuzzParseLink checks that malformed links are rejected with an error, and that the links accepted
+// generate the XRay config of a server with a valid address and port and an outbound of their protocol.
+func FuzzParseLink(f *testing.F) {
+	for _, link := range linkSeeds {
+		f.Add(link)
+	}
+
+	f.Fuzz(func(t *testing.T, link string) {
+		svc := xray.NewXrayService(false, false)
+		protocol, cfg, err := parseLink(svc, link)
+		if err != nil {
+			return
+		}
+		if cfg.Address == "" {
+			t.Fatalf("link %q accepted without server address", link)
+		}
+		if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+			t.Fatalf("link %q accepted with server port %q", link, cfg.Port)
+		}
+
+		hop, ok := protocol.(xray.Protocol)
+		if !ok {
+			t.Fatalf("link %q parsed into %T, which builds no outbound", link, protocol)
+		}
+		detour, err := hop.BuildOutboundDetourConfig(false)
+		if err != nil {
+			return
+		}
+		if detour.Protocol != cfg.Protocol && !(cfg.Protocol == "ss" && detour.Protocol == "shadowsocks") {
+			t.Fatalf("link %q of %s generated %s outbound", link, cfg.Protocol, detour.Protocol)
+		}
+		inst, err := (&Client{}).makeXrayInstance(svc, []xrayproto.Protocol{protocol}, nil)
+		if err == nil {
+			_ = inst.Close()
+		}
+	})
+}
+
+// FuzzParseXRayExtra checks that malformed Config.XRayExtra is rejected with an error, and that every accepted
+// outbound makes it into the generated config.
+func FuzzParseXRayExtra(f *testing.F) {
+	f.Add([]byte(`{"routing": {"rules": [{"type": "field", "domain": ["example.com"], "outboundTag": "direct"}]}}`))
+	f.Add([]byte(`{"dns": {"servers": ["1.1.1.1", {"address": "8.8.8.8", "domains": ["example.com"]}]}}`))
+	f.Add([]byte(`{"observatory": {"subjectSelector": ["proxy"]}, "burstObservatory": {"subjectSelector": ["proxy"]}}`))
+	f.Add([]byte(`{"outbounds": [{"protocol": "freedom", "tag": "direct"}, {"protocol": "blackhole", "tag": "block"}]}`))
+	f.Add([]byte(`{"inbounds": []}`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		var extra xrayExtra
+		_, outbounds, err := parseXRayExtra(raw)
+		if err != nil || json.Unmarshal(raw, &extra) != nil {
+			return
+		}
+		if len(outbounds) != len(extra.Outbounds) {
+			t.Fatalf("XRayExtra %q with %d outbounds generated %d", raw, len(extra.Outbounds), len(outbounds))
+		}
+	})
+}
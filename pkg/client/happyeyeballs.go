@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const (
+	// connectionAttemptDelay is how long a connection attempt goes on before the next address is tried
+	// in parallel, see RFC 8305 section 5.
+	connectionAttemptDelay = 250 * time.Millisecond
+	// happyEyeballsTimeout limits racing the addresses of the server.
+	happyEyeballsTimeout = 5 * time.Second
+)
+
+// dialFunc dials addr like net.Dialer.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// resolveServer returns the address XRay server host is connected on, and whether host has both IPv4 and IPv6
+// addresses. Connections to the addresses of a dual-stack host are raced like Happy Eyeballs (RFC 8305) do,
+// if its transport runs over TCP, and the address connected first wins. Otherwise, or if none of them connects,
+// an IPv4 address is preferred. Failed lookups and races are repeated according to retry.
+func resolveServer(ctx context.Context, host, port, network string, dial dialFunc, retry RetryPolicy) (net.IP, bool, error) {
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, false, nil
+	}
+
+	ips, err := lookupIP(ctx, host, retry)
+	if err != nil {
+		return nil, false, err
+	}
+
+	dualStack := slices.ContainsFunc(ips, func(ip net.IP) bool { return ip.To4() == nil }) &&
+		slices.ContainsFunc(ips, func(ip net.IP) bool { return ip.To4() != nil })
+	if !dualStack || !tcpTransport(network) {
+		return preferIPv4(ips), dualStack, nil
+	}
+	var ip net.IP
+	err = retry.do(ctx, func() error {
+		ctx, cancel := context.WithTimeout(ctx, happyEyeballsTimeout)
+		defer cancel()
+		ip, err = happyEyeballs(ctx, interleaveFamilies(ips), port, dial)
+
+		return err
+	})
+	if err != nil {
+		return preferIPv4(ips), true, nil // The server is reported unreachable by XRay.
+	}
+
+	return ip, true, nil
+}
+
+// resolveFirst resolves the server connected directly, the exit server or the first relay of Config.Chain,
+// see resolveServer. It returns the address to route past the TUN device, and the same address as pin
+// if the server is dual-stack, see makeXrayInstance. Behind Config.UpstreamProxy it is only resolved.
+func (c *Client) resolveFirst(ctx context.Context, cfg xrayproto.GeneralConfig) (ip, pin net.IP, err error) {
+	if c.cfg.UpstreamProxy != nil {
+		ip, err = resolveIP(ctx, cfg.Address, c.cfg.Retry)
+
+		return ip, nil, err
+	}
+
+	ip, dualStack, err := resolveServer(ctx, cfg.Address, cfg.Port, cfg.Network, (&net.Dialer{}).DialContext, c.cfg.Retry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dualStack {
+		pin = ip
+	}
+
+	return ip, pin, nil
+}
+
+// resolveIP returns an address of host, IPv4 preferred, like net.ResolveIPAddr does.
+func resolveIP(ctx context.Context, host string, retry RetryPolicy) (net.IP, error) {
+	ips, err := lookupIP(ctx, host, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	return preferIPv4(ips), nil
+}
+
+// lookupIP returns addresses of host, which may be an IP address in brackets too.
+// Failed lookups are repeated according to retry.
+func lookupIP(ctx context.Context, host string, retry RetryPolicy) ([]net.IP, error) {
+	host = strings.Trim(host, "[]")
+	var ips []net.IP
+	err := retry.do(ctx, func() error {
+		ctx, cancel := context.WithTimeout(ctx, happyEyeballsTimeout)
+		defer cancel()
+
+		var err error
+		ips, err = net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err == nil && len(ips) == 0 {
+			err = fmt.Errorf("no addresses of %s", host)
+		}
+
+		return err
+	})
+
+	return ips, err
+}
+
+// tcpTransport reports whether XRay transport network of a link runs over TCP.
+func tcpTransport(network string) bool {
+	switch network {
+	case "kcp", "mkcp", "quic":
+		return false
+	default:
+		return true
+	}
+}
+
+// preferIPv4 returns the first IPv4 address of ips, or the first address if there are none.
+func preferIPv4(ips []net.IP) net.IP {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip
+		}
+	}
+
+	return ips[0]
+}
+
+// interleaveFamilies orders ips by alternating families starting with IPv6, see RFC 8305 section 4.
+// Within a family the order of the resolver is kept.
+func interleaveFamilies(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	addrs := make([]net.IP, 0, len(ips))
+	for i := 0; i < max(len(v4), len(v6)); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, v6[i])
+		}
+		if i < len(v4) {
+			addrs = append(addrs, v4[i])
+		}
+	}
+
+	return addrs
+}
+
+// happyEyeballs connects to addrs in order, starting the next attempt once the previous one failed
+// or connectionAttemptDelay passed, and returns the address connected first. Other attempts are cancelled.
+func happyEyeballs(ctx context.Context, addrs []net.IP, port string, dial dialFunc) (net.IP, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		ip  net.IP
+		err error
+	}
+	results := make(chan result, len(addrs))
+	attempt := func(ip net.IP) {
+		conn, err := dial(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			_ = conn.Close()
+		}
+		results <- result{ip, err}
+	}
+
+	var errs []error
+	next, pending := 0, 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for next < len(addrs) || pending > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(append(errs, ctx.Err())...)
+		case <-timer.C:
+			go attempt(addrs[next])
+			next++
+			pending++
+			if next < len(addrs) {
+				timer.Reset(connectionAttemptDelay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.ip, nil
+			}
+			errs = append(errs, r.err)
+			if next < len(addrs) {
+				timer.Reset(0) // Start the next attempt right away.
+			}
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// pinFamily makes XRay resolve the server of the outbound to addresses of the family of ip only,
+// so that it connects on the family the route exception is installed for.
+func pinFamily(detour *conf.OutboundDetourConfig, ip net.IP) {
+	if ip == nil {
+		return
+	}
+	if detour.StreamSetting == nil {
+		detour.StreamSetting = &conf.StreamConfig{}
+	}
+	if detour.StreamSetting.SocketSettings == nil {
+		detour.StreamSetting.SocketSettings = &conf.SocketConfig{}
+	}
+	detour.StreamSetting.SocketSettings.DomainStrategy = "ForceIPv4"
+	if ip.To4() == nil {
+		detour.StreamSetting.SocketSettings.DomainStrategy = "ForceIPv6"
+	}
+}
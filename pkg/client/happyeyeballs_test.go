@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+var (
+	heV4 = net.IPv4(198, 51, 100, 1)
+	heV6 = net.ParseIP("2001:db8::1")
+)
+
+// fakeDial connects to addresses of ok, fails on ones of refused and blackholes the rest till ctx is done.
+func fakeDial(ok, refused []net.IP) dialFunc {
+	has := func(ips []net.IP, addr string) bool {
+		host, _, _ := net.SplitHostPort(addr)
+		for _, ip := range ips {
+			if ip.Equal(net.ParseIP(host)) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		switch {
+		case has(ok, addr):
+			conn, _ := net.Pipe()
+
+			return conn, nil
+		case has(refused, addr):
+			return nil, errors.New("connection refused")
+		default:
+			<-ctx.Done()
+
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	v4b, v6b := net.IPv4(198, 51, 100, 2), net.ParseIP("2001:db8::2")
+	require.Equal(t, []net.IP{heV6, heV4, v6b, v4b}, interleaveFamilies([]net.IP{heV4, v4b, heV6, v6b}))
+	require.Equal(t, []net.IP{heV6, heV4, v4b}, interleaveFamilies([]net.IP{heV4, v4b, heV6}))
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	addrs := []net.IP{heV6, heV4}
+	ctx := context.Background()
+
+	ip, err := happyEyeballs(ctx, addrs, "443", fakeDial([]net.IP{heV6, heV4}, nil))
+	require.NoError(t, err)
+	require.Equal(t, heV6, ip, "IPv6 is tried first")
+
+	start := time.Now()
+	ip, err = happyEyeballs(ctx, addrs, "443", fakeDial([]net.IP{heV4}, nil))
+	require.NoError(t, err)
+	require.Equal(t, heV4, ip, "blackholed IPv6 is raced")
+	require.GreaterOrEqual(t, time.Since(start), connectionAttemptDelay)
+
+	start = time.Now()
+	ip, err = happyEyeballs(ctx, addrs, "443", fakeDial([]net.IP{heV4}, []net.IP{heV6}))
+	require.NoError(t, err)
+	require.Equal(t, heV4, ip)
+	require.Less(t, time.Since(start), connectionAttemptDelay, "IPv4 is tried right after IPv6 failed")
+
+	_, err = happyEyeballs(ctx, addrs, "443", fakeDial(nil, addrs))
+	require.ErrorContains(t, err, "connection refused")
+
+	ctx, cancel := context.WithTimeout(ctx, 2*connectionAttemptDelay)
+	defer cancel()
+	_, err = happyEyeballs(ctx, addrs, "443", fakeDial(nil, nil))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestResolveServer_Literal(t *testing.T) {
+	ip, dualStack, err := resolveServer(context.Background(), "[2001:db8::1]", "443", "tcp", fakeDial(nil, nil), RetryPolicy{})
+	require.NoError(t, err)
+	require.False(t, dualStack)
+	require.Equal(t, heV6, ip)
+}
+
+func TestPinFamily(t *testing.T) {
+	detour := &conf.OutboundDetourConfig{}
+	pinFamily(detour, nil)
+	require.Nil(t, detour.StreamSetting)
+
+	pinFamily(detour, heV6)
+	require.Equal(t, "ForceIPv6", detour.StreamSetting.SocketSettings.DomainStrategy)
+	pinFamily(detour, heV4)
+	require.Equal(t, "ForceIPv4", detour.StreamSetting.SocketSettings.DomainStrategy)
+}
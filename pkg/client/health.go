@@ -0,0 +1,273 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+const (
+	// healthProbeTimeout limits each of the health probes, targets are probed at once.
+	healthProbeTimeout = 10 * time.Second
+	// healthFailures is the number of checks in a row a subsystem has to fail before it is restarted.
+	healthFailures = 2
+	// readyTTL is how long the result of Ready is reused, so that frequent checks don't flood the probe targets.
+	readyTTL = 5 * time.Second
+)
+
+// errNotPassingTraffic is returned by Ready when the probes through the tunnel fail.
+var errNotPassingTraffic = errors.New("tunnel is not passing traffic")
+
+// subsystem is a part of the client restarted on its own when it fails.
+type subsystem string
+
+const (
+	subsystemXray subsystem = "xray" // XRay instance carrying all traffic.
+	subsystemDNS  subsystem = "dns"  // System DNS settings and the split DNS forwarder.
+)
+
+// failedSubsystem returns the subsystem to restart after probes of the data plane and DNS,
+// it is empty if both succeeded. DNS queries go through XRay too, so XRay is restarted when both fail.
+func failedSubsystem(dataErr, dnsErr error) subsystem {
+	switch {
+	case dataErr != nil:
+		return subsystemXray
+	case dnsErr != nil:
+		return subsystemDNS
+	default:
+		return ""
+	}
+}
+
+// watchHealth probes the tunnel every Config.HealthCheckInterval and restarts the failing subsystem
+// once it failed healthFailures checks in a row. It returns when ctx is done.
+func (c *Client) watchHealth(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+
+	var failed subsystem
+	var failures int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		dataErr, dnsErr := c.probeData(ctx), c.probeDNS(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s := failedSubsystem(dataErr, dnsErr)
+		if s == "" || s != failed {
+			failed, failures = s, 0
+		}
+		if s == "" {
+			continue
+		}
+		failures++
+		c.cfg.Logger.Debug("health check failed", "subsystem", s, "failures", failures,
+			"data_err", dataErr, "dns_err", dnsErr)
+		if failures < healthFailures {
+			continue
+		}
+
+		failures = 0
+		if s == subsystemXray {
+			// Large packets blackholed on the way to the server stall the tunnel the same way,
+			// and restarting XRay does not help with that.
+			reopened, err := c.fallbackMTU(ctx)
+			if err != nil {
+				c.cfg.Logger.Debug("path MTU fallback failed", "err", err)
+			}
+			if reopened {
+				continue
+			}
+		}
+		if err := c.restart(s); err != nil {
+			c.cfg.Logger.Error("restarting failed subsystem failed", "subsystem", s, "err", err)
+
+			continue
+		}
+		c.cfg.Logger.Warn("failing subsystem restarted", "subsystem", s, "data_err", dataErr, "dns_err", dnsErr)
+		c.emit(observe.EventRestarted, "subsystem", string(s))
+	}
+}
+
+// readiness is the latest result of Ready.
+type readiness struct {
+	mu  sync.Mutex // mu is held while probing, so that concurrent checks share the probe.
+	at  time.Time
+	err error
+}
+
+// Ready reports whether the client is connected and passes traffic, e.g. for readiness checks of containers.
+// Config.ProbeTargets are requested through XRay like by health checks, the result is reused for a few seconds.
+// It returns ErrNotConnected if the client is not connected.
+func (c *Client) Ready(ctx context.Context) error {
+	c.tunMu.Lock()
+	connected := !c.connectedAt.IsZero()
+	c.tunMu.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+
+	c.ready.mu.Lock()
+	defer c.ready.mu.Unlock()
+	if time.Since(c.ready.at) < readyTTL {
+		return c.ready.err
+	}
+	err := c.probeData(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err() // Not the result of the tunnel, it is not kept.
+	}
+	c.ready.at, c.ready.err = time.Now(), nil
+	if err != nil {
+		c.cfg.Logger.Debug("readiness probe failed", "err", err)
+		c.ready.err = fmt.Errorf("%w: %w", errNotPassingTraffic, err)
+	}
+
+	return c.ready.err
+}
+
+// probeTargets returns the URLs probed through the tunnel and how many of them have to answer.
+func (c *Client) probeTargets() ([]string, int) {
+	targets := c.cfg.ProbeTargets
+	if len(targets) == 0 {
+		targets = []string{defaultProbeURL}
+	}
+
+	return targets, min(max(c.cfg.ProbeQuorum, 1), len(targets))
+}
+
+// validateProbeTarget checks that target is an HTTP(S) URL with a host, see Config.ProbeTargets.
+func validateProbeTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid probe target: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid probe target %q: http or https URL expected", target)
+	}
+
+	return nil
+}
+
+// probeQuorum runs probe for all targets at once and returns nil as soon as quorum of them succeeded,
+// otherwise it returns errors of the failed ones.
+func probeQuorum(ctx context.Context, targets []string, quorum int, probe func(context.Context, string) error) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	results := make(chan error, len(targets))
+	for _, target := range targets {
+		go func() {
+			if err := probe(ctx, target); err != nil {
+				results <- fmt.Errorf("%s: %w", target, err)
+
+				return
+			}
+			results <- nil
+		}()
+	}
+
+	var passed int
+	var errs []error
+	for range targets {
+		if err := <-results; err != nil {
+			errs = append(errs, err)
+		} else if passed++; passed >= quorum {
+			return nil // The rest is cancelled.
+		}
+	}
+
+	return fmt.Errorf("%d of %d targets answered, %d required: %w", passed, len(targets), quorum, errors.Join(errs...))
+}
+
+// probeData requests probe targets through the inbound proxy of XRay, host names are resolved by the server.
+func (c *Client) probeData(ctx context.Context) error {
+	dialer, err := proxy.SOCKS5("tcp", c.xrayInbound().String(), nil, proxy.Direct)
+	if err != nil {
+		return fmt.Errorf("socks dialer: %w", err)
+	}
+	httpClient := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext:       dialer.(proxy.ContextDialer).DialContext,
+	}}
+
+	targets, quorum := c.probeTargets()
+
+	return probeQuorum(ctx, targets, quorum, func(ctx context.Context, target string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return fmt.Errorf("new probe request: %w", err)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		return nil
+	})
+}
+
+// probeDNS resolves host names of probe targets with the system resolver. System DNS is only probed
+// if it is managed by the client, see Config.DNSServers.
+func (c *Client) probeDNS(ctx context.Context) error {
+	c.tunMu.Lock()
+	dnsSet := c.dnsSet
+	c.tunMu.Unlock()
+	if !dnsSet {
+		return nil
+	}
+
+	targets, quorum := c.probeTargets()
+
+	return probeQuorum(ctx, targets, quorum, func(ctx context.Context, target string) error {
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		_, err = net.DefaultResolver.LookupHost(ctx, u.Hostname())
+
+		return err
+	})
+}
+
+// restart restarts subsystem s while the rest of the connection is kept.
+func (c *Client) restart(s subsystem) error {
+	switch s {
+	case subsystemXray:
+		c.tunMu.Lock()
+		link := c.link
+		c.tunMu.Unlock()
+		_, err := c.replaceXray(link)
+
+		return err
+	case subsystemDNS:
+		c.tunMu.Lock()
+		defer c.tunMu.Unlock()
+
+		err := c.restoreDNS()
+		c.setDNS()
+		if !c.dnsSet {
+			return errors.Join(err, errors.New("system DNS was not set again"))
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown subsystem %q", s)
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailedSubsystem(t *testing.T) {
+	errProbe := errors.New("timeout")
+
+	require.Empty(t, failedSubsystem(nil, nil))
+	require.Equal(t, subsystemDNS, failedSubsystem(nil, errProbe))
+	require.Equal(t, subsystemXray, failedSubsystem(errProbe, nil))
+	require.Equal(t, subsystemXray, failedSubsystem(errProbe, errProbe))
+}
+
+func TestRestart_DNS(t *testing.T) {
+	dns := &fakeDNS{}
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.dns = dns
+	cl.cfg.DNSServers = []net.IP{net.IPv4(1, 1, 1, 1)}
+	cl.setDNS()
+
+	dns.servers = []net.IP{net.IPv4(192, 168, 1, 1)} // Overwritten by other software.
+	require.NoError(t, cl.restart(subsystemDNS))
+	require.True(t, cl.dnsSet)
+	require.Equal(t, cl.cfg.DNSServers, dns.servers)
+
+	dns.err = errors.New("denied")
+	require.Error(t, cl.restart(subsystemDNS))
+	require.False(t, cl.dnsSet)
+}
+
+func TestProbeQuorum(t *testing.T) {
+	targets := []string{"https://a.example", "https://b.example", "https://c.example"}
+	probe := func(_ context.Context, target string) error {
+		if target == "https://c.example" {
+			return errors.New("blocked")
+		}
+		return nil
+	}
+
+	require.NoError(t, probeQuorum(context.Background(), targets, 2, probe))
+	err := probeQuorum(context.Background(), targets, 3, probe)
+	require.ErrorContains(t, err, "2 of 3 targets answered, 3 required")
+	require.ErrorContains(t, err, "https://c.example: blocked")
+}
+
+func TestProbeTargets(t *testing.T) {
+	cl := &Client{}
+	targets, quorum := cl.probeTargets()
+	require.Equal(t, []string{defaultProbeURL}, targets)
+	require.Equal(t, 1, quorum)
+
+	cl.cfg.ProbeTargets = []string{"http://intranet.corp/health", "https://portal.corp"}
+	cl.cfg.ProbeQuorum = 5
+	targets, quorum = cl.probeTargets()
+	require.Len(t, targets, 2)
+	require.Equal(t, 2, quorum)
+
+	require.NoError(t, validateProbeTarget("http://intranet.corp/health"))
+	require.Error(t, validateProbeTarget("intranet.corp"))
+	require.Error(t, validateProbeTarget("ftp://intranet.corp"))
+}
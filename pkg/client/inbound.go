@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/goxray/core/network/route"
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"golang.org/x/net/proxy"
+)
+
+// inboundBindAttempts is how many free ports XRay inbound is tried on, see Client.startXray.
+const inboundBindAttempts = 3
+
+// inboundGate exposes XRay inbound listening on loopback on a LAN address, connections of clients
+// outside of the allowed subnets are refused, see Config.InboundAllow.
+type inboundGate struct {
+	ln     net.Listener
+	allow  []*net.IPNet
+	logger *slog.Logger
+
+	mu     sync.Mutex // mu guards target.
+	target string     // Address of XRay inbound.
+}
+
+// listenInboundGate starts passing connections accepted on addr to target.
+func listenInboundGate(addr, target string, allow []*net.IPNet, logger *slog.Logger) (*inboundGate, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	g := &inboundGate{ln: ln, allow: allow, target: target, logger: logger}
+	go g.serve()
+
+	return g, nil
+}
+
+// retarget passes connections accepted from now on to target, e.g. once XRay inbound is moved by Switch.
+func (g *inboundGate) retarget(target string) {
+	g.mu.Lock()
+	g.target = target
+	g.mu.Unlock()
+}
+
+// Close stops accepting connections, accepted ones end with XRay instance.
+func (g *inboundGate) Close() error {
+	return g.ln.Close()
+}
+
+func (g *inboundGate) serve() {
+	for {
+		conn, err := g.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				g.logger.Error("inbound proxy stopped accepting connections", "err", err)
+			}
+
+			return
+		}
+		go g.handle(conn)
+	}
+}
+
+func (g *inboundGate) handle(conn net.Conn) {
+	defer conn.Close()
+
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !inboundAllowed(addr.IP, g.allow) {
+		g.logger.Warn("inbound proxy connection refused, client is not allowed", "client", conn.RemoteAddr())
+
+		return
+	}
+	g.mu.Lock()
+	target := g.target
+	g.mu.Unlock()
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		g.logger.Debug("inbound proxy dial failed", "err", err, "client", addr)
+
+		return
+	}
+	defer upstream.Close()
+
+	relay(conn, upstream)
+}
+
+// relay copies data between conn and upstream in both directions till both of them are done.
+func relay(conn, upstream net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		closeWrite(upstream)
+		close(done)
+	}()
+	_, _ = io.Copy(conn, upstream)
+	closeWrite(conn)
+	<-done
+}
+
+// closeWrite signals the end of data to the peer of conn, if conn supports half-close.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	}
+}
+
+// inboundAllowed reports whether client ip may use the exposed inbound: loopback clients always can.
+func inboundAllowed(ip net.IP, allow []*net.IPNet) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, n := range allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exposesInbound reports whether Config.InboundProxy is bound to a non-loopback address.
+func (c *Client) exposesInbound() bool {
+	return c.cfg.InboundProxy != nil && !c.cfg.InboundProxy.IP.IsLoopback()
+}
+
+// xrayInbound returns a copy of the address XRay inbound listens on. It is Config.InboundProxy, unless the proxy
+// is exposed to the LAN, then XRay listens on loopback behind inboundGate. It may change with Switch.
+func (c *Client) xrayInbound() *Proxy {
+	c.cfgMu.RLock()
+	defer c.cfgMu.RUnlock()
+
+	inbound := c.cfg.InboundProxy
+	if c.inbound.IP != nil {
+		inbound = &c.inbound
+	}
+	if inbound == nil {
+		return nil
+	}
+	cp := *inbound
+
+	return &cp
+}
+
+// dialInbound dials addr through XRay inbound, the one it listens on at the time, see Switch.
+func (c *Client) dialInbound(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer, err := proxy.SOCKS5("tcp", c.xrayInbound().String(), nil, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("socks dialer: %w", err)
+	}
+
+	return dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+}
+
+// closeInboundGate stops exposing the inbound, if it was exposed.
+func (c *Client) closeInboundGate() error {
+	if c.gate == nil {
+		return nil
+	}
+	err := c.gate.Close()
+	c.gate = nil
+
+	return err
+}
+
+// bypassInboundClients routes allowed subnets not connected to the host through the gateway, otherwise
+// replies to their clients would follow RoutesToTUN. Failures are logged, such clients then can not connect.
+func (c *Client) bypassInboundClients() {
+	links, err := upLinks()
+	if err != nil {
+		c.cfg.Logger.Warn("listing network interfaces failed, inbound proxy clients are not routed", "err", err)
+
+		return
+	}
+	routes := remoteSubnets(c.cfg.InboundAllow, links)
+	if len(routes) == 0 {
+		return
+	}
+	if err = c.router.AddBypassRoutes(routes); err != nil {
+		c.cfg.Logger.Warn("routing inbound proxy clients to default route failed", "err", err, "routes", routes)
+
+		return
+	}
+	c.gateRoutes = routes
+}
+
+// remoteSubnets returns IPv4 subnets of allow which are not within networks of links, their routes
+// are not added by the system.
+func remoteSubnets(allow []*net.IPNet, links []link) []*route.Addr {
+	var routes []*route.Addr
+	for _, n := range allow {
+		if n.IP.To4() == nil || connected(n, links) {
+			continue
+		}
+		routes = append(routes, &route.Addr{IP: n.IP.To4().Mask(n.Mask), Mask: n.Mask})
+	}
+
+	return routes
+}
+
+// connected reports whether subnet n lies within a network of one of links.
+func connected(n *net.IPNet, links []link) bool {
+	ones, _ := n.Mask.Size()
+	for _, l := range links {
+		for _, a := range l.Addrs {
+			network, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if netOnes, _ := network.Mask.Size(); netOnes <= ones && network.Contains(n.IP) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// startXray creates XRay instance connected to the server of link and starts it, see createXrayProxy.
+// The free port picked for the inbound may be taken by someone else before XRay binds it, then the instance
+// is created again on another one. Ports set in Config.InboundProxy are not changed.
+func (c *Client) startXray(ctx context.Context, link string) (xrayproto.Instance, *xrayproto.GeneralConfig, net.IP, error) {
+	for attempt := 1; ; attempt++ {
+		inst, cfg, server, err := c.createXrayProxy(ctx, link)
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			c.cfg.Logger.Error("xray core creation failed", "err", redactErr(err, link), "link", redactLink(link))
+
+			return nil, nil, nil, fmt.Errorf("create xray core instance: %w", err)
+		}
+		c.cfg.Logger.Debug("xray core instance created", "xray_config", redactedConfig{cfg: cfg})
+
+		c.cfg.Logger.Debug("starting xray core instance")
+		err = inst.Start()
+		if err == nil {
+			return inst, cfg, server, nil
+		}
+		if attempt < inboundBindAttempts && isAddrInUse(err) && c.repickInbound() {
+			c.cfg.Logger.Debug("xray inbound port is taken, retrying on another one", "err", err)
+
+			continue
+		}
+		c.cfg.Logger.Error("xray core instance startup failed", "err", err)
+
+		return nil, nil, nil, withKind(ErrXrayStart, fmt.Errorf("start xray core instance: %w", err))
+	}
+}
+
+// repickInbound moves XRay inbound to another free port. It returns false if the port is set by the user.
+func (c *Client) repickInbound() bool {
+	if !c.inboundPicked {
+		return false
+	}
+
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+
+	c.inbound.Port = getFreePort()
+	if !c.exposesInbound() {
+		c.cfg.InboundProxy = &Proxy{IP: c.inbound.IP, Port: c.inbound.Port}
+	}
+
+	return true
+}
+
+// isAddrInUse reports whether err is returned for binding an address which is taken.
+func isAddrInUse(err error) bool {
+	// XRay errors do not always wrap the cause, so the message is checked too.
+	return errors.Is(err, syscall.EADDRINUSE) || strings.Contains(err.Error(), "address already in use")
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInboundAllowed(t *testing.T) {
+	_, lan, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+	allow := []*net.IPNet{lan}
+
+	require.True(t, inboundAllowed(net.ParseIP("127.0.0.1"), allow))
+	require.True(t, inboundAllowed(net.ParseIP("192.168.1.20"), allow))
+	require.False(t, inboundAllowed(net.ParseIP("192.168.2.20"), allow))
+	require.False(t, inboundAllowed(net.ParseIP("10.0.0.1"), nil))
+}
+
+func TestRemoteSubnets(t *testing.T) {
+	ipNet := func(s string) *net.IPNet {
+		ip, n, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		n.IP = ip
+
+		return n
+	}
+	links := []link{
+		{Name: "lo", Addrs: []net.Addr{ipNet("127.0.0.1/8")}},
+		{Name: "eth0", Addrs: []net.Addr{ipNet("192.168.1.10/24")}},
+	}
+	allow := []*net.IPNet{ipNet("192.168.1.0/24"), ipNet("192.168.1.128/25"), ipNet("10.20.0.5/16"), ipNet("fd00::/64")}
+
+	require.Equal(t, []*route.Addr{route.MustParseAddr("10.20.0.0/16")}, remoteSubnets(allow, links))
+}
+
+func TestInboundGate(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	gate, err := listenInboundGate("127.0.0.1:0", upstream.Addr().String(), nil, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	defer gate.Close()
+
+	conn, err := net.Dial("tcp", gate.ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	closeWrite(conn)
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+}
+
+func TestNewClientWithOpts_InboundAllowRequired(t *testing.T) {
+	_, err := NewClientWithOpts(Config{InboundProxy: &Proxy{IP: net.IPv4zero, Port: 1080}})
+	require.ErrorContains(t, err, "requires InboundAllow")
+}
+
+func TestClient_StartXray_PortTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	gw := net.IPv4(192, 168, 1, 1)
+	cl, err := NewClientWithOpts(Config{GatewayIP: &gw, Logger: slog.New(slog.DiscardHandler)})
+	require.NoError(t, err)
+	cl.inbound = Proxy{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	cl.cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	link := "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:9?type=tcp"
+	inst, _, _, err := cl.startXray(context.Background(), link)
+	require.NoError(t, err)
+	require.NoError(t, inst.Close())
+	require.NotEqual(t, port, cl.InboundProxy().Port, "picked port is replaced")
+	require.Equal(t, cl.InboundProxy().Port, cl.xrayInbound().Port)
+
+	// Port set by the user is kept.
+	cl.inboundPicked = false
+	cl.inbound.Port, cl.cfg.InboundProxy.Port = port, port
+	_, _, _, err = cl.startXray(context.Background(), link)
+	require.ErrorIs(t, err, ErrXrayStart)
+	require.True(t, isAddrInUse(err))
+}
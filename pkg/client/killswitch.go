@@ -0,0 +1,77 @@
+package client
+
+import (
+	"fmt"
+)
+
+// DownPolicy defines what happens to the system traffic once the Client is disconnected.
+type DownPolicy int
+
+const (
+	// DownPolicyRestore removes the TUN device and its routes, so that traffic goes directly
+	// through the default gateway again.
+	DownPolicyRestore DownPolicy = iota
+	// DownPolicyBlock keeps the TUN device and its routes in place without anything processing the packets,
+	// so that no traffic leaks outside the tunnel until Client.Release is called or the Client connects again.
+	DownPolicyBlock
+)
+
+func (p DownPolicy) String() string {
+	switch p {
+	case DownPolicyRestore:
+		return "restore"
+	case DownPolicyBlock:
+		return "block"
+	default:
+		return fmt.Sprintf("DownPolicy(%d)", p)
+	}
+}
+
+// Blocking reports whether the traffic is being blocked after disconnect according to DownPolicyBlock.
+func (c *Client) Blocking() bool {
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
+
+	return c.blocking != nil
+}
+
+// Release removes the traffic block left by Disconnect with DownPolicyBlock and restores direct routing.
+// It is a no-op if traffic is not blocked.
+func (c *Client) Release() error {
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
+
+	return c.release()
+}
+
+// release closes the blocking TUN device, the caller must hold c.tunMu.
+func (c *Client) release() error {
+	if c.blocking == nil {
+		return nil
+	}
+
+	err := c.blocking.Close()
+	c.blocking = nil
+	if err != nil {
+		return fmt.Errorf("close blocking TUN device: %w", err)
+	}
+	if err = c.deletePolicyRules(); err != nil {
+		return err
+	}
+	c.cfg.Logger.Info("traffic block released")
+
+	return nil
+}
+
+// closeTunnel closes the TUN device, or keeps it open to block the traffic if Config.OnDownPolicy is DownPolicyBlock.
+// The caller must hold c.tunMu.
+func (c *Client) closeTunnel() error {
+	if c.cfg.OnDownPolicy != DownPolicyBlock {
+		return c.tunnel.Close()
+	}
+
+	c.blocking = c.tunnel
+	c.cfg.Logger.Warn("traffic is blocked till the client is released or connected again")
+
+	return nil
+}
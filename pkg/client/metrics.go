@@ -1,47 +1,25 @@
 package client
 
 import (
-	"io"
-)
-
-// readerMetrics wraps io.ReadWriteCloser with simple metrics.
-type readerMetrics struct {
-	io.ReadWriteCloser
-
-	nRead    int
-	nWritten int
-}
-
-func newReaderMetrics(rw io.ReadWriteCloser) *readerMetrics {
-	return &readerMetrics{ReadWriteCloser: rw}
-}
-
-func (s *readerMetrics) BytesRead() int {
-	return s.nRead
-}
-
-func (s *readerMetrics) BytesWritten() int {
-	return s.nWritten
-}
+	"errors"
+	"time"
 
-func (s *readerMetrics) Read(p []byte) (n int, err error) {
-	n, err = s.ReadWriteCloser.Read(p)
-	if err == nil {
-		s.nRead += n
-	}
+	"github.com/goxray/tun/pkg/metrics"
+)
 
-	return n, err
+// MetricsConfig configures pushing of the tunnel metrics of Stats to monitoring systems, see Config.Metrics.
+type MetricsConfig struct {
+	// Exporters receive the metrics every Interval while connected, e.g. metrics.StatsD for Telegraf
+	// or Datadog agent and metrics.OTLP for an OpenTelemetry collector (default: nil, nothing is pushed).
+	Exporters []metrics.Exporter
+	// Interval is how often the metrics are pushed (default: metrics.DefaultInterval).
+	Interval time.Duration
 }
 
-func (s *readerMetrics) Write(p []byte) (n int, err error) {
-	n, err = s.ReadWriteCloser.Write(p)
-	if err == nil {
-		s.nWritten += n
+func (m MetricsConfig) validate() error {
+	if m.Interval < 0 {
+		return errors.New("metrics interval must not be negative")
 	}
 
-	return n, err
-}
-
-func (s *readerMetrics) Close() error {
-	return s.ReadWriteCloser.Close()
+	return nil
 }
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Sizes of headers subtracted from MTU to get MSS.
+const (
+	ipv4TCPHeaders = 40
+	ipv6TCPHeaders = 60
+)
+
+// clampTunnel clamps MSS of TCP connections going through the TUN device to its MTU, see clampMSS.
+func (c *Client) clampTunnel(tun io.ReadWriteCloser) io.ReadWriteCloser {
+	return &mssClampedTunnel{ReadWriteCloser: tun, mtu: c.mtu}
+}
+
+// mssClampedTunnel rewrites the MSS option of TCP SYN packets in both directions, so that neither end sends
+// segments which do not fit the MTU of the TUN device. Otherwise, e.g. LAN devices of Config.ShareLAN with
+// a larger MTU announce a larger MSS and their sessions stall once full sized segments are dropped.
+type mssClampedTunnel struct {
+	io.ReadWriteCloser
+
+	mtu int
+}
+
+func (t *mssClampedTunnel) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	if n > 0 {
+		clampMSS(p[:n], t.mtu)
+	}
+
+	return n, err
+}
+
+// Write clamps MSS of p in place before writing it.
+func (t *mssClampedTunnel) Write(p []byte) (int, error) {
+	clampMSS(p, t.mtu)
+
+	return t.ReadWriteCloser.Write(p)
+}
+
+// clampMSS lowers the MSS option of pkt, if it is an IPv4 or IPv6 TCP SYN packet, to mtu minus 40 or 60 bytes
+// of headers and updates the checksum. It reports whether pkt was changed. IPv6 packets with extension headers
+// are left as they are.
+func clampMSS(pkt []byte, mtu int) bool {
+	if len(pkt) < 1 {
+		return false
+	}
+
+	var tcp, pseudo []byte
+	var limit int
+	switch pkt[0] >> 4 {
+	case 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		if len(pkt) < 20 || ihl < 20 || pkt[9] != 6 || binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 {
+			return false // Not TCP or not the first fragment.
+		}
+		total := min(int(binary.BigEndian.Uint16(pkt[2:4])), len(pkt))
+		if total < ihl {
+			return false
+		}
+		tcp, limit = pkt[ihl:total], mtu-ipv4TCPHeaders
+		pseudo = append(append([]byte(nil), pkt[12:20]...), 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+	case 6:
+		if len(pkt) < 40 || pkt[6] != 6 {
+			return false
+		}
+		total := min(40+int(binary.BigEndian.Uint16(pkt[4:6])), len(pkt))
+		tcp, limit = pkt[40:total], mtu-ipv6TCPHeaders
+		pseudo = append(append([]byte(nil), pkt[8:40]...), 0, 0, byte(len(tcp)>>8), byte(len(tcp)), 0, 0, 0, 6)
+	default:
+		return false
+	}
+	if len(tcp) < 20 || tcp[13]&0x02 == 0 || limit <= 0 {
+		return false // Not SYN.
+	}
+
+	offset := int(tcp[12]>>4) * 4
+	if offset < 20 || offset > len(tcp) {
+		return false
+	}
+	opts := tcp[20:offset]
+	for i := 0; i < len(opts); {
+		switch kind := opts[i]; {
+		case kind == 0:
+			return false // End of options.
+		case kind == 1:
+			i++ // No-operation.
+		case i+1 >= len(opts) || opts[i+1] < 2:
+			return false // Malformed.
+		case kind == 2 && opts[i+1] == 4 && i+4 <= len(opts):
+			if int(binary.BigEndian.Uint16(opts[i+2:])) <= limit {
+				return false
+			}
+			binary.BigEndian.PutUint16(opts[i+2:], uint16(limit))
+			binary.BigEndian.PutUint16(tcp[16:18], 0)
+			binary.BigEndian.PutUint16(tcp[16:18], ^foldSum(onesSum(onesSum(0, pseudo), tcp)))
+
+			return true
+		default:
+			i += int(opts[i+1])
+		}
+	}
+
+	return false
+}
+
+// onesSum adds b to the one's complement sum of 16-bit words acc.
+func onesSum(acc uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		acc += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		acc += uint32(b[len(b)-1]) << 8
+	}
+
+	return acc
+}
+
+// foldSum folds the carries of acc into 16 bits.
+func foldSum(acc uint32) uint16 {
+	for acc>>16 != 0 {
+		acc = acc&0xffff + acc>>16
+	}
+
+	return uint16(acc)
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPacket returns IPv4 or IPv6 TCP packet from src to dst with flags and TCP options opts, checksum included.
+func tcpPacket(src, dst net.IP, flags byte, opts []byte) []byte {
+	tcp := make([]byte, 20, 20+len(opts))
+	binary.BigEndian.PutUint16(tcp[0:], 50000)
+	binary.BigEndian.PutUint16(tcp[2:], 443)
+	tcp[12], tcp[13] = byte((20+len(opts))/4)<<4, flags
+	tcp = append(tcp, opts...)
+
+	var ip, pseudo []byte
+	if src.To4() != nil {
+		ip = make([]byte, 20)
+		ip[0], ip[8], ip[9] = 0x45, 64, 6
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		copy(ip[12:], src.To4())
+		copy(ip[16:], dst.To4())
+		pseudo = append(append([]byte(nil), ip[12:20]...), 0, 6, 0, byte(len(tcp)))
+	} else {
+		ip = make([]byte, 40)
+		ip[0], ip[6], ip[7] = 0x60, 6, 64
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		copy(ip[8:], src.To16())
+		copy(ip[24:], dst.To16())
+		pseudo = append(append([]byte(nil), ip[8:40]...), 0, 0, 0, byte(len(tcp)), 0, 0, 0, 6)
+	}
+	binary.BigEndian.PutUint16(tcp[16:], ^foldSum(onesSum(onesSum(0, pseudo), tcp)))
+
+	return append(ip, tcp...)
+}
+
+// mssOption returns MSS option after a no-operation, so that its value is not aligned to 16 bits.
+func mssOption(mss uint16) []byte {
+	return []byte{1, 2, 4, byte(mss >> 8), byte(mss), 1, 1, 0}
+}
+
+func TestClampMSS(t *testing.T) {
+	v4src, v4dst := net.IPv4(192, 168, 1, 10), net.IPv4(1, 2, 3, 4)
+	v6src, v6dst := net.ParseIP("fd00::10"), net.ParseIP("2001:db8::1")
+	const syn, ack = 0x02, 0x10
+
+	tests := []struct {
+		name    string
+		pkt     []byte
+		hdr     int
+		changed bool
+		mss     uint16
+	}{
+		{name: "ipv4 syn", pkt: tcpPacket(v4src, v4dst, syn, mssOption(1460)), hdr: 20, changed: true, mss: 1360},
+		{name: "ipv4 syn-ack", pkt: tcpPacket(v4dst, v4src, syn|ack, mssOption(1460)), hdr: 20, changed: true, mss: 1360},
+		{name: "ipv6 syn", pkt: tcpPacket(v6src, v6dst, syn, mssOption(1440)), hdr: 40, changed: true, mss: 1340},
+		{name: "small mss", pkt: tcpPacket(v4src, v4dst, syn, mssOption(1200)), hdr: 20, mss: 1200},
+		{name: "not syn", pkt: tcpPacket(v4src, v4dst, ack, mssOption(1460)), hdr: 20, mss: 1460},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := bytes.Clone(tt.pkt)
+			require.Equal(t, tt.changed, clampMSS(tt.pkt, 1400))
+			if !tt.changed {
+				require.Equal(t, orig, tt.pkt)
+			}
+			tcp := tt.pkt[tt.hdr:]
+			require.Equal(t, tt.mss, binary.BigEndian.Uint16(tcp[23:]))
+
+			var pseudo []byte
+			if tt.hdr == 20 {
+				pseudo = append(append([]byte(nil), tt.pkt[12:20]...), 0, 6, 0, byte(len(tcp)))
+			} else {
+				pseudo = append(append([]byte(nil), tt.pkt[8:40]...), 0, 0, 0, byte(len(tcp)), 0, 0, 0, 6)
+			}
+			require.Equal(t, uint16(0xffff), foldSum(onesSum(onesSum(0, pseudo), tcp)), "checksum")
+		})
+	}
+
+	require.False(t, clampMSS([]byte{0x45, 0}, 1400))
+	require.False(t, clampMSS(nil, 1400))
+	udp := tcpPacket(v4src, v4dst, syn, mssOption(1460))
+	udp[9] = 17
+	require.False(t, clampMSS(udp, 1400))
+}
@@ -0,0 +1,17 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// neighborMAC returns MAC address of ip from the ARP table.
+func neighborMAC(ip net.IP) (net.HardwareAddr, error) {
+	table, err := os.ReadFile("/proc/net/arp")
+	if err != nil {
+		return nil, fmt.Errorf("read arp table: %w", err)
+	}
+
+	return parseNeighborMAC(string(table), ip)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+)
+
+// neighborMAC returns MAC address of ip from the ARP table.
+func neighborMAC(ip net.IP) (net.HardwareAddr, error) {
+	out, err := exec.Command("arp", "-an").Output()
+	if err != nil {
+		return nil, fmt.Errorf("arp: %w", err)
+	}
+
+	return parseNeighborMAC(string(out), ip)
+}
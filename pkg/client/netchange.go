@@ -0,0 +1,46 @@
+package client
+
+import (
+	"net"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// networkChangeDebounce is how long to wait for the network to settle after a change before following it.
+const networkChangeDebounce = 2 * time.Second
+
+// followNetworkChange moves the route exception for XRay server to the current default gateway
+// after the network was changed, e.g. on roaming to another Wi-Fi, and restores it if it was wiped.
+func (c *Client) followNetworkChange() {
+	gw, err := discoverGateway(c.wsl)
+	if err != nil {
+		c.cfg.Logger.Debug("no default gateway after network change", "err", err)
+
+		return
+	}
+
+	if err = c.followGateway(gw); err != nil {
+		c.cfg.Logger.Warn("moving xray server route to the new gateway failed", "gateway", gw, "err", err)
+	}
+}
+
+// followGateway moves the route exception for XRay server to gateway gw and restores it if it was wiped.
+func (c *Client) followGateway(gw net.IP) error {
+	old := c.router.Gateway()
+	if err := c.router.SetGateway(gw); err != nil {
+		return err
+	}
+	if !old.Equal(gw) {
+		c.cfg.Logger.Info("gateway changed", "old", old, "new", gw)
+		c.emit(observe.EventGatewayChanged, "old", old.String(), "new", gw.String())
+	}
+
+	if restored, err := c.router.EnsureServerRoute(); err != nil {
+		c.cfg.Logger.Warn("xray server route check failed", "err", err)
+	} else if restored {
+		c.cfg.Logger.Info("xray server route restored after network change")
+	}
+
+	return nil
+}
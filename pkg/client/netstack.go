@@ -0,0 +1,62 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/option"
+)
+
+// Limits of TCP buffer sizes of gVisor netstack.
+const (
+	netstackMinBuffer = 4 << 10
+	netstackMaxBuffer = 4 << 20
+)
+
+// NetstackOptions tune gVisor netstack of tun2socks, the userspace TCP/IP stack terminating connections
+// of the TUN device before they are passed to XRay inbound, see Config.Netstack. Zero fields keep the defaults of tun2socks.
+type NetstackOptions struct {
+	// TCPSendBufferSize is the initial send buffer of TCP connections in bytes, 4KiB to 4MiB (default: 1MiB).
+	TCPSendBufferSize int
+	// TCPReceiveBufferSize is the initial receive buffer of TCP connections in bytes, 4KiB to 4MiB (default: 1MiB).
+	// It sets the window advertised to applications and its scale.
+	TCPReceiveBufferSize int
+	// ModerateReceiveBuffer grows receive buffers, and so the window, of busy connections up to 4MiB,
+	// which helps bulk downloads over high latency links at the cost of memory (default: false).
+	ModerateReceiveBuffer bool
+	// CongestionControl is the algorithm of TCP congestion control, "reno" or "cubic" (default: "reno").
+	CongestionControl string
+}
+
+func (o NetstackOptions) validate() error {
+	for _, size := range []int{o.TCPSendBufferSize, o.TCPReceiveBufferSize} {
+		if size != 0 && (size < netstackMinBuffer || size > netstackMaxBuffer) {
+			return fmt.Errorf("netstack TCP buffer size %d is out of %d-%d", size, netstackMinBuffer, netstackMaxBuffer)
+		}
+	}
+	switch o.CongestionControl {
+	case "", "reno", "cubic":
+	default:
+		return fmt.Errorf("unknown TCP congestion control %q, reno or cubic is expected", o.CongestionControl)
+	}
+
+	return nil
+}
+
+// options returns the stack options applied on top of the defaults of tun2socks.
+func (o NetstackOptions) options() []option.Option {
+	var opts []option.Option
+	if o.TCPSendBufferSize > 0 {
+		opts = append(opts, option.WithTCPSendBufferSize(o.TCPSendBufferSize))
+	}
+	if o.TCPReceiveBufferSize > 0 {
+		opts = append(opts, option.WithTCPReceiveBufferSize(o.TCPReceiveBufferSize))
+	}
+	if o.ModerateReceiveBuffer {
+		opts = append(opts, option.WithTCPModerateReceiveBuffer(true))
+	}
+	if o.CongestionControl != "" {
+		opts = append(opts, option.WithTCPCongestionControl(o.CongestionControl))
+	}
+
+	return opts
+}
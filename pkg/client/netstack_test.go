@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestNetstackOptions_Validate(t *testing.T) {
+	require.NoError(t, NetstackOptions{}.validate())
+	require.NoError(t, NetstackOptions{TCPSendBufferSize: 2 << 20, TCPReceiveBufferSize: 4 << 20, CongestionControl: "cubic"}.validate())
+	require.Error(t, NetstackOptions{TCPReceiveBufferSize: 1024}.validate())
+	require.Error(t, NetstackOptions{CongestionControl: "bbr"}.validate())
+}
+
+func TestFlowPipe_NetstackOptions(t *testing.T) {
+	opts := pipeOpts{MTU: defaultMTU, Netstack: NetstackOptions{
+		TCPSendBufferSize:     64 << 10,
+		TCPReceiveBufferSize:  4 << 20,
+		ModerateReceiveBuffer: true,
+		CongestionControl:     "cubic",
+	}}
+	p := newFlowPipe(opts, observe.NewFlowTable(), nopObserver, slog.New(slog.DiscardHandler))
+	tunSide, _ := net.Pipe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	context.AfterFunc(ctx, func() { _ = tunSide.Close() }) // Like Disconnect, the device is closed with the pipe.
+	require.ErrorIs(t, p.Copy(ctx, tunSide, "127.0.0.1:1080"), context.DeadlineExceeded)
+}
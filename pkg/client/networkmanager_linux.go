@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// nmRunDir exists while NetworkManager is running.
+const nmRunDir = "/run/NetworkManager"
+
+// nmAvailable reports whether NetworkManager is running.
+func nmAvailable() bool {
+	_, err := os.Stat(nmRunDir)
+
+	return err == nil
+}
+
+// nmSetUnmanaged tells NetworkManager to leave addresses, routes and DNS of the device ifName alone.
+func nmSetUnmanaged(ifName string) error {
+	out, err := exec.Command("nmcli", "device", "set", ifName, "managed", "no").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nmcli: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// watchNetworkManager follows primary connection and connectivity changes reported by NetworkManager,
+// see followNetworkChange. It returns when ctx is done.
+func (c *Client) watchNetworkManager(ctx context.Context) {
+	cmd := exec.CommandContext(ctx, "nmcli", "monitor")
+	out, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		c.cfg.Logger.Warn("watching NetworkManager failed", "err", err)
+
+		return
+	}
+	defer func() { _ = cmd.Wait() }()
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			if isNMNetworkChange(scanner.Text()) {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			c.cfg.Logger.Debug("NetworkManager reported network change")
+			settle = time.After(networkChangeDebounce)
+		case <-settle:
+			settle = nil
+			c.followNetworkChange()
+		}
+	}
+}
+
+// isNMNetworkChange reports whether the line of `nmcli monitor` output means that the uplink may have changed.
+func isNMNetworkChange(line string) bool {
+	return strings.HasSuffix(line, "is now the primary connection") || strings.HasPrefix(line, "Connectivity is now")
+}
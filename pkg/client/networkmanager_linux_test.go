@@ -0,0 +1,14 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsNMNetworkChange(t *testing.T) {
+	require.True(t, isNMNetworkChange("'Home WiFi' is now the primary connection"))
+	require.True(t, isNMNetworkChange("Connectivity is now 'full'"))
+	require.False(t, isNMNetworkChange("tun0: device created"))
+	require.False(t, isNMNetworkChange("wlp2s0: using connection 'Home WiFi'"))
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+func nmAvailable() bool {
+	return false
+}
+
+func nmSetUnmanaged(string) error {
+	return errors.New("NetworkManager is supported on Linux only")
+}
+
+func (c *Client) watchNetworkManager(context.Context) {}
@@ -0,0 +1,368 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	"github.com/xtls/xray-core/app/dispatcher"
+	xapplog "github.com/xtls/xray-core/app/log"
+	"github.com/xtls/xray-core/app/proxyman"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+	xtls "github.com/xtls/xray-core/transport/internet/tls"
+)
+
+// XRayMux configures multiplexing of the connections to the server, see Config.XRayMux.
+// It is applied to the outbound of the exit server only.
+type XRayMux struct {
+	Enabled bool
+	// Concurrency is the number of connections carried by one underlying connection, 1 to 1024
+	// (default: 0, XRay default of 8), -1 sends TCP connections without mux.
+	Concurrency int
+	// XudpConcurrency is the number of UDP sessions carried by one underlying connection, 1 to 1024
+	// (default: 0, UDP sessions share the connections of TCP), -1 sends UDP sessions without mux.
+	XudpConcurrency int
+}
+
+// validate checks the limits of the mux settings.
+func (m XRayMux) validate() error {
+	if m.Concurrency < -1 || m.Concurrency > 1024 || m.XudpConcurrency < -1 || m.XudpConcurrency > 1024 {
+		return errors.New("mux concurrency must be between -1 and 1024")
+	}
+
+	return nil
+}
+
+// TLSOverrides replace TLS settings of the link of the exit server, e.g. a wrong SNI of a provider link,
+// see Config.TLSOverrides. Empty fields keep the settings of the link.
+type TLSOverrides struct {
+	SNI  string   // Server name sent in the handshake, for REALITY too.
+	ALPN []string // Protocols offered in the handshake, e.g. "h2" and "http/1.1".
+	// AllowInsecure, if set, decides whether the certificate of the server is verified, even if the link
+	// sets allowInsecure, Config.TLSAllowInsecure is ignored then.
+	AllowInsecure *bool
+}
+
+// fragmentTag is the tag of the outbound fragmenting connections of the first hop, see TLSFragment.
+const fragmentTag = "fragment"
+
+// TLSFragment splits the start of connections to the server into small TCP segments sent with delays,
+// which hides TLS ClientHello from DPI that does not reassemble the stream, see Config.TLSFragment.
+type TLSFragment struct {
+	// Packets selects what is split: "tlshello" for ClientHello only (default), or a range of TCP segments, e.g. "1-3".
+	Packets string
+	// Length is the range of segment lengths in bytes, e.g. "100-200". Fragmentation is enabled when it is set.
+	Length string
+	// Interval is the range of delays between segments in milliseconds, e.g. "10-20" (default: "0", no delay).
+	Interval string
+}
+
+func (f TLSFragment) enabled() bool {
+	return f.Length != ""
+}
+
+// outbound returns the freedom outbound fragmenting the connections dialed through it.
+func (f TLSFragment) outbound() (*conf.OutboundDetourConfig, error) {
+	packets, interval := f.Packets, f.Interval
+	if packets == "" {
+		packets = "tlshello"
+	}
+	if interval == "" {
+		interval = "0"
+	}
+	settings, err := json.Marshal(map[string]any{
+		"fragment": map[string]string{"packets": packets, "length": f.Length, "interval": interval},
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(settings)
+
+	return &conf.OutboundDetourConfig{Protocol: "freedom", Tag: fragmentTag, Settings: &raw}, nil
+}
+
+// validate checks that the fragment settings are accepted by XRay.
+func (f TLSFragment) validate() error {
+	if !f.enabled() {
+		return nil
+	}
+	detour, err := f.outbound()
+	if err == nil {
+		_, err = detour.Build()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid TLS fragment settings: %w", err)
+	}
+
+	return nil
+}
+
+// validateFingerprint checks that fp is a uTLS fingerprint known to XRay.
+func validateFingerprint(fp string) error {
+	if fp != "" && xtls.GetFingerprint(fp) == nil {
+		return fmt.Errorf("unknown TLS fingerprint %q", fp)
+	}
+
+	return nil
+}
+
+// exitTag is the tag of the outbound of the exit server, the one traffic is sent to unless routing
+// of Config.XRayExtra says otherwise.
+const exitTag = "proxy"
+
+// xrayExtra are the sections of the XRay config accepted in Config.XRayExtra.
+type xrayExtra struct {
+	Routing          *conf.RouterConfig           `json:"routing"`
+	DNS              *conf.DNSConfig              `json:"dns"`
+	Observatory      *conf.ObservatoryConfig      `json:"observatory"`
+	BurstObservatory *conf.BurstObservatoryConfig `json:"burstObservatory"`
+	Outbounds        []conf.OutboundDetourConfig  `json:"outbounds"`
+}
+
+// parseXRayExtra builds the sections of raw into apps and outbounds added to the generated config.
+func parseXRayExtra(raw json.RawMessage) ([]*serial.TypedMessage, []*core.OutboundHandlerConfig, error) {
+	if len(raw) == 0 {
+		return nil, nil, nil
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &sections); err != nil {
+		return nil, nil, fmt.Errorf("invalid XRayExtra: %w", err)
+	}
+	for name := range sections {
+		switch name {
+		case "routing", "dns", "observatory", "burstObservatory", "outbounds":
+		default:
+			return nil, nil, fmt.Errorf("invalid XRayExtra: section %q is not supported", name)
+		}
+	}
+	var extra xrayExtra
+	if err := json.Unmarshal(raw, &extra); err != nil {
+		return nil, nil, fmt.Errorf("invalid XRayExtra: %w", err)
+	}
+
+	var apps []*serial.TypedMessage
+	if extra.Routing != nil {
+		routing, err := extra.Routing.Build()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid XRayExtra routing: %w", err)
+		}
+		apps = append(apps, serial.ToTypedMessage(routing))
+	}
+	if extra.DNS != nil {
+		dns, err := extra.DNS.Build()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid XRayExtra dns: %w", err)
+		}
+		apps = append(apps, serial.ToTypedMessage(dns))
+	}
+	if extra.Observatory != nil {
+		observatory, err := extra.Observatory.Build()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid XRayExtra observatory: %w", err)
+		}
+		apps = append(apps, serial.ToTypedMessage(observatory))
+	}
+	if extra.BurstObservatory != nil {
+		observatory, err := extra.BurstObservatory.Build()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid XRayExtra burstObservatory: %w", err)
+		}
+		apps = append(apps, serial.ToTypedMessage(observatory))
+	}
+
+	var outbounds []*core.OutboundHandlerConfig
+	for _, detour := range extra.Outbounds {
+		outbound, err := detour.Build()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid XRayExtra outbound %q: %w", detour.Tag, err)
+		}
+		outbounds = append(outbounds, outbound)
+	}
+
+	return apps, outbounds, nil
+}
+
+// makeXrayInstance builds XRay instance like xray.Core.MakeInstance, but with an outbound per hop of Config.Chain,
+// the last hop is the exit server. Each outbound dials its server through the outbound of the previous hop.
+// If pin is set, the first hop is connected on its address family only, see resolveServer.
+func (c *Client) makeXrayInstance(svc *xray.Core, hops []xrayproto.Protocol, pin net.IP) (xrayproto.Instance, error) {
+	cfg := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&xapplog.Config{
+				ErrorLogType:  svc.LogType,
+				AccessLogType: svc.LogType,
+				ErrorLogLevel: svc.LogLevel,
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+	cfg.App = append(cfg.App, xrayStatsApps()...)
+	if svc.Inbound != nil {
+		cfg.App = append(cfg.App, serial.ToTypedMessage(&proxyman.InboundConfig{}))
+		detour, err := svc.Inbound.BuildInboundDetourConfig()
+		if err != nil {
+			return nil, err
+		}
+		inbound, err := detour.Build()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Inbound = []*core.InboundHandlerConfig{inbound}
+	}
+	if c.cfg.HTTPInbound != nil {
+		detour, err := httpInbound(c.cfg.HTTPInbound)
+		if err != nil {
+			return nil, err
+		}
+		inbound, err := detour.Build()
+		if err != nil {
+			return nil, fmt.Errorf("http inbound: %w", err)
+		}
+		cfg.Inbound = append(cfg.Inbound, inbound)
+	}
+
+	// XRay sends the traffic to the first outbound, so the exit goes first.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := hops[i].(xray.Protocol)
+		if !ok {
+			return nil, fmt.Errorf("unsupported protocol of chain link %d", i+1)
+		}
+		detour, err := hop.BuildOutboundDetourConfig(svc.AllowInsecure)
+		if err != nil {
+			return nil, err
+		}
+		if i == len(hops)-1 {
+			c.adjustExit(detour)
+		}
+		if i == 0 {
+			c.adjustFirst(detour)
+			pinFamily(detour, pin)
+		}
+		detour.Tag = exitTag
+		if i < len(hops)-1 {
+			detour.Tag = chainHopTag(i)
+		}
+		if i > 0 {
+			detour.ProxySettings = &conf.ProxyConfig{Tag: chainHopTag(i - 1)}
+		}
+		outbound, err := buildOutbound(detour)
+		if err != nil {
+			return nil, fmt.Errorf("chain link %d: %w", i+1, err)
+		}
+		cfg.Outbound = append(cfg.Outbound, outbound)
+	}
+	if c.cfg.TLSFragment.enabled() {
+		detour, err := c.cfg.TLSFragment.outbound()
+		if err != nil {
+			return nil, err
+		}
+		if c.cfg.UpstreamProxy != nil {
+			setDialerProxy(detour, upstreamTag)
+		}
+		outbound, err := detour.Build()
+		if err != nil {
+			return nil, fmt.Errorf("fragment outbound: %w", err)
+		}
+		cfg.Outbound = append(cfg.Outbound, outbound)
+	}
+	if c.cfg.UpstreamProxy != nil {
+		detour, err := upstreamOutbound(c.cfg.UpstreamProxy)
+		if err != nil {
+			return nil, err
+		}
+		outbound, err := detour.Build()
+		if err != nil {
+			return nil, fmt.Errorf("upstream outbound: %w", err)
+		}
+		cfg.Outbound = append(cfg.Outbound, outbound)
+	}
+	apps, outbounds, err := parseXRayExtra(c.cfg.XRayExtra)
+	if err != nil {
+		return nil, err
+	}
+	cfg.App = append(cfg.App, apps...)
+	cfg.Outbound = append(cfg.Outbound, outbounds...)
+
+	return core.New(cfg)
+}
+
+// buildOutbound is detour.Build returning panics of XRay config builders as errors, they panic on some
+// malformed values of links, e.g. a vless id of 32 characters which is not a UUID.
+func buildOutbound(detour *conf.OutboundDetourConfig) (_ *core.OutboundHandlerConfig, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed %s outbound: %v", detour.Protocol, r)
+		}
+	}()
+
+	return detour.Build()
+}
+
+// adjustExit applies the settings of Config on top of the outbound generated from the link of the exit server.
+func (c *Client) adjustExit(detour *conf.OutboundDetourConfig) {
+	if m := c.cfg.XRayMux; m.Enabled {
+		detour.MuxSettings = &conf.MuxConfig{
+			Enabled:         true,
+			Concurrency:     int16(m.Concurrency),
+			XudpConcurrency: int16(m.XudpConcurrency),
+		}
+	}
+
+	o := c.cfg.TLSOverrides
+	if detour.StreamSetting == nil {
+		return
+	}
+	if tls := detour.StreamSetting.TLSSettings; tls != nil {
+		if o.SNI != "" {
+			tls.ServerName = o.SNI
+		}
+		if len(o.ALPN) > 0 {
+			alpn := conf.StringList(o.ALPN)
+			tls.ALPN = &alpn
+		}
+		if o.AllowInsecure != nil {
+			tls.Insecure = *o.AllowInsecure
+		}
+	}
+	if reality := detour.StreamSetting.REALITYSettings; reality != nil && o.SNI != "" {
+		reality.ServerName = o.SNI
+	}
+}
+
+// adjustFirst applies the settings of Config on top of the outbound of the server connected directly,
+// the exit server or the first relay of Config.Chain. Only its handshake is seen by the local network.
+func (c *Client) adjustFirst(detour *conf.OutboundDetourConfig) {
+	if fp := c.cfg.TLSFingerprint; fp != "" && detour.StreamSetting != nil {
+		if detour.StreamSetting.TLSSettings != nil {
+			detour.StreamSetting.TLSSettings.Fingerprint = fp
+		}
+		if detour.StreamSetting.REALITYSettings != nil {
+			detour.StreamSetting.REALITYSettings.Fingerprint = fp
+		}
+	}
+	// The fragment outbound dials through the upstream proxy itself.
+	switch {
+	case c.cfg.TLSFragment.enabled():
+		setDialerProxy(detour, fragmentTag)
+	case c.cfg.UpstreamProxy != nil:
+		setDialerProxy(detour, upstreamTag)
+	}
+}
+
+// setDialerProxy makes the outbound dial its server through the outbound tagged tag.
+func setDialerProxy(detour *conf.OutboundDetourConfig, tag string) {
+	if detour.StreamSetting == nil {
+		detour.StreamSetting = &conf.StreamConfig{}
+	}
+	if detour.StreamSetting.SocketSettings == nil {
+		detour.StreamSetting.SocketSettings = &conf.SocketConfig{}
+	}
+	detour.StreamSetting.SocketSettings.DialerProxy = tag
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"testing"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// exitDetour returns the outbound generated from link with the settings of cfg applied.
+func exitDetour(t *testing.T, cfg Config, link string) *conf.OutboundDetourConfig {
+	svc := xray.NewXrayService(false, false)
+	protocol, _, err := parseLink(svc, link)
+	require.NoError(t, err)
+	detour, err := protocol.(xray.Protocol).BuildOutboundDetourConfig(false)
+	require.NoError(t, err)
+	(&Client{cfg: cfg}).adjustExit(detour)
+
+	return detour
+}
+
+func TestAdjustExit_Mux(t *testing.T) {
+	link := "vless://0c5b1e6a-1111-2222-3333-444455556666@1.2.3.4:443?type=tcp"
+	require.Nil(t, exitDetour(t, Config{}, link).MuxSettings)
+
+	detour := exitDetour(t, Config{XRayMux: XRayMux{Enabled: true, Concurrency: 16, XudpConcurrency: -1}}, link)
+	require.Equal(t, &conf.MuxConfig{Enabled: true, Concurrency: 16, XudpConcurrency: -1}, detour.MuxSettings)
+	_, err := detour.Build()
+	require.NoError(t, err)
+
+	require.Error(t, XRayMux{Enabled: true, Concurrency: 2000}.validate())
+	require.NoError(t, XRayMux{Enabled: true, Concurrency: -1}.validate())
+}
+
+func TestAdjustFirst(t *testing.T) {
+	svc := xray.NewXrayService(false, false)
+	protocol, _, err := parseLink(svc, "vless://0c5b1e6a-1111-2222-3333-444455556666@1.2.3.4:443?type=tcp&security=tls&sni=example.com&fp=chrome")
+	require.NoError(t, err)
+	detour, err := protocol.(xray.Protocol).BuildOutboundDetourConfig(false)
+	require.NoError(t, err)
+	cl := &Client{cfg: Config{TLSFingerprint: "firefox", TLSFragment: TLSFragment{Length: "100-200", Interval: "10-20"}}}
+
+	cl.adjustFirst(detour)
+	require.Equal(t, "firefox", detour.StreamSetting.TLSSettings.Fingerprint)
+	require.Equal(t, fragmentTag, detour.StreamSetting.SocketSettings.DialerProxy)
+
+	inst, err := cl.makeXrayInstance(svc, []xrayproto.Protocol{protocol}, nil)
+	require.NoError(t, err)
+	require.NoError(t, inst.Close())
+}
+
+func TestValidateTLSOptions(t *testing.T) {
+	require.NoError(t, validateFingerprint(""))
+	require.NoError(t, validateFingerprint("ios"))
+	require.ErrorContains(t, validateFingerprint("netscape"), "unknown TLS fingerprint")
+
+	require.NoError(t, TLSFragment{}.validate())
+	require.NoError(t, TLSFragment{Packets: "1-3", Length: "100-200"}.validate())
+	require.Error(t, TLSFragment{Length: "0-10"}.validate())
+	require.Error(t, TLSFragment{Length: "many"}.validate())
+}
+
+func TestAdjustExit_TLSOverrides(t *testing.T) {
+	link := "vless://0c5b1e6a-1111-2222-3333-444455556666@1.2.3.4:443?type=tcp&security=tls&sni=wrong.example.com&allowInsecure=1"
+	secure := false
+	detour := exitDetour(t, Config{TLSOverrides: TLSOverrides{SNI: "example.com", ALPN: []string{"h2"}, AllowInsecure: &secure}}, link)
+
+	tls := detour.StreamSetting.TLSSettings
+	require.Equal(t, "example.com", tls.ServerName)
+	require.Equal(t, &conf.StringList{"h2"}, tls.ALPN)
+	require.False(t, tls.Insecure)
+
+	// Settings of the link are kept by default.
+	tls = exitDetour(t, Config{}, link).StreamSetting.TLSSettings
+	require.Equal(t, "wrong.example.com", tls.ServerName)
+	require.True(t, tls.Insecure)
+}
+
+func TestParseXRayExtra(t *testing.T) {
+	apps, outbounds, err := parseXRayExtra(nil)
+	require.NoError(t, err)
+	require.Empty(t, apps)
+	require.Empty(t, outbounds)
+
+	extra := []byte(`{
+		"routing": {"rules": [{"type": "field", "domain": ["example.com"], "outboundTag": "direct"}]},
+		"observatory": {"subjectSelector": ["proxy"]},
+		"outbounds": [{"protocol": "freedom", "tag": "direct"}]
+	}`)
+	apps, outbounds, err = parseXRayExtra(extra)
+	require.NoError(t, err)
+	require.Len(t, apps, 2)
+	require.Len(t, outbounds, 1)
+
+	_, _, err = parseXRayExtra([]byte(`{"inbounds": []}`))
+	require.ErrorContains(t, err, `section "inbounds" is not supported`)
+	_, _, err = parseXRayExtra([]byte(`{"routing": {"rules": [{"type": "field", "ip": ["not-an-ip"], "outboundTag": "direct"}]}}`))
+	require.Error(t, err)
+	_, _, err = parseXRayExtra([]byte(`[]`))
+	require.Error(t, err)
+
+	svc := xray.NewXrayService(false, false)
+	protocol, _, err := parseLink(svc, "vless://0c5b1e6a-1111-2222-3333-444455556666@1.2.3.4:443?type=tcp")
+	require.NoError(t, err)
+	inst, err := (&Client{cfg: Config{XRayExtra: extra}}).makeXrayInstance(svc, []xrayproto.Protocol{protocol}, nil)
+	require.NoError(t, err)
+	require.NoError(t, inst.Close())
+}
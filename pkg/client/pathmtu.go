@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+const (
+	// minPathMTU is the smallest MTU probed, the minimum of IPv6 which nearly every path carries.
+	minPathMTU = 1280
+	// pathMTUProbeTimeout limits probing the path MTU on connect.
+	pathMTUProbeTimeout = 10 * time.Second
+)
+
+// errPathMTUUnanswered is returned when the server does not answer even the smallest probe,
+// e.g. because ICMP is filtered, so the path MTU can not be told.
+var errPathMTUUnanswered = errors.New("server does not answer pings")
+
+// pingFunc sends an echo request of size bytes, IP header included, which must not be fragmented,
+// and reports whether it was answered.
+type pingFunc func(ctx context.Context, size int) (bool, error)
+
+// searchPathMTU returns the largest size from minPathMTU to upper answered by ping.
+func searchPathMTU(ctx context.Context, upper int, ping pingFunc) (int, error) {
+	ok, err := ping(ctx, minPathMTU)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errPathMTUUnanswered
+	}
+
+	lo, hi := minPathMTU, upper // lo is answered, sizes above hi are not probed.
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if ok, err = ping(ctx, mid); err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return lo, nil
+}
+
+// probePathMTU returns the path MTU to server up to upper, see Config.PathMTUProbe.
+func (c *Client) probePathMTU(ctx context.Context, server net.IP, upper int) (int, error) {
+	if server.To4() == nil {
+		return 0, errors.New("path MTU is probed for IPv4 servers only")
+	}
+
+	return searchPathMTU(ctx, upper, func(ctx context.Context, size int) (bool, error) {
+		return c.ping(ctx, server, size)
+	})
+}
+
+// setMTU changes MTU of the TUN device created next and of its pipe. Caller must hold tunMu.
+func (c *Client) setMTU(mtu int) {
+	c.mtu = mtu
+	if p, ok := c.pipe.(interface{ setMTU(int) }); ok {
+		p.setMTU(mtu)
+	}
+}
+
+// adjustMTU sets MTU of the TUN device to be created to the path MTU to server, if it is probed.
+// The path is probed from scratch on every connect, so a reconnect on a better network raises MTU back.
+func (c *Client) adjustMTU(ctx context.Context, server net.IP) {
+	if !c.cfg.PathMTUProbe {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pathMTUProbeTimeout)
+	defer cancel()
+	mtu, err := c.probePathMTU(ctx, server, tunMTU(c.wsl, c.router.Gateway()))
+	if err != nil {
+		c.cfg.Logger.Warn("probing path MTU failed, keeping MTU", "err", err, "mtu", c.mtu)
+
+		return
+	}
+	c.cfg.Logger.Debug("path MTU probed", "mtu", mtu)
+	c.tunMu.Lock()
+	c.setMTU(mtu)
+	c.tunMu.Unlock()
+}
+
+// fallbackMTU probes the path MTU to the server again after the tunnel failed health checks, and reopens
+// the TUN device with a lower MTU if the path MTU dropped, e.g. after roaming to a network with a tunnel
+// of its own which blackholes bigger packets. It reports whether the device was reopened.
+func (c *Client) fallbackMTU(ctx context.Context) (bool, error) {
+	c.tunMu.Lock()
+	server, ok := c.router.ServerRoute()
+	skip := c.externalTUN || c.transparent != nil || c.proxyOnly
+	prev := c.mtu
+	c.tunMu.Unlock()
+	if !c.cfg.PathMTUProbe || skip || !ok {
+		return false, nil
+	}
+
+	mtu, err := c.probePathMTU(ctx, server.Routes[0].IP, prev)
+	if err != nil {
+		return false, fmt.Errorf("probe path MTU: %w", err)
+	}
+	if mtu >= prev {
+		return false, nil
+	}
+
+	c.tunMu.Lock()
+	c.setMTU(mtu)
+	c.tunMu.Unlock()
+	if err = c.reopenTunnel(ctx); err != nil {
+		c.tunMu.Lock()
+		c.setMTU(prev) // The old device keeps running with the old MTU.
+		c.tunMu.Unlock()
+
+		return false, fmt.Errorf("reopen TUN device: %w", err)
+	}
+	c.cfg.Logger.Warn("path MTU dropped, TUN device reopened with lower MTU", "from", prev, "to", mtu)
+	c.emit(observe.EventMTUChanged, "from", prev, "to", mtu)
+
+	return true, nil
+}
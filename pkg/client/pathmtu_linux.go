@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const pathMTUSupported = true
+
+const (
+	// pingTimeout is how long an echo reply is waited for, a lost reply is taken as a dropped packet.
+	pingTimeout = time.Second
+	// pingAttempts is how many echo requests of a size are sent before it is taken as too big.
+	pingAttempts = 2
+	// ipv4ICMPHeaders is the size of IPv4 and ICMP echo headers.
+	ipv4ICMPHeaders = 28
+)
+
+var pingSeq atomic.Uint32
+
+// pingDF sends ICMP echo requests of size bytes to ip with fragmentation prohibited and reports whether
+// one of them was answered. Requests too big for a link known to the kernel fail with EMSGSIZE, and ones
+// too big further on are answered with "fragmentation needed" or dropped. It needs CAP_NET_RAW.
+func pingDF(ctx context.Context, ip net.IP, size int) (bool, error) {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false, fmt.Errorf("open ICMP socket: %w", err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.(*net.IPConn).SyscallConn()
+	if err != nil {
+		return false, fmt.Errorf("open ICMP socket: %w", err)
+	}
+	var sockErr error
+	if err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	}); err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		return false, fmt.Errorf("prohibit fragmentation: %w", err)
+	}
+
+	for range pingAttempts {
+		ok, err := pingOnce(ctx, conn, ip, size)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+// pingOnce sends a single echo request over conn and waits for its reply.
+func pingOnce(ctx context.Context, conn net.PacketConn, ip net.IP, size int) (bool, error) {
+	id, seq := os.Getpid()&0xffff, int(pingSeq.Add(1)&0xffff)
+	req, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: make([]byte, size-ipv4ICMPHeaders)},
+	}).Marshal(nil)
+	if err != nil {
+		return false, fmt.Errorf("marshal echo request: %w", err)
+	}
+
+	if _, err = conn.WriteTo(req, &net.IPAddr{IP: ip}); err != nil {
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("send echo request: %w", err)
+	}
+
+	deadline := time.Now().Add(pingTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err = conn.SetReadDeadline(deadline); err != nil {
+		return false, fmt.Errorf("set read deadline: %w", err)
+	}
+
+	buf := make([]byte, 2*defaultMTU)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return false, ctx.Err()
+			}
+
+			return false, fmt.Errorf("receive echo reply: %w", err)
+		}
+		msg, err := icmp.ParseMessage(1, buf[:n]) // 1 is ICMP protocol number.
+		if err != nil {
+			continue
+		}
+
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if msg.Type == ipv4.ICMPTypeEchoReply && body.ID == id && body.Seq == seq &&
+				from.(*net.IPAddr).IP.Equal(ip) {
+				return true, nil
+			}
+		case *icmp.DstUnreach:
+			// "Fragmentation needed" quotes the header of the dropped request.
+			if msg.Code == 4 && len(body.Data) >= 20 && net.IP(body.Data[16:20]).Equal(ip) {
+				return false, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+const pathMTUSupported = false
+
+// pingDF is not used, PathMTUProbe is rejected by NewClientWithOpts.
+func pingDF(context.Context, net.IP, int) (bool, error) {
+	return false, errors.New("path MTU probing is only supported on Linux")
+}
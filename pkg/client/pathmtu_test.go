@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// pathPing answers pings up to mtu bytes and counts them.
+func pathPing(mtu int, calls *int) func(context.Context, net.IP, int) (bool, error) {
+	return func(_ context.Context, _ net.IP, size int) (bool, error) {
+		*calls++
+
+		return size <= mtu, nil
+	}
+}
+
+func TestSearchPathMTU(t *testing.T) {
+	for _, mtu := range []int{minPathMTU, 1280, 1400, 1472, 1499, 1500} {
+		var calls int
+		ping := pathPing(mtu, &calls)
+		got, err := searchPathMTU(context.Background(), defaultMTU, func(ctx context.Context, size int) (bool, error) {
+			return ping(ctx, nil, size)
+		})
+		require.NoError(t, err)
+		require.Equal(t, mtu, got)
+		require.LessOrEqual(t, calls, 10)
+	}
+
+	_, err := searchPathMTU(context.Background(), defaultMTU, func(context.Context, int) (bool, error) { return false, nil })
+	require.ErrorIs(t, err, errPathMTUUnanswered)
+
+	errPing := errors.New("no permission")
+	_, err = searchPathMTU(context.Background(), defaultMTU, func(context.Context, int) (bool, error) { return false, errPing })
+	require.ErrorIs(t, err, errPing)
+}
+
+func TestClient_AdjustMTU(t *testing.T) {
+	var calls int
+	p := newFlowPipe(pipeOpts{MTU: defaultMTU}, observe.NewFlowTable(), nopObserver, slog.New(slog.DiscardHandler))
+	c := &Client{
+		cfg:    Config{Logger: slog.New(slog.DiscardHandler)},
+		router: newRouter(nil, net.IPv4(192, 168, 1, 1)),
+		pipe:   p,
+		mtu:    defaultMTU,
+		ping:   pathPing(1400, &calls),
+	}
+	server := net.IPv4(1, 2, 3, 4)
+
+	c.adjustMTU(context.Background(), server)
+	require.Zero(t, calls, "probing is disabled")
+
+	c.cfg.PathMTUProbe = true
+	c.adjustMTU(context.Background(), server)
+	require.Equal(t, 1400, c.mtu)
+	require.Equal(t, 1400, p.opts.MTU)
+
+	c.ping = pathPing(defaultMTU, &calls)
+	c.adjustMTU(context.Background(), server)
+	require.Equal(t, defaultMTU, c.mtu, "MTU is raised back on a better path")
+
+	c.ping = pathPing(0, &calls)
+	c.adjustMTU(context.Background(), server)
+	require.Equal(t, defaultMTU, c.mtu, "MTU is kept when the server does not answer")
+}
+
+func TestClient_FallbackMTU(t *testing.T) {
+	var calls int
+	c := newTestClient(nil, nil, nil, nil, nil)
+	c.ping = pathPing(defaultMTU, &calls)
+
+	reopened, err := c.fallbackMTU(context.Background())
+	require.NoError(t, err)
+	require.False(t, reopened)
+	require.Zero(t, calls, "probing is disabled")
+
+	c.cfg.PathMTUProbe = true
+	reopened, err = c.fallbackMTU(context.Background())
+	require.NoError(t, err)
+	require.False(t, reopened, "path MTU did not drop")
+	require.NotZero(t, calls)
+
+	c.ping = pathPing(0, &calls)
+	_, err = c.fallbackMTU(context.Background())
+	require.ErrorIs(t, err, errPathMTUUnanswered)
+	require.Equal(t, defaultMTU, c.mtu)
+}
@@ -0,0 +1,433 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xjasonlyu/tun2socks/v2/core"
+	"github.com/xjasonlyu/tun2socks/v2/core/adapter"
+	"github.com/xjasonlyu/tun2socks/v2/core/device/iobased"
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+
+	"github.com/goxray/tun/pkg/observe"
+	"github.com/goxray/tun/pkg/tracing"
+)
+
+// maxReapInterval is the longest period between idle flow checks.
+const maxReapInterval = 30 * time.Second
+
+// pipeOpts contain options for the connection between TUN device and socks proxy.
+type pipeOpts struct {
+	MTU            int           // MTU of the TUN device.
+	UDPTimeout     time.Duration // UDPTimeout closes UDP sessions with no traffic for this long.
+	MaxUDPSessions int           // MaxUDPSessions limits concurrent UDP sessions, 0 means no limit.
+	MaxTCPConns    int           // MaxTCPConns limits concurrent TCP connections, 0 means no limit.
+	TCPIdleTimeout time.Duration // TCPIdleTimeout closes TCP connections with no traffic for this long, 0 disables.
+	Netstack       NetstackOptions
+	// BreakerThreshold is the number of flows failed in a row after which new flows are refused
+	// for BreakerCooldown, 0 disables, see breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// ConnLog logs every new flow with its outcome, nil disables, see Config.ConnectionLog.
+	ConnLog *slog.Logger
+	// Route returns the XRay outbound and routing rule of the flow for ConnLog, it may be nil.
+	Route func(network observe.Network, dst netip.AddrPort) (outbound, rule string)
+	// Tracer records a span of every flow, nil disables, see Config.Tracer.
+	Tracer *tracing.Tracer
+	// DialFailure is the probability of failing a flow on purpose, see Config.Chaos.
+	DialFailure float64
+}
+
+// flowPipe routes IP packets from io.ReadWriteCloser to socks proxy and back.
+//
+// It works the same way as pipe2socks does, but every flow is dialed through flowDialer,
+// so that flows can be tracked and limited per Client.
+type flowPipe struct {
+	mu       sync.Mutex // mu guards opts.MTU, see setMTU.
+	opts     pipeOpts
+	flows    *observe.FlowTable
+	observer observe.Observer
+	logger   *slog.Logger
+	breaker  *breaker
+	upstream upstreamDialer
+
+	// accepted holds the time TCP connections were accepted from the TUN device till they are dialed,
+	// keyed by flowKey, to measure first-packet latency.
+	accepted sync.Map
+	// firstFlow is the span ended by the outcome of the next flow, see traceFirstFlow.
+	firstFlow atomic.Pointer[tracing.Span]
+}
+
+// flowKey identifies a connection by its endpoints.
+type flowKey struct {
+	src, dst netip.AddrPort
+}
+
+func newFlowPipe(opts pipeOpts, flows *observe.FlowTable, observer observe.Observer, logger *slog.Logger) *flowPipe {
+	return &flowPipe{opts: opts, flows: flows, observer: observer, logger: logger,
+		breaker: newBreaker(opts.BreakerThreshold, opts.BreakerCooldown)}
+}
+
+// traceFirstFlow makes span end with the outcome of the next flow, e.g. to time the first flow after connecting.
+// Flows are traced only with pipeOpts.ConnLog or pipeOpts.Tracer set.
+func (p *flowPipe) traceFirstFlow(span *tracing.Span) {
+	if prev := p.firstFlow.Swap(span); prev != nil {
+		prev.End(errors.New("no flow till connecting again"))
+	}
+}
+
+// Copy connects io.ReadWriteCloser to socks5 server.
+//
+// It blocks for the duration of the whole transmission and returns once ctx is cancelled.
+func (p *flowPipe) Copy(ctx context.Context, rwc io.ReadWriteCloser, socks5 string) error {
+	if err := p.redirect(socks5); err != nil {
+		return err
+	}
+
+	var dialer proxy.Dialer = &p.upstream
+	if p.opts.DialFailure > 0 {
+		dialer = &chaosDialer{Dialer: dialer, failure: p.opts.DialFailure}
+	}
+	t := tunnel.New(&flowDialer{Dialer: dialer, pipe: p}, statistic.DefaultManager)
+	t.SetUDPTimeout(p.opts.UDPTimeout)
+	t.ProcessAsync()
+	defer t.Close()
+
+	p.mu.Lock()
+	mtu := p.opts.MTU
+	p.mu.Unlock()
+	device, err := iobased.New(rwc, uint32(mtu), 0)
+	if err != nil {
+		return fmt.Errorf("create device: %w", err)
+	}
+
+	stack, err := core.CreateStack(&core.Config{
+		LinkEndpoint:     device,
+		TransportHandler: &acceptHandler{t, p},
+		Options:          p.opts.Netstack.options(),
+	})
+	if err != nil {
+		return fmt.Errorf("create stack: %w", err)
+	}
+
+	if p.opts.TCPIdleTimeout > 0 {
+		go p.reapIdle(ctx)
+	}
+
+	<-ctx.Done()
+
+	stack.Close()
+	stack.Wait()
+	p.flows.CloseAll()
+	p.accepted.Clear() // Connections still queued by the tunnel are never dialed.
+
+	if err = ctx.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return nil
+}
+
+// redirect dials flows through socks5 server from now on, flows open already keep their connections,
+// UDP sessions move to socks5 once their connections fail, see resumablePacketConn.
+func (p *flowPipe) redirect(socks5 string) error {
+	socks, err := proxy.NewSocks5(socks5, "", "")
+	if err != nil {
+		return fmt.Errorf("create socks proxy: %w", err)
+	}
+	p.upstream.current.Store(socks)
+
+	return nil
+}
+
+// errLinkDown is returned for new flows while the link is down, see flowPipe.setLinkDown.
+var errLinkDown = errors.New("network is unreachable: link is down")
+
+// setLinkDown makes new flows fail like with the server unreachable while down is true and closes the open ones,
+// as if the network link went down, e.g. to test how apps handle it.
+func (p *flowPipe) setLinkDown(down bool) {
+	p.upstream.down.Store(down)
+	if down {
+		p.flows.CloseAll()
+	}
+}
+
+// upstreamDialer dials through the socks proxy the pipe currently points to, see flowPipe.redirect.
+type upstreamDialer struct {
+	current atomic.Pointer[proxy.Socks5]
+	down    atomic.Bool // See flowPipe.setLinkDown.
+}
+
+func (d *upstreamDialer) DialContext(ctx context.Context, m *M.Metadata) (net.Conn, error) {
+	if d.down.Load() {
+		return nil, errLinkDown
+	}
+
+	return d.current.Load().DialContext(ctx, m)
+}
+
+func (d *upstreamDialer) DialUDP(m *M.Metadata) (net.PacketConn, error) {
+	if d.down.Load() {
+		return nil, errLinkDown
+	}
+
+	return d.current.Load().DialUDP(m)
+}
+
+// setMTU changes MTU of the device created by the next Copy.
+func (p *flowPipe) setMTU(mtu int) {
+	p.mu.Lock()
+	p.opts.MTU = mtu
+	p.mu.Unlock()
+}
+
+// reapIdle periodically closes TCP flows idle for longer than TCPIdleTimeout till ctx is done.
+func (p *flowPipe) reapIdle(ctx context.Context) {
+	ticker := time.NewTicker(min(p.opts.TCPIdleTimeout/2, maxReapInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, f := range p.flows.IdleFor(observe.TCP, p.opts.TCPIdleTimeout) {
+			p.logger.Debug("closing idle TCP flow", "src", f.Src, "dst", f.Dst, "idle", f.Idle())
+			p.flows.Remove(f)
+			_ = f.Close()
+			p.observer.Observe(observe.NewEvent(observe.EventFlowReaped, "network", f.Network, "dst", f.Dst))
+		}
+	}
+}
+
+// acceptHandler notes when TCP connections are accepted from the TUN device before the tunnel dials them.
+// The stack accepts a connection right on its first packet, so this is the time the SYN was seen.
+type acceptHandler struct {
+	adapter.TransportHandler
+
+	pipe *flowPipe
+}
+
+func (h *acceptHandler) HandleTCP(conn adapter.TCPConn) {
+	id := conn.ID()
+	src, _ := netip.AddrFromSlice(id.RemoteAddress.AsSlice())
+	dst, _ := netip.AddrFromSlice(id.LocalAddress.AsSlice())
+	key := flowKey{netip.AddrPortFrom(src, id.RemotePort), netip.AddrPortFrom(dst, id.LocalPort)}
+	h.pipe.accepted.Store(key, time.Now())
+	h.TransportHandler.HandleTCP(&acceptedConn{TCPConn: conn, pipe: h.pipe, key: key})
+}
+
+// acceptedConn forgets the accept time of the connection on Close, in case it was never dialed.
+type acceptedConn struct {
+	adapter.TCPConn
+
+	pipe *flowPipe
+	key  flowKey
+}
+
+func (c *acceptedConn) Close() error {
+	c.pipe.accepted.Delete(c.key)
+
+	return c.TCPConn.Close()
+}
+
+func (c *acceptedConn) CloseRead() error { return halfCloseRead(c.TCPConn) }
+
+func (c *acceptedConn) CloseWrite() error { return halfCloseWrite(c.TCPConn) }
+
+// firstReadConn calls onFirstRead once the first data is read from the connection, or onNoData
+// if reading fails before, unless the connection was closed on this side.
+type firstReadConn struct {
+	net.Conn
+
+	once        sync.Once
+	closed      atomic.Bool
+	onFirstRead func()
+	onNoData    func()
+}
+
+func (c *firstReadConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	switch {
+	case n > 0:
+		c.once.Do(c.onFirstRead)
+	case err != nil && !c.closed.Load():
+		c.once.Do(c.onNoData)
+	}
+
+	return n, err
+}
+
+func (c *firstReadConn) Close() error {
+	c.closed.Store(true)
+
+	return c.Conn.Close()
+}
+
+func (c *firstReadConn) CloseWrite() error { return halfCloseWrite(c.Conn) }
+
+// halfCloseRead and halfCloseWrite pass TCP half-close on to conn, they return errors.ErrUnsupported
+// if it can not. tun2socks ends each direction of a flow with them, so wrappers of its connections
+// have to forward them, otherwise finished flows stay open till tun2socks times them out.
+func halfCloseRead(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseRead() error }); ok {
+		return c.CloseRead()
+	}
+
+	return errors.ErrUnsupported
+}
+
+func halfCloseWrite(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+
+	return errors.ErrUnsupported
+}
+
+var (
+	// errTCPLimit is returned for new TCP flows once Config.MaxTCPConnections is reached.
+	errTCPLimit = errors.New("tcp connection limit reached")
+	// errUDPLimit is returned for new UDP sessions if Config.MaxUDPSessions is reached and none can be evicted.
+	errUDPLimit = errors.New("udp session limit reached")
+)
+
+// flowDialer dials flows through the socks proxy and registers them in the pipe flow table.
+type flowDialer struct {
+	proxy.Dialer
+
+	pipe *flowPipe
+}
+
+func (d *flowDialer) DialContext(ctx context.Context, m *M.Metadata) (net.Conn, error) {
+	log := d.pipe.newFlowLog(observe.TCP, m.SourceAddrPort(), m.DestinationAddrPort())
+	if !d.pipe.breaker.Allow() {
+		log.done(outcomeRejected, errBreakerOpen)
+
+		return nil, errBreakerOpen
+	}
+	accepted := time.Now()
+	if t, ok := d.pipe.accepted.LoadAndDelete(flowKey{m.SourceAddrPort(), m.DestinationAddrPort()}); ok {
+		accepted = t.(time.Time)
+	}
+	f, ok := d.pipe.flows.Reserve(observe.TCP, m.SourceAddrPort(), m.DestinationAddrPort(), d.pipe.opts.MaxTCPConns)
+	if !ok {
+		d.pipe.logger.Warn("rejecting TCP flow", "reason", errTCPLimit, "dst", m.DestinationAddress(),
+			"limit", d.pipe.opts.MaxTCPConns)
+		d.pipe.observer.Observe(observe.NewEvent(observe.EventFlowRejected,
+			"network", observe.TCP, "dst", m.DestinationAddrPort(), "reason", errTCPLimit.Error()))
+		log.done(outcomeRejected, errTCPLimit)
+
+		return nil, errTCPLimit
+	}
+
+	c, err := d.Dialer.DialContext(ctx, m)
+	if err != nil {
+		d.pipe.flows.Remove(f)
+		d.pipe.flowFailed()
+		log.done(outcomeFailed, err)
+
+		return nil, err
+	}
+	// XRay confirms the socks connection before reaching the server, the connection is known to be established
+	// once the server sends data. XRay closes it without any if the server is unreachable.
+	c = &firstReadConn{
+		Conn: c,
+		onFirstRead: func() {
+			d.pipe.flows.ObserveFirstPacket(time.Since(accepted))
+			d.pipe.flowReached()
+			log.done(outcomeReached, nil)
+		},
+		onNoData: func() {
+			d.pipe.flowFailed()
+			log.done(outcomeUnreachable, nil)
+		},
+	}
+	if log != nil {
+		c = &sniffedConn{Conn: c, log: log}
+	}
+
+	return d.pipe.flows.TrackConn(c, f), nil
+}
+
+func (d *flowDialer) DialUDP(m *M.Metadata) (net.PacketConn, error) {
+	log := d.pipe.newFlowLog(observe.UDP, m.SourceAddrPort(), m.DestinationAddrPort())
+	if !d.pipe.breaker.Allow() {
+		log.done(outcomeRejected, errBreakerOpen)
+
+		return nil, errBreakerOpen
+	}
+	limit := d.pipe.opts.MaxUDPSessions
+	f, ok := d.pipe.flows.Reserve(observe.UDP, m.SourceAddrPort(), m.DestinationAddrPort(), limit)
+	// Concurrent dials may take the room made by eviction, so reserving is retried until it succeeds.
+	for !ok {
+		if !d.evictUDP() {
+			log.done(outcomeRejected, errUDPLimit)
+
+			return nil, errUDPLimit
+		}
+		f, ok = d.pipe.flows.Reserve(observe.UDP, m.SourceAddrPort(), m.DestinationAddrPort(), limit)
+	}
+	pc, err := d.Dialer.DialUDP(m)
+	if err != nil {
+		d.pipe.flows.Remove(f)
+		d.pipe.flowFailed()
+		log.done(outcomeFailed, err)
+
+		return nil, err
+	}
+	log.done(outcomeOpened, nil)
+	pc = newResumablePacketConn(pc, func() (net.PacketConn, error) { return d.Dialer.DialUDP(m) }, d.pipe.logger)
+
+	return d.pipe.flows.TrackPacketConn(pc, f), nil
+}
+
+// evictUDP closes the most idle UDP session to make room for a new one.
+// It returns false if there are no sessions to evict.
+func (d *flowDialer) evictUDP() bool {
+	f := d.pipe.flows.Idlest(observe.UDP)
+	if f == nil {
+		return false
+	}
+
+	d.pipe.logger.Debug("evicting idle UDP session", "dst", f.Dst, "idle", f.Idle())
+	d.pipe.flows.Remove(f)
+	_ = f.Close()
+	d.pipe.observer.Observe(observe.NewEvent(observe.EventFlowEvicted, "network", f.Network, "dst", f.Dst))
+
+	return true
+}
+
+// flowFailed records a flow which did not reach the server and reports the tunnel degraded if the breaker tripped.
+func (p *flowPipe) flowFailed() {
+	if p.breaker.Failure() {
+		p.logger.Warn("server is unreachable, refusing new flows", "cooldown", p.breaker.cooldown)
+		p.observer.Observe(observe.NewEvent(observe.EventDegraded, "reason", errBreakerOpen.Error()))
+	}
+}
+
+// flowReached records a flow which reached the server and reports the tunnel recovered if the breaker was tripped.
+func (p *flowPipe) flowReached() {
+	if p.breaker.Success() {
+		p.logger.Info("server is reachable again")
+		p.observer.Observe(observe.NewEvent(observe.EventRecovered))
+	}
+}
+
+// resetBreaker forgets failed flows, e.g. once the connection is moved to another server.
+func (p *flowPipe) resetBreaker() {
+	p.breaker.Reset()
+}
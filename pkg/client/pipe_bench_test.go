@@ -0,0 +1,313 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+
+	"github.com/goxray/tun/pkg/observe"
+	"github.com/goxray/tun/pkg/tuntest"
+)
+
+// Benchmarks of the pipe between the TUN device and the socks proxy: applications are played by a userspace
+// TCP/IP stack or synthetic packets on an in-memory TUN device, the proxy by a loopback SOCKS5 server echoing
+// TCP and counting UDP datagrams. Compare runs with benchstat, e.g.
+//
+//	go test -run '^$' -bench BenchmarkPipe -count 10 ./pkg/client
+
+var (
+	benchAppAddr    = tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+	benchRemoteAddr = tcpip.AddrFrom4([4]byte{198, 18, 0, 1})
+)
+
+// BenchmarkPipeTCP measures MB/s of a TCP connection echoed through the pipe, both directions are counted.
+func BenchmarkPipeTCP(b *testing.B) {
+	for _, size := range []int{1 << 10, 32 << 10} {
+		b.Run(byteSize(size), func(b *testing.B) {
+			dev := startBenchPipe(b, nil)
+			conn := dialThroughDevice(b, dev)
+			chunk, got := make([]byte, size), make([]byte, size)
+
+			b.SetBytes(int64(2 * size))
+			b.ResetTimer()
+			for range b.N {
+				if _, err := conn.Write(chunk); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(conn, got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// benchUDPWindow is the number of datagrams BenchmarkPipeUDP keeps on the way, so that the rate measured
+// is the one the pipe keeps up with rather than the one of the datagrams dropped by full buffers.
+const benchUDPWindow = 64
+
+// BenchmarkPipeUDP measures packets/s of UDP datagrams sent by applications and delivered to the proxy.
+// Datagrams lost on the way are reported as loss, the ratio of the ones sent.
+func BenchmarkPipeUDP(b *testing.B) {
+	for _, size := range []int{64, 1200} {
+		b.Run(byteSize(size), func(b *testing.B) {
+			var received atomic.Int64
+			dev := startBenchPipe(b, &received)
+			// Sources of datagrams are spread over a few flows, like DNS or QUIC of several applications.
+			packets := make([][]byte, 16)
+			for i := range packets {
+				packets[i] = udpPacket(benchAppAddr, benchRemoteAddr, uint16(40000+i), 53, make([]byte, size))
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			start := time.Now()
+			for i := range b.N {
+				waitReceived(&received, int64(i-benchUDPWindow+1))
+				if err := dev.Inject(ctx, packets[i%len(packets)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			waitReceived(&received, int64(b.N))
+			elapsed := time.Since(start)
+			b.StopTimer()
+
+			b.ReportMetric(float64(received.Load())/elapsed.Seconds(), "packets/s")
+			b.ReportMetric(1-float64(received.Load())/float64(b.N), "loss")
+		})
+	}
+}
+
+// waitReceived waits till received reaches n. Lost datagrams never arrive, so it gives up after a while
+// without any arriving.
+func waitReceived(received *atomic.Int64, n int64) {
+	last, progress := received.Load(), time.Now()
+	for last < n && time.Since(progress) < 100*time.Millisecond {
+		runtime.Gosched()
+		if got := received.Load(); got != last {
+			last, progress = got, time.Now()
+		}
+	}
+}
+
+// startBenchPipe starts the pipe between an in-memory TUN device and a loopback SOCKS5 server, and returns
+// the device. UDP datagrams delivered to the server are counted in udp, they are dropped if it is nil.
+func startBenchPipe(b *testing.B, udp *atomic.Int64) *tuntest.Device {
+	socks := startSocks5(b, udp)
+	dev := tuntest.NewDevice()
+	p := newFlowPipe(pipeOpts{MTU: 1500, UDPTimeout: time.Minute}, observe.NewFlowTable(), nopObserver,
+		slog.New(slog.DiscardHandler))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = p.Copy(ctx, dev, socks)
+	}()
+	b.Cleanup(func() {
+		_ = dev.Close()
+		cancel()
+		<-done
+	})
+
+	return dev
+}
+
+// dialThroughDevice connects to benchRemoteAddr from a userspace TCP/IP stack attached to dev.
+func dialThroughDevice(b *testing.B, dev *tuntest.Device) net.Conn {
+	ep := channel.New(512, 1500, "")
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(func() {
+		cancel()
+		s.Close()
+		s.Wait()
+	})
+	if err := s.CreateNIC(1, ep); err != nil {
+		b.Fatalf("create NIC: %v", err)
+	}
+	addr := tcpip.ProtocolAddress{Protocol: ipv4.ProtocolNumber, AddressWithPrefix: benchAppAddr.WithPrefix()}
+	if err := s.AddProtocolAddress(1, addr, stack.AddressProperties{}); err != nil {
+		b.Fatalf("add address: %v", err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: 1}})
+
+	go func() {
+		for {
+			pkt := ep.ReadContext(ctx)
+			if pkt == nil {
+				return
+			}
+			view := pkt.ToView()
+			err := dev.Inject(ctx, view.AsSlice())
+			view.Release()
+			pkt.DecRef()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			packet, err := dev.Capture(ctx)
+			if err != nil {
+				return
+			}
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(packet)})
+			ep.InjectInbound(ipv4.ProtocolNumber, pkt)
+			pkt.DecRef()
+		}
+	}()
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer dialCancel()
+	conn, err := gonet.DialContextTCP(dialCtx, s, tcpip.FullAddress{NIC: 1, Addr: benchRemoteAddr, Port: 80},
+		ipv4.ProtocolNumber)
+	if err != nil {
+		b.Fatalf("dial through the pipe: %v", err)
+	}
+	b.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+// udpPacket returns IPv4 packet of UDP datagram from src to dst carrying payload.
+func udpPacket(src, dst tcpip.Address, srcPort, dstPort uint16, payload []byte) []byte {
+	packet := make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize+len(payload))
+	ip := header.IPv4(packet)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(packet)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	udp := header.UDP(ip.Payload())
+	udp.Encode(&header.UDPFields{SrcPort: srcPort, DstPort: dstPort, Length: uint16(header.UDPMinimumSize + len(payload))})
+	copy(udp.Payload(), payload)
+	sum := header.PseudoHeaderChecksum(header.UDPProtocolNumber, src, dst, udp.Length())
+	udp.SetChecksum(^udp.CalculateChecksum(checksum.Checksum(payload, sum)))
+
+	return packet
+}
+
+// startSocks5 starts SOCKS5 server on loopback and returns its address. The server echoes TCP connections
+// to any destination back and counts UDP datagrams in udp, if it is set.
+func startSocks5(b *testing.B, udp *atomic.Int64) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks5(conn, udp)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// serveSocks5 serves SOCKS5 connection without authentication, see RFC 1928.
+func serveSocks5(conn net.Conn, udp *atomic.Int64) {
+	defer conn.Close()
+	buf := make([]byte, 262)
+	// Greeting: version, number of methods and the methods.
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+	// Request: version, command, reserved, address type, address and port.
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	cmd, addrLen := buf[1], 0
+	switch buf[3] {
+	case 1:
+		addrLen = net.IPv4len
+	case 4:
+		addrLen = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		addrLen = int(buf[0])
+	}
+	if _, err := io.ReadFull(conn, buf[:addrLen+2]); err != nil {
+		return
+	}
+
+	switch cmd {
+	case 1: // CONNECT
+		if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+			return
+		}
+		_, _ = io.Copy(conn, conn)
+	case 3: // UDP ASSOCIATE
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer pc.Close()
+		bound := pc.LocalAddr().(*net.UDPAddr)
+		reply := append([]byte{5, 0, 0, 1}, bound.IP.To4()...)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(bound.Port))
+		if _, err = conn.Write(reply); err != nil {
+			return
+		}
+		go func() {
+			datagram := make([]byte, 65535)
+			for {
+				if _, _, err := pc.ReadFrom(datagram); err != nil {
+					return
+				}
+				if udp != nil {
+					udp.Add(1)
+				}
+			}
+		}()
+		_, _ = io.Copy(io.Discard, conn) // The association lasts as long as the control connection.
+	default:
+		_, _ = conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+// byteSize returns size as a sub-benchmark name, e.g. 32KiB.
+func byteSize(size int) string {
+	if size >= 1<<10 && size%(1<<10) == 0 {
+		return strconv.Itoa(size>>10) + "KiB"
+	}
+
+	return strconv.Itoa(size) + "B"
+}
@@ -0,0 +1,242 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+var nopObserver = observe.Observers(nil)
+
+// stubDialer returns local UDP sockets instead of dialing the socks proxy.
+type stubDialer struct{}
+
+func (stubDialer) DialContext(context.Context, *M.Metadata) (net.Conn, error) {
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func (stubDialer) DialUDP(*M.Metadata) (net.PacketConn, error) {
+	return net.ListenPacket("udp", "127.0.0.1:0")
+}
+
+func TestFlowDialer_MaxUDPSessions(t *testing.T) {
+	p := newFlowPipe(pipeOpts{MaxUDPSessions: 2}, observe.NewFlowTable(), nopObserver, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	d := &flowDialer{Dialer: stubDialer{}, pipe: p}
+
+	meta := func(port uint16) *M.Metadata {
+		return &M.Metadata{Network: M.UDP, DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: port}
+	}
+
+	first, err := d.DialUDP(meta(1))
+	require.NoError(t, err)
+	second, err := d.DialUDP(meta(2))
+	require.NoError(t, err)
+	require.Equal(t, 2, p.flows.Count(observe.UDP))
+
+	// Activity on the first session makes the second one the most idle.
+	_, err = first.WriteTo([]byte("ping"), first.LocalAddr())
+	require.NoError(t, err)
+
+	third, err := d.DialUDP(meta(3))
+	require.NoError(t, err)
+	require.Equal(t, 2, p.flows.Count(observe.UDP))
+
+	_, err = second.WriteTo([]byte("ping"), first.LocalAddr())
+	require.ErrorIs(t, err, net.ErrClosed)
+
+	require.NoError(t, first.Close())
+	require.NoError(t, third.Close())
+	require.Zero(t, p.flows.Count(observe.UDP))
+}
+
+func TestFlowDialer_MaxUDPSessions_Concurrent(t *testing.T) {
+	p := newFlowPipe(pipeOpts{MaxUDPSessions: 2}, observe.NewFlowTable(), nopObserver, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	d := &flowDialer{Dialer: stubDialer{}, pipe: p}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pc, err := d.DialUDP(&M.Metadata{Network: M.UDP, DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: uint16(i + 1)})
+			if err == nil {
+				t.Cleanup(func() { _ = pc.Close() })
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, 2, p.flows.Count(observe.UDP))
+	require.Equal(t, 2, p.flows.Peak(observe.UDP), "the limit is never exceeded")
+}
+
+func TestFlowDialer_MaxTCPConnections(t *testing.T) {
+	var events []observe.Event
+	observer := observe.ObserverFunc(func(e observe.Event) { events = append(events, e) })
+	p := newFlowPipe(pipeOpts{MaxTCPConns: 2}, observe.NewFlowTable(), observer, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	d := &flowDialer{Dialer: stubDialer{}, pipe: p}
+	meta := &M.Metadata{Network: M.TCP, DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: 443}
+
+	first, err := d.DialContext(context.Background(), meta)
+	require.NoError(t, err)
+	second, err := d.DialContext(context.Background(), meta)
+	require.NoError(t, err)
+
+	_, err = d.DialContext(context.Background(), meta)
+	require.ErrorIs(t, err, errTCPLimit)
+	require.Equal(t, 2, p.flows.Count(observe.TCP))
+	require.Len(t, events, 1)
+	require.Equal(t, observe.EventFlowRejected, events[0].Type)
+
+	require.NoError(t, first.Close())
+	third, err := d.DialContext(context.Background(), meta)
+	require.NoError(t, err)
+
+	require.NoError(t, second.Close())
+	require.NoError(t, third.Close())
+	require.Zero(t, p.flows.Count(observe.TCP))
+	require.Equal(t, 2, p.flows.Peak(observe.TCP))
+}
+
+func TestFlowPipe_ReapIdle(t *testing.T) {
+	p := newFlowPipe(pipeOpts{TCPIdleTimeout: 20 * time.Millisecond}, observe.NewFlowTable(), nopObserver, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	d := &flowDialer{Dialer: stubDialer{}, pipe: p}
+	meta := &M.Metadata{Network: M.TCP, DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: 443}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.reapIdle(ctx)
+
+	_, err := d.DialContext(context.Background(), meta)
+	require.NoError(t, err)
+	require.Equal(t, 1, p.flows.Count(observe.TCP))
+
+	require.Eventually(t, func() bool {
+		return p.flows.Count(observe.TCP) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+// answeringDialer returns connections on which the server has already sent a greeting.
+type answeringDialer struct {
+	stubDialer
+}
+
+func (answeringDialer) DialContext(context.Context, *M.Metadata) (net.Conn, error) {
+	c, server := net.Pipe()
+	go func() {
+		_, _ = server.Write([]byte("hello"))
+		_ = server.Close()
+	}()
+
+	return c, nil
+}
+
+func TestFlowDialer_FirstPacket(t *testing.T) {
+	p := newFlowPipe(pipeOpts{}, observe.NewFlowTable(), nopObserver, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	d := &flowDialer{Dialer: answeringDialer{}, pipe: p}
+	meta := &M.Metadata{Network: M.TCP, SrcIP: netip.MustParseAddr("192.18.0.1"), SrcPort: 50000,
+		DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: 443}
+	p.accepted.Store(flowKey{meta.SourceAddrPort(), meta.DestinationAddrPort()}, time.Now().Add(-time.Second))
+
+	conn, err := d.DialContext(context.Background(), meta)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Zero(t, p.flows.FirstPacket().Count, "nothing was received yet")
+
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	latency := p.flows.FirstPacket()
+	require.Equal(t, 1, latency.Count)
+	require.GreaterOrEqual(t, latency.Max, time.Second, "latency counts from the accepted SYN")
+
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 1, p.flows.FirstPacket().Count, "only the first read is counted")
+	_, ok := p.accepted.Load(flowKey{meta.SourceAddrPort(), meta.DestinationAddrPort()})
+	require.False(t, ok)
+}
+
+// loopbackDialer dials TCP server addr.
+type loopbackDialer struct {
+	stubDialer
+
+	addr string
+}
+
+func (d loopbackDialer) DialContext(ctx context.Context, _ *M.Metadata) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, "tcp", d.addr)
+}
+
+func TestFlowDialer_HalfClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	read := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, err = io.Copy(io.Discard, conn)
+		}
+		read <- err
+	}()
+
+	// The connection log wraps flows once more.
+	p := newFlowPipe(pipeOpts{ConnLog: slog.New(slog.DiscardHandler)}, observe.NewFlowTable(), nopObserver,
+		slog.New(slog.DiscardHandler))
+	d := &flowDialer{Dialer: loopbackDialer{addr: l.Addr().String()}, pipe: p}
+	conn, err := d.DialContext(context.Background(), &M.Metadata{Network: M.TCP,
+		DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: 443})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	cw, ok := conn.(interface{ CloseWrite() error })
+	require.True(t, ok, "tun2socks ends flows with CloseWrite")
+	require.NoError(t, cw.CloseWrite())
+	select {
+	case err = <-read:
+		require.NoError(t, err, "the server reads till the end of data")
+	case <-time.After(5 * time.Second):
+		t.Fatal("half-close did not reach the server")
+	}
+}
+
+func TestFlowPipe_LinkDown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	p := newFlowPipe(pipeOpts{}, observe.NewFlowTable(), nopObserver, slog.New(slog.DiscardHandler))
+	require.NoError(t, p.redirect(fmt.Sprintf("127.0.0.1:%d", freePort(t))))
+	meta := &M.Metadata{Network: M.TCP, DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: 443}
+	open, err := (&flowDialer{Dialer: loopbackDialer{addr: l.Addr().String()}, pipe: p}).DialContext(context.Background(), meta)
+	require.NoError(t, err)
+	defer open.Close()
+
+	p.setLinkDown(true)
+	require.Zero(t, p.flows.Count(observe.TCP), "open flows are closed")
+	d := &flowDialer{Dialer: &p.upstream, pipe: p}
+	_, err = d.DialContext(context.Background(), meta)
+	require.ErrorIs(t, err, errLinkDown)
+	_, err = d.DialUDP(&M.Metadata{Network: M.UDP, DstIP: netip.MustParseAddr("1.1.1.1"), DstPort: 53})
+	require.ErrorIs(t, err, errLinkDown)
+
+	p.setLinkDown(false)
+	_, err = d.DialContext(context.Background(), meta)
+	require.Error(t, err, "nothing listens on the proxy port")
+	require.NotErrorIs(t, err, errLinkDown)
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Plan lists the system changes Connect would make, see Client.Plan.
+type Plan struct {
+	Server       LinkInfo
+	Chain        []LinkInfo // Relays reached before Server, see Config.Chain.
+	Upstream     string     // Proxy the server is reached through with credentials masked, see Config.UpstreamProxy.
+	InboundProxy string     // Local XRay socks inbound address.
+	InboundAllow []string   // Client subnets allowed to use InboundProxy exposed to the LAN, see Config.InboundAllow.
+	TUNAddress   string     // Address of the new TUN device.
+	RoutesToTUN  []string   // Routes pointed to the TUN device.
+	// Transparent redirects TCP connections of RoutesToTUN to a local proxy instead of creating
+	// the TUN device, see EngineTProxy.
+	Transparent bool
+	// PolicyRouting puts RoutesToTUN to a dedicated table selected by rules, see Config.PolicyRouting.
+	PolicyRouting bool
+	// ServerRoute is the exception routing XRay server through Gateway, so that its traffic
+	// does not loop through the TUN device.
+	ServerRoute string
+	Gateway     string
+	// ExcludedRoutes are routed through Gateway outside of the TUN device, see Config.ExcludeRoutes.
+	ExcludedRoutes []string
+	// TUNFileDescriptor is the external TUN device used instead of creating one, see Config.TUNFileDescriptor.
+	// No routes are added with it.
+	TUNFileDescriptor int
+	DNSServers        []string // System resolvers set while connected, empty if system DNS is left unchanged.
+	DNSRules          []string // Domains resolved by other resolvers than DNSServers, see DNSRule.
+	ShareLAN          bool     // LAN devices may route through the tunnel, see Config.ShareLAN.
+	// BridgeRoutes are container and VM networks kept off the TUN device by their interface names,
+	// see Config.BypassBridges.
+	BridgeRoutes map[string][]string
+}
+
+// Plan validates the link and returns the changes Connect(link) would make without applying any of them.
+// Unless Config.DNSServers are set, system DNS settings are not changed and DNS queries follow RoutesToTUN
+// like any other traffic.
+func (c *Client) Plan(link string) (Plan, error) {
+	info, err := ValidateLink(link)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	p := Plan{
+		Server:       info,
+		InboundProxy: c.cfg.InboundProxy.String(),
+	}
+	for i, hop := range c.cfg.Chain {
+		hopInfo, err := ValidateLink(hop)
+		if err != nil {
+			return Plan{}, fmt.Errorf("chain link %d: %s", i+1, redactErr(err, hop))
+		}
+		p.Chain = append(p.Chain, hopInfo)
+	}
+	if c.cfg.UpstreamProxy != nil {
+		p.Upstream = upstreamString(c.cfg.UpstreamProxy)
+	}
+	if c.exposesInbound() {
+		for _, n := range c.cfg.InboundAllow {
+			p.InboundAllow = append(p.InboundAllow, n.String())
+		}
+	}
+	if c.cfg.TUNFileDescriptor > 0 {
+		p.TUNFileDescriptor = c.cfg.TUNFileDescriptor
+
+		return p, nil
+	}
+
+	p.Transparent = c.cfg.Engine == EngineTProxy
+	if !p.Transparent {
+		p.TUNAddress = c.cfg.TUNAddress.String()
+	}
+	p.ServerRoute = info.ServerIP.String() + "/32" // Same as router.serverRoute.
+	if len(p.Chain) > 0 {
+		p.ServerRoute = p.Chain[0].ServerIP.String() + "/32" // Only the first relay is connected directly.
+	}
+	if c.cfg.UpstreamProxy != nil {
+		ip, err := upstreamIP(c.cfg.UpstreamProxy)
+		if err != nil {
+			return Plan{}, err
+		}
+		p.ServerRoute = ip.String() + "/32"
+	}
+	p.Gateway = c.router.Gateway().String()
+	for _, r := range c.Routes() {
+		p.RoutesToTUN = append(p.RoutesToTUN, r.String())
+	}
+	p.PolicyRouting = c.cfg.PolicyRouting
+	p.ShareLAN = c.cfg.ShareLAN
+	for _, r := range c.cfg.ExcludeRoutes {
+		p.ExcludedRoutes = append(p.ExcludedRoutes, r.String())
+	}
+	if c.cfg.BypassBridges {
+		links, err := upLinks()
+		if err != nil {
+			return Plan{}, fmt.Errorf("list network interfaces: %w", err)
+		}
+		for ifName, addrs := range bridgeRoutes(links, "") {
+			if p.BridgeRoutes == nil {
+				p.BridgeRoutes = make(map[string][]string)
+			}
+			for _, a := range addrs {
+				p.BridgeRoutes[ifName] = append(p.BridgeRoutes[ifName], a.String())
+			}
+		}
+	}
+	for _, ip := range c.cfg.DNSServers {
+		p.DNSServers = append(p.DNSServers, ip.String())
+	}
+	if len(p.DNSServers) > 0 {
+		for _, r := range c.cfg.DNSRules {
+			p.DNSRules = append(p.DNSRules, r.String())
+		}
+	}
+
+	return p, nil
+}
+
+// String formats the plan for humans, one change per line.
+func (p Plan) String() string {
+	var s string
+	for _, hop := range p.Chain {
+		s += fmt.Sprintf("relay %s %s (%s)\n", hop.Protocol, hop.Server, hop.ServerIP)
+	}
+	s += fmt.Sprintf("server %s %s (%s)\n", p.Server.Protocol, p.Server.Server, p.Server.ServerIP)
+	if p.Upstream != "" {
+		s += fmt.Sprintf("dial through upstream proxy %s\n", p.Upstream)
+	}
+	s += fmt.Sprintf("start xray socks inbound on %s\n", p.InboundProxy)
+	if len(p.InboundAllow) > 0 {
+		s += fmt.Sprintf("allow socks clients from %s\n", strings.Join(p.InboundAllow, ", "))
+	}
+	if p.TUNFileDescriptor > 0 {
+		s += fmt.Sprintf("use TUN device with descriptor %d, routes are left to its owner\n", p.TUNFileDescriptor)
+
+		return s
+	}
+	if p.Transparent {
+		for _, r := range p.RoutesToTUN {
+			s += fmt.Sprintf("redirect TCP connections to %s to a local proxy with nftables\n", r)
+		}
+		for _, r := range append([]string{p.ServerRoute}, p.ExcludedRoutes...) {
+			s += fmt.Sprintf("exclude %s from the redirect\n", r)
+		}
+
+		return s + "UDP traffic and system DNS settings are left unchanged\n"
+	}
+	s += fmt.Sprintf("create TUN device with address %s\n", p.TUNAddress)
+	table := ""
+	if p.PolicyRouting {
+		table = fmt.Sprintf(" in table %d", policyTable)
+	}
+	for _, r := range p.RoutesToTUN {
+		s += fmt.Sprintf("add route %s via TUN device%s\n", r, table)
+	}
+	if p.PolicyRouting {
+		s += fmt.Sprintf("add rule to use main table except its default route, then table %d for sockets without fwmark %#x\n",
+			policyTable, policyMark)
+	}
+	s += fmt.Sprintf("add route %s via gateway %s\n", p.ServerRoute, p.Gateway)
+	for _, r := range p.ExcludedRoutes {
+		s += fmt.Sprintf("add route %s via gateway %s\n", r, p.Gateway)
+	}
+	for _, ifName := range slices.Sorted(maps.Keys(p.BridgeRoutes)) {
+		for _, r := range p.BridgeRoutes[ifName] {
+			s += fmt.Sprintf("add route %s via %s\n", r, ifName)
+		}
+	}
+	if len(p.DNSServers) == 0 {
+		s += "system DNS settings are left unchanged\n"
+	} else {
+		s += fmt.Sprintf("set system DNS servers to %s\n", strings.Join(p.DNSServers, ", "))
+	}
+	for _, r := range p.DNSRules {
+		s += fmt.Sprintf("resolve %s\n", r)
+	}
+	if p.ShareLAN {
+		s += "enable IP forwarding and translate traffic of LAN devices to the TUN address\n"
+	}
+
+	return s
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	cl := &Client{cfg: Config{
+		GatewayIP:    &gw,
+		InboundProxy: &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 10808},
+		TUNAddress:   defaultTUNAddress,
+		RoutesToTUN:  DefaultRoutesToTUN,
+	}, router: newRouter(nil, gw)}
+
+	p, err := cl.Plan("trojan://password@1.2.3.4:8443?sni=example.com")
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3.4/32", p.ServerRoute)
+	require.Equal(t, "192.168.1.1", p.Gateway)
+	require.Equal(t, "127.0.0.1:10808", p.InboundProxy)
+	require.Equal(t, []string{"0.0.0.0/1", "128.0.0.0/1"}, p.RoutesToTUN)
+	require.Contains(t, p.String(), "add route 1.2.3.4/32 via gateway 192.168.1.1\n")
+
+	_, err = cl.Plan("trojan://")
+	require.Error(t, err)
+}
+
+func TestPlan_ExcludeRoutes(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	cl := &Client{cfg: Config{
+		InboundProxy:  &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 10808},
+		TUNAddress:    defaultTUNAddress,
+		RoutesToTUN:   []*route.Addr{route.MustParseAddr("10.8.0.0/16")},
+		ExcludeRoutes: []*route.Addr{route.MustParseAddr("10.8.1.0/24")},
+	}, router: newRouter(nil, gw)}
+
+	p, err := cl.Plan("trojan://password@1.2.3.4:8443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.8.0.0/16"}, p.RoutesToTUN)
+	require.Equal(t, []string{"10.8.1.0/24"}, p.ExcludedRoutes)
+	require.Contains(t, p.String(), "add route 10.8.1.0/24 via gateway 192.168.1.1\n")
+}
+
+func TestPlan_ExternalTUN(t *testing.T) {
+	cl := &Client{cfg: Config{
+		InboundProxy:      &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 10808},
+		RoutesToTUN:       DefaultRoutesToTUN,
+		TUNFileDescriptor: 3,
+	}}
+
+	p, err := cl.Plan("trojan://password@1.2.3.4:8443")
+	require.NoError(t, err)
+	require.Empty(t, p.RoutesToTUN)
+	require.Empty(t, p.ServerRoute)
+	require.Contains(t, p.String(), "descriptor 3")
+}
+
+func TestPlan_Chain(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	cl := &Client{cfg: Config{
+		InboundProxy: &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 10808},
+		TUNAddress:   defaultTUNAddress,
+		RoutesToTUN:  DefaultRoutesToTUN,
+		Chain:        []string{"trojan://password@5.6.7.8:443"},
+	}, router: newRouter(nil, gw)}
+
+	p, err := cl.Plan("trojan://password@1.2.3.4:8443")
+	require.NoError(t, err)
+	require.Len(t, p.Chain, 1)
+	require.Equal(t, "5.6.7.8/32", p.ServerRoute) // The exit is reached through the relay.
+	require.Contains(t, p.String(), "relay trojan 5.6.7.8:443 (5.6.7.8)\nserver trojan 1.2.3.4:8443 (1.2.3.4)\n")
+}
+
+func TestPlan_UpstreamProxy(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	cl := &Client{cfg: Config{
+		InboundProxy:  &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 10808},
+		TUNAddress:    defaultTUNAddress,
+		RoutesToTUN:   DefaultRoutesToTUN,
+		UpstreamProxy: &url.URL{Scheme: "http", User: url.UserPassword("user", "secret"), Host: "10.1.2.3:3128"},
+	}, router: newRouter(nil, gw)}
+
+	p, err := cl.Plan("trojan://password@1.2.3.4:8443")
+	require.NoError(t, err)
+	require.Equal(t, "10.1.2.3/32", p.ServerRoute) // The server is reached through the proxy.
+	require.Contains(t, p.String(), "dial through upstream proxy http://***@10.1.2.3:3128\n")
+	require.NotContains(t, p.String(), "secret")
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"fmt"
+)
+
+const (
+	// policyTable is the routing table of the routes pointed to the TUN device, see Config.PolicyRouting.
+	policyTable = 0x7867
+	// policyMark is the fwmark of sockets bypassing the TUN device, see Config.PolicyRouting.
+	policyMark = 0x7867
+	// policyPriority is the priority of the first policy rule, the second one follows it.
+	policyPriority = 0x7866
+)
+
+// addPolicyRules installs the rules sending the traffic to policyTable, see Config.PolicyRouting.
+// Rules kept by a traffic block are reused.
+func (c *Client) addPolicyRules() error {
+	if !c.cfg.PolicyRouting {
+		return nil
+	}
+
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
+
+	if c.policySet {
+		return nil
+	}
+	if err := addPolicyRules(); err != nil {
+		return err
+	}
+	c.policySet = true
+
+	return nil
+}
+
+// deletePolicyRules removes the rules installed by addPolicyRules, the caller must hold c.tunMu.
+func (c *Client) deletePolicyRules() error {
+	if !c.policySet {
+		return nil
+	}
+	if err := deletePolicyRules(); err != nil {
+		return fmt.Errorf("delete policy routing rules: %w", err)
+	}
+	c.policySet = false
+
+	return nil
+}
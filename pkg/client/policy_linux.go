@@ -0,0 +1,99 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/goxray/core/network/route"
+	"github.com/vishvananda/netlink"
+)
+
+const policyRoutingSupported = true
+
+// policyRoutes adds routes to policyTable instead of the main table.
+type policyRoutes struct{}
+
+func (policyRoutes) Add(opts route.Opts) error {
+	return applyPolicyRoutes(opts, netlink.RouteAdd)
+}
+
+func (policyRoutes) Delete(opts route.Opts) error {
+	return applyPolicyRoutes(opts, netlink.RouteDel)
+}
+
+func applyPolicyRoutes(opts route.Opts, apply func(*netlink.Route) error) error {
+	link, err := netlink.LinkByName(opts.IfName)
+	if err != nil {
+		return fmt.Errorf("find link %s: %w", opts.IfName, err)
+	}
+
+	var errs error
+	for _, r := range policyTableRoutes(link.Attrs().Index, opts.Routes) {
+		if err = apply(r); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to update route %s: %w", r.Dst, err))
+		}
+	}
+
+	return errs
+}
+
+// policyTableRoutes returns routes of addrs pointed to the link with linkIndex in policyTable.
+func policyTableRoutes(linkIndex int, addrs []*route.Addr) []*netlink.Route {
+	routes := make([]*netlink.Route, 0, len(addrs))
+	for _, a := range addrs {
+		routes = append(routes, &netlink.Route{
+			LinkIndex: linkIndex,
+			Dst:       (*net.IPNet)(a),
+			Scope:     netlink.SCOPE_LINK,
+			Table:     policyTable,
+		})
+	}
+
+	return routes
+}
+
+// policyRules returns the rules of wg-quick: routes of the main table are used, except for the default one,
+// then everything but marked sockets goes to policyTable.
+func policyRules() []*netlink.Rule {
+	main := netlink.NewRule()
+	main.Family = netlink.FAMILY_V4
+	main.Priority = policyPriority
+	main.Table = syscall.RT_TABLE_MAIN
+	main.SuppressPrefixlen = 0
+
+	tunnel := netlink.NewRule()
+	tunnel.Family = netlink.FAMILY_V4
+	tunnel.Priority = policyPriority + 1
+	tunnel.Table = policyTable
+	tunnel.Mark = policyMark
+	tunnel.Invert = true
+
+	return []*netlink.Rule{main, tunnel}
+}
+
+func addPolicyRules() error {
+	_ = deletePolicyRules() // In case previous run failed.
+
+	for _, r := range policyRules() {
+		if err := netlink.RuleAdd(r); err != nil {
+			_ = deletePolicyRules()
+
+			return fmt.Errorf("add rule %s: %w", r, err)
+		}
+	}
+
+	return nil
+}
+
+func deletePolicyRules() error {
+	var errs error
+	for _, r := range policyRules() {
+		if err := netlink.RuleDel(r); err != nil && !errors.Is(err, syscall.ENOENT) {
+			errs = errors.Join(errs, fmt.Errorf("delete rule %s: %w", r, err))
+		}
+	}
+
+	return errs
+}
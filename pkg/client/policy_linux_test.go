@@ -0,0 +1,32 @@
+package client
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyRules(t *testing.T) {
+	rules := policyRules()
+	require.Len(t, rules, 2)
+
+	require.Equal(t, syscall.RT_TABLE_MAIN, rules[0].Table)
+	require.Equal(t, 0, rules[0].SuppressPrefixlen)
+	require.Less(t, rules[0].Priority, rules[1].Priority, "main table is looked up first")
+
+	require.Equal(t, policyTable, rules[1].Table)
+	require.Equal(t, uint32(policyMark), rules[1].Mark)
+	require.True(t, rules[1].Invert)
+}
+
+func TestPolicyTableRoutes(t *testing.T) {
+	routes := policyTableRoutes(7, []*route.Addr{route.MustParseAddr("0.0.0.0/1"), route.MustParseAddr("128.0.0.0/1")})
+	require.Len(t, routes, 2)
+	for _, r := range routes {
+		require.Equal(t, 7, r.LinkIndex)
+		require.Equal(t, policyTable, r.Table)
+	}
+	require.Equal(t, "128.0.0.0/1", routes[1].Dst.String())
+}
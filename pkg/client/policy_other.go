@@ -0,0 +1,32 @@
+//go:build !linux
+
+package client
+
+import (
+	"errors"
+
+	"github.com/goxray/core/network/route"
+)
+
+const policyRoutingSupported = false
+
+var errPolicyRoutingUnsupported = errors.New("policy routing is only supported on Linux")
+
+// policyRoutes is not used, PolicyRouting is rejected by NewClientWithOpts.
+type policyRoutes struct{}
+
+func (policyRoutes) Add(route.Opts) error {
+	return errPolicyRoutingUnsupported
+}
+
+func (policyRoutes) Delete(route.Opts) error {
+	return errPolicyRoutingUnsupported
+}
+
+func addPolicyRules() error {
+	return errPolicyRoutingUnsupported
+}
+
+func deletePolicyRules() error {
+	return errPolicyRoutingUnsupported
+}
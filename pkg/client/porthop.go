@@ -0,0 +1,219 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+)
+
+// portProbeTimeout limits probing of a single server port.
+const portProbeTimeout = 5 * time.Second
+
+// PortStore remembers the server port which worked on a network.
+// Networks are identified by MAC address of the gateway, or by its IP if the MAC is unknown.
+type PortStore interface {
+	Port(network string) (int, bool)
+	SetPort(network string, port int) error
+}
+
+// FilePortStore is PortStore kept in a JSON file.
+type FilePortStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Port returns the port remembered for network.
+func (s *FilePortStore) Port(network string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ports, _ := s.load()
+	port, ok := ports[network]
+
+	return port, ok
+}
+
+// SetPort remembers port for network.
+func (s *FilePortStore) SetPort(network string, port int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ports, err := s.load()
+	if err != nil {
+		return err
+	}
+	ports[network] = port
+
+	data, err := json.MarshalIndent(ports, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal ports: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(s.Path), 0o700); err != nil {
+		return fmt.Errorf("create port store dir: %w", err)
+	}
+	if err = os.WriteFile(s.Path, data, 0o600); err != nil {
+		return fmt.Errorf("write port store: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FilePortStore) load() (map[string]int, error) {
+	ports := make(map[string]int)
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ports, nil
+	}
+	if err != nil {
+		return ports, fmt.Errorf("read port store: %w", err)
+	}
+	if err = json.Unmarshal(data, &ports); err != nil {
+		return make(map[string]int), fmt.Errorf("parse port store: %w", err)
+	}
+
+	return ports, nil
+}
+
+// selectPort finds a port of Config.ServerPorts the server is reachable on from the current network
+// and sets it to protocol. Ports are tried starting from the one which worked on this network before,
+// then the port of the link. Nothing is probed if there are no alternative ports.
+func (c *Client) selectPort(ctx context.Context, protocol xrayproto.Protocol, linkPort string, server net.IP) error {
+	if len(c.cfg.ServerPorts) == 0 {
+		return nil
+	}
+
+	c.tunMu.Lock()
+	blocked := c.blocking != nil
+	c.tunMu.Unlock()
+	if blocked {
+		// Traffic block left by DownPolicyBlock would swallow the probes, the server is let through like when connected.
+		if err := c.router.AddServerRoute(server); err != nil {
+			return fmt.Errorf("add xray server route exception: %w", err)
+		}
+	}
+
+	var ports []int
+	network := c.networkID()
+	if c.cfg.PortStore != nil {
+		if port, ok := c.cfg.PortStore.Port(network); ok {
+			ports = append(ports, port)
+		}
+	}
+	if port, err := strconv.Atoi(linkPort); err == nil {
+		ports = append(ports, port)
+	}
+	for _, port := range c.cfg.ServerPorts {
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+
+	svc := xray.NewXrayService(false, c.cfg.TLSAllowInsecure)
+	targets, _ := c.probeTargets()
+	var errs []error
+	for _, port := range ports {
+		if err := setPort(protocol, port); err != nil {
+			return err
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, portProbeTimeout)
+		_, err := probeProtocol(probeCtx, svc, protocol, targets[0])
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			c.cfg.Logger.Debug("server port is not reachable", "port", port, "err", err)
+			errs = append(errs, fmt.Errorf("port %d: %w", port, err))
+
+			continue
+		}
+
+		if strconv.Itoa(port) != linkPort {
+			c.cfg.Logger.Info("server port of the link is not reachable, using alternative", "port", port)
+		}
+		if c.cfg.PortStore != nil {
+			if err = c.cfg.PortStore.SetPort(network, port); err != nil {
+				c.cfg.Logger.Warn("remembering server port failed", "err", err)
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("server is not reachable on any port: %w", errors.Join(errs...))
+}
+
+// networkID identifies the network the client is connected to, see PortStore.
+func (c *Client) networkID() string {
+	gw := c.router.Gateway()
+	if mac, err := neighborMAC(gw); err == nil {
+		return mac.String()
+	}
+
+	return gw.String()
+}
+
+// setPort changes server port of protocol.
+func setPort(protocol xrayproto.Protocol, port int) error {
+	p := strconv.Itoa(port)
+	switch v := protocol.(type) {
+	case *xray.Vless:
+		v.Port = p
+	case *xray.Vmess:
+		v.Port = p
+	case *xray.Trojan:
+		v.Port = p
+	case *xray.Shadowsocks:
+		v.Port = p
+	case *shadowsocksLink:
+		v.port = p
+	default:
+		return fmt.Errorf("changing port of %T is not supported", protocol)
+	}
+
+	return nil
+}
+
+// parseNeighborMAC returns MAC address of ip from neighbor table, /proc/net/arp on Linux or `arp -an` output elsewhere.
+func parseNeighborMAC(table string, ip net.IP) (net.HardwareAddr, error) {
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		if !slices.ContainsFunc(fields, func(f string) bool { return ip.Equal(net.ParseIP(strings.Trim(f, "()"))) }) {
+			continue
+		}
+		for _, f := range fields {
+			if mac, err := parseMAC(f); err == nil && !bytes.Equal(mac, make(net.HardwareAddr, len(mac))) {
+				return mac, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no MAC address of %s", ip)
+}
+
+// parseMAC parses MAC address, also without leading zeroes in octets, as printed by arp on macOS.
+func parseMAC(s string) (net.HardwareAddr, error) {
+	octets := strings.Split(s, ":")
+	for i, o := range octets {
+		if len(o) == 1 {
+			octets[i] = "0" + o
+		}
+	}
+
+	return net.ParseMAC(strings.Join(octets, ":"))
+}
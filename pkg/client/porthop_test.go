@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetPort(t *testing.T) {
+	protocol, _, err := parseLink(xray.NewXrayService(false, false), "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:443?type=tcp")
+	require.NoError(t, err)
+
+	require.NoError(t, setPort(protocol, 8443))
+	require.Equal(t, "8443", protocol.ConvertToGeneralConfig().Port)
+}
+
+func TestFilePortStore(t *testing.T) {
+	store := &FilePortStore{Path: filepath.Join(t.TempDir(), "goxray", "ports.json")}
+	_, ok := store.Port("aa:bb:cc:dd:ee:ff")
+	require.False(t, ok)
+
+	require.NoError(t, store.SetPort("aa:bb:cc:dd:ee:ff", 8443))
+	require.NoError(t, store.SetPort("192.168.1.1", 443))
+
+	port, ok := (&FilePortStore{Path: store.Path}).Port("aa:bb:cc:dd:ee:ff")
+	require.True(t, ok)
+	require.Equal(t, 8443, port)
+}
+
+func TestParseNeighborMAC(t *testing.T) {
+	gw := net.ParseIP("192.168.1.1")
+	linux := "IP address       HW type     Flags       HW address            Mask     Device\n" +
+		"192.168.1.10     0x1         0x0         00:00:00:00:00:00     *        wlan0\n" +
+		"192.168.1.1      0x1         0x2         a0:b1:c2:d3:e4:f5     *        wlan0\n"
+	mac, err := parseNeighborMAC(linux, gw)
+	require.NoError(t, err)
+	require.Equal(t, "a0:b1:c2:d3:e4:f5", mac.String())
+
+	darwin := "? (192.168.1.1) at a0:b1:c2:d3:e4:5 on en0 ifscope [ethernet]\n"
+	mac, err = parseNeighborMAC(darwin, gw)
+	require.NoError(t, err)
+	require.Equal(t, "a0:b1:c2:d3:e4:05", mac.String())
+
+	_, err = parseNeighborMAC(linux, net.ParseIP("192.168.1.10"))
+	require.Error(t, err)
+}
+
+func TestSelectPort_NoAlternatives(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+
+	// Nothing is probed without alternative ports.
+	require.NoError(t, cl.selectPort(context.Background(), nil, "443", net.ParseIP("127.0.0.3")))
+}
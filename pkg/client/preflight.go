@@ -0,0 +1,61 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// minFDLimit is the lowest hard RLIMIT_NOFILE the client works reliably with, every proxied
+// connection holds at least two descriptors.
+const minFDLimit = 1024
+
+// PreflightError describes a missing privilege or too low limit which would make Connect fail.
+type PreflightError struct {
+	Problem string // What is wrong, e.g. "CAP_NET_ADMIN capability is missing".
+	Fix     string // Command or action resolving the problem.
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("%s, to fix: %s", e.Problem, e.Fix)
+}
+
+// Preflight verifies that the process has the privileges and limits required by Connect without changing anything,
+// so that missing permissions are reported before any route is added.
+// Every failed check is returned as *PreflightError, use errors.As to get the fix.
+//
+// Connect runs the same checks itself.
+func (c *Client) Preflight() error {
+	return c.preflight(c.cfg.TUNFileDescriptor > 0)
+}
+
+// preflight runs the checks, privileges are not required with external TUN device as nothing is changed in the system.
+func (c *Client) preflight(externalTUN bool) error {
+	var errs []error
+	if !externalTUN && !hasNetAdmin() {
+		errs = append(errs, withKind(ErrPermission, &PreflightError{Problem: netAdminProblem, Fix: netAdminFix()}))
+	}
+
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		errs = append(errs, fmt.Errorf("get rlimit: %w", err))
+	} else if want := max(c.cfg.FDLimit, minFDLimit); rl.Max < want {
+		errs = append(errs, &PreflightError{
+			Problem: fmt.Sprintf("open files hard limit is %d, at least %d is needed", rl.Max, want),
+			Fix:     fmt.Sprintf("run ulimit -Hn %d as root before starting the client or raise nofile in /etc/security/limits.conf", want),
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// executable returns path of the running binary for fix commands.
+func executable() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return os.Args[0]
+	}
+
+	return exe
+}
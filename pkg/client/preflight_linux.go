@@ -0,0 +1,55 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in capability sets.
+const capNetAdmin = 12
+
+const netAdminProblem = "CAP_NET_ADMIN capability is missing, it is needed to create TUN device and change routes"
+
+func netAdminFix() string {
+	return fmt.Sprintf("run with sudo or sudo setcap cap_net_raw,cap_net_admin,cap_net_bind_service+eip %s", executable())
+}
+
+// hasNetAdmin reports whether the process has effective CAP_NET_ADMIN.
+func hasNetAdmin() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	caps, err := parseCapEff(f)
+	if err != nil {
+		return false
+	}
+
+	return caps&(1<<capNetAdmin) != 0
+}
+
+// parseCapEff returns the effective capability set from /proc/<pid>/status contents.
+func parseCapEff(status io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, errors.New("CapEff not found")
+}
@@ -0,0 +1,17 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCapEff(t *testing.T) {
+	caps, err := parseCapEff(strings.NewReader("Name:\tgoxray\nCapInh:\t0000000000000000\nCapEff:\t0000000000003000\n"))
+	require.NoError(t, err)
+	require.NotZero(t, caps&(1<<capNetAdmin))
+
+	_, err = parseCapEff(strings.NewReader("Name:\tgoxray\n"))
+	require.Error(t, err)
+}
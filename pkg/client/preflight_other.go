@@ -0,0 +1,19 @@
+//go:build !linux
+
+package client
+
+import (
+	"fmt"
+	"os"
+)
+
+const netAdminProblem = "root privileges are missing, they are needed to create TUN device and change routes"
+
+func netAdminFix() string {
+	return fmt.Sprintf("sudo %s", executable())
+}
+
+// hasNetAdmin reports whether the process runs as root.
+func hasNetAdmin() bool {
+	return os.Geteuid() == 0
+}
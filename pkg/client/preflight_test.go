@@ -0,0 +1,19 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflight_FDLimit(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.FDLimit = 1 << 62
+
+	err := cl.preflight(true)
+	var perr *PreflightError
+	require.True(t, errors.As(err, &perr))
+	require.Contains(t, perr.Problem, "open files hard limit")
+	require.Contains(t, perr.Fix, "ulimit -Hn 4611686018427387904")
+}
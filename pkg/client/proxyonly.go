@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/infra/conf"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// httpInboundTag is the tag of the inbound of Config.HTTPInbound.
+const httpInboundTag = "http"
+
+// validateHTTPInbound checks that Config.HTTPInbound is not exposed, unlike the socks inbound it is not
+// guarded by Config.InboundAllow.
+func validateHTTPInbound(cfg Config) error {
+	if cfg.HTTPInbound != nil && !cfg.HTTPInbound.IP.IsLoopback() {
+		return errors.New("HTTP inbound must listen on a loopback address")
+	}
+
+	return nil
+}
+
+// httpInbound returns XRay HTTP proxy inbound listening on p.
+func httpInbound(p *Proxy) (*conf.InboundDetourConfig, error) {
+	if p.Port <= 0 || p.Port > 65535 {
+		return nil, fmt.Errorf("invalid HTTP inbound port %d", p.Port)
+	}
+	settings := json.RawMessage(`{"allowTransparent": false}`)
+
+	return &conf.InboundDetourConfig{
+		Protocol: "http",
+		Tag:      httpInboundTag,
+		ListenOn: &conf.Address{Address: xnet.IPAddress(p.IP)},
+		PortList: &conf.PortList{Range: []conf.PortRange{{From: uint32(p.Port), To: uint32(p.Port)}}},
+		Settings: &settings,
+	}, nil
+}
+
+// StartProxyOnly starts XRay connected to the server of link with the local proxies only: the socks one
+// of Config.InboundProxy and the HTTP one of Config.HTTPInbound, if set. No TUN device is created and routes
+// and DNS settings are left unchanged, so no privileges are needed, e.g. to try a link before Connect.
+// Applications have to be configured to use the proxies.
+//
+// It is stopped with Disconnect, SwitchLink moves it to another server.
+func (c *Client) StartProxyOnly(link string) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.running() {
+		return ErrAlreadyConnected
+	}
+
+	inst, cfg, server, err := c.startXray(context.Background(), link)
+	if err != nil {
+		return err
+	}
+	if err = c.waitInbound(context.Background()); err != nil {
+		c.cfg.Logger.Error("xray core instance startup failed", "err", err)
+		_ = inst.Close()
+
+		return err
+	}
+	if c.exposesInbound() {
+		if c.gate, err = listenInboundGate(c.cfg.InboundProxy.String(), c.xrayInbound().String(),
+			c.cfg.InboundAllow, c.cfg.Logger); err != nil {
+			c.cfg.Logger.Error("exposing inbound proxy failed", "err", err)
+			_ = inst.Close()
+
+			return fmt.Errorf("expose inbound proxy: %w", err)
+		}
+	}
+
+	// Nothing to pipe, Disconnect only has to be unblocked.
+	var ctx context.Context
+	ctx, c.stopTunnel = context.WithCancel(context.Background())
+	go func() {
+		<-ctx.Done()
+		c.tunnelStopped <- nil
+	}()
+
+	c.tunMu.Lock()
+	c.xInst, c.xCfg, c.link = inst, cfg, link
+	c.proxyOnly = true
+	c.connectedAt = time.Now()
+	c.tunMu.Unlock()
+	c.cfg.Logger.Debug("proxy started", "server", server)
+	c.emit(observe.EventConnected, "server", server.String())
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// freePort returns a loopback TCP port nobody listens on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestStartProxyOnly(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	socks := &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: freePort(t)}
+	http := &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: freePort(t)}
+	cl, err := NewClientWithOpts(Config{
+		GatewayIP:    &gw,
+		InboundProxy: socks,
+		HTTPInbound:  http,
+		Logger:       slog.New(slog.DiscardHandler),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, cl.StartProxyOnly("vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:9?type=tcp"))
+	require.Equal(t, StateConnected, cl.Status().State)
+	require.Empty(t, cl.Status().TUNName)
+	require.ErrorIs(t, cl.StartProxyOnly("vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:9?type=tcp"), ErrAlreadyConnected)
+	require.ErrorIs(t, cl.Connect("vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:9?type=tcp"), ErrAlreadyConnected)
+	for _, p := range []*Proxy{socks, http} {
+		conn, err := net.Dial("tcp", p.String())
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	}
+
+	require.NoError(t, cl.Disconnect(context.Background()))
+	require.NoError(t, cl.Disconnect(context.Background()), "disconnecting twice does nothing")
+	require.Equal(t, StateDisconnected, cl.Status().State)
+	_, err = net.Dial("tcp", http.String())
+	require.Error(t, err)
+}
+
+func TestValidateHTTPInbound(t *testing.T) {
+	require.NoError(t, validateHTTPInbound(Config{}))
+	require.NoError(t, validateHTTPInbound(Config{HTTPInbound: &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 8080}}))
+	require.Error(t, validateHTTPInbound(Config{HTTPInbound: &Proxy{IP: net.IPv4zero, Port: 8080}}))
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// watchQuality measures the round trip through the tunnel every Config.QualityProbeInterval, the results
+// are reported by Stats. It returns when ctx is done.
+func (c *Client) watchQuality(ctx context.Context) {
+	target := c.cfg.QualityProbeURL
+	if target == "" {
+		targets, _ := c.probeTargets()
+		target = targets[0]
+	}
+	// The connection is kept open between probes, so that each of them takes a single round trip.
+	transport := &http.Transport{
+		DialContext:         c.dialInbound,
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     2 * c.cfg.QualityProbeInterval,
+	}
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{Transport: transport}
+	timeout := min(c.cfg.QualityProbeInterval, healthProbeTimeout)
+
+	ticker := time.NewTicker(c.cfg.QualityProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rtt, err := probeRTT(ctx, httpClient, target, timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.cfg.Logger.Debug("quality probe failed", "target", target, "err", err)
+			c.quality.Lost()
+			transport.CloseIdleConnections()
+
+			continue
+		}
+		c.quality.Observe(rtt)
+	}
+}
+
+// probeRTT requests target and returns the time from the request being written till the first byte
+// of the response, which excludes dialing and TLS handshake of a new connection.
+func probeRTT(ctx context.Context, httpClient *http.Client, target string, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var wrote, answered time.Time
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { answered = time.Now() },
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, fmt.Errorf("new probe request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, fmt.Errorf("read probe response: %w", err)
+	}
+
+	return answered.Sub(wrote), nil
+}
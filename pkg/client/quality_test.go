@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeRTT(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	for range 2 { // The second probe goes over the same connection.
+		rtt, err := probeRTT(context.Background(), srv.Client(), srv.URL, time.Second)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, rtt, delay)
+		require.Less(t, rtt, time.Second)
+	}
+
+	_, err := probeRTT(context.Background(), srv.Client(), srv.URL, time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
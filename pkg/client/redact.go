@@ -0,0 +1,107 @@
+package client
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+)
+
+// redacted replaces secrets in logs.
+const redacted = "***"
+
+// secretParams are link query parameters carrying credentials.
+var secretParams = []string{"pbk", "sid", "key", "password", "pass", "obfs-password"}
+
+// redactLink masks credentials in a connection link while keeping scheme, host and port visible.
+//
+// vmess links and legacy ss links are base64 encoded as a whole, so only the scheme is kept for them.
+func redactLink(link string) string {
+	link = strings.TrimSpace(link)
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme == "" {
+		return redacted
+	}
+	if u.Scheme == xrayproto.VmessIdentifier || u.Host == "" || u.Scheme == xrayproto.ShadowsocksIdentifier && u.User == nil {
+		return u.Scheme + "://" + redacted
+	}
+
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	q := u.Query()
+	for _, param := range secretParams {
+		if q.Has(param) {
+			q.Set(param, redacted)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	return strings.Replace(u.String(), url.QueryEscape(redacted), redacted, -1)
+}
+
+// redactErr returns err message with every occurrence of link masked by redactLink.
+func redactErr(err error, link string) string {
+	if err == nil {
+		return ""
+	}
+
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return err.Error()
+	}
+
+	return strings.ReplaceAll(err.Error(), link, redactLink(link))
+}
+
+// redactClientConfig returns cfg with credentials masked for logging: the management API token, the user
+// of the upstream proxy, relay links of the chain and XRay config sections, whose outbounds carry credentials.
+func redactClientConfig(cfg Config) Config {
+	if cfg.APIToken != "" {
+		cfg.APIToken = redacted
+	}
+	if cfg.UpstreamProxy != nil && cfg.UpstreamProxy.User != nil {
+		proxy := *cfg.UpstreamProxy
+		proxy.User = url.User(redacted)
+		cfg.UpstreamProxy = &proxy
+	}
+	if cfg.Chain != nil {
+		chain := make([]string, len(cfg.Chain))
+		for i, link := range cfg.Chain {
+			chain[i] = redactLink(link)
+		}
+		cfg.Chain = chain
+	}
+	if cfg.XRayExtra != nil {
+		cfg.XRayExtra = json.RawMessage(strconv.Quote(redacted))
+	}
+
+	return cfg
+}
+
+// redactedConfig logs xray-knife general config without credentials.
+type redactedConfig struct {
+	cfg *xrayproto.GeneralConfig
+}
+
+func (r redactedConfig) LogValue() slog.Value {
+	if r.cfg == nil {
+		return slog.AnyValue(nil)
+	}
+
+	return slog.GroupValue(
+		slog.String("protocol", r.cfg.Protocol),
+		slog.String("address", r.cfg.Address),
+		slog.String("port", r.cfg.Port),
+		slog.String("network", r.cfg.Network),
+		slog.String("security", r.cfg.Security),
+		slog.String("tls", r.cfg.TLS),
+		slog.String("sni", r.cfg.SNI),
+		slog.String("remark", r.cfg.Remark),
+		slog.String("id", redacted),
+		slog.String("link", redactLink(r.cfg.OrigLink)),
+	)
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// udpResumeAttempts is how many times a UDP session is dialed again once its upstream leg fails,
+	// XRay instance may be still starting, see Client.SwitchLink.
+	udpResumeAttempts = 5
+	// udpResumeDelay is the pause between the attempts.
+	udpResumeDelay = 200 * time.Millisecond
+	// udpResumeInterval is how long a resumed session has to live before it is resumed again,
+	// so that sessions the server keeps dropping are not dialed in a loop.
+	udpResumeInterval = time.Second
+)
+
+// resumablePacketConn is the upstream leg of a UDP session which is dialed again when it fails,
+// e.g. when XRay instance is replaced. Datagrams tolerate the loss of a few packets, so the local
+// session and its flow are kept. TCP connections can not be resumed like that, their streams are reset.
+type resumablePacketConn struct {
+	mu       sync.Mutex
+	pc       net.PacketConn
+	redial   func() (net.PacketConn, error)
+	resumed  time.Time
+	deadline time.Time // Read deadline, it is carried over to the resumed connection.
+	closed   bool
+	logger   *slog.Logger
+}
+
+func newResumablePacketConn(pc net.PacketConn, redial func() (net.PacketConn, error), logger *slog.Logger) *resumablePacketConn {
+	return &resumablePacketConn{pc: pc, redial: redial, logger: logger}
+}
+
+func (c *resumablePacketConn) current() net.PacketConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.pc
+}
+
+// resume replaces the failed connection, it returns false if the session can not be resumed.
+func (c *resumablePacketConn) resume(failed net.PacketConn, err error) (net.PacketConn, bool) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, false
+	}
+	if c.pc != failed {
+		return c.pc, true // Resumed by the other direction.
+	}
+	if time.Since(c.resumed) < udpResumeInterval {
+		return nil, false
+	}
+	for attempt := range udpResumeAttempts {
+		if attempt > 0 {
+			time.Sleep(udpResumeDelay)
+		}
+		pc, dialErr := c.redial()
+		if dialErr != nil {
+			c.logger.Debug("resuming UDP session failed", "err", dialErr, "attempt", attempt+1)
+
+			continue
+		}
+		_ = failed.Close()
+		_ = pc.SetReadDeadline(c.deadline)
+		c.pc, c.resumed = pc, time.Now()
+		c.logger.Debug("UDP session resumed", "err", err)
+
+		return pc, true
+	}
+
+	return nil, false
+}
+
+func (c *resumablePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	pc := c.current()
+	for {
+		n, addr, err := pc.ReadFrom(p)
+		if err == nil {
+			return n, addr, nil
+		}
+		var ok bool
+		if pc, ok = c.resume(pc, err); !ok {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *resumablePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	pc := c.current()
+	for {
+		n, err := pc.WriteTo(p, addr)
+		if err == nil {
+			return n, nil
+		}
+		var ok bool
+		if pc, ok = c.resume(pc, err); !ok {
+			return n, err
+		}
+	}
+}
+
+func (c *resumablePacketConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+
+	return c.pc.Close()
+}
+
+func (c *resumablePacketConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+func (c *resumablePacketConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+
+	return c.pc.SetDeadline(t)
+}
+
+func (c *resumablePacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+
+	return c.pc.SetReadDeadline(t)
+}
+
+func (c *resumablePacketConn) SetWriteDeadline(t time.Time) error {
+	return c.current().SetWriteDeadline(t)
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumablePacketConn(t *testing.T) {
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer peer.Close()
+
+	var dialed []net.PacketConn
+	dial := func() (net.PacketConn, error) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err == nil {
+			dialed = append(dialed, pc)
+		}
+
+		return pc, err
+	}
+	first, err := dial()
+	require.NoError(t, err)
+	pc := newResumablePacketConn(first, dial, slog.New(slog.DiscardHandler))
+
+	// Upstream leg fails, e.g. XRay instance was replaced.
+	require.NoError(t, first.Close())
+	_, err = pc.WriteTo([]byte("ping"), peer.LocalAddr())
+	require.NoError(t, err)
+	require.Len(t, dialed, 2)
+
+	buf := make([]byte, 4)
+	_, from, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, dialed[1].LocalAddr().String(), from.String())
+
+	// Timeouts are not failures.
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, _, err = pc.ReadFrom(buf)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout())
+	require.Len(t, dialed, 2)
+
+	// Sessions failing right after resuming are given up.
+	require.NoError(t, dialed[1].Close())
+	_, err = pc.WriteTo([]byte("ping"), peer.LocalAddr())
+	require.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// defaultRetryBaseDelay is the delay before the second attempt if RetryPolicy.BaseDelay is not set.
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	// defaultRetryMaxDelay bounds the delay between attempts if RetryPolicy.MaxDelay is not set.
+	defaultRetryMaxDelay = 5 * time.Second
+	// inboundReadyAttempts is the least number of times XRay inbound is dialed after the instance start,
+	// see waitInbound. The instance is local, so it is polled regardless of RetryPolicy.MaxAttempts.
+	inboundReadyAttempts = 5
+)
+
+// RetryPolicy configures retries of the steps of Connect which may fail transiently, e.g. while the network
+// is coming up: resolving and dialing the server and adding the TUN device with its routes.
+// Zero value makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts of a step including the first one (default: 1).
+	BaseDelay   time.Duration // Delay before the second attempt, doubled before each next one (default: 100ms).
+	MaxDelay    time.Duration // Upper bound of the delay (default: 5s).
+	// Jitter is the fraction of the delay it is randomly changed by either way, from 0 to 1 (default: 0).
+	Jitter float64
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 || p.BaseDelay < 0 || p.MaxDelay < 0 {
+		return errors.New("retry attempts and delays must not be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter %v is out of 0-1", p.Jitter)
+	}
+
+	return nil
+}
+
+// atLeast returns the policy making at least n attempts.
+func (p RetryPolicy) atLeast(n int) RetryPolicy {
+	p.MaxAttempts = max(p.MaxAttempts, n)
+
+	return p
+}
+
+// delay returns the delay before attempt, counted from 1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	base, limit := p.BaseDelay, p.MaxDelay
+	if base == 0 {
+		base = defaultRetryBaseDelay
+	}
+	if limit == 0 {
+		limit = defaultRetryMaxDelay
+	}
+
+	d := base
+	for i := 2; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+
+	return d
+}
+
+// do runs op till it succeeds, fails permanently or the attempts are used up, and returns its last error.
+// If ctx is done while waiting for the next attempt, its error is returned together with the last one.
+func (p RetryPolicy) do(ctx context.Context, op func() error) error {
+	attempts := max(p.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= attempts || permanent(err) {
+			return err
+		}
+
+		timer := time.NewTimer(p.delay(attempt + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("%w, last error: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// permanent reports whether err is not fixed by retrying: the link is invalid or privileges are missing.
+func permanent(err error) bool {
+	return errors.Is(err, ErrInvalidLink) || errors.Is(err, ErrPermission) || errors.Is(err, os.ErrPermission)
+}
+
+// waitInbound waits till XRay inbound accepts connections once the instance is started.
+func (c *Client) waitInbound(ctx context.Context) error {
+	return c.waitListening(ctx, c.xrayInbound().String())
+}
+
+// waitListening waits till XRay inbound listening on addr accepts connections, see waitInbound.
+func (c *Client) waitListening(ctx context.Context, addr string) error {
+	err := c.cfg.Retry.atLeast(inboundReadyAttempts).do(ctx, func() error {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	})
+	if err != nil && ctx.Err() == nil {
+		return withKind(ErrXrayStart, fmt.Errorf("xray inbound is not ready: %w", err))
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	require.Equal(t, 10*time.Millisecond, p.delay(2))
+	require.Equal(t, 20*time.Millisecond, p.delay(3))
+	require.Equal(t, 40*time.Millisecond, p.delay(4))
+	require.Equal(t, 50*time.Millisecond, p.delay(5), "capped by MaxDelay")
+	require.Equal(t, 50*time.Millisecond, p.delay(100))
+
+	require.Equal(t, defaultRetryBaseDelay, RetryPolicy{}.delay(2))
+
+	p.Jitter = 0.5
+	for range 100 {
+		d := p.delay(2)
+		require.GreaterOrEqual(t, d, 5*time.Millisecond)
+		require.LessOrEqual(t, d, 15*time.Millisecond)
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	var calls int
+	failing := func(err error) func() error {
+		return func() error {
+			calls++
+
+			return err
+		}
+	}
+	ctx := context.Background()
+	errTransient := errors.New("network is unreachable")
+
+	require.ErrorIs(t, RetryPolicy{}.do(ctx, failing(errTransient)), errTransient)
+	require.Equal(t, 1, calls, "zero policy makes a single attempt")
+
+	calls = 0
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	require.ErrorIs(t, p.do(ctx, failing(errTransient)), errTransient)
+	require.Equal(t, 3, calls)
+
+	calls = 0
+	require.NoError(t, p.do(ctx, func() error {
+		calls++
+		if calls < 2 {
+			return errTransient
+		}
+
+		return nil
+	}))
+	require.Equal(t, 2, calls)
+
+	for _, err := range []error{
+		withKind(ErrInvalidLink, errors.New("invalid config")),
+		fmt.Errorf("add route: %w", syscall.EPERM),
+	} {
+		calls = 0
+		require.ErrorIs(t, p.do(ctx, failing(err)), err)
+		require.Equal(t, 1, calls, "permanent errors are not retried")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	calls = 0
+	err := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}.do(ctx, failing(errTransient))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	require.NoError(t, RetryPolicy{}.validate())
+	require.NoError(t, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: 1}.validate())
+	require.Error(t, RetryPolicy{MaxAttempts: -1}.validate())
+	require.Error(t, RetryPolicy{BaseDelay: -time.Second}.validate())
+	require.Error(t, RetryPolicy{Jitter: 1.5}.validate())
+}
+
+func TestClient_WaitInbound(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	c := &Client{cfg: Config{
+		InboundProxy: &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: port},
+		Retry:        RetryPolicy{BaseDelay: time.Millisecond},
+		Logger:       slog.New(slog.DiscardHandler),
+	}}
+	require.NoError(t, c.waitInbound(context.Background()))
+
+	require.NoError(t, ln.Close())
+	err = c.waitInbound(context.Background())
+	require.ErrorIs(t, err, ErrXrayStart)
+	require.ErrorContains(t, err, "xray inbound is not ready")
+}
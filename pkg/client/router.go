@@ -0,0 +1,379 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/goxray/core/network/route"
+)
+
+// router owns the gateway and the routes installed by the Client.
+//
+// Every routing change goes through it, so that gateway updates and route bookkeeping
+// are serialised and never observed half-done.
+type router struct {
+	mu       sync.Mutex
+	table    ipTable
+	tunTable ipTable // Table of the routes pointed to the TUN device, see Config.PolicyRouting.
+	gateway  net.IP
+	tun      string        // TUN device routes are pointed to, empty if they are not added.
+	routes   []*route.Addr // Routes pointed to the TUN device.
+	server   net.IP        // XRay server routed through the gateway, nil if the exception is not installed.
+	bypass   []*route.Addr // Destinations routed through the gateway outside of the TUN device.
+	// links are networks routed through their own interfaces outside of the TUN device, by interface name.
+	links map[string][]*route.Addr
+}
+
+func newRouter(table ipTable, gateway net.IP) *router {
+	return &router{table: table, tunTable: table, gateway: gateway}
+}
+
+// Gateway returns the gateway XRay server traffic is routed through.
+func (r *router) Gateway() net.IP {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.gateway)
+}
+
+// SetGateway moves the XRay server route exception and bypass routes, if installed, to the new gateway.
+func (r *router) SetGateway(gateway net.IP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.gateway.Equal(gateway) {
+		return nil
+	}
+
+	old := r.gateway
+	if r.server != nil {
+		_ = r.table.Delete(r.serverRoute()) // The route may be already gone with the old network.
+		r.gateway = gateway
+		if err := r.table.Add(r.serverRoute()); err != nil {
+			r.gateway = old
+
+			return fmt.Errorf("add xray server route exception: %w", err)
+		}
+	}
+	r.gateway = gateway
+
+	if len(r.bypass) > 0 {
+		_ = r.table.Delete(route.Opts{Gateway: old, Routes: r.bypass})
+		if err := r.table.Add(r.bypassRoutes()); err != nil {
+			return fmt.Errorf("add bypass routes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AddTUNRoutes points routes to the TUN device ifName. The routes are removed by the system with the device.
+func (r *router) AddTUNRoutes(ifName string, routes []*route.Addr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.tunTable.Add(route.Opts{IfName: ifName, Routes: routes}); err != nil {
+		return err
+	}
+	r.tun, r.routes = ifName, slices.Clone(routes)
+
+	return nil
+}
+
+// AddTUNRoute points addr to the TUN device of the last AddTUNRoutes call.
+func (r *router) AddTUNRoute(addr *route.Addr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tun == "" {
+		return ErrNotConnected
+	}
+	if err := r.tunTable.Add(route.Opts{IfName: r.tun, Routes: []*route.Addr{addr}}); err != nil {
+		return err
+	}
+	r.routes = append(r.routes, addr)
+
+	return nil
+}
+
+// DeleteTUNRoute removes route for addr pointed to the TUN device.
+func (r *router) DeleteTUNRoute(addr *route.Addr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tun == "" {
+		return ErrNotConnected
+	}
+	if err := r.tunTable.Delete(route.Opts{IfName: r.tun, Routes: []*route.Addr{addr}}); err != nil {
+		return err
+	}
+	r.routes = slices.DeleteFunc(r.routes, func(a *route.Addr) bool { return a.String() == addr.String() })
+
+	return nil
+}
+
+// EnsureTUNRoutes adds routes pointed to the TUN device which are missing, e.g. removed by a DHCP client.
+// It returns the restored routes.
+func (r *router) EnsureTUNRoutes() ([]*route.Addr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tun == "" {
+		return nil, ErrNotConnected
+	}
+
+	var restored []*route.Addr
+	var errs error
+	for _, addr := range r.routes {
+		// Same as in EnsureServerRoute, adding the route is the portable check.
+		err := r.tunTable.Add(route.Opts{IfName: r.tun, Routes: []*route.Addr{addr}})
+		switch {
+		case err == nil:
+			restored = append(restored, addr)
+		case !isRouteExists(err):
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	return restored, errs
+}
+
+// MoveTUN points the recorded TUN routes to the new device ifName, the routes of the previous device
+// are expected to be gone with it. The device is recorded even if adding the routes fails,
+// so that EnsureTUNRoutes adds them later.
+func (r *router) MoveTUN(ifName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tun = ifName
+	if len(r.routes) == 0 {
+		return nil
+	}
+
+	return r.tunTable.Add(route.Opts{IfName: ifName, Routes: r.routes})
+}
+
+// ReleaseTUN forgets the TUN device once it is closed, its routes are removed by the system.
+func (r *router) ReleaseTUN() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tun, r.routes = "", nil
+}
+
+// AddServerRoute routes XRay server through the gateway, so that its traffic does not loop through the TUN device.
+// A route left by a previous run is replaced. IPv6 servers need no route, routes of the TUN device are IPv4 only.
+func (r *router) AddServerRoute(server net.IP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if server.To4() == nil {
+		r.server = nil
+
+		return nil
+	}
+	r.server = server
+	_ = r.table.Delete(r.serverRoute()) // In case previous run failed.
+	if err := r.table.Add(r.serverRoute()); err != nil {
+		r.server = nil
+
+		return err
+	}
+
+	return nil
+}
+
+// MoveServerRoute moves the XRay server route exception to server. The route of the new server is added
+// before the previous one is deleted, so that the traffic of the previous server, which may still be in use,
+// does not loop through the TUN device meanwhile. The previous route is kept if adding the new one fails.
+func (r *router) MoveServerRoute(server net.IP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev := r.server
+	if prev != nil && prev.Equal(server) {
+		return nil
+	}
+	var old route.Opts
+	if prev != nil {
+		old = r.serverRoute()
+	}
+
+	r.server = nil
+	if server.To4() != nil {
+		r.server = server
+		_ = r.table.Delete(r.serverRoute()) // In case previous run failed.
+		if err := r.table.Add(r.serverRoute()); err != nil {
+			r.server = prev
+
+			return err
+		}
+	}
+	if prev != nil {
+		_ = r.table.Delete(old) // The route may be already gone, it is not used anymore either way.
+	}
+
+	return nil
+}
+
+// DeleteServerRoute removes the XRay server route exception.
+func (r *router) DeleteServerRoute() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil {
+		return nil
+	}
+	err := r.table.Delete(r.serverRoute())
+	r.server = nil
+
+	return err
+}
+
+// ServerRoute returns the XRay server route exception, it returns false if it is not installed.
+func (r *router) ServerRoute() (route.Opts, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil {
+		return route.Opts{}, false
+	}
+
+	return r.serverRoute(), true
+}
+
+// RefreshServerRoute reinstalls the XRay server route exception.
+func (r *router) RefreshServerRoute() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil {
+		return ErrNotConnected
+	}
+
+	_ = r.table.Delete(r.serverRoute()) // The route may be already gone.
+	if err := r.table.Add(r.serverRoute()); err != nil {
+		return fmt.Errorf("add xray server route exception: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureServerRoute adds the XRay server route exception if it is missing.
+// It returns true if the route had to be restored.
+func (r *router) EnsureServerRoute() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil {
+		return false, ErrNotConnected
+	}
+
+	// Route packages report existing routes only by error message, so adding the route
+	// is the portable way to check that it is still there.
+	err := r.table.Add(r.serverRoute())
+	switch {
+	case err == nil:
+		return true, nil
+	case isRouteExists(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// DropServerRoute removes the XRay server route exception from the table but keeps it recorded,
+// like the system does on network changes, so that EnsureServerRoute adds it again.
+func (r *router) DropServerRoute() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil {
+		return ErrNotConnected
+	}
+
+	return r.table.Delete(r.serverRoute())
+}
+
+// serverRoute returns the XRay server route exception, r.mu must be held.
+func (r *router) serverRoute() route.Opts {
+	// Append "/32" to match only the XRay server route.
+	return route.Opts{Gateway: r.gateway, Routes: []*route.Addr{route.MustParseAddr(r.server.String() + "/32")}}
+}
+
+// AddBypassRoutes routes addrs through the gateway, so that their traffic does not go through the TUN device.
+func (r *router) AddBypassRoutes(addrs []*route.Addr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opts := route.Opts{Gateway: r.gateway, Routes: addrs}
+	_ = r.table.Delete(opts) // In case previous run failed.
+	if err := r.table.Add(opts); err != nil {
+		return err
+	}
+	r.bypass = append(r.bypass, addrs...)
+
+	return nil
+}
+
+// DeleteBypassRoutes removes addrs added by AddBypassRoutes, others are ignored.
+func (r *router) DeleteBypassRoutes(addrs []*route.Addr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var added []*route.Addr
+	for _, a := range addrs {
+		if slices.ContainsFunc(r.bypass, func(b *route.Addr) bool { return a.String() == b.String() }) {
+			added = append(added, a)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	r.bypass = slices.DeleteFunc(r.bypass, func(b *route.Addr) bool {
+		return slices.ContainsFunc(added, func(a *route.Addr) bool { return a.String() == b.String() })
+	})
+
+	return r.table.Delete(route.Opts{Gateway: r.gateway, Routes: added})
+}
+
+// bypassRoutes returns the routes added by AddBypassRoutes, r.mu must be held.
+func (r *router) bypassRoutes() route.Opts {
+	return route.Opts{Gateway: r.gateway, Routes: r.bypass}
+}
+
+// AddLinkRoutes routes addrs through the interface ifName, so that their traffic does not go through the TUN device.
+// Unlike bypass routes they do not depend on the gateway.
+func (r *router) AddLinkRoutes(ifName string, addrs []*route.Addr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opts := route.Opts{IfName: ifName, Routes: addrs}
+	_ = r.table.Delete(opts) // In case previous run failed.
+	if err := r.table.Add(opts); err != nil {
+		return err
+	}
+	if r.links == nil {
+		r.links = make(map[string][]*route.Addr)
+	}
+	r.links[ifName] = append(r.links[ifName], addrs...)
+
+	return nil
+}
+
+// DeleteLinkRoutes removes all routes added by AddLinkRoutes.
+func (r *router) DeleteLinkRoutes() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for ifName, addrs := range r.links {
+		err = errors.Join(err, r.table.Delete(route.Opts{IfName: ifName, Routes: addrs}))
+	}
+	r.links = nil
+
+	return err
+}
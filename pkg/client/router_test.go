@@ -0,0 +1,223 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/goxray/tun/pkg/client/mocks"
+)
+
+func TestRouter_ServerRoute(t *testing.T) {
+	tableMock := mocks.NewMockipTable(gomock.NewController(t))
+	r := newRouter(tableMock, net.IPv4(192, 168, 1, 1))
+	want := route.Opts{Gateway: net.IPv4(192, 168, 1, 1), Routes: []*route.Addr{route.MustParseAddr("1.2.3.4/32")}}
+
+	_, err := r.EnsureServerRoute()
+	require.ErrorIs(t, err, ErrNotConnected)
+
+	tableMock.EXPECT().Delete(want).Return(errors.New("no such process"))
+	tableMock.EXPECT().Add(want).Return(nil)
+	require.NoError(t, r.AddServerRoute(net.IPv4(1, 2, 3, 4)))
+	got, ok := r.ServerRoute()
+	require.True(t, ok)
+	require.Equal(t, want, got)
+
+	tableMock.EXPECT().Add(want).Return(errors.New("failed to update route: file exists"))
+	restored, err := r.EnsureServerRoute()
+	require.NoError(t, err)
+	require.False(t, restored)
+
+	tableMock.EXPECT().Add(want).Return(nil)
+	restored, err = r.EnsureServerRoute()
+	require.NoError(t, err)
+	require.True(t, restored)
+
+	tableMock.EXPECT().Add(want).Return(errors.New("permission denied"))
+	_, err = r.EnsureServerRoute()
+	require.ErrorContains(t, err, "permission denied")
+
+	tableMock.EXPECT().Delete(want).Return(nil)
+	require.NoError(t, r.DropServerRoute())
+	tableMock.EXPECT().Add(want).Return(nil)
+	restored, err = r.EnsureServerRoute()
+	require.NoError(t, err)
+	require.True(t, restored, "dropped route is kept recorded")
+
+	tableMock.EXPECT().Delete(want).Return(nil)
+	require.NoError(t, r.DeleteServerRoute())
+	_, ok = r.ServerRoute()
+	require.False(t, ok)
+	require.NoError(t, r.DeleteServerRoute())
+	require.ErrorIs(t, r.DropServerRoute(), ErrNotConnected)
+
+	require.NoError(t, r.AddServerRoute(net.ParseIP("2001:db8::1")), "IPv6 server is not routed to the TUN device")
+	_, ok = r.ServerRoute()
+	require.False(t, ok)
+}
+
+func TestRouter_MoveServerRoute(t *testing.T) {
+	tableMock := mocks.NewMockipTable(gomock.NewController(t))
+	r := newRouter(tableMock, net.IPv4(192, 168, 1, 1))
+	r.server = net.IPv4(1, 2, 3, 4)
+	oldRoute, _ := r.ServerRoute()
+	newRoute := route.Opts{Gateway: oldRoute.Gateway, Routes: []*route.Addr{route.MustParseAddr("5.6.7.8/32")}}
+
+	// Failed move keeps the previous route.
+	gomock.InOrder(
+		tableMock.EXPECT().Delete(newRoute).Return(nil),
+		tableMock.EXPECT().Add(newRoute).Return(errors.New("network is unreachable")),
+	)
+	require.Error(t, r.MoveServerRoute(net.IPv4(5, 6, 7, 8)))
+	got, ok := r.ServerRoute()
+	require.True(t, ok)
+	require.Equal(t, oldRoute, got)
+
+	// The previous server is routed outside of the TUN device till the new one is.
+	gomock.InOrder(
+		tableMock.EXPECT().Delete(newRoute).Return(nil),
+		tableMock.EXPECT().Add(newRoute).Return(nil),
+		tableMock.EXPECT().Delete(oldRoute).Return(nil),
+	)
+	require.NoError(t, r.MoveServerRoute(net.IPv4(5, 6, 7, 8)))
+	got, _ = r.ServerRoute()
+	require.Equal(t, newRoute, got)
+	require.NoError(t, r.MoveServerRoute(net.IPv4(5, 6, 7, 8)), "the same server keeps its route")
+
+	tableMock.EXPECT().Delete(newRoute).Return(nil)
+	require.NoError(t, r.MoveServerRoute(net.ParseIP("2001:db8::1")), "IPv6 server is not routed to the TUN device")
+	_, ok = r.ServerRoute()
+	require.False(t, ok)
+}
+
+func TestRouter_SetGateway(t *testing.T) {
+	tableMock := mocks.NewMockipTable(gomock.NewController(t))
+	r := newRouter(tableMock, net.IPv4(192, 168, 1, 1))
+	r.server = net.IPv4(1, 2, 3, 4)
+	oldRoute, _ := r.ServerRoute()
+	newRoute := route.Opts{Gateway: net.IPv4(10, 0, 0, 1), Routes: oldRoute.Routes}
+
+	// Failed update keeps the old gateway.
+	tableMock.EXPECT().Delete(oldRoute).Return(nil)
+	tableMock.EXPECT().Add(newRoute).Return(errors.New("network is unreachable"))
+	require.Error(t, r.SetGateway(net.IPv4(10, 0, 0, 1)))
+	require.Equal(t, "192.168.1.1", r.Gateway().String())
+
+	tableMock.EXPECT().Delete(oldRoute).Return(nil)
+	tableMock.EXPECT().Add(newRoute).Return(nil)
+	require.NoError(t, r.SetGateway(net.IPv4(10, 0, 0, 1)))
+	require.Equal(t, "10.0.0.1", r.Gateway().String())
+
+	// Same gateway is a no-op.
+	require.NoError(t, r.SetGateway(net.IPv4(10, 0, 0, 1)))
+}
+
+func TestRouter_BypassRoutes(t *testing.T) {
+	tableMock := mocks.NewMockipTable(gomock.NewController(t))
+	r := newRouter(tableMock, net.IPv4(192, 168, 1, 1))
+	addrs := []*route.Addr{route.MustParseAddr("10.0.0.53/32")}
+	oldRoutes := route.Opts{Gateway: net.IPv4(192, 168, 1, 1), Routes: addrs}
+	newRoutes := route.Opts{Gateway: net.IPv4(10, 0, 0, 1), Routes: addrs}
+
+	tableMock.EXPECT().Delete(oldRoutes).Return(errors.New("no such process"))
+	tableMock.EXPECT().Add(oldRoutes).Return(nil)
+	require.NoError(t, r.AddBypassRoutes(addrs))
+
+	tableMock.EXPECT().Delete(oldRoutes).Return(nil)
+	tableMock.EXPECT().Add(newRoutes).Return(nil)
+	require.NoError(t, r.SetGateway(net.IPv4(10, 0, 0, 1)))
+
+	tableMock.EXPECT().Delete(newRoutes).Return(nil)
+	require.NoError(t, r.DeleteBypassRoutes(addrs))
+	require.NoError(t, r.DeleteBypassRoutes(addrs))
+}
+
+func TestRouter_LinkRoutes(t *testing.T) {
+	tableMock := mocks.NewMockipTable(gomock.NewController(t))
+	r := newRouter(tableMock, net.IPv4(192, 168, 1, 1))
+	opts := route.Opts{IfName: "docker0", Routes: []*route.Addr{route.MustParseAddr("172.17.0.0/16")}}
+
+	tableMock.EXPECT().Delete(opts).Return(errors.New("no such process"))
+	tableMock.EXPECT().Add(opts).Return(nil)
+	require.NoError(t, r.AddLinkRoutes(opts.IfName, opts.Routes))
+
+	// Link routes are bound to the interface and stay when the gateway changes.
+	require.NoError(t, r.SetGateway(net.IPv4(10, 0, 0, 1)))
+
+	tableMock.EXPECT().Delete(opts).Return(nil)
+	require.NoError(t, r.DeleteLinkRoutes())
+	require.NoError(t, r.DeleteLinkRoutes())
+}
+
+func TestRouter_EnsureTUNRoutes(t *testing.T) {
+	tableMock := mocks.NewMockipTable(gomock.NewController(t))
+	r := newRouter(tableMock, net.IPv4(192, 168, 1, 1))
+	first, second := route.MustParseAddr("0.0.0.0/1"), route.MustParseAddr("128.0.0.0/1")
+	routeOpts := func(a *route.Addr) route.Opts { return route.Opts{IfName: "tun0", Routes: []*route.Addr{a}} }
+
+	_, err := r.EnsureTUNRoutes()
+	require.ErrorIs(t, err, ErrNotConnected)
+
+	tableMock.EXPECT().Add(route.Opts{IfName: "tun0", Routes: []*route.Addr{first, second}}).Return(nil)
+	require.NoError(t, r.AddTUNRoutes("tun0", []*route.Addr{first, second}))
+
+	// The second route was removed by a DHCP client.
+	tableMock.EXPECT().Add(routeOpts(first)).Return(errors.New("file exists"))
+	tableMock.EXPECT().Add(routeOpts(second)).Return(nil)
+	restored, err := r.EnsureTUNRoutes()
+	require.NoError(t, err)
+	require.Equal(t, []*route.Addr{second}, restored)
+
+	tableMock.EXPECT().Delete(routeOpts(first)).Return(nil)
+	require.NoError(t, r.DeleteTUNRoute(first))
+	tableMock.EXPECT().Add(routeOpts(second)).Return(errors.New("network is down"))
+	restored, err = r.EnsureTUNRoutes()
+	require.ErrorContains(t, err, "network is down")
+	require.Empty(t, restored)
+
+	r.ReleaseTUN()
+	_, err = r.EnsureTUNRoutes()
+	require.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestRouter_MoveTUN(t *testing.T) {
+	tableMock := mocks.NewMockipTable(gomock.NewController(t))
+	r := newRouter(tableMock, net.IPv4(192, 168, 1, 1))
+	routes := []*route.Addr{route.MustParseAddr("0.0.0.0/1"), route.MustParseAddr("128.0.0.0/1")}
+
+	tableMock.EXPECT().Add(route.Opts{IfName: "tun0", Routes: routes}).Return(nil)
+	require.NoError(t, r.AddTUNRoutes("tun0", routes))
+
+	// Adding fails, the new device is still recorded for EnsureTUNRoutes to retry.
+	tableMock.EXPECT().Add(route.Opts{IfName: "tun1", Routes: routes}).Return(errors.New("network is down"))
+	require.ErrorContains(t, r.MoveTUN("tun1"), "network is down")
+	tableMock.EXPECT().Add(route.Opts{IfName: "tun1", Routes: routes[:1]}).Return(nil)
+	tableMock.EXPECT().Add(route.Opts{IfName: "tun1", Routes: routes[1:]}).Return(nil)
+	restored, err := r.EnsureTUNRoutes()
+	require.NoError(t, err)
+	require.Equal(t, routes, restored)
+}
+
+func TestRouter_TUNTable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	tableMock, tunTableMock := mocks.NewMockipTable(ctrl), mocks.NewMockipTable(ctrl)
+	r := newRouter(tableMock, net.IPv4(192, 168, 1, 1))
+	r.tunTable = tunTableMock
+	routes := []*route.Addr{route.MustParseAddr("0.0.0.0/1")}
+
+	tunTableMock.EXPECT().Add(route.Opts{IfName: "tun0", Routes: routes}).Return(nil)
+	require.NoError(t, r.AddTUNRoutes("tun0", routes))
+
+	// Exceptions stay in the main table.
+	server := route.Opts{Gateway: net.IPv4(192, 168, 1, 1), Routes: []*route.Addr{route.MustParseAddr("1.2.3.4/32")}}
+	tableMock.EXPECT().Delete(server).Return(nil)
+	tableMock.EXPECT().Add(server).Return(nil)
+	require.NoError(t, r.AddServerRoute(net.IPv4(1, 2, 3, 4)))
+
+	tunTableMock.EXPECT().Delete(route.Opts{IfName: "tun0", Routes: routes}).Return(nil)
+	require.NoError(t, r.DeleteTUNRoute(routes[0]))
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goxray/core/network/route"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// defaultServerRouteCheckInterval is how often the routes of the client are verified.
+const defaultServerRouteCheckInterval = 30 * time.Second
+
+// ServerRoute returns the route exception which directs traffic for XRay server through the gateway.
+// It returns false if the client is not connected or routing is left to the owner of the TUN device,
+// see ConnectWithTUN.
+func (c *Client) ServerRoute() (route.Opts, bool) {
+	if c.router == nil {
+		return route.Opts{}, false
+	}
+
+	return c.router.ServerRoute()
+}
+
+// RefreshServerRoute reinstalls the route exception for XRay server.
+//
+// Use it when the system routing table was changed externally, e.g. after network reconfiguration.
+func (c *Client) RefreshServerRoute() error {
+	if c.router == nil {
+		return ErrNotConnected
+	}
+	if err := c.router.RefreshServerRoute(); err != nil {
+		return err
+	}
+	c.cfg.Logger.Debug("xray server route refreshed")
+
+	return nil
+}
+
+// watchRoutes verifies that the routes to the TUN device and the route exception for XRay server are present
+// every Config.ServerRouteCheckInterval and on routing table changes, and restores them if needed,
+// e.g. after a DHCP renew. It returns when ctx is done.
+func (c *Client) watchRoutes(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.ServerRouteCheckInterval)
+	defer ticker.Stop()
+	changes := routeChanges(ctx, c.cfg.Logger)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changes:
+		}
+
+		c.ensureRoutes()
+	}
+}
+
+// ensureRoutes restores routes of the client removed externally.
+func (c *Client) ensureRoutes() {
+	restored, err := c.router.EnsureServerRoute()
+	if err != nil {
+		c.cfg.Logger.Warn("xray server route check failed", "err", err)
+	}
+	if r, ok := c.router.ServerRoute(); restored && ok {
+		c.cfg.Logger.Warn("xray server route was removed externally and has been restored", "route", r)
+		c.emit(observe.EventRouteRestored, "route", r.Routes[0].IP.String())
+	}
+
+	tunRoutes, err := c.router.EnsureTUNRoutes()
+	if err != nil {
+		c.cfg.Logger.Warn("TUN routes check failed", "err", err)
+	}
+	for _, r := range tunRoutes {
+		c.cfg.Logger.Warn("TUN route was removed externally and has been restored", "route", r)
+		c.emit(observe.EventRouteRestored, "route", r.String())
+	}
+}
+
+// AddRoute points cidr, e.g. "10.0.0.0/8", to the TUN device. If the client is connected the route is added
+// right away, and it is kept in Config.RoutesToTUN for the following connections.
+func (c *Client) AddRoute(cidr string) error {
+	addr, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+
+	if slices.ContainsFunc(c.cfg.RoutesToTUN, addr.equal) {
+		return nil
+	}
+	if c.router == nil {
+		err = ErrNotConnected
+	} else {
+		err = c.router.AddTUNRoute((*route.Addr)(addr))
+	}
+	if err != nil && !errors.Is(err, ErrNotConnected) {
+		return fmt.Errorf("add route %s: %w", addr, err)
+	}
+	// RoutesToTUN may be shared with DefaultRoutesToTUN, so it is never modified in place.
+	c.cfg.RoutesToTUN = append(slices.Clip(c.cfg.RoutesToTUN), (*route.Addr)(addr))
+	c.cfg.Logger.Debug("route to TUN added", "route", addr)
+
+	return nil
+}
+
+// RemoveRoute stops pointing cidr to the TUN device, it is the reverse of AddRoute and also applies
+// to routes of Config.RoutesToTUN.
+func (c *Client) RemoveRoute(cidr string) error {
+	addr, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+
+	i := slices.IndexFunc(c.cfg.RoutesToTUN, addr.equal)
+	if i < 0 {
+		return fmt.Errorf("route %s is not pointed to TUN", addr)
+	}
+	if c.router == nil {
+		err = ErrNotConnected
+	} else {
+		err = c.router.DeleteTUNRoute((*route.Addr)(addr))
+	}
+	if err != nil && !errors.Is(err, ErrNotConnected) {
+		return fmt.Errorf("delete route %s: %w", addr, err)
+	}
+	c.cfg.RoutesToTUN = slices.Delete(slices.Clone(c.cfg.RoutesToTUN), i, i+1)
+	c.cfg.Logger.Debug("route to TUN removed", "route", addr)
+
+	return nil
+}
+
+// Routes returns routes pointed to the TUN device, including those added by AddRoute.
+func (c *Client) Routes() []*route.Addr {
+	c.cfgMu.RLock()
+	defer c.cfgMu.RUnlock()
+
+	routes := make([]*route.Addr, len(c.cfg.RoutesToTUN))
+	for i, r := range c.cfg.RoutesToTUN {
+		routes[i] = &route.Addr{IP: slices.Clone(r.IP), Mask: slices.Clone(r.Mask)}
+	}
+
+	return routes
+}
+
+// cidrAddr is a network route, comparable with routes of Config.RoutesToTUN.
+type cidrAddr route.Addr
+
+// parseCIDR parses cidr into the network it denotes, host bits are cleared.
+func parseCIDR(cidr string) (*cidrAddr, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("parse route: %w", err)
+	}
+
+	return (*cidrAddr)(ipNet), nil
+}
+
+func (a *cidrAddr) equal(r *route.Addr) bool {
+	ones, bits := r.Mask.Size()
+	aOnes, aBits := a.Mask.Size()
+
+	return a.IP.Equal(r.IP) && ones == aOnes && bits == aBits
+}
+
+func (a *cidrAddr) String() string {
+	return (*route.Addr)(a).String()
+}
+
+// isRouteExists reports whether err is returned for adding a route that is already present.
+func isRouteExists(err error) bool {
+	return strings.Contains(err.Error(), "file exists")
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// routeChanges returns a channel signalled after the routing table changes, bursts of changes
+// are coalesced into one signal. The channel is nil if the table can not be watched.
+func routeChanges(ctx context.Context, logger *slog.Logger) <-chan struct{} {
+	updates := make(chan netlink.RouteUpdate)
+	err := netlink.RouteSubscribeWithOptions(updates, ctx.Done(), netlink.RouteSubscribeOptions{
+		ErrorCallback: func(err error) { logger.Debug("routing table watch failed", "err", err) },
+	})
+	if err != nil {
+		logger.Warn("watching routing table failed, routes are verified periodically", "err", err)
+
+		return nil
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		var settled <-chan time.Time
+		for {
+			select {
+			case _, ok := <-updates:
+				if !ok {
+					return // ctx is done.
+				}
+				if settled == nil {
+					settled = time.After(networkChangeDebounce)
+				}
+			case <-settled:
+				settled = nil
+				select {
+				case changes <- struct{}{}:
+				default: // The previous change is not handled yet.
+				}
+			}
+		}
+	}()
+
+	return changes
+}
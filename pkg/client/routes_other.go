@@ -0,0 +1,13 @@
+//go:build !linux
+
+package client
+
+import (
+	"context"
+	"log/slog"
+)
+
+// routeChanges returns nil, routing table changes are not watched and routes are verified periodically.
+func routeChanges(context.Context, *slog.Logger) <-chan struct{} {
+	return nil
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/goxray/tun/pkg/client/mocks"
+)
+
+func TestServerRoute_NotConnected(t *testing.T) {
+	cl := &Client{}
+
+	_, ok := cl.ServerRoute()
+	require.False(t, ok)
+	require.ErrorIs(t, cl.RefreshServerRoute(), ErrNotConnected)
+}
+
+func TestClient_AddRemoveRoute(t *testing.T) {
+	tableMock := mocks.NewMockipTable(gomock.NewController(t))
+	gw := net.IPv4(192, 168, 1, 1)
+	cl := &Client{cfg: Config{
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		RoutesToTUN: DefaultRoutesToTUN,
+	}, router: newRouter(tableMock, gw)}
+
+	// Not connected, only the config is changed.
+	require.NoError(t, cl.AddRoute("10.1.2.3/8"))
+	require.Equal(t, "10.0.0.0/8", cl.Routes()[2].String())
+	require.Len(t, DefaultRoutesToTUN, 2)
+	require.NoError(t, cl.AddRoute("10.0.0.0/8"))
+	require.Len(t, cl.Routes(), 3)
+
+	tun := route.Opts{IfName: "utun9", Routes: cl.Routes()}
+	tableMock.EXPECT().Add(tun).Return(nil)
+	require.NoError(t, cl.router.AddTUNRoutes("utun9", cl.Routes()))
+
+	added := route.Opts{IfName: "utun9", Routes: []*route.Addr{route.MustParseAddr("172.16.0.0/12")}}
+	tableMock.EXPECT().Add(added).Return(nil)
+	require.NoError(t, cl.AddRoute("172.16.0.0/12"))
+
+	tableMock.EXPECT().Delete(added).Return(nil)
+	require.NoError(t, cl.RemoveRoute("172.16.0.0/12"))
+	require.Len(t, cl.Routes(), 3)
+
+	tableMock.EXPECT().Add(added).Return(errors.New("network is unreachable"))
+	require.ErrorContains(t, cl.AddRoute("172.16.0.0/12"), "network is unreachable")
+	require.Len(t, cl.Routes(), 3)
+
+	require.ErrorContains(t, cl.RemoveRoute("172.16.0.0/12"), "is not pointed to TUN")
+	require.Error(t, cl.AddRoute("not a route"))
+
+	cl.router.ReleaseTUN()
+	require.NoError(t, cl.RemoveRoute("0.0.0.0/1"))
+	require.Equal(t, "0.0.0.0/1", DefaultRoutesToTUN[0].String())
+	require.Len(t, cl.Routes(), 2)
+}
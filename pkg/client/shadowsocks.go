@@ -0,0 +1,254 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// shadowsocksMethods are ciphers of the shadowsocks outbound of XRay, 2022 ones take base64 encoded keys
+// of the length in bytes as the password.
+var shadowsocksMethods = map[string]int{
+	"aes-128-gcm":                   0,
+	"aes-256-gcm":                   0,
+	"chacha20-poly1305":             0,
+	"chacha20-ietf-poly1305":        0,
+	"xchacha20-poly1305":            0,
+	"xchacha20-ietf-poly1305":       0,
+	"none":                          0,
+	"plain":                         0,
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
+// shadowsocksLink is ss:// link parsed according to SIP002. Unlike the parser of xray-knife, it accepts
+// percent-encoded user info required for 2022 ciphers, links encoded in base64 as a whole and plugin options
+// which XRay transports can stand in for: v2ray-plugin websocket mode and obfs-local HTTP obfuscation.
+type shadowsocksLink struct {
+	link string
+
+	method   string
+	password string
+	address  string
+	port     string
+	remark   string
+	plugin   string
+	opts     map[string]string // Options of the plugin, flags without a value are set to "".
+}
+
+func (s *shadowsocksLink) Parse() error {
+	link := s.link
+	if u, err := url.Parse(link); err == nil && u.User == nil {
+		// Legacy link, ss://BASE64(method:password@host:port)#remark.
+		rest, fragment, _ := strings.Cut(strings.TrimPrefix(link, xrayproto.ShadowsocksIdentifier+"://"), "#")
+		rest, query, _ := strings.Cut(rest, "?")
+		decoded, err := decodeBase64(rest)
+		if err != nil {
+			return errors.New("shadowsocks link is neither SIP002 nor base64 encoded")
+		}
+		creds, host, ok := cutLast(string(decoded), "@")
+		if !ok {
+			return errors.New("shadowsocks link has no server address")
+		}
+		method, password, _ := strings.Cut(creds, ":")
+		link = xrayproto.ShadowsocksIdentifier + "://" + url.UserPassword(method, password).String() + "@" + host
+		if query != "" {
+			link += "?" + query
+		}
+		if fragment != "" {
+			link += "#" + fragment
+		}
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+	if u.User == nil {
+		return errors.New("shadowsocks link has no credentials")
+	}
+	if password, ok := u.User.Password(); ok {
+		s.method, s.password = u.User.Username(), password
+	} else {
+		decoded, err := decodeBase64(u.User.Username())
+		if err != nil {
+			return errors.New("shadowsocks credentials are neither percent nor base64 encoded")
+		}
+		s.method, s.password, _ = strings.Cut(string(decoded), ":")
+	}
+	s.method = strings.ToLower(s.method)
+	if err = validateShadowsocksKey(s.method, s.password); err != nil {
+		return err
+	}
+
+	if s.address, s.port, err = net.SplitHostPort(u.Host); err != nil {
+		return err
+	}
+	s.remark = u.Fragment
+	s.plugin, s.opts = parsePluginOpts(u.Query().Get("plugin"))
+	if _, err = s.streamSettings(false); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateShadowsocksKey checks that method is supported and the password fits 2022 ciphers. A 2022 password
+// may list several keys separated by colons, e.g. the key of the server and of the user.
+func validateShadowsocksKey(method, password string) error {
+	size, ok := shadowsocksMethods[method]
+	if !ok {
+		return fmt.Errorf("unsupported shadowsocks method %q", method)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	for _, key := range strings.Split(password, ":") {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(decoded) != size {
+			return fmt.Errorf("%s requires base64 encoded %d byte keys as the password", method, size)
+		}
+	}
+
+	return nil
+}
+
+// parsePluginOpts splits SIP003 plugin parameter "name;key=value;flag".
+func parsePluginOpts(plugin string) (string, map[string]string) {
+	if plugin == "" {
+		return "", nil
+	}
+
+	parts := strings.Split(plugin, ";")
+	opts := make(map[string]string, len(parts)-1)
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		opts[k] = v
+	}
+
+	return parts[0], opts
+}
+
+// streamSettings returns XRay transport standing in for the plugin.
+func (s *shadowsocksLink) streamSettings(allowInsecure bool) (*conf.StreamConfig, error) {
+	switch s.plugin {
+	case "":
+		return &conf.StreamConfig{}, nil
+	case "v2ray-plugin", "xray-plugin":
+		if mode, ok := s.opts["mode"]; ok && mode != "websocket" {
+			return nil, fmt.Errorf("%s mode %q is not supported", s.plugin, mode)
+		}
+		host := s.opts["host"]
+		network := conf.TransportProtocol("ws")
+		stream := &conf.StreamConfig{
+			Network:    &network,
+			WSSettings: &conf.WebSocketConfig{Path: s.opts["path"], Host: host},
+		}
+		if _, ok := s.opts["tls"]; ok {
+			stream.Security = "tls"
+			stream.TLSSettings = &conf.TLSConfig{ServerName: host, Insecure: allowInsecure}
+		}
+
+		return stream, nil
+	case "obfs-local", "simple-obfs":
+		if obfs := s.opts["obfs"]; obfs != "http" {
+			return nil, fmt.Errorf("%s obfs %q is not supported, only http is", s.plugin, obfs)
+		}
+		network := conf.TransportProtocol("tcp")
+		header, err := json.Marshal(map[string]any{
+			"type":    "http",
+			"request": map[string]any{"headers": map[string][]string{"Host": {s.opts["obfs-host"]}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &conf.StreamConfig{Network: &network, TCPSettings: &conf.TCPConfig{HeaderConfig: header}}, nil
+	default:
+		return nil, fmt.Errorf("shadowsocks plugin %q is not supported", s.plugin)
+	}
+}
+
+func (s *shadowsocksLink) BuildOutboundDetourConfig(allowInsecure bool) (*conf.OutboundDetourConfig, error) {
+	port, err := strconv.Atoi(s.port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", s.port)
+	}
+	stream, err := s.streamSettings(allowInsecure)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := json.Marshal(map[string]any{"servers": []map[string]any{{
+		"address":  s.address,
+		"port":     port,
+		"method":   s.method,
+		"password": s.password,
+	}}})
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(settings)
+
+	return &conf.OutboundDetourConfig{Protocol: "shadowsocks", Tag: "proxy", Settings: &raw, StreamSetting: stream}, nil
+}
+
+func (s *shadowsocksLink) BuildInboundDetourConfig() (*conf.InboundDetourConfig, error) {
+	return nil, nil
+}
+
+func (s *shadowsocksLink) DetailsStr() string {
+	return fmt.Sprintf("Protocol: shadowsocks\nRemark: %s\nIP: %s\nPort: %s\nEncryption: %s\nPlugin: %s\n",
+		s.remark, s.address, s.port, s.method, s.plugin)
+}
+
+func (s *shadowsocksLink) ConvertToGeneralConfig() xrayproto.GeneralConfig {
+	g := xrayproto.GeneralConfig{
+		Protocol: "shadowsocks",
+		Address:  s.address,
+		ID:       s.password,
+		Port:     s.port,
+		Remark:   s.remark,
+		Network:  "tcp",
+		OrigLink: s.link,
+	}
+	if stream, err := s.streamSettings(false); err == nil {
+		if stream.Network != nil {
+			g.Network = string(*stream.Network)
+		}
+		g.TLS = stream.Security
+		if stream.TLSSettings != nil {
+			g.SNI = stream.TLSSettings.ServerName
+		}
+	}
+
+	return g
+}
+
+// decodeBase64 decodes standard or URL base64, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if b, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadowsocksLink(t *testing.T) {
+	key := "AAECAwQFBgcICQoLDA0ODw==" // 16 bytes.
+	b64 := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name     string
+		link     string
+		method   string
+		password string
+		network  string
+		err      string
+	}{
+		{
+			name:     "2022 percent-encoded",
+			link:     "ss://2022-blake3-aes-128-gcm:AAECAwQFBgcICQoLDA0ODw%3D%3D@1.2.3.4:8388#my%20server",
+			method:   "2022-blake3-aes-128-gcm",
+			password: key,
+			network:  "tcp",
+		},
+		{
+			name:     "2022 with user key",
+			link:     "ss://" + b64("2022-blake3-aes-128-gcm:"+key+":"+key) + "@1.2.3.4:8388",
+			method:   "2022-blake3-aes-128-gcm",
+			password: key + ":" + key,
+			network:  "tcp",
+		},
+		{
+			name:     "base64 user info",
+			link:     "ss://" + b64("chacha20-ietf-poly1305:secret") + "@1.2.3.4:8388",
+			method:   "chacha20-ietf-poly1305",
+			password: "secret",
+			network:  "tcp",
+		},
+		{
+			name:     "legacy",
+			link:     "ss://" + b64("aes-256-gcm:pass:word@1.2.3.4:8388") + "#legacy",
+			method:   "aes-256-gcm",
+			password: "pass:word",
+			network:  "tcp",
+		},
+		{
+			name:     "v2ray-plugin",
+			link:     "ss://" + b64("aes-128-gcm:secret") + "@1.2.3.4:443?plugin=v2ray-plugin%3Btls%3Bhost%3Dexample.com%3Bpath%3D%2Fws",
+			method:   "aes-128-gcm",
+			password: "secret",
+			network:  "ws",
+		},
+		{
+			name:     "obfs-local http",
+			link:     "ss://" + b64("aes-128-gcm:secret") + "@1.2.3.4:80?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dexample.com",
+			method:   "aes-128-gcm",
+			password: "secret",
+			network:  "tcp",
+		},
+		{name: "short 2022 key", link: "ss://2022-blake3-aes-256-gcm:" + "AAECAwQFBgcICQoLDA0ODw%3D%3D@1.2.3.4:8388", err: "32 byte keys"},
+		{name: "unknown method", link: "ss://" + b64("rc4-md5:secret") + "@1.2.3.4:8388", err: "unsupported shadowsocks method"},
+		{name: "obfs tls", link: "ss://" + b64("aes-128-gcm:secret") + "@1.2.3.4:443?plugin=obfs-local%3Bobfs%3Dtls", err: "not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protocol, cfg, err := parseLink(xray.NewXrayService(false, false), tt.link)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+
+				return
+			}
+			require.NoError(t, err)
+			ss := protocol.(*shadowsocksLink)
+			require.Equal(t, tt.method, ss.method)
+			require.Equal(t, tt.password, ss.password)
+			require.Equal(t, "1.2.3.4", cfg.Address)
+			require.Equal(t, tt.network, cfg.Network)
+
+			detour, err := ss.BuildOutboundDetourConfig(false)
+			require.NoError(t, err)
+			_, err = detour.Build()
+			require.NoError(t, err)
+			var settings struct {
+				Servers []struct{ Method, Password string }
+			}
+			require.NoError(t, json.Unmarshal(*detour.Settings, &settings))
+			require.Equal(t, tt.password, settings.Servers[0].Password)
+		})
+	}
+}
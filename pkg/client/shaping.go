@@ -0,0 +1,192 @@
+package client
+
+import (
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// shapingQueueLen is the number of packets queued in each direction, packets over it are dropped
+// like on a congested link.
+const shapingQueueLen = 1024
+
+// Shaping simulates network conditions on the TUN path, e.g. for testing apps on a slow network.
+// Zero value disables shaping.
+type Shaping struct {
+	Latency   time.Duration // Delay added to every packet in each direction.
+	Jitter    time.Duration // Random deviation of Latency, up to Jitter either way.
+	Bandwidth int           // Bytes per second in each direction, 0 is unlimited.
+}
+
+func (s Shaping) enabled() bool {
+	return s.Latency > 0 || s.Jitter > 0 || s.Bandwidth > 0
+}
+
+// shapeTunnel wraps tun with Config.Shaping, if enabled.
+func (c *Client) shapeTunnel(tun io.ReadWriteCloser) io.ReadWriteCloser {
+	if !c.cfg.Shaping.enabled() {
+		return tun
+	}
+
+	return newShapedTunnel(tun, c.cfg.Shaping)
+}
+
+// delayLine computes delivery times of packets going in one direction.
+type delayLine struct {
+	cfg Shaping
+
+	mu        sync.Mutex
+	busyUntil time.Time // End of transmission of the last packet with limited bandwidth.
+	last      time.Time // Delivery time of the last packet, packets are never reordered.
+}
+
+// schedule returns delivery time of a packet of size bytes sent now.
+func (l *delayLine) schedule(size int) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sent := time.Now()
+	if l.cfg.Bandwidth > 0 {
+		l.busyUntil = later(l.busyUntil, sent).Add(time.Duration(size) * time.Second / time.Duration(l.cfg.Bandwidth))
+		sent = l.busyUntil
+	}
+
+	delay := l.cfg.Latency
+	if l.cfg.Jitter > 0 {
+		delay += time.Duration(rand.Int64N(int64(2*l.cfg.Jitter))) - l.cfg.Jitter
+	}
+	l.last = later(l.last, sent.Add(max(delay, 0)))
+
+	return l.last
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// delayedPacket is a packet waiting for its delivery time.
+type delayedPacket struct {
+	data []byte
+	due  time.Time
+	err  error
+}
+
+// shapedTunnel delays packets read from and written to the TUN device according to Shaping.
+type shapedTunnel struct {
+	io.ReadWriteCloser
+
+	up, down *delayLine
+	readQ    chan delayedPacket
+	writeQ   chan delayedPacket
+	done     chan struct{}
+	close    sync.Once
+}
+
+// newShapedTunnel starts shaping traffic of tun.
+func newShapedTunnel(tun io.ReadWriteCloser, cfg Shaping) *shapedTunnel {
+	s := &shapedTunnel{
+		ReadWriteCloser: tun,
+		up:              &delayLine{cfg: cfg},
+		down:            &delayLine{cfg: cfg},
+		readQ:           make(chan delayedPacket, shapingQueueLen),
+		writeQ:          make(chan delayedPacket, shapingQueueLen),
+		done:            make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.writeLoop()
+
+	return s
+}
+
+// readLoop queues packets read from the device.
+func (s *shapedTunnel) readLoop() {
+	buf := make([]byte, defaultMTU)
+	for {
+		n, err := s.ReadWriteCloser.Read(buf)
+		if err != nil {
+			select {
+			case s.readQ <- delayedPacket{err: err}:
+			case <-s.done:
+			}
+
+			return
+		}
+
+		select {
+		case s.readQ <- delayedPacket{data: append([]byte(nil), buf[:n]...), due: s.up.schedule(n)}:
+		case <-s.done:
+			return
+		default: // Queue is full, the packet is lost.
+		}
+	}
+}
+
+// writeLoop writes queued packets to the device once they are due.
+func (s *shapedTunnel) writeLoop() {
+	for {
+		select {
+		case p := <-s.writeQ:
+			if !sleepUntil(p.due, s.done) {
+				return
+			}
+			_, _ = s.ReadWriteCloser.Write(p.data)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *shapedTunnel) Read(p []byte) (int, error) {
+	select {
+	case pkt := <-s.readQ:
+		if pkt.err != nil {
+			return 0, pkt.err
+		}
+		if !sleepUntil(pkt.due, s.done) {
+			return 0, io.ErrClosedPipe
+		}
+
+		return copy(p, pkt.data), nil
+	case <-s.done:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (s *shapedTunnel) Write(p []byte) (int, error) {
+	select {
+	case s.writeQ <- delayedPacket{data: append([]byte(nil), p...), due: s.down.schedule(len(p))}:
+	case <-s.done:
+		return 0, io.ErrClosedPipe
+	default: // Queue is full, the packet is lost.
+	}
+
+	return len(p), nil
+}
+
+func (s *shapedTunnel) Close() error {
+	s.close.Do(func() { close(s.done) })
+
+	return s.ReadWriteCloser.Close()
+}
+
+// sleepUntil waits till t, it returns false if done is closed first.
+func sleepUntil(t time.Time, done <-chan struct{}) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// packetPipe is a TUN device stub, packets written to in are read from the device, written packets go to out.
+type packetPipe struct {
+	in, out chan []byte
+}
+
+func (p *packetPipe) Read(b []byte) (int, error) {
+	pkt, ok := <-p.in
+	if !ok {
+		return 0, io.EOF
+	}
+
+	return copy(b, pkt), nil
+}
+
+func (p *packetPipe) Write(b []byte) (int, error) {
+	p.out <- append([]byte(nil), b...)
+
+	return len(b), nil
+}
+
+func (p *packetPipe) Close() error { return nil }
+
+func TestShapedTunnel_Latency(t *testing.T) {
+	dev := &packetPipe{in: make(chan []byte, 1), out: make(chan []byte, 1)}
+	tun := newShapedTunnel(dev, Shaping{Latency: 50 * time.Millisecond})
+	defer tun.Close()
+
+	start := time.Now()
+	_, err := tun.Write([]byte("down"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("down"), <-dev.out)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	start = time.Now()
+	dev.in <- []byte("up")
+	buf := make([]byte, defaultMTU)
+	n, err := tun.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("up"), buf[:n])
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	close(dev.in)
+	_, err = tun.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestDelayLine_Schedule(t *testing.T) {
+	t.Run("bandwidth", func(t *testing.T) {
+		l := &delayLine{cfg: Shaping{Bandwidth: 1000}}
+		start := time.Now()
+		l.schedule(500)
+		due := l.schedule(500)
+		require.WithinDuration(t, start.Add(time.Second), due, 50*time.Millisecond)
+	})
+
+	t.Run("jitter keeps order", func(t *testing.T) {
+		l := &delayLine{cfg: Shaping{Latency: 10 * time.Millisecond, Jitter: 10 * time.Millisecond}}
+		prev := l.schedule(100)
+		for range 100 {
+			due := l.schedule(100)
+			require.False(t, due.Before(prev))
+			require.True(t, due.Before(time.Now().Add(20*time.Millisecond)))
+			prev = due
+		}
+	})
+}
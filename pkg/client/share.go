@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// errShareUnsupported is returned when LAN sharing is not implemented for the platform.
+var errShareUnsupported = errors.New("sharing the tunnel with LAN is not supported on this platform")
+
+// lanSharer lets other devices of the LAN use the host as their gateway, their traffic is forwarded
+// to the TUN device and translated to its address.
+type lanSharer interface {
+	// Share enables forwarding and address translation to the TUN device ifName.
+	Share(ifName string) error
+	// Unshare reverts changes of Share.
+	Unshare() error
+}
+
+// shareLAN lets LAN devices route through the tunnel, see Config.ShareLAN. Failures are logged,
+// the tunnel then keeps working for the host only.
+func (c *Client) shareLAN() {
+	if err := c.sharer.Share(c.tunName); err != nil {
+		c.cfg.Logger.Error("sharing tunnel with LAN failed", "err", err)
+		if err = c.sharer.Unshare(); err != nil {
+			c.cfg.Logger.Debug("reverting LAN sharing failed", "err", err)
+		}
+
+		return
+	}
+	c.cfg.Logger.Info("tunnel shared with LAN, set this host as the gateway of other devices", "tun", c.tunName)
+}
+
+// moveLANShare moves LAN sharing to the TUN device reopened under a new name, see reopenTunnel.
+func (c *Client) moveLANShare() {
+	if err := c.sharer.Unshare(); err != nil {
+		c.cfg.Logger.Debug("reverting LAN sharing of the previous TUN device failed", "err", err)
+	}
+	c.shareLAN()
+}
+
+// runCommand runs name with args and input on stdin and returns its combined output.
+func runCommand(input, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(out.String()))
+	}
+
+	return out.String(), nil
+}
+
+// unsupportedSharer is the lanSharer of platforms without LAN sharing.
+type unsupportedSharer struct{}
+
+func (unsupportedSharer) Share(string) error {
+	return errShareUnsupported
+}
+
+func (unsupportedSharer) Unshare() error {
+	return nil
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pfShareAnchor holds the NAT rule for LAN sharing, the default pf.conf evaluates "com.apple/*" anchors.
+const pfShareAnchor = "com.apple/goxray-tun"
+
+var pfTokenRe = regexp.MustCompile(`Token : (\d+)`)
+
+func newLANSharer() lanSharer {
+	return &pfSharer{run: runCommand}
+}
+
+// pfSharer enables IPv4 forwarding and translates the traffic forwarded to the TUN device with pf,
+// the same way Internet Sharing does.
+type pfSharer struct {
+	run func(input, name string, args ...string) (string, error)
+
+	forwardWas string // Original forwarding setting, empty if it was not changed.
+	token      string // Reference taken on pf with "pfctl -E", empty if pf was not enabled.
+	anchored   bool   // NAT rule is loaded into pfShareAnchor.
+}
+
+func (s *pfSharer) Share(ifName string) error {
+	out, err := s.run("", "sysctl", "-n", "net.inet.ip.forwarding")
+	if err != nil {
+		return fmt.Errorf("read IP forwarding: %w", err)
+	}
+	if was := strings.TrimSpace(out); was != "1" {
+		if _, err = s.run("", "sysctl", "-w", "net.inet.ip.forwarding=1"); err != nil {
+			return fmt.Errorf("enable IP forwarding: %w", err)
+		}
+		s.forwardWas = was
+	}
+
+	rule := fmt.Sprintf("nat on %[1]s from ! (%[1]s) to any -> (%[1]s)\n", ifName)
+	if _, err = s.run(rule, "pfctl", "-a", pfShareAnchor, "-f", "-"); err != nil {
+		return fmt.Errorf("load NAT rule: %w", err)
+	}
+	s.anchored = true
+
+	// Enabling pf with a reference keeps it enabled for other users after the reference is released.
+	if out, err = s.run("", "pfctl", "-E"); err != nil {
+		return fmt.Errorf("enable pf: %w", err)
+	}
+	if m := pfTokenRe.FindStringSubmatch(out); m != nil {
+		s.token = m[1]
+	}
+
+	return nil
+}
+
+func (s *pfSharer) Unshare() error {
+	var err error
+	if s.anchored {
+		if _, e := s.run("", "pfctl", "-a", pfShareAnchor, "-F", "all"); e != nil {
+			err = errors.Join(err, fmt.Errorf("flush NAT rule: %w", e))
+		}
+		s.anchored = false
+	}
+	if s.token != "" {
+		if _, e := s.run("", "pfctl", "-X", s.token); e != nil {
+			err = errors.Join(err, fmt.Errorf("release pf: %w", e))
+		}
+		s.token = ""
+	}
+	if s.forwardWas != "" {
+		if _, e := s.run("", "sysctl", "-w", "net.inet.ip.forwarding="+s.forwardWas); e != nil {
+			err = errors.Join(err, fmt.Errorf("restore IP forwarding: %w", e))
+		}
+		s.forwardWas = ""
+	}
+
+	return err
+}
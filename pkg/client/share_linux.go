@@ -0,0 +1,77 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	// ipForwardPath switches forwarding of IPv4 packets between interfaces.
+	ipForwardPath = "/proc/sys/net/ipv4/ip_forward"
+	// shareRuleComment tags iptables rules added for LAN sharing.
+	shareRuleComment = "goxray-tun"
+)
+
+func newLANSharer() lanSharer {
+	return &iptablesSharer{run: runCommand, forwardPath: ipForwardPath}
+}
+
+// iptablesSharer enables IPv4 forwarding and masquerades the traffic forwarded to the TUN device with iptables.
+type iptablesSharer struct {
+	run         func(input, name string, args ...string) (string, error)
+	forwardPath string
+
+	forwardWas []byte     // Original forwarding setting, nil if it was not changed.
+	rules      [][]string // Added rules as table, chain and rule specification.
+}
+
+func (s *iptablesSharer) Share(ifName string) error {
+	was, err := os.ReadFile(s.forwardPath)
+	if err != nil {
+		return fmt.Errorf("read IP forwarding: %w", err)
+	}
+	if strings.TrimSpace(string(was)) != "1" {
+		if err = os.WriteFile(s.forwardPath, []byte("1\n"), 0o644); err != nil {
+			return fmt.Errorf("enable IP forwarding: %w", err)
+		}
+		s.forwardWas = was
+	}
+
+	comment := []string{"-m", "comment", "--comment", shareRuleComment}
+	for _, rule := range [][]string{
+		{"nat", "POSTROUTING", "-o", ifName, "-j", "MASQUERADE"},
+		{"filter", "FORWARD", "-o", ifName, "-j", "ACCEPT"},
+		{"filter", "FORWARD", "-i", ifName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	} {
+		rule = append(rule, comment...)
+		// Rules are inserted first, as firewalls commonly drop forwarded traffic by the end of the chain.
+		if _, err = s.run("", "iptables", slices.Concat([]string{"-t", rule[0], "-I", rule[1]}, rule[2:])...); err != nil {
+			return fmt.Errorf("add %s rule: %w", rule[1], err)
+		}
+		s.rules = append(s.rules, rule)
+	}
+
+	return nil
+}
+
+func (s *iptablesSharer) Unshare() error {
+	var err error
+	for _, rule := range slices.Backward(s.rules) {
+		if _, e := s.run("", "iptables", slices.Concat([]string{"-t", rule[0], "-D", rule[1]}, rule[2:])...); e != nil {
+			err = errors.Join(err, fmt.Errorf("delete %s rule: %w", rule[1], e))
+		}
+	}
+	s.rules = nil
+
+	if s.forwardWas != nil {
+		if e := os.WriteFile(s.forwardPath, s.forwardWas, 0o644); e != nil {
+			err = errors.Join(err, fmt.Errorf("restore IP forwarding: %w", e))
+		}
+		s.forwardWas = nil
+	}
+
+	return err
+}
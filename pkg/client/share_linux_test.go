@@ -0,0 +1,97 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIptablesSharer(t *testing.T) {
+	forward := filepath.Join(t.TempDir(), "ip_forward")
+	require.NoError(t, os.WriteFile(forward, []byte("0\n"), 0o644))
+
+	var calls []string
+	s := &iptablesSharer{forwardPath: forward, run: func(_, name string, args ...string) (string, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		return "", nil
+	}}
+
+	require.NoError(t, s.Share("tun0"))
+	data, err := os.ReadFile(forward)
+	require.NoError(t, err)
+	require.Equal(t, "1\n", string(data))
+
+	require.NoError(t, s.Unshare())
+	data, err = os.ReadFile(forward)
+	require.NoError(t, err)
+	require.Equal(t, "0\n", string(data))
+	require.NoError(t, s.Unshare()) // Nothing to revert anymore.
+
+	comment := " -m comment --comment goxray-tun"
+	require.Equal(t, []string{
+		"iptables -t nat -I POSTROUTING -o tun0 -j MASQUERADE" + comment,
+		"iptables -t filter -I FORWARD -o tun0 -j ACCEPT" + comment,
+		"iptables -t filter -I FORWARD -i tun0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT" + comment,
+		"iptables -t filter -D FORWARD -i tun0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT" + comment,
+		"iptables -t filter -D FORWARD -o tun0 -j ACCEPT" + comment,
+		"iptables -t nat -D POSTROUTING -o tun0 -j MASQUERADE" + comment,
+	}, calls)
+}
+
+func TestIptablesSharer_Failure(t *testing.T) {
+	forward := filepath.Join(t.TempDir(), "ip_forward")
+	require.NoError(t, os.WriteFile(forward, []byte("1\n"), 0o644))
+
+	var calls int
+	s := &iptablesSharer{forwardPath: forward, run: func(string, string, ...string) (string, error) {
+		if calls++; calls == 2 {
+			return "", errors.New("iptables: not found")
+		}
+		return "", nil
+	}}
+
+	require.ErrorContains(t, s.Share("tun0"), "add FORWARD rule")
+	require.Len(t, s.rules, 1)
+	require.Nil(t, s.forwardWas) // Forwarding was already on.
+	require.NoError(t, s.Unshare())
+	require.Equal(t, 3, calls)
+}
+
+func TestMoveLANShare(t *testing.T) {
+	forward := filepath.Join(t.TempDir(), "ip_forward")
+	require.NoError(t, os.WriteFile(forward, []byte("0\n"), 0o644))
+	var calls []string
+	s := &iptablesSharer{forwardPath: forward, run: func(_, name string, args ...string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return "", nil
+	}}
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.sharer, cl.tunName = s, "tun0"
+	cl.shareLAN()
+
+	// Rules of the reopened device match its new name.
+	calls = nil
+	cl.tunName = "tun1"
+	cl.moveLANShare()
+	require.Len(t, calls, 6)
+	for _, call := range calls[:3] {
+		require.Contains(t, call, "-D")
+		require.Contains(t, call, "tun0")
+	}
+	for _, call := range calls[3:] {
+		require.Contains(t, call, "-I")
+		require.Contains(t, call, "tun1")
+	}
+	data, err := os.ReadFile(forward)
+	require.NoError(t, err)
+	require.Equal(t, "1\n", string(data))
+
+	require.NoError(t, s.Unshare())
+	data, err = os.ReadFile(forward)
+	require.NoError(t, err)
+	require.Equal(t, "0\n", string(data), "forwarding is restored to the state before sharing")
+}
@@ -0,0 +1,7 @@
+//go:build !darwin && !linux
+
+package client
+
+func newLANSharer() lanSharer {
+	return unsupportedSharer{}
+}
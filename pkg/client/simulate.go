@@ -0,0 +1,67 @@
+//go:build goxray_simulate
+
+package client
+
+import (
+	"errors"
+	"net"
+)
+
+// Network change simulation hooks, built only with the goxray_simulate build tag so that apps can test
+// how they handle roaming and outages, e.g. their reconnect UX, without toggling Wi-Fi:
+//
+//	go test -tags goxray_simulate ./...
+
+// SimulateGatewayChange handles the default gateway changing to gateway as if the network was changed,
+// e.g. on roaming to another Wi-Fi: the route exception for XRay server is moved to it
+// and observe.EventGatewayChanged is emitted. Traffic to the server goes through gateway afterwards,
+// so it has to be reachable for the tunnel to keep working.
+func (c *Client) SimulateGatewayChange(gateway net.IP) error {
+	c.tunMu.Lock()
+	connected, routed := !c.connectedAt.IsZero(), !c.externalTUN && !c.proxyOnly
+	c.tunMu.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+	if !routed {
+		return errors.New("routes are not managed by the client")
+	}
+	if gateway.To4() == nil {
+		return errors.New("gateway must be an IPv4 address")
+	}
+
+	c.cfg.Logger.Warn("simulating gateway change", "gateway", gateway)
+
+	return c.followGateway(gateway)
+}
+
+// SimulateLinkDown makes the tunnel behave as if the network link went down till SimulateLinkUp:
+// connections open through the tunnel are closed and new ones fail like with the server unreachable,
+// which trips Config.BreakerThreshold if set. The TUN device and routes are kept. The link stays down
+// till SimulateLinkUp, also when connected again.
+func (c *Client) SimulateLinkDown() error {
+	return c.simulateLink(false)
+}
+
+// SimulateLinkUp ends SimulateLinkDown, new connections go through the tunnel again.
+func (c *Client) SimulateLinkUp() error {
+	return c.simulateLink(true)
+}
+
+func (c *Client) simulateLink(up bool) error {
+	c.tunMu.Lock()
+	connected, piped := !c.connectedAt.IsZero(), !c.proxyOnly && c.transparent == nil
+	c.tunMu.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+	p, ok := c.pipe.(interface{ setLinkDown(bool) })
+	if !ok || !piped {
+		return errors.New("link simulation needs the TUN engine")
+	}
+
+	c.cfg.Logger.Warn("simulating link change", "up", up)
+	p.setLinkDown(!up)
+
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build goxray_simulate
+
+package client
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/goxray/tun/pkg/client/mocks"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestClient_SimulateGatewayChange(t *testing.T) {
+	ip := mocks.NewMockipTable(gomock.NewController(t))
+	cl := newTestClient(nil, nil, ip, nil, nil)
+	require.ErrorIs(t, cl.SimulateGatewayChange(net.IPv4(10, 0, 0, 1)), ErrNotConnected)
+
+	cl.connectedAt = time.Now()
+	require.Error(t, cl.SimulateGatewayChange(net.ParseIP("2001:db8::1")))
+
+	serverRoutes := []*route.Addr{route.MustParseAddr("127.0.0.3/32")}
+	gomock.InOrder(
+		ip.EXPECT().Delete(route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: serverRoutes}).Return(nil),
+		ip.EXPECT().Add(route.Opts{Gateway: net.IPv4(10, 0, 0, 1), Routes: serverRoutes}).Return(nil),
+		ip.EXPECT().Add(route.Opts{Gateway: net.IPv4(10, 0, 0, 1), Routes: serverRoutes}).Return(nil),
+	)
+	require.NoError(t, cl.SimulateGatewayChange(net.IPv4(10, 0, 0, 1)))
+	require.Equal(t, "10.0.0.1", cl.router.Gateway().String())
+
+	cl.proxyOnly = true
+	require.Error(t, cl.SimulateGatewayChange(net.IPv4(10, 0, 0, 2)), "no routes to move")
+}
+
+func TestClient_SimulateLink(t *testing.T) {
+	p := newFlowPipe(pipeOpts{}, observe.NewFlowTable(), nopObserver, slog.New(slog.DiscardHandler))
+	cl := newTestClient(nil, nil, nil, p, nil)
+	require.ErrorIs(t, cl.SimulateLinkDown(), ErrNotConnected)
+
+	cl.connectedAt = time.Now()
+	require.NoError(t, cl.SimulateLinkDown())
+	require.True(t, p.upstream.down.Load())
+	require.NoError(t, cl.SimulateLinkUp())
+	require.False(t, p.upstream.down.Load())
+
+	cl.pipe = mocks.NewMockpipe(gomock.NewController(t))
+	require.Error(t, cl.SimulateLinkDown(), "only the TUN engine has a link")
+}
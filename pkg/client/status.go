@@ -0,0 +1,121 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// State of the Client connection.
+type State string
+
+const (
+	StateDisconnected State = "disconnected" // Client is not connected, traffic goes directly.
+	StateConnected    State = "connected"    // Traffic is routed through the tunnel.
+	StateBlocking     State = "blocking"     // Client is disconnected and traffic is blocked, see DownPolicyBlock.
+)
+
+// Status is a snapshot of the Client state suitable for machine-readable output.
+type Status struct {
+	State        State   `json:"state"`
+	Server       string  `json:"server,omitempty"`   // XRay server address as host:port.
+	Protocol     string  `json:"protocol,omitempty"` // XRay protocol, e.g. vless.
+	Uptime       float64 `json:"uptime_seconds"`
+	BytesRead    int     `json:"bytes_read"`
+	BytesWritten int     `json:"bytes_written"`
+	ReadRate     float64 `json:"read_rate"`  // Bytes per second read from TUN device.
+	WriteRate    float64 `json:"write_rate"` // Bytes per second written to TUN device.
+	Gateway      string  `json:"gateway"`
+	TUNName      string  `json:"tun_name,omitempty"`
+	TUNAddress   string  `json:"tun_address"`
+	// DNS lists resolvers set by the client, empty if system DNS is not changed.
+	DNS []string `json:"dns,omitempty"`
+	// XRay is the traffic of XRay inbounds and outbounds, see Client.XRayStats.
+	XRay []XRayTraffic `json:"xray,omitempty"`
+}
+
+// Status returns current state of the Client.
+func (c *Client) Status() Status {
+	s := Status{State: StateDisconnected}
+	if c.cfg.TUNAddress != nil {
+		s.TUNAddress = c.cfg.TUNAddress.IP.String()
+	}
+	if c.router != nil {
+		s.Gateway = c.router.Gateway().String()
+	}
+	if c.Blocking() {
+		s.State = StateBlocking
+	}
+
+	c.tunMu.Lock()
+	connectedAt, dnsSet, xCfg, tunName := c.connectedAt, c.dnsSet, c.xCfg, c.tunName
+	c.tunMu.Unlock()
+	if connectedAt.IsZero() {
+		return s
+	}
+
+	s.State = StateConnected
+	s.Uptime = time.Since(connectedAt).Seconds()
+	if xCfg != nil {
+		s.Server = net.JoinHostPort(xCfg.Address, xCfg.Port)
+		s.Protocol = xCfg.Protocol
+	}
+	s.TUNName = tunName
+	if dnsSet {
+		for _, ip := range c.cfg.DNSServers {
+			s.DNS = append(s.DNS, ip.String())
+		}
+	}
+	s.BytesRead, s.BytesWritten = c.BytesRead(), c.BytesWritten()
+	s.ReadRate, s.WriteRate = c.rates.update(s.BytesRead, s.BytesWritten)
+	s.XRay = c.XRayStats()
+
+	return s
+}
+
+// minRateWindow is the shortest period the transfer rates are averaged over.
+const minRateWindow = time.Second
+
+// Logs returns up to n of the latest log records of Config.Logger, oldest first, n <= 0 returns all that are kept.
+// Records are kept only if the handler of the logger is observe.LogRing, it returns nil otherwise.
+func (c *Client) Logs(n int) []observe.LogRecord {
+	ring, ok := c.cfg.Logger.Handler().(*observe.LogRing)
+	if !ok {
+		return nil
+	}
+
+	return ring.Recent(n)
+}
+
+// rateMeter calculates transfer rates between subsequent byte counter samples.
+type rateMeter struct {
+	mu        sync.Mutex
+	at        time.Time
+	read      int
+	written   int
+	readRate  float64
+	writeRate float64
+}
+
+// update takes new counter samples and returns the rates in bytes per second.
+// Samples taken sooner than minRateWindow after the previous one return the previous rates.
+func (r *rateMeter) update(read, written int) (float64, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(r.at)
+	switch {
+	case r.at.IsZero() || read < r.read || written < r.written: // First sample or counters were reset.
+	case elapsed < minRateWindow:
+		return r.readRate, r.writeRate
+	default:
+		r.readRate = float64(read-r.read) / elapsed.Seconds()
+		r.writeRate = float64(written-r.written) / elapsed.Seconds()
+	}
+	r.at, r.read, r.written = now, read, written
+
+	return r.readRate, r.writeRate
+}
@@ -0,0 +1,305 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// SwitchLink moves the established connection to the server of link. The TUN device, its routes and DNS
+// settings are kept and only XRay instance is replaced, so the traffic does not leave the tunnel while switching.
+// TCP connections open through the previous server are closed, UDP sessions are dialed again through the new one.
+func (c *Client) SwitchLink(link string) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	return c.switchLink(link)
+}
+
+// switchLink is SwitchLink with c.connMu held.
+func (c *Client) switchLink(link string) error {
+	c.tunMu.Lock()
+	connected := !c.connectedAt.IsZero()
+	c.tunMu.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+
+	server, err := c.replaceXray(link)
+	if err != nil {
+		return err
+	}
+
+	c.cfg.Logger.Info("switched xray server", "server", server)
+	c.emit(observe.EventServerSwitched, "server", server.String())
+
+	return nil
+}
+
+// Reconnect connects again to the current server by restarting XRay instance, e.g. when the connection is
+// stuck but the server is fine. The TUN device, its routes and DNS settings are kept like with SwitchLink.
+func (c *Client) Reconnect() error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	c.tunMu.Lock()
+	connected := !c.connectedAt.IsZero()
+	c.tunMu.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+
+	if err := c.restart(subsystemXray); err != nil {
+		return fmt.Errorf("restart xray: %w", err)
+	}
+
+	c.cfg.Logger.Info("reconnected to xray server")
+	c.emit(observe.EventRestarted, "subsystem", string(subsystemXray))
+
+	return nil
+}
+
+// Switch moves the established connection to the server of link like SwitchLink, but with no gap in between:
+// XRay instance for link is started on another inbound port next to the running one, then new flows are pointed
+// to it and the server route exception is moved, and only then the previous instance is stopped.
+// TCP connections open through the previous server are closed once it stops, UDP sessions move to the new one.
+// It falls back to SwitchLink when the inbound can not be moved: its port is set by Config.InboundProxy
+// and not exposed behind the gate, Config.HTTPInbound is set or the engine is EngineTProxy.
+func (c *Client) Switch(link string) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	c.tunMu.Lock()
+	connected := !c.connectedAt.IsZero()
+	c.tunMu.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+
+	redirector, ok := c.pipe.(interface{ redirect(socks5 string) error })
+	if !ok || !c.inboundPicked || c.cfg.HTTPInbound != nil || c.cfg.Engine == EngineTProxy ||
+		c.proxyOnly && !c.exposesInbound() {
+		c.cfg.Logger.Debug("xray inbound can not be moved, switching with the previous instance stopped first")
+
+		return c.switchLink(link)
+	}
+
+	server, err := c.handOverXray(link, redirector)
+	if err != nil {
+		return err
+	}
+
+	c.cfg.Logger.Info("switched xray server", "server", server)
+	c.emit(observe.EventServerSwitched, "server", server.String())
+
+	return nil
+}
+
+// handOverXray starts XRay instance for link on a new inbound port, points the pipe and the gate to it,
+// moves the server route exception and stops the previous instance, see Switch.
+func (c *Client) handOverXray(link string, redirector interface{ redirect(socks5 string) error }) (net.IP, error) {
+	current := c.xrayInbound()
+	var (
+		inst   xrayproto.Instance
+		cfg    *xrayproto.GeneralConfig
+		server net.IP
+		next   Proxy
+		err    error
+	)
+	for attempt := 1; ; attempt++ {
+		next = Proxy{IP: current.IP, Port: getFreePort()}
+		if inst, cfg, server, err = c.createXrayProxyOn(context.Background(), link, &next); err != nil {
+			c.cfg.Logger.Error("xray core creation failed", "err", redactErr(err, link), "link", redactLink(link))
+
+			return nil, fmt.Errorf("create xray core instance: %w", err)
+		}
+		if err = inst.Start(); err == nil {
+			break
+		}
+		if attempt < inboundBindAttempts && isAddrInUse(err) {
+			continue
+		}
+		c.cfg.Logger.Error("xray core instance startup failed", "err", err)
+
+		return nil, withKind(ErrXrayStart, fmt.Errorf("start xray core instance: %w", err))
+	}
+	if err = c.waitListening(context.Background(), next.String()); err != nil {
+		_ = inst.Close()
+
+		return nil, err
+	}
+
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
+
+	if err = redirector.redirect(next.String()); err != nil {
+		_ = inst.Close()
+
+		return nil, err
+	}
+	if !c.externalTUN && !c.proxyOnly {
+		if err = c.moveServerRoute(server); err != nil {
+			_ = redirector.redirect(current.String()) // The previous instance keeps serving.
+			_ = inst.Close()
+
+			return nil, err
+		}
+	}
+	c.cfgMu.Lock()
+	c.inbound = next
+	if !c.exposesInbound() {
+		c.cfg.InboundProxy = &Proxy{IP: next.IP, Port: next.Port}
+	}
+	c.cfgMu.Unlock()
+	if c.gate != nil {
+		c.gate.retarget(next.String())
+	}
+
+	prev := c.xInst
+	c.xInst, c.xCfg, c.link = inst, cfg, link
+	c.setXrayRoute(inst)
+	if p, ok := c.pipe.(interface{ resetBreaker() }); ok {
+		p.resetBreaker()
+	}
+	if err = prev.Close(); err != nil {
+		c.cfg.Logger.Debug("closing previous xray core instance failed", "err", err)
+	}
+
+	return server, nil
+}
+
+// ProbeOutbound probes link like ProbeLink, but it may be called while the Client is connected:
+// unless link is the server in use, its server is routed outside of the TUN device for the probe,
+// so that a standby server is checked on its own and not through the current one.
+func (c *Client) ProbeOutbound(ctx context.Context, link, probeURL string) (time.Duration, error) {
+	info, err := ValidateLink(link)
+	if err != nil {
+		return 0, err
+	}
+
+	c.tunMu.Lock()
+	bypass := !c.connectedAt.IsZero() && !c.externalTUN && !c.proxyOnly && c.transparent == nil && info.ServerIP.To4() != nil
+	c.tunMu.Unlock()
+	if current, ok := c.router.ServerRoute(); bypass && (!ok || !current.Routes[0].IP.Equal(info.ServerIP)) {
+		addrs := []*route.Addr{{IP: info.ServerIP.To4(), Mask: net.CIDRMask(32, 32)}}
+		if err = c.router.AddBypassRoutes(addrs); err != nil {
+			return 0, fmt.Errorf("add probe route exception: %w", err)
+		}
+		defer func() { _ = c.router.DeleteBypassRoutes(addrs) }()
+	}
+
+	return ProbeLink(ctx, link, probeURL)
+}
+
+// replaceXray replaces XRay instance with a new one for link and moves the server route exception to its server.
+func (c *Client) replaceXray(link string) (net.IP, error) {
+	inst, cfg, server, err := c.createXrayProxy(context.Background(), link)
+	if err != nil {
+		c.cfg.Logger.Error("xray core creation failed", "err", redactErr(err, link), "link", redactLink(link))
+
+		return nil, fmt.Errorf("create xray core instance: %w", err)
+	}
+
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
+
+	// Monitors restarting XRay may race Disconnect, the new instance must not outlive the client.
+	if c.connectedAt.IsZero() {
+		return nil, ErrNotConnected
+	}
+
+	old, hadRoute := c.router.ServerRoute()
+	var oldServer net.IP
+	switch {
+	case c.proxyOnly:
+		// No routes to move.
+	case c.transparent != nil:
+		oldServer = c.transparent.server
+		if err = c.redirectTransparent(server); err != nil {
+			return nil, err
+		}
+	case !c.externalTUN:
+		if err = c.moveServerRoute(server); err != nil {
+			return nil, err
+		}
+	}
+
+	// Both instances listen on the inbound proxy, so the previous one must stop first.
+	if err = c.xInst.Close(); err != nil {
+		c.cfg.Logger.Debug("closing previous xray core instance failed", "err", err)
+	}
+	if err = inst.Start(); err != nil {
+		err = withKind(ErrXrayStart, fmt.Errorf("start xray core instance: %w", err))
+	} else if err = c.waitInbound(context.Background()); err != nil {
+		_ = inst.Close()
+	}
+	if err != nil {
+		c.cfg.Logger.Error("xray core instance startup failed", "err", err)
+		if rollbackErr := c.restoreXray(old, hadRoute, oldServer); rollbackErr != nil {
+			c.cfg.Logger.Error("restoring previous xray core instance failed", "err", rollbackErr)
+		}
+
+		return nil, err
+	}
+	c.xInst, c.xCfg, c.link = inst, cfg, link
+	c.setXrayRoute(inst)
+	// Flows failed through the previous instance say nothing about the new one.
+	if p, ok := c.pipe.(interface{ resetBreaker() }); ok {
+		p.resetBreaker()
+	}
+
+	return server, nil
+}
+
+// moveServerRoute moves the server route exception from the previous server, if it had one, to server,
+// see router.MoveServerRoute. c.tunMu must be held.
+func (c *Client) moveServerRoute(server net.IP) error {
+	if err := c.router.MoveServerRoute(server); err != nil {
+		return fmt.Errorf("add xray server route exception: %w", err)
+	}
+
+	return nil
+}
+
+// restoreXray starts XRay instance for the current link again after the replacement failed to start,
+// and moves the server route exception, or the redirect exclusion of oldServer, back to its server.
+// c.tunMu must be held.
+func (c *Client) restoreXray(old route.Opts, hadRoute bool, oldServer net.IP) error {
+	switch {
+	case c.proxyOnly:
+		// No routes to move.
+	case c.transparent != nil:
+		if err := c.redirectTransparent(oldServer); err != nil {
+			return err
+		}
+	case c.externalTUN:
+		// Routes are managed by the owner of the device.
+	case hadRoute:
+		if err := c.moveServerRoute(old.Routes[0].IP); err != nil {
+			return err
+		}
+	default:
+		if err := c.router.DeleteServerRoute(); err != nil {
+			c.cfg.Logger.Debug("deleting xray server route failed", "err", err)
+		}
+	}
+
+	// Closed instance can not be started again.
+	inst, cfg, _, err := c.createXrayProxy(context.Background(), c.link)
+	if err != nil {
+		return fmt.Errorf("create xray core instance: %s", redactErr(err, c.link))
+	}
+	if err = inst.Start(); err != nil {
+		return fmt.Errorf("start xray core instance: %w", err)
+	}
+	c.xInst, c.xCfg = inst, cfg
+	c.setXrayRoute(inst)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/goxray/tun/pkg/client/mocks"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestSwitchLink(t *testing.T) {
+	t.Run("not connected", func(t *testing.T) {
+		cl := newTestClient(nil, nil, nil, nil, nil)
+		require.ErrorIs(t, cl.SwitchLink("vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.4:443?type=tcp"), ErrNotConnected)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		inst := mocks.NewMockrunnable(ctrl)
+		ip := mocks.NewMockipTable(ctrl)
+		cl := newTestClient(inst, nil, ip, nil, nil)
+		cl.cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+		cl.connectedAt = time.Now()
+
+		gomock.InOrder(
+			ip.EXPECT().Delete(route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.4/32")}}).Return(nil),
+			ip.EXPECT().Add(route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.4/32")}}).Return(nil),
+			ip.EXPECT().Delete(route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.3/32")}}).Return(nil),
+			inst.EXPECT().Close().Return(nil),
+		)
+
+		require.NoError(t, cl.SwitchLink("vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.4:443?type=tcp"))
+		defer cl.xInst.Close()
+		require.Equal(t, "127.0.0.4", cl.xCfg.Address)
+		r, ok := cl.ServerRoute()
+		require.True(t, ok)
+		require.Equal(t, "127.0.0.4/32", r.Routes[0].String())
+	})
+	t.Run("start fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		inst := mocks.NewMockrunnable(ctrl)
+		ip := mocks.NewMockipTable(ctrl)
+		cl := newTestClient(inst, nil, ip, nil, nil)
+		busy, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer busy.Close()
+		cl.cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: busy.Addr().(*net.TCPAddr).Port}
+		cl.connectedAt = time.Now()
+		oldRoute := route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.3/32")}}
+		newRoute := route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.4/32")}}
+
+		gomock.InOrder(
+			ip.EXPECT().Delete(newRoute).Return(nil),
+			ip.EXPECT().Add(newRoute).Return(nil),
+			ip.EXPECT().Delete(oldRoute).Return(nil),
+			inst.EXPECT().Close().Return(nil),
+			// The server route goes back to the previous server.
+			ip.EXPECT().Delete(oldRoute).Return(nil),
+			ip.EXPECT().Add(oldRoute).Return(nil),
+			ip.EXPECT().Delete(newRoute).Return(nil),
+		)
+
+		require.ErrorContains(t, cl.SwitchLink("vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.4:443?type=tcp"), "start xray core instance")
+		r, ok := cl.ServerRoute()
+		require.True(t, ok)
+		require.Equal(t, "127.0.0.3/32", r.Routes[0].String())
+	})
+}
+
+func TestSwitch(t *testing.T) {
+	link := "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.4:443?type=tcp"
+	t.Run("not connected", func(t *testing.T) {
+		cl := newTestClient(nil, nil, nil, nil, nil)
+		require.ErrorIs(t, cl.Switch(link), ErrNotConnected)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		inst := mocks.NewMockrunnable(ctrl)
+		ip := mocks.NewMockipTable(ctrl)
+		p := newFlowPipe(pipeOpts{}, observe.NewFlowTable(), nopObserver, slog.New(slog.DiscardHandler))
+		cl := newTestClient(inst, nil, ip, p, nil)
+		cl.inboundPicked = true
+		cl.connectedAt = time.Now()
+		prev := cl.InboundProxy()
+		require.NoError(t, p.redirect(prev.String()))
+
+		// The previous instance is stopped only once the route exception is moved to the new server.
+		gomock.InOrder(
+			ip.EXPECT().Delete(route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.4/32")}}).Return(nil),
+			ip.EXPECT().Add(route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.4/32")}}).Return(nil),
+			ip.EXPECT().Delete(route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.3/32")}}).Return(nil),
+			inst.EXPECT().Close().Return(nil),
+		)
+
+		require.NoError(t, cl.Switch(link))
+		defer cl.xInst.Close()
+		require.Equal(t, "127.0.0.4", cl.xCfg.Address)
+		next := cl.InboundProxy()
+		require.NotEqual(t, prev.Port, next.Port)
+		require.Equal(t, next.String(), p.upstream.current.Load().Addr())
+		// The new instance listens on the inbound flows are sent to.
+		conn, err := net.Dial("tcp", next.String())
+		require.NoError(t, err)
+		conn.Close()
+	})
+
+	t.Run("inbound set by user", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		inst := mocks.NewMockrunnable(ctrl)
+		ip := mocks.NewMockipTable(ctrl)
+		cl := newTestClient(inst, nil, ip, nil, nil)
+		cl.cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+		cl.connectedAt = time.Now()
+		prev := cl.InboundProxy()
+
+		// Like SwitchLink, the previous instance is stopped before the new one starts on the same port.
+		gomock.InOrder(
+			ip.EXPECT().Delete(gomock.Any()).Return(nil),
+			ip.EXPECT().Add(gomock.Any()).Return(nil),
+			ip.EXPECT().Delete(gomock.Any()).Return(nil),
+			inst.EXPECT().Close().Return(nil),
+		)
+
+		require.NoError(t, cl.Switch(link))
+		defer cl.xInst.Close()
+		require.Equal(t, prev, cl.InboundProxy())
+	})
+}
+
+func TestSwitch_Disconnected(t *testing.T) {
+	// Switching waits for Disconnect in progress and does not start XRay after it.
+	for name, switchTo := range map[string]func(*Client, string) error{
+		"SwitchLink": (*Client).SwitchLink,
+		"Switch":     (*Client).Switch,
+		"Reconnect":  func(cl *Client, _ string) error { return cl.Reconnect() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cl := newTestClient(nil, nil, mocks.NewMockipTable(gomock.NewController(t)), nil, nil)
+			cl.connectedAt = time.Now()
+			cl.connMu.Lock()
+			done := make(chan error, 1)
+			go func() { done <- switchTo(cl, "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.4:443?type=tcp") }()
+			select {
+			case err := <-done:
+				t.Fatalf("switched while disconnecting: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			cl.connectedAt = time.Time{}
+			cl.connMu.Unlock()
+			require.ErrorIs(t, <-done, ErrNotConnected)
+		})
+	}
+}
+
+func TestProbeOutbound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ip := mocks.NewMockipTable(ctrl)
+	cl := newTestClient(nil, nil, ip, nil, nil)
+	cl.connectedAt = time.Now()
+	probeRoute := route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.4/32")}}
+
+	// The standby server is routed outside of the tunnel for the probe only.
+	gomock.InOrder(
+		ip.EXPECT().Delete(probeRoute).Return(nil),
+		ip.EXPECT().Add(probeRoute).Return(nil),
+		ip.EXPECT().Delete(probeRoute).Return(nil),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cl.ProbeOutbound(ctx, "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.4:443?type=tcp", "")
+	require.Error(t, err)
+
+	// The server in use already has its route exception.
+	_, err = cl.ProbeOutbound(ctx, "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.3:443?type=tcp", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/goxray/core/network/route"
+	"golang.org/x/net/proxy"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// Engine is the way the traffic is captured, see Config.Engine.
+type Engine string
+
+const (
+	// EngineTUN routes the traffic to the TUN device, TCP and UDP are captured.
+	EngineTUN Engine = "tun"
+	// EngineTProxy redirects TCP connections to a local transparent proxy with nftables on Linux,
+	// no TUN device is created. UDP traffic, DNS queries included, is not captured.
+	EngineTProxy Engine = "tproxy"
+)
+
+var errTransparentUnsupported = errors.New("transparent proxy engine is only supported on Linux")
+
+// validateEngine checks that Config.Engine is supported together with the other settings.
+func validateEngine(cfg Config) error {
+	switch cfg.Engine {
+	case "", EngineTUN:
+		return nil
+	case EngineTProxy:
+	default:
+		return fmt.Errorf("unknown engine %q", cfg.Engine)
+	}
+
+	if !transparentSupported {
+		return errTransparentUnsupported
+	}
+	if len(cfg.DNSServers) > 0 || cfg.PolicyRouting || cfg.ShareLAN || cfg.TUNFileDescriptor > 0 {
+		return errors.New("engine tproxy captures TCP connections of the host only, " +
+			"it can not be used with DNSServers, PolicyRouting, ShareLAN and TUNFileDescriptor")
+	}
+
+	return nil
+}
+
+// redirector steers TCP connections to the transparent proxy.
+type redirector interface {
+	// Redirect sends TCP connections to routes, except for exclude, to the local port.
+	// Calling it again replaces the previous redirect.
+	Redirect(port int, routes, exclude []*route.Addr) error
+	// Restore removes the redirect.
+	Restore() error
+}
+
+// transparentProxy passes TCP connections redirected to it to their original destinations through XRay inbound.
+type transparentProxy struct {
+	ln       net.Listener
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	origDst  func(conn net.Conn) (netip.AddrPort, error)
+	flows    *observe.FlowTable
+	maxConns int
+	logger   *slog.Logger
+
+	server net.IP // XRay server excluded from the redirect.
+}
+
+// serve handles redirected connections till ctx is done, then it closes them.
+func (p *transparentProxy) serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { _ = p.ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("accept redirected connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handle(ctx, conn)
+		}()
+	}
+}
+
+func (p *transparentProxy) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	dst, err := p.origDst(conn)
+	if err != nil {
+		p.logger.Debug("original destination of redirected connection not found", "err", err, "src", conn.RemoteAddr())
+
+		return
+	}
+	var src netip.AddrPort
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		src = addr.AddrPort()
+	}
+	f, ok := p.flows.Reserve(observe.TCP, src, dst, p.maxConns)
+	if !ok {
+		p.logger.Debug("TCP connection limit reached, connection rejected", "dst", dst)
+
+		return
+	}
+	upstream, err := p.dial(ctx, "tcp", dst.String())
+	if err != nil {
+		p.flows.Remove(f)
+		p.logger.Debug("dialing redirected connection failed", "err", err, "dst", dst)
+
+		return
+	}
+	upstream = p.flows.TrackConn(upstream, f)
+	defer upstream.Close()
+	stopUpstream := context.AfterFunc(ctx, func() { _ = upstream.Close() })
+	defer stopUpstream()
+
+	relay(conn, upstream)
+}
+
+// setupTransparent starts the transparent proxy and redirects the traffic of RoutesToTUN to it, see EngineTProxy.
+func (c *Client) setupTransparent(server net.IP) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen transparent proxy: %w", err)
+	}
+	dialer, err := proxy.SOCKS5("tcp", c.xrayInbound().String(), nil, proxy.Direct)
+	if err != nil {
+		_ = ln.Close()
+
+		return fmt.Errorf("create socks dialer: %w", err)
+	}
+	c.transparent = &transparentProxy{
+		ln:       ln,
+		dial:     dialer.(proxy.ContextDialer).DialContext,
+		origDst:  originalDst,
+		flows:    c.flows,
+		maxConns: c.cfg.MaxTCPConnections,
+		logger:   c.cfg.Logger,
+	}
+	if err = c.redirectTransparent(server); err != nil {
+		_ = ln.Close()
+		c.transparent = nil
+
+		return err
+	}
+
+	return nil
+}
+
+// redirectTransparent redirects RoutesToTUN to the transparent proxy, except for XRay server and ExcludeRoutes.
+func (c *Client) redirectTransparent(server net.IP) error {
+	exclude := append([]*route.Addr{{IP: server.To4(), Mask: net.CIDRMask(32, 32)}}, c.cfg.ExcludeRoutes...)
+	port := c.transparent.ln.Addr().(*net.TCPAddr).Port
+	if err := c.redirector.Redirect(port, c.Routes(), exclude); err != nil {
+		return withKind(ErrRouteInstall, fmt.Errorf("redirect TCP connections: %w", err))
+	}
+	c.transparent.server = server
+
+	return nil
+}
+
+// startTransparent serves the transparent proxy, Disconnect waits for it like for the TUN pipe.
+func (c *Client) startTransparent() {
+	var ctx context.Context
+	ctx, c.stopTunnel = context.WithCancel(context.Background())
+	go func() {
+		err := c.transparent.serve(ctx)
+		c.tunnelStopped <- err
+		c.cfg.Logger.Debug("transparent proxy closed", "err", err)
+	}()
+}
+
+// closeTransparent removes the redirect, the proxy stops with the context of startTransparent.
+// The caller must hold c.tunMu.
+func (c *Client) closeTransparent() error {
+	c.transparent = nil
+
+	return c.redirector.Restore()
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+
+	"github.com/goxray/core/network/route"
+)
+
+const (
+	transparentSupported = true
+
+	// nftTable is the nftables table of the redirect, see EngineTProxy.
+	nftTable = "goxray_tun"
+	// soOriginalDst is the socket option of the destination of a connection before it was redirected.
+	soOriginalDst = 80
+)
+
+func newRedirector() redirector {
+	return &nftRedirector{run: runCommand}
+}
+
+// nftRedirector redirects TCP connections of the host with a table of nftables.
+type nftRedirector struct {
+	run   func(input, name string, args ...string) (string, error)
+	added bool
+}
+
+func (r *nftRedirector) Redirect(port int, routes, exclude []*route.Addr) error {
+	if _, err := r.run(nftRedirectScript(port, routes, exclude), "nft", "-f", "-"); err != nil {
+		return err
+	}
+	r.added = true
+
+	return nil
+}
+
+func (r *nftRedirector) Restore() error {
+	if !r.added {
+		return nil
+	}
+	if _, err := r.run("", "nft", "delete", "table", "ip", nftTable); err != nil {
+		return fmt.Errorf("delete nftables redirect: %w", err)
+	}
+	r.added = false
+
+	return nil
+}
+
+// nftRedirectScript returns nft script replacing the table of the redirect in one transaction.
+func nftRedirectScript(port int, routes, exclude []*route.Addr) string {
+	var b strings.Builder
+	// The table is created first, so that deleting it does not fail.
+	fmt.Fprintf(&b, "table ip %[1]s\ndelete table ip %[1]s\ntable ip %[1]s {\n", nftTable)
+	b.WriteString("\tchain output {\n\t\ttype nat hook output priority -100; policy accept;\n")
+	b.WriteString("\t\tip daddr 127.0.0.0/8 return\n")
+	for _, r := range exclude {
+		fmt.Fprintf(&b, "\t\tip daddr %s return\n", r)
+	}
+	for _, r := range routes {
+		fmt.Fprintf(&b, "\t\tip daddr %s meta l4proto tcp redirect to :%d\n", r, port)
+	}
+	b.WriteString("\t}\n}\n")
+
+	return b.String()
+}
+
+// originalDst returns the destination of conn before it was redirected.
+func originalDst(conn net.Conn) (netip.AddrPort, error) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return netip.AddrPort{}, errors.New("not a TCP connection")
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	// SO_ORIGINAL_DST returns sockaddr_in, IPv6Mreq has the same size and is used to read it.
+	var addr *syscall.IPv6Mreq
+	var sockErr error
+	if err = raw.Control(func(fd uintptr) {
+		addr, sockErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+	}); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if sockErr != nil {
+		return netip.AddrPort{}, fmt.Errorf("get original destination: %w", sockErr)
+	}
+
+	b := addr.Multiaddr
+
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[4:8])), binary.BigEndian.Uint16(b[2:4])), nil
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNftRedirector(t *testing.T) {
+	var calls, scripts []string
+	r := &nftRedirector{run: func(input, name string, args ...string) (string, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		scripts = append(scripts, input)
+		return "", nil
+	}}
+
+	require.NoError(t, r.Restore()) // Nothing redirected yet.
+	routes := []*route.Addr{route.MustParseAddr("0.0.0.0/1"), route.MustParseAddr("128.0.0.0/1")}
+	require.NoError(t, r.Redirect(40000, routes, []*route.Addr{route.MustParseAddr("1.2.3.4/32")}))
+	require.NoError(t, r.Restore())
+	require.NoError(t, r.Restore())
+
+	require.Equal(t, []string{"nft -f -", "nft delete table ip goxray_tun"}, calls)
+	require.Equal(t, `table ip goxray_tun
+delete table ip goxray_tun
+table ip goxray_tun {
+	chain output {
+		type nat hook output priority -100; policy accept;
+		ip daddr 127.0.0.0/8 return
+		ip daddr 1.2.3.4/32 return
+		ip daddr 0.0.0.0/1 meta l4proto tcp redirect to :40000
+		ip daddr 128.0.0.0/1 meta l4proto tcp redirect to :40000
+	}
+}
+`, scripts[0])
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+package client
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/goxray/core/network/route"
+)
+
+const transparentSupported = false
+
+func newRedirector() redirector {
+	return unsupportedRedirector{}
+}
+
+// unsupportedRedirector is the redirector of platforms without the transparent proxy engine.
+type unsupportedRedirector struct{}
+
+func (unsupportedRedirector) Redirect(int, []*route.Addr, []*route.Addr) error {
+	return errTransparentUnsupported
+}
+
+func (unsupportedRedirector) Restore() error {
+	return nil
+}
+
+func originalDst(net.Conn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, errTransparentUnsupported
+}
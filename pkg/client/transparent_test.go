@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestValidateEngine(t *testing.T) {
+	require.NoError(t, validateEngine(Config{}))
+	require.NoError(t, validateEngine(Config{Engine: EngineTUN, ShareLAN: true}))
+	require.ErrorContains(t, validateEngine(Config{Engine: "ebpf"}), `unknown engine "ebpf"`)
+	if !transparentSupported {
+		require.ErrorIs(t, validateEngine(Config{Engine: EngineTProxy}), errTransparentUnsupported)
+
+		return
+	}
+	require.NoError(t, validateEngine(Config{Engine: EngineTProxy}))
+	require.Error(t, validateEngine(Config{Engine: EngineTProxy, DNSServers: []net.IP{net.IPv4(1, 1, 1, 1)}}))
+	require.Error(t, validateEngine(Config{Engine: EngineTProxy, TUNFileDescriptor: 3}))
+}
+
+func TestTransparentProxy(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dst := netip.MustParseAddrPort("1.2.3.4:443")
+	var dialed string
+	var dialer net.Dialer
+	p := &transparentProxy{
+		ln: ln,
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = addr // The upstream is XRay inbound, here the echo server stands for it.
+			return dialer.DialContext(ctx, network, echo.Addr().String())
+		},
+		origDst: func(net.Conn) (netip.AddrPort, error) { return dst, nil },
+		flows:   observe.NewFlowTable(),
+		logger:  slog.New(slog.DiscardHandler),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- p.serve(ctx) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	require.Equal(t, dst.String(), dialed)
+	require.Equal(t, 1, p.flows.Count(observe.TCP))
+
+	// Stopping closes the open connections too.
+	cancel()
+	require.NoError(t, <-served)
+	_, err = conn.Read(buf)
+	require.Error(t, err)
+	require.Zero(t, p.flows.Count(observe.TCP))
+}
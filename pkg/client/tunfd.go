@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// ConnectWithTUN connects like Connect, but pipes traffic of the TUN device created by the caller
+// and passed as file descriptor fd, e.g. by Android VpnService or iOS NetworkExtension.
+//
+// Routing is left to the owner of the device: the Client adds no routes, including the exception
+// for XRay server, so the caller must keep XRay connections off the device (e.g. VpnService.protect).
+// The Client takes ownership of fd and closes it on Disconnect.
+func (c *Client) ConnectWithTUN(fd int, link string) error {
+	if fd < 0 {
+		return fmt.Errorf("invalid TUN file descriptor %d", fd)
+	}
+
+	return c.connect(context.Background(), link, newFDTunnel(fd))
+}
+
+// ConnectDevice connects like ConnectWithTUN, but pipes IP packets of dev instead of a TUN device, one packet
+// per Read and Write, e.g. the in-memory device of package tuntest in tests without root privileges.
+// The Client takes ownership of dev and closes it on Disconnect.
+func (c *Client) ConnectDevice(dev io.ReadWriteCloser, link string) error {
+	if dev == nil {
+		return errors.New("nil device")
+	}
+
+	return c.connect(context.Background(), link, dev)
+}
+
+// newFDTunnel wraps TUN device file descriptor fd.
+//
+// Packets of utun devices on Apple platforms are prefixed with 4 byte protocol family,
+// which is stripped on read and added on write.
+//
+// The descriptor is switched to non-blocking mode, so that closing it on Disconnect interrupts a pending read.
+// Close of a blocking descriptor waits for the read, which may never come on an idle device.
+func newFDTunnel(fd int) io.ReadWriteCloser {
+	_ = syscall.SetNonblock(fd, true) // Failure leaves it blocking, it still works till Disconnect.
+	f := os.NewFile(uintptr(fd), "tun")
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return &utunFile{File: f}
+	}
+
+	return f
+}
+
+// utunHeaderLen is the length of the protocol family header of utun packets.
+const utunHeaderLen = 4
+
+// utunFile strips and adds protocol family header of utun packets.
+type utunFile struct {
+	*os.File
+}
+
+func (u *utunFile) Read(p []byte) (int, error) {
+	buf := make([]byte, len(p)+utunHeaderLen)
+	n, err := u.File.Read(buf)
+	if n <= utunHeaderLen {
+		return 0, err
+	}
+
+	return copy(p, buf[utunHeaderLen:n]), err
+}
+
+func (u *utunFile) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	family := uint32(syscall.AF_INET)
+	if p[0]>>4 == 6 {
+		family = syscall.AF_INET6
+	}
+
+	buf := make([]byte, len(p)+utunHeaderLen)
+	binary.BigEndian.PutUint32(buf, family)
+	copy(buf[utunHeaderLen:], p)
+
+	n, err := u.File.Write(buf)
+
+	return max(n-utunHeaderLen, 0), err
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+
+	"github.com/goxray/tun/pkg/tuntest"
+)
+
+func TestUTUNFile(t *testing.T) {
+	// Datagram socket pair keeps packet boundaries like a TUN device.
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	require.NoError(t, err)
+	tun, peer := &utunFile{File: os.NewFile(uintptr(fds[0]), "tun")}, os.NewFile(uintptr(fds[1]), "peer")
+	defer tun.Close()
+	defer peer.Close()
+
+	n, err := tun.Write([]byte{0x60, 1, 2})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	buf := make([]byte, 64)
+	n, err = peer.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0, 0, syscall.AF_INET6, 0x60, 1, 2}, buf[:n])
+
+	_, err = peer.Write([]byte{0, 0, 0, syscall.AF_INET, 0x45, 9})
+	require.NoError(t, err)
+	n, err = tun.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x45, 9}, buf[:n])
+}
+
+func TestFDTunnel_CloseInterruptsRead(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	require.NoError(t, err)
+	defer syscall.Close(fds[1])
+	tun := newFDTunnel(fds[0])
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := tun.Read(make([]byte, 64))
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // Let the read block on the idle device.
+	require.NoError(t, tun.Close())
+	select {
+	case err := <-read:
+		require.ErrorIs(t, err, os.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read is still blocked after Close")
+	}
+}
+
+func TestConnectWithTUN_InvalidFD(t *testing.T) {
+	require.Error(t, (&Client{}).ConnectWithTUN(-1, "vless://example.com"))
+}
+
+func TestConnectDevice(t *testing.T) {
+	gw := net.IPv4(192, 168, 1, 1)
+	cl, err := NewClientWithOpts(Config{
+		GatewayIP:    &gw,
+		InboundProxy: &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: freePort(t)},
+		Logger:       slog.New(slog.DiscardHandler),
+	})
+	require.NoError(t, err)
+	require.Error(t, cl.ConnectDevice(nil, "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:9?type=tcp"))
+
+	dev := tuntest.NewDevice()
+	require.NoError(t, cl.ConnectDevice(dev, "vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:9?type=tcp"))
+	require.Equal(t, StateConnected, cl.Status().State)
+
+	// The TCP/IP stack of the client answers SYN of an application before dialing the server.
+	src, dst := tcpip.AddrFrom4([4]byte{10, 0, 0, 2}), tcpip.AddrFrom4([4]byte{198, 18, 0, 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, dev.Inject(ctx, tcpSYN(src, dst, 40000, 80)))
+	for {
+		packet, err := dev.Capture(ctx)
+		require.NoError(t, err)
+		ip := header.IPv4(packet)
+		if !ip.IsValid(len(packet)) || ip.TransportProtocol() != header.TCPProtocolNumber {
+			continue
+		}
+		tcp := header.TCP(ip.Payload())
+		if ip.DestinationAddress() == src && tcp.DestinationPort() == 40000 {
+			require.Equal(t, dst, ip.SourceAddress())
+			require.Equal(t, header.TCPFlagSyn|header.TCPFlagAck, tcp.Flags())
+
+			break
+		}
+	}
+
+	require.NoError(t, cl.Disconnect(context.Background()))
+	require.ErrorIs(t, dev.Inject(ctx, tcpSYN(src, dst, 40001, 80)), os.ErrClosed, "the device is closed on Disconnect")
+}
+
+// tcpSYN returns IPv4 packet opening TCP connection from src to dst.
+func tcpSYN(src, dst tcpip.Address, srcPort, dstPort uint16) []byte {
+	packet := make([]byte, header.IPv4MinimumSize+header.TCPMinimumSize)
+	ip := header.IPv4(packet)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(packet)),
+		TTL:         64,
+		Protocol:    uint8(header.TCPProtocolNumber),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	tcp := header.TCP(ip.Payload())
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    srcPort,
+		DstPort:    dstPort,
+		SeqNum:     1,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      header.TCPFlagSyn,
+		WindowSize: 65535,
+	})
+	sum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, header.TCPMinimumSize)
+	tcp.SetChecksum(^tcp.CalculateChecksum(sum))
+
+	return packet
+}
@@ -0,0 +1,24 @@
+package client
+
+import "errors"
+
+// undoStack collects the steps reverting what connect has set up so far, so that a failure or cancellation
+// midway leaves nothing behind. Steps are run in reverse order, like deferred calls.
+type undoStack []func() error
+
+// push adds step reverting the last change.
+func (u *undoStack) push(step func() error) {
+	*u = append(*u, step)
+}
+
+// rollback runs the steps from the last to the first and empties the stack. Every step is run,
+// even if some of them fail, and their errors are joined.
+func (u *undoStack) rollback() error {
+	var errs []error
+	for i := len(*u) - 1; i >= 0; i-- {
+		errs = append(errs, (*u)[i]())
+	}
+	*u = nil
+
+	return errors.Join(errs...)
+}
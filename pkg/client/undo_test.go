@@ -0,0 +1,22 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoStack(t *testing.T) {
+	var order []int
+	var undo undoStack
+	errRoute := errors.New("route gone")
+	undo.push(func() error { order = append(order, 1); return nil })
+	undo.push(func() error { order = append(order, 2); return errRoute })
+	undo.push(func() error { order = append(order, 3); return nil })
+
+	require.ErrorIs(t, undo.rollback(), errRoute)
+	require.Equal(t, []int{3, 2, 1}, order, "steps are undone in reverse order, all of them")
+	require.NoError(t, undo.rollback(), "the stack is emptied")
+	require.Len(t, order, 3)
+}
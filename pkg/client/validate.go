@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	xraynet "github.com/xtls/xray-core/common/net"
+	xcore "github.com/xtls/xray-core/core"
+)
+
+// defaultProbeURL is requested through the server by ProbeLink, it answers with an empty 204 response.
+const defaultProbeURL = "https://www.gstatic.com/generate_204"
+
+// LinkInfo describes the server of a valid connection link.
+type LinkInfo struct {
+	Protocol string `json:"protocol"`  // XRay protocol, e.g. vless.
+	Server   string `json:"server"`    // Server address as host:port.
+	ServerIP net.IP `json:"server_ip"` // Resolved server address.
+	Security string `json:"security"`  // Transport security, e.g. tls, reality or none.
+	Network  string `json:"network"`   // Transport, e.g. tcp, ws or grpc.
+	SNI      string `json:"sni,omitempty"`
+	Remark   string `json:"remark,omitempty"`
+}
+
+// ValidateLink parses the link and resolves its server address.
+// It does not touch routes or TUN devices, so it can be called without root privileges.
+func ValidateLink(link string) (LinkInfo, error) {
+	_, cfg, err := parseLink(xray.NewXrayService(false, false), link)
+	if err != nil {
+		return LinkInfo{}, err
+	}
+
+	ip, err := net.ResolveIPAddr("ip", cfg.Address)
+	if err != nil {
+		return LinkInfo{}, withKind(ErrServerUnresolvable, fmt.Errorf("xray address not resolvable: %w", err))
+	}
+
+	return LinkInfo{
+		Protocol: cfg.Protocol,
+		Server:   net.JoinHostPort(cfg.Address, cfg.Port),
+		ServerIP: ip.IP,
+		Security: cfg.TLS,
+		Network:  cfg.Type,
+		SNI:      cfg.SNI,
+		Remark:   cfg.Remark,
+	}, nil
+}
+
+// ProbeLink connects to the server of the link and requests probeURL through it (default: a 204 endpoint),
+// which tests TLS/REALITY handshake and credentials end to end. It returns the request round trip time.
+//
+// Like ValidateLink, it does not touch routes or TUN devices.
+func ProbeLink(ctx context.Context, link, probeURL string) (time.Duration, error) {
+	if probeURL == "" {
+		probeURL = defaultProbeURL
+	}
+
+	svc := xray.NewXrayService(false, false)
+	protocol, _, err := parseLink(svc, link)
+	if err != nil {
+		return 0, err
+	}
+
+	return probeProtocol(ctx, svc, protocol, probeURL)
+}
+
+// probeProtocol starts XRay instance of protocol and requests probeURL through it.
+func probeProtocol(ctx context.Context, svc *xray.Core, protocol xrayproto.Protocol, probeURL string) (time.Duration, error) {
+	inst, err := svc.MakeInstance(protocol)
+	if err != nil {
+		return 0, withKind(ErrXrayStart, fmt.Errorf("make instance: %w", err))
+	}
+	if err = inst.Start(); err != nil {
+		return 0, withKind(ErrXrayStart, fmt.Errorf("start xray core instance: %w", err))
+	}
+	defer inst.Close()
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dest, err := xraynet.ParseDestination(network + ":" + addr)
+			if err != nil {
+				return nil, err
+			}
+
+			return xcore.Dial(ctx, inst.(*xcore.Instance), dest)
+		},
+	}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("new probe request: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("probe request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return time.Since(start), nil
+}
+
+// quicSchemes are link schemes of QUIC based protocols, which the bundled XRay core has no outbounds for.
+var quicSchemes = []string{"hysteria2", "hy2", "hysteria", "tuic"}
+
+// parseLink creates XRay protocol from connection link and returns it along with its general config.
+// Panics of the parsers on malformed links are returned as ErrInvalidLink.
+func parseLink(svc *xray.Core, link string) (_ xrayproto.Protocol, _ xrayproto.GeneralConfig, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = withKind(ErrInvalidLink, fmt.Errorf("invalid config: malformed link: %v", r))
+		}
+	}()
+	link = strings.TrimSpace(link)
+	scheme, _, _ := strings.Cut(link, "://")
+	if slices.Contains(quicSchemes, strings.ToLower(scheme)) {
+		return nil, xrayproto.GeneralConfig{}, withKind(ErrInvalidLink,
+			fmt.Errorf("invalid config: %s links are not supported by the bundled XRay core", scheme))
+	}
+
+	var protocol xrayproto.Protocol
+	if strings.EqualFold(scheme, xrayproto.ShadowsocksIdentifier) {
+		protocol = &shadowsocksLink{link: link} // Parser of xray-knife rejects SIP002 links of 2022 ciphers.
+	} else {
+		if protocol, err = svc.CreateProtocol(link); err != nil {
+			return nil, xrayproto.GeneralConfig{}, withKind(ErrInvalidLink, fmt.Errorf("invalid config: protocol create: %w", err))
+		}
+	}
+
+	if err = protocol.Parse(); err != nil {
+		return nil, xrayproto.GeneralConfig{}, withKind(ErrInvalidLink, fmt.Errorf("invalid config: parse: %w", err))
+	}
+	cfg := protocol.ConvertToGeneralConfig()
+	if err = validateServer(cfg); err != nil {
+		return nil, xrayproto.GeneralConfig{}, withKind(ErrInvalidLink, fmt.Errorf("invalid config: %w", err))
+	}
+
+	return protocol, cfg, nil
+}
+
+// validateServer checks the server address parsed from a link, the parsers of xray-knife take whatever
+// the link has, e.g. port 99999 which XRay would silently truncate.
+func validateServer(cfg xrayproto.GeneralConfig) error {
+	if cfg.Address == "" {
+		return errors.New("no server address")
+	}
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid server port %q", cfg.Port)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLink(t *testing.T) {
+	info, err := ValidateLink(" vless://0c5b1e6a-1111-2222-3333-444455556666@127.0.0.1:443?security=reality&sni=example.com&type=tcp#name\n")
+	require.NoError(t, err)
+	require.Equal(t, "vless", info.Protocol)
+	require.Equal(t, "127.0.0.1:443", info.Server)
+	require.Equal(t, "127.0.0.1", info.ServerIP.String())
+	require.Equal(t, "reality", info.Security)
+	require.Equal(t, "tcp", info.Network)
+	require.Equal(t, "example.com", info.SNI)
+
+	_, err = ValidateLink("unknown://example.com")
+	require.ErrorContains(t, err, "invalid config")
+	_, err = ValidateLink("hysteria2://password@127.0.0.1:443?sni=example.com")
+	require.ErrorContains(t, err, "hysteria2 links are not supported")
+	_, err = ValidateLink("trojan://password@127.0.0.1:99999")
+	require.ErrorIs(t, err, ErrInvalidLink)
+	require.ErrorContains(t, err, `invalid server port "99999"`)
+	_, err = ValidateLink("ss:")
+	require.ErrorIs(t, err, ErrInvalidLink, "panic of the parser is returned as error")
+}
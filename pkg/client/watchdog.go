@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// watchTunnel reopens the TUN device when it stops delivering packets for Config.TUNStallTimeout
+// while packets are still being written to it, which happens to wedged devices after suspend.
+// It returns when ctx is done.
+func (c *Client) watchTunnel(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.TUNStallTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !c.tunnelStalled() {
+			continue
+		}
+
+		c.cfg.Logger.Warn("TUN device stopped delivering packets, reopening", "timeout", c.cfg.TUNStallTimeout)
+		if err := c.reopenTunnel(ctx); err != nil {
+			c.cfg.Logger.Error("reopening TUN device failed", "err", err)
+
+			continue
+		}
+		c.emit(observe.EventTUNReopened)
+	}
+}
+
+// watchStall emits observe.EventTunnelStalled when data keeps being sent through the tunnel for
+// Config.TunnelStallTimeout with nothing received, and reconnects to the server with Config.ReconnectOnStall.
+// A stall is reported once till something is received, with ReconnectOnStall once per reconnect.
+// It returns when ctx is done.
+func (c *Client) watchStall(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.TunnelStallTimeout / 2)
+	defer ticker.Stop()
+
+	since := time.Now() // Nothing received before the watch started counts as received then.
+	var reported bool
+	var reportedRead time.Time // LastReadAt when the stall was reported.
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lastRead := c.flows.LastReadAt()
+		if reported && lastRead.Equal(reportedRead) {
+			continue
+		}
+		reported = false
+		silent, stalled := c.serverStalled(since)
+		if !stalled {
+			continue
+		}
+		reported, reportedRead = true, lastRead
+
+		c.cfg.Logger.Warn("nothing received through the tunnel while sending, it is stalled", "for", silent)
+		c.emit(observe.EventTunnelStalled, "for", silent.String())
+		if !c.cfg.ReconnectOnStall {
+			continue
+		}
+		err := c.restart(subsystemXray)
+		reported, since = false, time.Now() // The stall is reported and reconnected again if it goes on.
+		if err != nil {
+			c.cfg.Logger.Error("reconnecting stalled tunnel failed", "err", err)
+
+			continue
+		}
+		c.cfg.Logger.Info("stalled tunnel reconnected")
+		c.emit(observe.EventRestarted, "subsystem", string(subsystemXray))
+	}
+}
+
+// serverStalled returns how long data was sent through the tunnel with nothing received after since,
+// and whether that is at least Config.TunnelStallTimeout.
+func (c *Client) serverStalled(since time.Time) (time.Duration, bool) {
+	if lastRead := c.flows.LastReadAt(); lastRead.After(since) {
+		since = lastRead
+	}
+	silent := c.flows.LastWriteAt().Sub(since)
+
+	return silent, silent >= c.cfg.TunnelStallTimeout
+}
+
+// tunnelStalled reports whether writes to the TUN device went on for TUNStallTimeout after the last read
+// while TCP connections kept receiving data. Hosts acknowledge TCP data, so it is read back from a working device,
+// unlike e.g. datagrams of receive-only UDP streams.
+func (c *Client) tunnelStalled() bool {
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
+
+	m, ok := c.tunnel.(*observe.IOMetrics)
+	if !ok {
+		return false
+	}
+	lastRead := m.LastReadAt()
+
+	return m.LastWriteAt().Sub(lastRead) >= c.cfg.TUNStallTimeout && c.flows.ActiveSince(observe.TCP, lastRead)
+}
+
+// reopenTunnel replaces the TUN device with a new one together with its routes and restarts the pipe on it.
+// The new device is created first, so that the traffic keeps going through the old one if that fails.
+// System DNS and LAN sharing set for the old device are moved to the new one, the xray instance
+// and the server route exception are kept intact.
+func (c *Client) reopenTunnel(ctx context.Context) error {
+	c.tunMu.Lock()
+	defer c.tunMu.Unlock()
+
+	if ctx.Err() != nil {
+		return ctx.Err() // Disconnect is in progress.
+	}
+
+	ifc, err := c.createTunnel()
+	if err != nil {
+		return fmt.Errorf("setup TUN device: %w", err)
+	}
+
+	c.stopTunnel()
+	if err = <-c.tunnelStopped; err != nil {
+		c.cfg.Logger.Debug("tunnel pipe stopped with error", "err", err)
+	}
+	// Routes of the old device go away with it, they can not be added to the new one before.
+	if err = c.tunnel.Close(); err != nil {
+		c.cfg.Logger.Debug("closing wedged TUN device failed", "err", err)
+	}
+	c.tunnel, c.tunName = c.traffic.Wrap(c.shapeTunnel(c.chaosTunnel(c.clampTunnel(c.captureTunnel(ifc))))), ifc.Name()
+	c.startPipe()
+	if err = c.router.MoveTUN(ifc.Name()); err != nil {
+		// The route watchdog keeps adding them to the new device.
+		c.cfg.Logger.Error("routing traffic to the new TUN device failed, retrying", "err", err)
+	}
+	c.moveDNS()
+	if c.cfg.ShareLAN {
+		c.moveLANShare()
+	}
+
+	return nil
+}
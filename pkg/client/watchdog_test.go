@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/goxray/tun/pkg/client/mocks"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestClient_TunnelStalled(t *testing.T) {
+	tunMock := mocks.NewMockioReadWriteCloser(gomock.NewController(t))
+	tunMock.EXPECT().Write(gomock.Any()).Return(1, nil).AnyTimes()
+	var traffic observe.Traffic
+	c := &Client{cfg: Config{TUNStallTimeout: 20 * time.Millisecond}, flows: observe.NewFlowTable(), tunnel: traffic.Wrap(tunMock)}
+
+	time.Sleep(30 * time.Millisecond)
+	_, _ = c.tunnel.Write([]byte{0})
+	require.False(t, c.tunnelStalled(), "nothing is expected to be read back from receive-only UDP streams")
+
+	dst := netip.MustParseAddrPort("1.1.1.1:443")
+	_, _ = c.flows.Reserve(observe.UDP, netip.AddrPort{}, dst, 0)
+	require.False(t, c.tunnelStalled())
+
+	_, _ = c.flows.Reserve(observe.TCP, netip.AddrPort{}, dst, 0)
+	require.True(t, c.tunnelStalled(), "TCP data is not acknowledged")
+}
+
+// trackedPipe returns a connection tracked by flows and its other end, which discards what it receives.
+func trackedPipe(t *testing.T, flows *observe.FlowTable) (net.Conn, net.Conn) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { _ = local.Close(); _ = remote.Close() })
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+	f, _ := flows.Reserve(observe.TCP, netip.AddrPort{}, netip.MustParseAddrPort("1.1.1.1:443"), 0)
+
+	return flows.TrackConn(local, f), remote
+}
+
+func TestClient_ServerStalled(t *testing.T) {
+	c := &Client{cfg: Config{TunnelStallTimeout: 20 * time.Millisecond}, flows: observe.NewFlowTable()}
+	conn, remote := trackedPipe(t, c.flows)
+	since := time.Now()
+
+	_, _ = conn.Write([]byte{0})
+	_, stalled := c.serverStalled(since)
+	require.False(t, stalled)
+
+	time.Sleep(30 * time.Millisecond)
+	_, stalled = c.serverStalled(since)
+	require.False(t, stalled, "nothing was sent since")
+	_, _ = conn.Write([]byte{0})
+	silent, stalled := c.serverStalled(since)
+	require.True(t, stalled)
+	require.GreaterOrEqual(t, silent, 30*time.Millisecond)
+
+	go func() { _, _ = remote.Write([]byte{0}) }()
+	_, _ = conn.Read(make([]byte, 1))
+	_, _ = conn.Write([]byte{0})
+	_, stalled = c.serverStalled(since)
+	require.False(t, stalled, "data was received")
+}
+
+func TestClient_WatchStall(t *testing.T) {
+	var mu sync.Mutex
+	var stalls int
+	c := &Client{cfg: Config{
+		TunnelStallTimeout: 20 * time.Millisecond,
+		Logger:             slog.New(slog.DiscardHandler),
+		Observer: observe.ObserverFunc(func(e observe.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Type == observe.EventTunnelStalled {
+				stalls++
+			}
+		}),
+	}, flows: observe.NewFlowTable()}
+	conn, _ := trackedPipe(t, c.flows)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.watchStall(ctx)
+		close(done)
+	}()
+	for range 20 {
+		_, _ = conn.Write([]byte{0})
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 1, stalls, "the stall is reported once")
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/jackpal/gateway"
+)
+
+// wslMode is the networking mode of WSL2 virtual machine the client runs in.
+type wslMode int
+
+const (
+	wslNone     wslMode = iota // Not running in WSL2.
+	wslNAT                     // Default NAT networking, Windows host is the gateway.
+	wslMirrored                // Mirrored networking, network interfaces of Windows are mirrored into the VM.
+)
+
+func (m wslMode) String() string {
+	switch m {
+	case wslNAT:
+		return "nat"
+	case wslMirrored:
+		return "mirrored"
+	default:
+		return "none"
+	}
+}
+
+// isWSL2 reports whether the kernel release string belongs to WSL2 kernel, e.g. "5.15.167.4-microsoft-standard-WSL2".
+func isWSL2(osRelease string) bool {
+	r := strings.ToLower(osRelease)
+
+	return strings.Contains(r, "microsoft") && strings.Contains(r, "wsl2")
+}
+
+// discoverGateway returns IP of the default gateway.
+func discoverGateway(wsl wslMode) (net.IP, error) {
+	ip, err := gateway.DiscoverGateway()
+	if err == nil || wsl != wslMirrored {
+		return ip, err
+	}
+
+	// Mirrored networking keeps default routes in policy routing tables, the main table may have none.
+	if ip, wslErr := wslGateway(); wslErr == nil {
+		return ip, nil
+	}
+
+	return nil, err
+}
+
+// parseDefaultGateway returns the gateway of the first default route in `ip route` output.
+func parseDefaultGateway(out string) (net.IP, error) {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != "default" || fields[1] != "via" {
+			continue
+		}
+		if ip := net.ParseIP(fields[2]); ip != nil {
+			return ip, nil
+		}
+	}
+
+	return nil, errors.New("no default route found")
+}
+
+// gatewayMTU returns MTU of the interface the gateway is reachable through, false if it is not found.
+func gatewayMTU(gw net.IP) (int, bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 0, false
+	}
+
+	for _, ifc := range ifaces {
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok && n.Contains(gw) {
+				return ifc.MTU, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// tunMTU returns MTU for the TUN device. In WSL2 the virtual network adapter may have lower MTU than usual,
+// e.g. when Windows is connected to another VPN, packets bigger than that are silently dropped.
+func tunMTU(wsl wslMode, gw net.IP) int {
+	if wsl == wslNone {
+		return defaultMTU
+	}
+	if mtu, ok := gatewayMTU(gw); ok && mtu > 0 {
+		return min(mtu, defaultMTU)
+	}
+
+	return defaultMTU
+}
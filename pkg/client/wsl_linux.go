@@ -0,0 +1,33 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+)
+
+// detectWSL returns the WSL2 networking mode, wslNone outside of WSL2.
+func detectWSL() wslMode {
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil || !isWSL2(string(release)) {
+		return wslNone
+	}
+
+	// Mirrored networking adds loopback0 interface connecting the VM to Windows loopback.
+	if _, err = net.InterfaceByName("loopback0"); err == nil {
+		return wslMirrored
+	}
+
+	return wslNAT
+}
+
+// wslGateway returns the gateway of the first default route in any routing table.
+func wslGateway() (net.IP, error) {
+	out, err := exec.Command("ip", "-4", "route", "show", "default", "table", "all").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ip route: %w", err)
+	}
+
+	return parseDefaultGateway(string(out))
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package client
+
+import (
+	"errors"
+	"net"
+)
+
+// detectWSL returns wslNone, WSL2 runs Linux only.
+func detectWSL() wslMode {
+	return wslNone
+}
+
+func wslGateway() (net.IP, error) {
+	return nil, errors.New("not running in WSL2")
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsWSL2(t *testing.T) {
+	require.True(t, isWSL2("5.15.167.4-microsoft-standard-WSL2\n"))
+	require.False(t, isWSL2("4.4.0-19041-Microsoft")) // WSL1 has no TUN devices.
+	require.False(t, isWSL2("6.8.0-49-generic"))
+}
+
+func TestParseDefaultGateway(t *testing.T) {
+	out := "10.0.0.0/8 dev eth1 proto kernel scope link\n" +
+		"default via 192.168.1.1 dev eth0 table 127 proto kernel metric 25\n" +
+		"default via 10.0.0.1 dev eth1 table 128 proto kernel metric 35\n"
+	ip, err := parseDefaultGateway(out)
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("192.168.1.1"), ip)
+
+	_, err = parseDefaultGateway("default dev eth0 scope link\n")
+	require.Error(t, err)
+}
+
+func TestTUNMTU(t *testing.T) {
+	require.Equal(t, defaultMTU, tunMTU(wslNone, net.IPv4(127, 0, 0, 1)))
+	// Loopback MTU is bigger than the default, it is capped.
+	require.Equal(t, defaultMTU, tunMTU(wslNAT, net.IPv4(127, 0, 0, 1)))
+}
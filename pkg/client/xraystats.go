@@ -0,0 +1,96 @@
+package client
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/xtls/xray-core/app/policy"
+	appstats "github.com/xtls/xray-core/app/stats"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/features"
+	"github.com/xtls/xray-core/features/stats"
+)
+
+// XRayTraffic is the traffic of an XRay inbound or outbound counted by XRay, see Client.XRayStats.
+type XRayTraffic struct {
+	// Tag of the inbound or the outbound, "proxy" for the exit server, see Config.XRayExtra.
+	Tag     string `json:"tag"`
+	Inbound bool   `json:"inbound,omitempty"`
+	// Uplink is the number of bytes sent to the server by an outbound or received from clients by an inbound.
+	Uplink int64 `json:"uplink"`
+	// Downlink is the number of bytes received from the server by an outbound or sent to clients by an inbound.
+	Downlink int64 `json:"downlink"`
+}
+
+// xrayStatsApps returns the apps enabling XRay counters of the traffic of every tagged inbound and outbound.
+func xrayStatsApps() []*serial.TypedMessage {
+	return []*serial.TypedMessage{
+		serial.ToTypedMessage(&appstats.Config{}),
+		serial.ToTypedMessage(&policy.Config{System: &policy.SystemPolicy{Stats: &policy.SystemPolicy_Stats{
+			InboundUplink:    true,
+			InboundDownlink:  true,
+			OutboundUplink:   true,
+			OutboundDownlink: true,
+		}}}),
+	}
+}
+
+// XRayStats returns the traffic of XRay inbounds and outbounds, inbounds first, sorted by tag.
+// Unlike BytesRead and BytesWritten, it includes the traffic of the socks proxy and the overhead of the protocol
+// and of Config.Chain relays. The counters start over with every XRay instance, i.e. on reconnects and
+// link switches. It returns nil when not connected.
+func (c *Client) XRayStats() []XRayTraffic {
+	c.tunMu.Lock()
+	xInst := c.xInst
+	c.tunMu.Unlock()
+	inst, ok := xInst.(interface{ GetFeature(any) features.Feature })
+	if !ok {
+		return nil
+	}
+	manager, ok := inst.GetFeature(stats.ManagerType()).(*appstats.Manager)
+	if !ok {
+		return nil
+	}
+
+	byTag := make(map[string]*XRayTraffic)
+	manager.VisitCounters(func(name string, counter stats.Counter) bool {
+		// Counters are named like "outbound>>>proxy>>>traffic>>>uplink".
+		parts := strings.Split(name, ">>>")
+		if len(parts) != 4 || parts[2] != "traffic" || parts[0] != "inbound" && parts[0] != "outbound" {
+			return true
+		}
+		key := parts[0] + ">>>" + parts[1]
+		t, ok := byTag[key]
+		if !ok {
+			t = &XRayTraffic{Tag: parts[1], Inbound: parts[0] == "inbound"}
+			byTag[key] = t
+		}
+		switch parts[3] {
+		case "uplink":
+			t.Uplink = counter.Value()
+		case "downlink":
+			t.Downlink = counter.Value()
+		}
+
+		return true
+	})
+
+	traffic := make([]XRayTraffic, 0, len(byTag))
+	for _, t := range byTag {
+		traffic = append(traffic, *t)
+	}
+	slices.SortFunc(traffic, func(a, b XRayTraffic) int {
+		if a.Inbound != b.Inbound {
+			if a.Inbound {
+				return -1
+			}
+
+			return 1
+		}
+
+		return cmp.Compare(a.Tag, b.Tag)
+	})
+
+	return traffic
+}
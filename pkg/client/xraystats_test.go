@@ -0,0 +1,33 @@
+package client
+
+import (
+	"testing"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/stats"
+)
+
+func TestXRayStats(t *testing.T) {
+	require.Nil(t, (&Client{}).XRayStats())
+
+	svc := xray.NewXrayService(false, false)
+	protocol, _, err := parseLink(svc, "vless://0c5b1e6a-1111-2222-3333-444455556666@1.2.3.4:443?type=tcp")
+	require.NoError(t, err)
+	cl := &Client{cfg: Config{TLSFragment: TLSFragment{Length: "100-200"}}}
+	inst, err := cl.makeXrayInstance(svc, []xrayproto.Protocol{protocol}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = inst.Close() })
+	cl.xInst = inst
+
+	manager := inst.(*core.Instance).GetFeature(stats.ManagerType()).(stats.Manager)
+	manager.GetCounter("outbound>>>proxy>>>traffic>>>uplink").Add(100)
+	manager.GetCounter("outbound>>>proxy>>>traffic>>>downlink").Add(2000)
+
+	require.Equal(t, []XRayTraffic{
+		{Tag: fragmentTag},
+		{Tag: exitTag, Uplink: 100, Downlink: 2000},
+	}, cl.XRayStats())
+}
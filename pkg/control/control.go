@@ -0,0 +1,386 @@
+/*
+Package control implements the local control socket of a running client.
+
+The socket serves a small HTTP API over a unix domain socket, so that other processes
+(status bars, scripts, the CLI itself) can query the client without extra privileges on the network.
+The socket is accessible to its owner only, unless it is shared with a group, see Options.
+*/
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// DefaultSocketPath is the control socket location used by the CLI.
+const DefaultSocketPath = "/var/run/goxray-tun.sock"
+
+// Source provides the client state served over the socket, it is implemented by client.Client.
+type Source interface {
+	Status() client.Status
+	Flows() []observe.FlowInfo
+	Stats() observe.Stats
+	TopDestinations(n int) []observe.Destination
+	DNSQueries(n int) []client.DNSQuery
+	Logs(n int) []observe.LogRecord
+	Capture(ctx context.Context, w io.Writer, filter string, limits client.CaptureLimits) (client.CaptureInfo, error)
+}
+
+// NewHandler returns http.Handler serving the control API:
+//
+//	GET /status - client.Status as JSON.
+//	GET /flows  - list of observe.FlowInfo as JSON.
+//	GET /stats  - observe.Stats as JSON.
+//	GET /destinations?n=10 - list of observe.Destination consuming the most traffic as JSON, all of them without n.
+//	GET /dns?n=50 - list of the most recent client.DNSQuery as JSON, all that are kept without n.
+//	GET /logs?n=20 - list of the latest observe.LogRecord as JSON, all that are kept without n.
+//	GET /capture?filter=tcp+port+443&duration=30s&max-size=1048576&snaplen=128 - pcap stream of packets
+//	    of the TUN device till the request is cancelled or a limit is reached, see client.Client.Capture.
+//	    It is refused on a socket accessible to others than its owner, e.g. shared with a group.
+func NewHandler(src Source) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, src.Status())
+	})
+	mux.HandleFunc("GET /flows", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, src.Flows())
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, src.Stats())
+	})
+	mux.HandleFunc("GET /destinations", func(w http.ResponseWriter, r *http.Request) {
+		if n, ok := queryCount(w, r); ok {
+			writeJSON(w, src.TopDestinations(n))
+		}
+	})
+	mux.HandleFunc("GET /dns", func(w http.ResponseWriter, r *http.Request) {
+		if n, ok := queryCount(w, r); ok {
+			writeJSON(w, src.DNSQueries(n))
+		}
+	})
+	mux.HandleFunc("GET /logs", func(w http.ResponseWriter, r *http.Request) {
+		if n, ok := queryCount(w, r); ok {
+			writeJSON(w, src.Logs(n))
+		}
+	})
+	mux.HandleFunc("GET /capture", func(w http.ResponseWriter, r *http.Request) {
+		if !ownerOnly(r) {
+			http.Error(w, "capture is not available on a socket shared with others", http.StatusForbidden)
+
+			return
+		}
+		limits, err := captureLimits(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		stream := &flushWriter{w: w}
+		w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+		if _, err = src.Capture(r.Context(), stream, r.URL.Query().Get("filter"), limits); err != nil && !stream.written {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+
+	return mux
+}
+
+// queryCount returns the count parameter n of r, 0 if it is missing. It replies with an error if n is invalid.
+func queryCount(w http.ResponseWriter, r *http.Request) (int, bool) {
+	q := r.URL.Query().Get("n")
+	if q == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(q)
+	if err != nil {
+		http.Error(w, "invalid n: "+err.Error(), http.StatusBadRequest)
+
+		return 0, false
+	}
+
+	return n, true
+}
+
+// captureLimits returns the limits of the capture requested by r.
+func captureLimits(r *http.Request) (client.CaptureLimits, error) {
+	var limits client.CaptureLimits
+	var err error
+	q := r.URL.Query()
+	if v := q.Get("duration"); v != "" {
+		if limits.Duration, err = time.ParseDuration(v); err != nil {
+			return limits, fmt.Errorf("invalid duration: %w", err)
+		}
+	}
+	if v := q.Get("max-size"); v != "" {
+		if limits.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return limits, fmt.Errorf("invalid max-size: %w", err)
+		}
+	}
+	if v := q.Get("snaplen"); v != "" {
+		if limits.SnapLen, err = strconv.Atoi(v); err != nil {
+			return limits, fmt.Errorf("invalid snaplen: %w", err)
+		}
+	}
+
+	return limits, nil
+}
+
+// ownerOnly reports whether the socket r came over is accessible to its owner only. Traffic of the tunnel
+// is not exposed to members of the group the socket is shared with, see Options.
+func ownerOnly(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(addr.Name)
+
+	return err == nil && info.Mode().Perm()&0o077 == 0
+}
+
+// flushWriter sends every write to the peer right away, so that a capture is streamed as it goes.
+type flushWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.written = true
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = http.NewResponseController(f.w).Flush()
+	}
+
+	return n, err
+}
+
+// Options control access to the socket created by ListenWithOptions.
+type Options struct {
+	// Mode is the permission of the socket (default: 0600, the owner only).
+	Mode os.FileMode
+	// GID is the group the socket is given to, e.g. a group of users allowed to query the client
+	// together with Mode 0660 (default: -1, group is not changed).
+	GID int
+}
+
+// Listen creates the control socket at path accessible to the owner only, see ListenWithOptions.
+func Listen(path string) (net.Listener, error) {
+	return ListenWithOptions(path, Options{GID: -1})
+}
+
+// ListenWithOptions creates the control socket at path, replacing a stale socket left by a previous run.
+// It fails if the socket is served by a running instance. The socket is created with opts.Mode right away,
+// it is never accessible to anyone else even for a moment.
+func ListenWithOptions(path string, opts Options) (net.Listener, error) {
+	if opts.Mode == 0 {
+		opts.Mode = 0o600
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("socket %s is in use by another instance", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove stale socket: %w", err)
+	}
+
+	var ln net.Listener
+	err := withUmask(0o777&^int(opts.Mode.Perm()), func() error {
+		var err error
+		ln, err = net.Listen("unix", path)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	if opts.GID >= 0 {
+		if err = os.Chown(path, -1, opts.GID); err != nil {
+			_ = ln.Close()
+
+			return nil, fmt.Errorf("chown socket: %w", err)
+		}
+	}
+
+	return ln, nil
+}
+
+// Serve serves handler on the control socket at path till ctx is cancelled.
+func Serve(ctx context.Context, path string, handler http.Handler) error {
+	ln, err := Listen(path)
+	if err != nil {
+		return err
+	}
+
+	return ServeListener(ctx, ln, handler)
+}
+
+// ServeListener serves handler on ln created with Listen till ctx is cancelled.
+func ServeListener(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
+	}
+
+	return nil
+}
+
+// GetStatus requests client status from the control socket at path.
+func GetStatus(ctx context.Context, path string) (client.Status, error) {
+	var status client.Status
+	if err := get(ctx, path, "/status", &status); err != nil {
+		return client.Status{}, err
+	}
+
+	return status, nil
+}
+
+// GetFlows requests flows going through the tunnel from the control socket at path.
+func GetFlows(ctx context.Context, path string) ([]observe.FlowInfo, error) {
+	var flows []observe.FlowInfo
+	if err := get(ctx, path, "/flows", &flows); err != nil {
+		return nil, err
+	}
+
+	return flows, nil
+}
+
+// GetStats requests tunnel metrics, including the memory footprint, from the control socket at path.
+func GetStats(ctx context.Context, path string) (observe.Stats, error) {
+	var stats observe.Stats
+	if err := get(ctx, path, "/stats", &stats); err != nil {
+		return observe.Stats{}, err
+	}
+
+	return stats, nil
+}
+
+// GetTopDestinations requests up to n destinations consuming the most traffic from the control socket at path,
+// n <= 0 requests all of them.
+func GetTopDestinations(ctx context.Context, path string, n int) ([]observe.Destination, error) {
+	var dests []observe.Destination
+	if err := get(ctx, path, "/destinations?n="+strconv.Itoa(n), &dests); err != nil {
+		return nil, err
+	}
+
+	return dests, nil
+}
+
+// GetDNSQueries requests up to n of the most recent DNS queries from the control socket at path,
+// n <= 0 requests all that are kept.
+func GetDNSQueries(ctx context.Context, path string, n int) ([]client.DNSQuery, error) {
+	var queries []client.DNSQuery
+	if err := get(ctx, path, "/dns?n="+strconv.Itoa(n), &queries); err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
+
+// GetLogs requests up to n of the latest log records of the client from the control socket at path,
+// n <= 0 requests all that are kept.
+func GetLogs(ctx context.Context, path string, n int) ([]observe.LogRecord, error) {
+	var records []observe.LogRecord
+	if err := get(ctx, path, "/logs?n="+strconv.Itoa(n), &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Capture streams packets of the TUN device of the client matching filter in pcap format to w,
+// till ctx is done or one of limits is reached, from the control socket at path. See client.Client.StartCapture.
+func Capture(ctx context.Context, path string, w io.Writer, filter string, limits client.CaptureLimits) error {
+	q := url.Values{"filter": {filter}}
+	if limits.Duration > 0 {
+		q.Set("duration", limits.Duration.String())
+	}
+	if limits.MaxSize > 0 {
+		q.Set("max-size", strconv.FormatInt(limits.MaxSize, 10))
+	}
+	if limits.SnapLen > 0 {
+		q.Set("snaplen", strconv.Itoa(limits.SnapLen))
+	}
+
+	resp, err := request(ctx, path, "/capture?"+q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("read capture: %w", err)
+	}
+
+	return nil
+}
+
+// get performs GET request to the control socket at path and decodes JSON response into v.
+func get(ctx context.Context, path, endpoint string, v any) error {
+	resp, err := request(ctx, path, endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s response: %w", endpoint, err)
+	}
+
+	return nil
+}
+
+// request performs GET request to the control socket at path, the response is OK unless an error is returned.
+func request(ctx context.Context, path, endpoint string) (*http.Response, error) {
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://control"+endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request %s: %w", endpoint, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		_ = resp.Body.Close()
+		if msg = bytes.TrimSpace(msg); len(msg) > 0 {
+			return nil, fmt.Errorf("request %s: unexpected status %s: %s", endpoint, resp.Status, msg)
+		}
+
+		return nil, fmt.Errorf("request %s: unexpected status %s", endpoint, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,202 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+type staticSource struct {
+	status client.Status
+	flows  []observe.FlowInfo
+	stats  observe.Stats
+	dests  []observe.Destination
+	dns    []client.DNSQuery
+	logs   []observe.LogRecord
+}
+
+func (s staticSource) Status() client.Status {
+	return s.status
+}
+
+func (s staticSource) Flows() []observe.FlowInfo {
+	return s.flows
+}
+
+func (s staticSource) Stats() observe.Stats {
+	return s.stats
+}
+
+func (s staticSource) TopDestinations(n int) []observe.Destination {
+	if n > 0 && len(s.dests) > n {
+		return s.dests[:n]
+	}
+
+	return s.dests
+}
+
+func (s staticSource) DNSQueries(n int) []client.DNSQuery {
+	if n > 0 && len(s.dns) > n {
+		return s.dns[len(s.dns)-n:]
+	}
+
+	return s.dns
+}
+
+func (s staticSource) Logs(n int) []observe.LogRecord {
+	if n > 0 && len(s.logs) > n {
+		return s.logs[len(s.logs)-n:]
+	}
+
+	return s.logs
+}
+
+// Capture writes the filter and limits it got, it fails on "invalid" filter.
+func (s staticSource) Capture(_ context.Context, w io.Writer, filter string, limits client.CaptureLimits) (client.CaptureInfo, error) {
+	if filter == "invalid" {
+		return client.CaptureInfo{}, errors.New("capture filter: invalid")
+	}
+	_, err := fmt.Fprintf(w, "%s %s %d %d", filter, limits.Duration, limits.MaxSize, limits.SnapLen)
+
+	return client.CaptureInfo{Filter: filter}, err
+}
+
+func TestControl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	src := staticSource{
+		status: client.Status{State: client.StateConnected, Server: "example.com:443", BytesRead: 42, DNS: []string{"1.1.1.1"}},
+		flows: []observe.FlowInfo{{
+			ID:      1,
+			Network: observe.UDP,
+			Src:     netip.MustParseAddrPort("192.18.0.1:5353"),
+			Dst:     netip.MustParseAddrPort("1.1.1.1:53"),
+			Started: time.Unix(1, 0).UTC(),
+			Service: "dns",
+			Process: &observe.Process{PID: 7, Name: "resolver"},
+		}},
+		stats: observe.Stats{ActiveTCP: 2, Footprint: observe.Footprint{Sys: 8 << 20, PerTCPFlow: 96 << 10}},
+		dests: []observe.Destination{
+			{Host: "github.com", Addrs: []netip.Addr{netip.MustParseAddr("140.82.121.4")}, Sent: 10, Received: 900, Flows: 3},
+			{Addrs: []netip.Addr{netip.MustParseAddr("1.1.1.1")}, Sent: 40, Received: 80, Flows: 1},
+		},
+		dns: []client.DNSQuery{
+			{Time: time.Unix(2, 0).UTC(), Name: "example.com.", Type: "A", Network: "udp", Upstream: "9.9.9.9:53", Error: "i/o timeout"},
+			{Time: time.Unix(3, 0).UTC(), Name: "example.com.", Type: "A", Network: "udp", Upstream: "1.1.1.1:53", RCode: "Success", Answers: []string{"93.184.215.14"}},
+		},
+		logs: []observe.LogRecord{
+			{Time: time.Unix(4, 0).UTC(), Level: "INFO", Message: "connected"},
+			{Time: time.Unix(5, 0).UTC(), Level: "WARN", Message: "failing subsystem restarted", Attrs: "subsystem=dns"},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, path, NewHandler(src)) }()
+
+	require.Eventually(t, func() bool {
+		_, err := GetStatus(ctx, path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	status, err := GetStatus(ctx, path)
+	require.NoError(t, err)
+	require.Equal(t, src.status, status)
+
+	flows, err := GetFlows(ctx, path)
+	require.NoError(t, err)
+	require.Equal(t, src.flows, flows)
+
+	stats, err := GetStats(ctx, path)
+	require.NoError(t, err)
+	require.Equal(t, src.stats, stats)
+
+	dests, err := GetTopDestinations(ctx, path, 1)
+	require.NoError(t, err)
+	require.Equal(t, src.dests[:1], dests)
+	dests, err = GetTopDestinations(ctx, path, 0)
+	require.NoError(t, err)
+	require.Equal(t, src.dests, dests)
+
+	queries, err := GetDNSQueries(ctx, path, 1)
+	require.NoError(t, err)
+	require.Equal(t, src.dns[1:], queries)
+
+	logs, err := GetLogs(ctx, path, 0)
+	require.NoError(t, err)
+	require.Equal(t, src.logs, logs)
+
+	var capture bytes.Buffer
+	require.NoError(t, Capture(ctx, path, &capture, "tcp port 443", client.CaptureLimits{Duration: time.Minute, SnapLen: 128}))
+	require.Equal(t, "tcp port 443 1m0s 0 128", capture.String())
+	require.ErrorContains(t, Capture(ctx, path, io.Discard, "invalid", client.CaptureLimits{}), "capture filter: invalid")
+
+	cancel()
+	require.NoError(t, <-served)
+}
+
+func TestListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	running, err := ListenWithOptions(path, Options{Mode: 0o660, GID: -1})
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o660), info.Mode().Perm())
+
+	// A socket served by a running instance is kept.
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		for {
+			conn, err := running.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, err = Listen(path)
+	require.ErrorContains(t, err, "in use")
+
+	// A stale socket left by a killed instance is replaced.
+	running.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, running.Close())
+	<-served
+	ln, err := Listen(path)
+	require.NoError(t, err)
+	defer ln.Close()
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestCapture_SharedSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := ListenWithOptions(path, Options{Mode: 0o660, GID: -1})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = ServeListener(ctx, ln, NewHandler(staticSource{})) }()
+
+	// Members of the group may query the status, but not read the traffic.
+	_, err = GetStatus(ctx, path)
+	require.NoError(t, err)
+	err = Capture(ctx, path, io.Discard, "", client.CaptureLimits{})
+	require.ErrorContains(t, err, "403 Forbidden: capture is not available on a socket shared with others")
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package control
+
+// withUmask runs fn, there is no file mode creation mask on this platform.
+func withUmask(_ int, fn func() error) error {
+	return fn()
+}
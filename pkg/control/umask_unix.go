@@ -0,0 +1,22 @@
+//go:build unix
+
+package control
+
+import (
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes umask changes, umask is shared by the whole process.
+var umaskMu sync.Mutex
+
+// withUmask runs fn with the file mode creation mask set to mask.
+func withUmask(mask int, fn func() error) error {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+
+	old := syscall.Umask(mask)
+	defer syscall.Umask(old)
+
+	return fn()
+}
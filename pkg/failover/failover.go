@@ -0,0 +1,181 @@
+/*
+Package failover keeps the client on the most preferred healthy outbound (connection link) of an ordered list.
+Every outbound is probed periodically, the client is switched away from the active one once it becomes
+unhealthy and back to a preferred one after it recovers.
+*/
+package failover
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// Outbound is a connection link to fail over to.
+type Outbound struct {
+	Name string // Shown in logs and events instead of the link, which contains credentials.
+	Link string
+}
+
+// Policy controls which outbound is active.
+type Policy struct {
+	// Order lists the outbounds, most preferred first. The first one is active initially.
+	Order []Outbound
+	// ProbeURL is requested through every outbound, empty for the default of the Prober.
+	ProbeURL string
+	// Interval is how often the outbounds are probed.
+	Interval time.Duration
+	// UnhealthyThreshold is the number of consecutive failed probes after which an outbound is unhealthy (default: 1).
+	UnhealthyThreshold int
+	// Cooldown is the time after a switchover during which the client is not switched back to a recovered
+	// preferred outbound, so that a flapping server does not bounce the connection. Switching away
+	// from an unhealthy outbound is never delayed.
+	Cooldown time.Duration
+}
+
+// Switcher moves the connection to another link, it is implemented by client.Client.
+type Switcher interface {
+	SwitchLink(link string) error
+}
+
+// Prober requests probeURL through the server of link, it is implemented by client.Client.
+type Prober interface {
+	ProbeOutbound(ctx context.Context, link, probeURL string) (time.Duration, error)
+}
+
+// Failover switches the client among outbounds according to Policy.
+type Failover struct {
+	policy   Policy
+	sw       Switcher
+	prober   Prober
+	observer observe.Observer
+	logger   *slog.Logger
+	now      func() time.Time
+
+	mu         sync.Mutex
+	failures   []int // Consecutive failed probes of every outbound.
+	active     int
+	switchedAt time.Time
+}
+
+// New creates Failover of policy, switchovers are reported to observer.
+func New(policy Policy, sw Switcher, prober Prober, observer observe.Observer, logger *slog.Logger) (*Failover, error) {
+	if len(policy.Order) == 0 {
+		return nil, errors.New("no outbounds to fail over to")
+	}
+	if policy.Interval <= 0 {
+		return nil, errors.New("probe interval must be positive")
+	}
+	if policy.UnhealthyThreshold <= 0 {
+		policy.UnhealthyThreshold = 1
+	}
+	if observer == nil {
+		observer = observe.Observers(nil)
+	}
+
+	return &Failover{
+		policy:   policy,
+		sw:       sw,
+		prober:   prober,
+		observer: observer,
+		logger:   logger,
+		now:      time.Now,
+		failures: make([]int, len(policy.Order)),
+	}, nil
+}
+
+// Active returns the outbound in use.
+func (f *Failover) Active() Outbound {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.policy.Order[f.active]
+}
+
+// Run probes the outbounds every Policy.Interval and switches sw accordingly till ctx is done.
+// The client must be connected to the first outbound of Policy.Order.
+func (f *Failover) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		f.probe(ctx)
+		f.apply()
+	}
+}
+
+// probe checks every outbound once. Probes run one by one, each of them may change routes of the client.
+func (f *Failover) probe(ctx context.Context) {
+	for i, o := range f.policy.Order {
+		probeCtx, cancel := context.WithTimeout(ctx, f.policy.Interval)
+		_, err := f.prober.ProbeOutbound(probeCtx, o.Link, f.policy.ProbeURL)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		f.mu.Lock()
+		if err != nil {
+			f.failures[i]++
+			f.logger.Debug("outbound probe failed", "outbound", o.Name, "failures", f.failures[i], "err", err)
+		} else {
+			f.failures[i] = 0
+		}
+		f.mu.Unlock()
+	}
+}
+
+// apply switches to the most preferred healthy outbound, if it is not the active one.
+func (f *Failover) apply() {
+	f.mu.Lock()
+	next := -1
+	for i := range f.policy.Order {
+		if f.failures[i] < f.policy.UnhealthyThreshold {
+			next = i
+
+			break
+		}
+	}
+	activeHealthy := f.failures[f.active] < f.policy.UnhealthyThreshold
+	cooling := f.now().Sub(f.switchedAt) < f.policy.Cooldown
+	prev := f.active
+	f.mu.Unlock()
+
+	switch {
+	case next == -1:
+		if !activeHealthy {
+			f.logger.Warn("all outbounds are unhealthy, keeping the active one", "outbound", f.policy.Order[prev].Name)
+		}
+
+		return
+	case next == prev, activeHealthy && cooling:
+		return
+	}
+
+	reason := "unhealthy"
+	if activeHealthy {
+		reason = "preferred outbound recovered"
+	}
+	from, to := f.policy.Order[prev], f.policy.Order[next]
+	if err := f.sw.SwitchLink(to.Link); err != nil {
+		f.logger.Warn("failing over failed", "from", from.Name, "to", to.Name, "err", err)
+
+		return
+	}
+
+	f.mu.Lock()
+	f.active, f.switchedAt = next, f.now()
+	f.mu.Unlock()
+	f.logger.Info("failed over", "from", from.Name, "to", to.Name, "reason", reason)
+	f.observer.Observe(observe.NewEvent(observe.EventFailover, "from", from.Name, "to", to.Name, "reason", reason))
+}
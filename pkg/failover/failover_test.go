@@ -0,0 +1,87 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// fakeClient fails probes of links in down and records switches.
+type fakeClient struct {
+	down     map[string]bool
+	switched []string
+}
+
+func (f *fakeClient) SwitchLink(link string) error {
+	f.switched = append(f.switched, link)
+
+	return nil
+}
+
+func (f *fakeClient) ProbeOutbound(_ context.Context, link, _ string) (time.Duration, error) {
+	if f.down[link] {
+		return 0, errors.New("probe timed out")
+	}
+
+	return time.Millisecond, nil
+}
+
+func TestFailover(t *testing.T) {
+	fc := &fakeClient{down: make(map[string]bool)}
+	var events []observe.Event
+	policy := Policy{
+		Order:              []Outbound{{Name: "primary", Link: "link-a"}, {Name: "backup", Link: "link-b"}},
+		Interval:           time.Second,
+		UnhealthyThreshold: 2,
+		Cooldown:           time.Minute,
+	}
+	f, err := New(policy, fc, fc, observe.ObserverFunc(func(e observe.Event) { events = append(events, e) }),
+		slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	check := func() {
+		f.probe(context.Background())
+		f.apply()
+	}
+
+	// A single failure is tolerated.
+	fc.down["link-a"] = true
+	check()
+	require.Equal(t, "primary", f.Active().Name)
+	check()
+	require.Equal(t, "backup", f.Active().Name)
+	require.Equal(t, []string{"link-b"}, fc.switched)
+	require.Len(t, events, 1)
+	require.Equal(t, observe.EventFailover, events[0].Type)
+	require.Equal(t, "unhealthy", events[0].Attrs["reason"])
+
+	// The primary recovered, but the client is not switched back during the cooldown.
+	fc.down["link-a"] = false
+	check()
+	require.Equal(t, "backup", f.Active().Name)
+	now = now.Add(time.Minute)
+	check()
+	require.Equal(t, "primary", f.Active().Name)
+	require.Equal(t, "preferred outbound recovered", events[1].Attrs["reason"])
+
+	// Nothing to fail over to.
+	fc.down["link-a"], fc.down["link-b"] = true, true
+	check()
+	check()
+	require.Equal(t, "primary", f.Active().Name)
+	require.Len(t, fc.switched, 2)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Policy{Interval: time.Second}, nil, nil, nil, slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	_, err = New(Policy{Order: []Outbound{{Link: "link-a"}}}, nil, nil, nil, slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+}
@@ -0,0 +1,17 @@
+/*
+Package integration holds end-to-end tests of the client, it has no code of its own.
+
+The tests start an XRay server on loopback with the freedom outbound redirecting every flow to a local
+echo server, connect the client to it through ConnectWithTUN, and drive the other end of the TUN
+with a userspace TCP/IP stack, so that bytes make the full trip: TUN, pipe, XRay client, server
+and back. No root or network namespace is needed, they run with the rest of the tests on Linux.
+
+Run as root, TestNetns also puts the client with a real TUN device and the server into network namespaces
+joined by a veth pair, and checks routing, DNS set by the client and that Disconnect leaves routes, links
+and resolv.conf as they were. The namespaces are removed afterwards, resolv.conf of the host is not touched.
+
+TestSoak runs only with the -soak flag, e.g. -soak 1h: connections keep going through the tunnel with
+client.Config.Chaos failing dials and dropping packets, and it checks that finished flows are closed and that
+descriptors and goroutines do not pile up.
+*/
+package integration
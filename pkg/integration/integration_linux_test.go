@@ -0,0 +1,215 @@
+//go:build linux
+
+package integration
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+const (
+	testUUID = "0c5b1e6a-1111-2222-3333-444455556666"
+	tunMTU   = 1500
+)
+
+var (
+	// hostAddr is the address of the host end of the TUN device.
+	hostAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+	// remoteAddr is dialed through the tunnel, the server redirects it to the echo server.
+	remoteAddr = tcpip.AddrFrom4([4]byte{198, 18, 0, 1})
+)
+
+func TestEndToEnd(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		protocol string
+		settings string
+		link     func(port int) string
+	}{
+		{
+			name:     "vless",
+			protocol: "vless",
+			settings: fmt.Sprintf(`{"clients": [{"id": %q}], "decryption": "none"}`, testUUID),
+			link: func(port int) string {
+				return fmt.Sprintf("vless://%s@127.0.0.1:%d?type=tcp&security=none#integration", testUUID, port)
+			},
+		},
+		{
+			name:     "vmess",
+			protocol: "vmess",
+			settings: fmt.Sprintf(`{"clients": [{"id": %q}]}`, testUUID),
+			link: func(port int) string {
+				share, _ := json.Marshal(map[string]string{"v": "2", "ps": "integration", "add": "127.0.0.1",
+					"port": fmt.Sprint(port), "id": testUUID, "aid": "0", "net": "tcp", "type": "none", "tls": ""})
+
+				return "vmess://" + base64.StdEncoding.EncodeToString(share)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := startEcho(t)
+			port := startServer(t, tt.protocol, tt.settings, echo)
+
+			gw := net.IPv4(192, 168, 1, 1)
+			cl, err := client.NewClientWithOpts(client.Config{
+				GatewayIP:    &gw,
+				InboundProxy: &client.Proxy{IP: net.IPv4(127, 0, 0, 1), Port: freePort(t)},
+				Logger:       slog.New(slog.DiscardHandler),
+			})
+			require.NoError(t, err)
+
+			fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+			require.NoError(t, err)
+			host := hostStack(t, fds[1])
+			require.NoError(t, cl.ConnectWithTUN(fds[0], tt.link(port)))
+			t.Cleanup(func() { _ = cl.Disconnect(context.Background()) })
+			require.Equal(t, client.StateConnected, cl.Status().State)
+
+			payload := make([]byte, 256<<10)
+			_, _ = rand.Read(payload)
+			require.Equal(t, payload, roundTrip(t, host, payload))
+			stats := cl.Stats()
+			require.GreaterOrEqual(t, stats.BytesRead, len(payload), "bytes sent through the TUN")
+			require.GreaterOrEqual(t, stats.BytesWritten, len(payload), "bytes received through the TUN")
+
+			require.NoError(t, cl.Disconnect(context.Background()))
+			require.Equal(t, client.StateDisconnected, cl.Status().State)
+		})
+	}
+}
+
+// roundTrip sends payload to the echo server through the tunnel and returns what comes back.
+func roundTrip(t *testing.T, host *stack.Stack, payload []byte) []byte {
+	got, err := echo(host, payload)
+	require.NoError(t, err)
+
+	return got
+}
+
+// echo is roundTrip returning the error, e.g. of connections failed on purpose.
+func echo(host *stack.Stack, payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := gonet.DialContextTCP(ctx, host, tcpip.FullAddress{NIC: 1, Addr: remoteAddr, Port: 80},
+		ipv4.ProtocolNumber)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return nil, err
+	}
+
+	go func() { _, _ = conn.Write(payload) }()
+	got := make([]byte, len(payload))
+	if _, err = io.ReadFull(conn, got); err != nil {
+		return nil, fmt.Errorf("read echo: %w", err)
+	}
+
+	return got, nil
+}
+
+// startEcho starts TCP server writing back whatever it reads and returns its address.
+func startEcho(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// startServer starts XRay server with protocol inbound on loopback, its freedom outbound redirects every flow
+// to redirect. It returns the port of the inbound.
+func startServer(t *testing.T, protocol, settings, redirect string) int {
+	port := freePort(t)
+	startXrayServer(t, "127.0.0.1", port, protocol, settings, fmt.Sprintf(`{"redirect": %q}`, redirect))
+
+	return port
+}
+
+// startXrayServer starts XRay server with protocol inbound on listen:port and freedom outbound, settings
+// and freedom are the JSON settings of them.
+func startXrayServer(t *testing.T, listen string, port int, protocol, settings, freedom string) {
+	var cfg conf.Config
+	require.NoError(t, json.Unmarshal([]byte(fmt.Sprintf(`{
+		"log": {"loglevel": "none"},
+		"inbounds": [{"listen": %q, "port": %d, "protocol": %q, "settings": %s}],
+		"outbounds": [{"protocol": "freedom", "settings": %s}]
+	}`, listen, port, protocol, settings, freedom)), &cfg))
+	built, err := cfg.Build()
+	require.NoError(t, err)
+	server, err := core.New(built)
+	require.NoError(t, err)
+	require.NoError(t, server.Start())
+	t.Cleanup(func() { _ = server.Close() })
+}
+
+// hostStack returns TCP/IP stack of the host end of the TUN device, which is fd, routing everything to it.
+func hostStack(t *testing.T, fd int) *stack.Stack {
+	require.NoError(t, syscall.SetNonblock(fd, true))
+	ep, err := fdbased.New(&fdbased.Options{FDs: []int{fd}, MTU: tunMTU})
+	require.NoError(t, err)
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	t.Cleanup(func() {
+		s.Close()
+		s.Wait()
+		_ = syscall.Close(fd)
+	})
+	if err := s.CreateNIC(1, ep); err != nil {
+		t.Fatalf("create NIC: %v", err)
+	}
+	addr := tcpip.ProtocolAddress{Protocol: ipv4.ProtocolNumber, AddressWithPrefix: hostAddr.WithPrefix()}
+	if err := s.AddProtocolAddress(1, addr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("add address: %v", err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: 1}})
+
+	return s
+}
+
+// freePort returns a loopback TCP port nobody listens on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
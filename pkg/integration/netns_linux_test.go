@@ -0,0 +1,276 @@
+//go:build linux
+
+package integration
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// Topology of TestNetns: the client and server namespaces are joined by a veth pair, the default route
+// of the client goes to the server. Services dialed through the tunnel listen on remoteIP of the server,
+// so they are reachable without the tunnel too, and report the address they see the peer from.
+const (
+	netnsServerIP = "10.200.0.1"
+	netnsClientIP = "10.200.0.2"
+	netnsRemoteIP = "10.200.1.1" // Echo on TCP 7, DNS on UDP 53.
+	netnsXrayPort = 443
+
+	// netnsRoleEnv selects the part TestNetnsHelper plays in the namespace it is started in.
+	netnsRoleEnv = "GOXRAY_NETNS_ROLE"
+)
+
+// TestNetns runs the client with a real TUN device in a network namespace against XRay server in another one,
+// and checks that traffic and DNS queries go through the tunnel and that routes, links and resolv.conf
+// are left as they were after Disconnect.
+func TestNetns(t *testing.T) {
+	if testing.Short() {
+		t.Skip("network namespaces are not set up in short mode")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("creating network namespaces requires root")
+	}
+	for _, bin := range []string{"ip", "unshare"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	srv, cli := fmt.Sprintf("goxray-srv-%d", os.Getpid()), fmt.Sprintf("goxray-cli-%d", os.Getpid())
+	for _, ns := range []string{srv, cli} {
+		ip(t, "netns", "add", ns)
+		t.Cleanup(func() { _ = exec.Command("ip", "netns", "del", ns).Run() }) // The veth pair goes with it.
+		ip(t, "-n", ns, "link", "set", "lo", "up")
+	}
+	ip(t, "link", "add", "gx-srv", "netns", srv, "type", "veth", "peer", "name", "gx-cli", "netns", cli)
+	ip(t, "-n", srv, "addr", "add", netnsServerIP+"/24", "dev", "gx-srv")
+	ip(t, "-n", srv, "link", "set", "gx-srv", "up")
+	ip(t, "-n", srv, "addr", "add", netnsRemoteIP+"/32", "dev", "lo")
+	ip(t, "-n", cli, "addr", "add", netnsClientIP+"/24", "dev", "gx-cli")
+	ip(t, "-n", cli, "link", "set", "gx-cli", "up")
+	ip(t, "-n", cli, "route", "add", "default", "via", netnsServerIP)
+
+	server := helper(t, "server", "ip", "netns", "exec", srv)
+	server.Stderr = os.Stderr
+	stdout, err := server.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, server.Start())
+	t.Cleanup(func() {
+		_ = server.Process.Kill()
+		_ = server.Wait()
+	})
+	ready, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err, "server failed to start")
+	require.Equal(t, "ready\n", ready)
+
+	// The client gets its own mount namespace to change resolv.conf without touching the one of the host.
+	out, err := helper(t, "client", "ip", "netns", "exec", cli, "unshare", "--mount", "--propagation", "private").
+		CombinedOutput()
+	require.NoError(t, err, "client:\n%s", out)
+}
+
+// TestNetnsHelper is the server or client of TestNetns started in its namespace, it does nothing in other runs.
+func TestNetnsHelper(t *testing.T) {
+	switch os.Getenv(netnsRoleEnv) {
+	case "server":
+		runNetnsServer(t)
+	case "client":
+		runNetnsClient(t)
+	}
+}
+
+// runNetnsServer starts XRay server with the echo and DNS services and serves till it is killed.
+func runNetnsServer(t *testing.T) {
+	l, err := net.Listen("tcp", net.JoinHostPort(netnsRemoteIP, "7"))
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = fmt.Fprintln(conn, conn.RemoteAddr().(*net.TCPAddr).IP)
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	dns, err := net.ListenPacket("udp", net.JoinHostPort(netnsRemoteIP, "53"))
+	require.NoError(t, err)
+	go serveWhoamiDNS(dns)
+
+	vless := fmt.Sprintf(`{"clients": [{"id": %q}], "decryption": "none"}`, testUUID)
+	startXrayServer(t, netnsServerIP, netnsXrayPort, "vless", vless, "{}")
+	fmt.Println("ready")
+	select {}
+}
+
+// serveWhoamiDNS answers A queries for any name with the address the query came from.
+func serveWhoamiDNS(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			continue
+		}
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true})
+		_ = b.StartQuestions()
+		_ = b.Question(q)
+		_ = b.StartAnswers()
+		if src := addr.(*net.UDPAddr).IP.To4(); q.Type == dnsmessage.TypeA && src != nil {
+			_ = b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET},
+				dnsmessage.AResource{A: [4]byte(src)})
+		}
+		if msg, err := b.Finish(); err == nil {
+			_, _ = conn.WriteTo(msg, addr)
+		}
+	}
+}
+
+// runNetnsClient connects the client, checks traffic and DNS going through the tunnel, disconnects
+// and checks the namespace is back to where it was.
+func runNetnsClient(t *testing.T) {
+	isolateEtc(t)
+	routes, links, resolvConf := netnsState(t)
+
+	cl, err := client.NewClientWithOpts(client.Config{
+		DNSServers: []net.IP{net.ParseIP(netnsRemoteIP)},
+		Logger:     slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	require.NoError(t, err)
+	link := fmt.Sprintf("vless://%s@%s:%d?type=tcp&security=none#netns", testUUID, netnsServerIP, netnsXrayPort)
+	require.NoError(t, cl.Connect(link))
+	t.Cleanup(func() { _ = cl.Disconnect(context.Background()) })
+	tunName := cl.Status().TUNName
+	require.NotEmpty(t, tunName)
+
+	// Routing: the remote goes to the TUN device, the server around it.
+	require.Contains(t, ipOutput(t, "route", "get", netnsRemoteIP), "dev "+tunName)
+	require.Contains(t, ipOutput(t, "route", "get", netnsServerIP), "dev gx-cli")
+
+	// Traffic: the echo service sees the connection coming from the server, not from the client.
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(netnsRemoteIP, "7"), 10*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+	r := bufio.NewReader(conn)
+	peer, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.NotEqual(t, netnsClientIP, strings.TrimSpace(peer), "connection bypassed the tunnel")
+	payload := make([]byte, 64<<10)
+	_, _ = rand.Read(payload)
+	go func() { _, _ = conn.Write(payload) }()
+	got := make([]byte, len(payload))
+	_, err = io.ReadFull(r, got)
+	require.NoError(t, err)
+	require.Equal(t, payload, got)
+
+	// DNS: the system resolver is the configured server, queried through the tunnel.
+	current, err := os.ReadFile("/etc/resolv.conf")
+	require.NoError(t, err)
+	require.Contains(t, string(current), "nameserver "+netnsRemoteIP)
+	addrs := lookupHost(t, "whoami.test")
+	require.NotContains(t, addrs, netnsClientIP, "DNS query bypassed the tunnel")
+	require.NoError(t, conn.Close())
+
+	// Cleanup: routes, links and resolv.conf are restored.
+	require.NoError(t, cl.Disconnect(context.Background()))
+	afterRoutes, afterLinks, afterResolvConf := netnsState(t)
+	require.Equal(t, routes, afterRoutes)
+	require.Equal(t, links, afterLinks)
+	require.Equal(t, resolvConf, afterResolvConf)
+}
+
+// lookupHost resolves host with the system resolver. Go rereads resolv.conf at most every 5 seconds,
+// so queries failing to the resolver set before connecting are retried for a while.
+func lookupHost(t *testing.T, host string) []string {
+	resolver := &net.Resolver{PreferGo: true}
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		addrs, err := resolver.LookupHost(ctx, host)
+		cancel()
+		if err == nil || time.Now().After(deadline) {
+			require.NoError(t, err)
+
+			return addrs
+		}
+	}
+}
+
+// isolateEtc overlays /etc and mounts /run of the private mount namespace of the client, so that resolv.conf,
+// its backup and the lock file of the client are changed in temporary directories.
+func isolateEtc(t *testing.T) {
+	dir := t.TempDir()
+	upper, work := filepath.Join(dir, "upper"), filepath.Join(dir, "work")
+	require.NoError(t, os.Mkdir(upper, 0o755))
+	require.NoError(t, os.Mkdir(work, 0o755))
+	require.NoError(t, syscall.Mount("overlay", "/etc", "overlay", 0,
+		"lowerdir=/etc,upperdir="+upper+",workdir="+work))
+	require.NoError(t, syscall.Mount("tmpfs", "/run", "tmpfs", 0, ""))
+}
+
+// netnsState returns all IPv4 routes, the names of links and resolv.conf of the namespace.
+func netnsState(t *testing.T) (routes string, links []string, resolvConf string) {
+	ifcs, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, ifc := range ifcs {
+		links = append(links, ifc.Name)
+	}
+	slices.Sort(links)
+	data, err := os.ReadFile("/etc/resolv.conf")
+	require.NoError(t, err)
+
+	return ipOutput(t, "-4", "route", "show", "table", "all"), links, string(data)
+}
+
+// helper returns command running TestNetnsHelper as role, prefixed with the command entering its namespace.
+func helper(t *testing.T, role string, prefix ...string) *exec.Cmd {
+	args := append(prefix[1:], os.Args[0], "-test.run=^TestNetnsHelper$", "-test.timeout=60s")
+	cmd := exec.Command(prefix[0], args...)
+	cmd.Env = append(os.Environ(), netnsRoleEnv+"="+role)
+	t.Logf("starting %s: %s", role, cmd)
+
+	return cmd
+}
+
+// ip runs ip command with args.
+func ip(t *testing.T, args ...string) {
+	ipOutput(t, args...)
+}
+
+// ipOutput runs ip command with args and returns its output.
+func ipOutput(t *testing.T, args ...string) string {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	require.NoError(t, err, "ip %s: %s", strings.Join(args, " "), out)
+
+	return string(out)
+}
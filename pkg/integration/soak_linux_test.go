@@ -0,0 +1,126 @@
+//go:build linux
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+var soak = flag.Duration("soak", 0, "run TestSoak for this long, e.g. 1h")
+
+const (
+	// soakWorkers is the number of connections kept going through the tunnel at once.
+	soakWorkers = 8
+	// soakSlack is how many descriptors and goroutines may stay over the baseline, e.g. of connections
+	// still being closed by the XRay server.
+	soakSlack = 16
+)
+
+// TestSoak keeps connections going through the tunnel for -soak with Config.Chaos failing dials and dropping
+// packets, and checks that the tunnel keeps working, that finished flows are closed and that descriptors
+// and goroutines do not pile up. The TUN device is a socket, so network changes are not simulated.
+func TestSoak(t *testing.T) {
+	if *soak <= 0 {
+		t.Skip("soak runs only with -soak, e.g. go test ./pkg/integration -run TestSoak -soak 1h")
+	}
+
+	port := startServer(t, "vless", fmt.Sprintf(`{"clients": [{"id": %q}], "decryption": "none"}`, testUUID),
+		startEcho(t))
+	gw := net.IPv4(192, 168, 1, 1)
+	cl, err := client.NewClientWithOpts(client.Config{
+		GatewayIP:    &gw,
+		InboundProxy: &client.Proxy{IP: net.IPv4(127, 0, 0, 1), Port: freePort(t)},
+		Logger:       slog.New(slog.DiscardHandler),
+		Chaos:        client.Chaos{DialFailure: 0.1, PacketLoss: 0.01},
+	})
+	require.NoError(t, err)
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	require.NoError(t, err)
+	host := hostStack(t, fds[1])
+	beforeFDs, beforeGoroutines := openFDs(t), runtime.NumGoroutine()
+
+	link := fmt.Sprintf("vless://%s@127.0.0.1:%d?type=tcp&security=none#soak", testUUID, port)
+	require.NoError(t, cl.ConnectWithTUN(fds[0], link))
+	t.Cleanup(func() { _ = cl.Disconnect(context.Background()) })
+
+	var ok, failed atomic.Int64
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(*soak)
+	for range soakWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload := make([]byte, 16<<10)
+			for time.Now().Before(deadline) {
+				_, _ = rand.Read(payload)
+				if got, err := echo(host, payload); err == nil && bytes.Equal(got, payload) {
+					ok.Add(1)
+				} else {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	// Usage is logged while connected to tell when it started growing, it is checked after Disconnect.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-ticker.C:
+			t.Logf("%d round trips, %d failed, %d descriptors, %d goroutines",
+				ok.Load(), failed.Load(), openFDs(t), runtime.NumGoroutine())
+		case <-done:
+			running = false
+		}
+	}
+	t.Logf("%d round trips, %d failed", ok.Load(), failed.Load())
+	require.Positive(t, ok.Load(), "no round trip went through the tunnel")
+	require.Positive(t, failed.Load(), "no dial failed on purpose")
+	// Flows of finished connections are closed while connected, Disconnect would close leaked ones as well.
+	for settled := time.Now().Add(15 * time.Second); len(cl.Flows()) > 0 && time.Now().Before(settled); {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Zero(t, len(cl.Flows()), "flows of finished connections are left open")
+
+	require.NoError(t, cl.Disconnect(context.Background()))
+	leaking := func() bool {
+		return openFDs(t) > beforeFDs+soakSlack || runtime.NumGoroutine() > beforeGoroutines+soakSlack
+	}
+	for settled := time.Now().Add(10 * time.Second); leaking() && time.Now().Before(settled); {
+		// Pipes splicing TCP connections, e.g. of the echo server, are pooled by the runtime till collected.
+		runtime.GC()
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.LessOrEqual(t, openFDs(t), beforeFDs+soakSlack, "descriptors left after disconnecting")
+	require.LessOrEqual(t, runtime.NumGoroutine(), beforeGoroutines+soakSlack, "goroutines left after disconnecting")
+}
+
+// openFDs returns the number of descriptors open by the process.
+func openFDs(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	require.NoError(t, err)
+
+	return len(entries)
+}
@@ -0,0 +1,253 @@
+/*
+Package ipfix exports flows going through the tunnel as IPFIX (NetFlow v10) records to a collector over UDP,
+so that the traffic of a gateway running the client can be accounted in existing tooling.
+
+Every flow is exported as up to two uniflow records, one per direction with traffic: from the local source
+to the destination and back. Closed flows are exported right away, long-lived ones every active timeout
+with the traffic since the previous export.
+*/
+package ipfix
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+const (
+	// DefaultActiveTimeout is how often long-lived flows are exported if Options.ActiveTimeout is not set.
+	DefaultActiveTimeout = time.Minute
+	// DefaultTemplateRefresh is how often templates are sent again if Options.TemplateRefresh is not set.
+	DefaultTemplateRefresh = 10 * time.Minute
+
+	// flushInterval is how often records of closed flows are sent.
+	flushInterval = time.Second
+	// maxPending bounds the records of closed flows waiting to be sent, newer ones are dropped when it is reached.
+	maxPending = 4096
+	// maxMessageSize keeps messages within a single unfragmented datagram on common links.
+	maxMessageSize = 1400
+)
+
+// Flow end reasons of IPFIX information element flowEndReason.
+const (
+	endActiveTimeout = 0x02
+	endOfFlow        = 0x03
+)
+
+// Options configure Exporter.
+type Options struct {
+	// Collector is the host:port of the collector receiving IPFIX over UDP, e.g. "10.0.0.5:4739".
+	Collector string
+	// ObservationDomain identifies the exporting client to the collector (default: 0).
+	ObservationDomain uint32
+	// ActiveTimeout is how often flows still open are exported (default: 1m).
+	ActiveTimeout time.Duration
+	// TemplateRefresh is how often the templates are sent again, so that a restarted collector
+	// can decode the records (default: 10m).
+	TemplateRefresh time.Duration
+}
+
+// counters are the traffic of a flow in both directions.
+type counters struct {
+	sent, received               int64
+	packetsSent, packetsReceived int64
+}
+
+// record is a uniflow record of a flow.
+type record struct {
+	src, dst   netip.AddrPort
+	protocol   uint8
+	octets     int64
+	packets    int64
+	start, end time.Time
+	endReason  uint8
+}
+
+// Exporter sends flow records to the collector. It must receive closed flows as observe.FlowRecorder,
+// e.g. set as client.Config.FlowRecorder, and be started with Run to export them.
+type Exporter struct {
+	opts   Options
+	conn   net.Conn
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	pending  []record
+	exported map[uint64]counters // Traffic of open flows exported already, by flow ID.
+	dropped  int                 // Records dropped since it was last logged.
+
+	// Used by Run only.
+	seq          uint32 // Data records sent so far.
+	templateSent time.Time
+}
+
+// NewExporter creates Exporter sending records to opts.Collector, failures to send are logged with logger.
+func NewExporter(opts Options, logger *slog.Logger) (*Exporter, error) {
+	if opts.ActiveTimeout < 0 || opts.TemplateRefresh < 0 {
+		return nil, errors.New("ipfix timeouts must not be negative")
+	}
+	if opts.ActiveTimeout == 0 {
+		opts.ActiveTimeout = DefaultActiveTimeout
+	}
+	if opts.TemplateRefresh == 0 {
+		opts.TemplateRefresh = DefaultTemplateRefresh
+	}
+
+	conn, err := net.Dial("udp", opts.Collector)
+	if err != nil {
+		return nil, fmt.Errorf("dial collector: %w", err)
+	}
+
+	return &Exporter{opts: opts, conn: conn, logger: logger, exported: make(map[uint64]counters)}, nil
+}
+
+// RecordFlow implements observe.FlowRecorder, it queues records of the closed flow f.
+func (e *Exporter) RecordFlow(f observe.FlowInfo) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prev := e.exported[f.ID]
+	delete(e.exported, f.ID)
+	records := flowRecords(f, prev, endOfFlow)
+	if len(e.pending)+len(records) > maxPending {
+		e.dropped += len(records)
+
+		return
+	}
+	e.pending = append(e.pending, records...)
+}
+
+// Run exports closed flows and, every active timeout, flows of src still open till ctx is done.
+// Records queued by then are sent before it returns.
+func (e *Exporter) Run(ctx context.Context, src observe.FlowSource) {
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	active := time.NewTicker(e.opts.ActiveTimeout)
+	defer active.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.send(e.takePending())
+
+			return
+		case <-flush.C:
+			e.send(e.takePending())
+		case <-active.C:
+			e.send(e.activeRecords(src.Flows()))
+		}
+	}
+}
+
+// Close closes the socket of the exporter.
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
+
+// takePending returns queued records of closed flows and logs the ones dropped.
+func (e *Exporter) takePending() []record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.dropped > 0 {
+		e.logger.Warn("ipfix records dropped, the collector is not keeping up", "records", e.dropped)
+		e.dropped = 0
+	}
+	records := e.pending
+	e.pending = nil
+
+	return records
+}
+
+// activeRecords returns records of the traffic of open flows since their previous export.
+func (e *Exporter) activeRecords(flows []observe.FlowInfo) []record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var records []record
+	for _, f := range flows {
+		records = append(records, flowRecords(f, e.exported[f.ID], endActiveTimeout)...)
+		e.exported[f.ID] = counters{f.Sent, f.Received, f.PacketsSent, f.PacketsReceived}
+	}
+
+	return records
+}
+
+// flowRecords returns records of the traffic of f in both directions since prev was exported.
+// Directions with no new traffic are left out.
+func flowRecords(f observe.FlowInfo, prev counters, endReason uint8) []record {
+	protocol := uint8(6)
+	if f.Network == observe.UDP {
+		protocol = 17
+	}
+
+	var records []record
+	if octets := f.Sent - prev.sent; octets > 0 {
+		records = append(records, record{src: f.Src, dst: f.Dst, protocol: protocol, octets: octets,
+			packets: f.PacketsSent - prev.packetsSent, start: f.Started, end: f.LastSeen, endReason: endReason})
+	}
+	if octets := f.Received - prev.received; octets > 0 {
+		records = append(records, record{src: f.Dst, dst: f.Src, protocol: protocol, octets: octets,
+			packets: f.PacketsReceived - prev.packetsReceived, start: f.Started, end: f.LastSeen, endReason: endReason})
+	}
+
+	return records
+}
+
+// send sends records in as many messages as needed, with templates when they are due.
+func (e *Exporter) send(records []record) {
+	for len(records) > 0 {
+		now := time.Now()
+		withTemplates := now.Sub(e.templateSent) >= e.opts.TemplateRefresh
+		msg, n := e.message(records, withTemplates, now)
+		if _, err := e.conn.Write(msg); err != nil {
+			e.logger.Debug("sending ipfix message failed", "collector", e.opts.Collector, "err", err)
+		} else if withTemplates {
+			e.templateSent = now
+		}
+		e.seq += uint32(n)
+		records = records[n:]
+	}
+}
+
+// message encodes as many of records as fit in maxMessageSize, it returns the message and the number
+// of records in it.
+func (e *Exporter) message(records []record, withTemplates bool, now time.Time) ([]byte, int) {
+	msg := make([]byte, headerSize, maxMessageSize)
+	if withTemplates {
+		msg = appendTemplates(msg)
+	}
+
+	n := 0
+	for n < len(records) {
+		template := templateFor(records[n].src.Addr())
+		// Records of the same template go to one set.
+		setStart := len(msg)
+		if setStart+setHeaderSize+template.recordSize > maxMessageSize {
+			break
+		}
+		msg = binary.BigEndian.AppendUint16(msg, template.id)
+		msg = binary.BigEndian.AppendUint16(msg, 0) // Set length, filled below.
+		for n < len(records) && templateFor(records[n].src.Addr()).id == template.id &&
+			len(msg)+template.recordSize <= maxMessageSize {
+			msg = appendRecord(msg, records[n])
+			n++
+		}
+		binary.BigEndian.PutUint16(msg[setStart+2:], uint16(len(msg)-setStart))
+	}
+
+	binary.BigEndian.PutUint16(msg[0:], version)
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+	binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(msg[8:], e.seq)
+	binary.BigEndian.PutUint32(msg[12:], e.opts.ObservationDomain)
+
+	return msg, n
+}
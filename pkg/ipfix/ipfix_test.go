@@ -0,0 +1,195 @@
+package ipfix
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// decoded is an IPFIX message decoded with the templates it carries or received before.
+type decoded struct {
+	seq     uint32
+	domain  uint32
+	records []map[uint16][]byte // Field values by information element.
+}
+
+// collector receives IPFIX messages and decodes them.
+type collector struct {
+	t         *testing.T
+	conn      net.PacketConn
+	templates map[uint16][]field
+}
+
+func newCollector(t *testing.T) *collector {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return &collector{t: t, conn: conn, templates: make(map[uint16][]field)}
+}
+
+func (c *collector) receive() decoded {
+	c.t.Helper()
+	buf := make([]byte, 65535)
+	require.NoError(c.t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := c.conn.ReadFrom(buf)
+	require.NoError(c.t, err)
+	msg := buf[:n]
+	require.Equal(c.t, uint16(version), binary.BigEndian.Uint16(msg))
+	require.Equal(c.t, n, int(binary.BigEndian.Uint16(msg[2:])))
+	require.LessOrEqual(c.t, n, maxMessageSize)
+
+	d := decoded{seq: binary.BigEndian.Uint32(msg[8:]), domain: binary.BigEndian.Uint32(msg[12:])}
+	for sets := msg[headerSize:]; len(sets) > 0; {
+		id, size := binary.BigEndian.Uint16(sets), int(binary.BigEndian.Uint16(sets[2:]))
+		body := sets[setHeaderSize:size]
+		sets = sets[size:]
+		if id == templateSetID {
+			for len(body) > 0 {
+				tid, count := binary.BigEndian.Uint16(body), int(binary.BigEndian.Uint16(body[2:]))
+				body = body[4:]
+				var fields []field
+				for range count {
+					fields = append(fields, field{binary.BigEndian.Uint16(body), binary.BigEndian.Uint16(body[2:])})
+					body = body[4:]
+				}
+				c.templates[tid] = fields
+			}
+
+			continue
+		}
+		fields, ok := c.templates[id]
+		require.True(c.t, ok, "data set of unknown template %d", id)
+		for len(body) > 0 {
+			r := make(map[uint16][]byte)
+			for _, f := range fields {
+				r[f.ie], body = body[:f.length], body[f.length:]
+			}
+			d.records = append(d.records, r)
+		}
+	}
+
+	return d
+}
+
+func newTestExporter(t *testing.T, c *collector) *Exporter {
+	t.Helper()
+	e, err := NewExporter(Options{Collector: c.conn.LocalAddr().String(), ObservationDomain: 7}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = e.Close() })
+
+	return e
+}
+
+func TestExporter_ClosedFlow(t *testing.T) {
+	c := newCollector(t)
+	e := newTestExporter(t, c)
+	started := time.UnixMilli(1_700_000_000_000)
+
+	e.RecordFlow(observe.FlowInfo{
+		ID: 1, Network: observe.TCP, Started: started, LastSeen: started.Add(time.Second),
+		Src: netip.MustParseAddrPort("192.18.0.1:50000"), Dst: netip.MustParseAddrPort("140.82.121.4:443"),
+		Sent: 100, Received: 2000, PacketsSent: 2, PacketsReceived: 3,
+	})
+	e.RecordFlow(observe.FlowInfo{
+		ID: 2, Network: observe.UDP, Started: started, LastSeen: started,
+		Src: netip.MustParseAddrPort("[fd00::1]:5353"), Dst: netip.MustParseAddrPort("[2606:4700::1111]:53"),
+		Sent: 40, PacketsSent: 1,
+	})
+	e.RecordFlow(observe.FlowInfo{ID: 3, Network: observe.TCP, Src: netip.MustParseAddrPort("192.18.0.1:50001"),
+		Dst: netip.MustParseAddrPort("1.1.1.1:443")}) // No traffic, not exported.
+	e.send(e.takePending())
+
+	d := c.receive()
+	require.Equal(t, uint32(0), d.seq)
+	require.Equal(t, uint32(7), d.domain)
+	require.Len(t, d.records, 3)
+
+	out, back, udp := d.records[0], d.records[1], d.records[2]
+	require.Equal(t, []byte{192, 18, 0, 1}, out[ieSourceIPv4Address])
+	require.Equal(t, []byte{140, 82, 121, 4}, out[ieDestinationIPv4Address])
+	require.Equal(t, uint16(443), binary.BigEndian.Uint16(out[ieDestinationTransportPort]))
+	require.Equal(t, []byte{6}, out[ieProtocolIdentifier])
+	require.Equal(t, uint64(100), binary.BigEndian.Uint64(out[ieOctetDeltaCount]))
+	require.Equal(t, uint64(2), binary.BigEndian.Uint64(out[iePacketDeltaCount]))
+	require.Equal(t, uint64(started.UnixMilli()), binary.BigEndian.Uint64(out[ieFlowStartMilliseconds]))
+	require.Equal(t, uint64(started.Add(time.Second).UnixMilli()), binary.BigEndian.Uint64(out[ieFlowEndMilliseconds]))
+	require.Equal(t, []byte{endOfFlow}, out[ieFlowEndReason])
+
+	require.Equal(t, []byte{140, 82, 121, 4}, back[ieSourceIPv4Address])
+	require.Equal(t, uint16(50000), binary.BigEndian.Uint16(back[ieDestinationTransportPort]))
+	require.Equal(t, uint64(2000), binary.BigEndian.Uint64(back[ieOctetDeltaCount]))
+
+	require.Equal(t, netip.MustParseAddr("2606:4700::1111").AsSlice(), udp[ieDestinationIPv6Address])
+	require.Equal(t, []byte{17}, udp[ieProtocolIdentifier])
+
+	// Templates were sent, the next message carries data only and continues the sequence.
+	e.RecordFlow(observe.FlowInfo{ID: 4, Network: observe.TCP, Src: netip.MustParseAddrPort("192.18.0.1:50002"),
+		Dst: netip.MustParseAddrPort("1.1.1.1:443"), Sent: 1})
+	e.send(e.takePending())
+	d = c.receive()
+	require.Equal(t, uint32(3), d.seq)
+	require.Len(t, d.records, 1)
+}
+
+func TestExporter_ActiveFlows(t *testing.T) {
+	c := newCollector(t)
+	e := newTestExporter(t, c)
+	f := observe.FlowInfo{ID: 1, Network: observe.TCP, Src: netip.MustParseAddrPort("192.18.0.1:50000"),
+		Dst: netip.MustParseAddrPort("140.82.121.4:443"), Sent: 100, PacketsSent: 1}
+
+	e.send(e.activeRecords([]observe.FlowInfo{f}))
+	d := c.receive()
+	require.Len(t, d.records, 1)
+	require.Equal(t, []byte{endActiveTimeout}, d.records[0][ieFlowEndReason])
+
+	// Only the traffic since the previous export is sent.
+	f.Sent, f.PacketsSent, f.Received, f.PacketsReceived = 150, 2, 10, 1
+	e.send(e.activeRecords([]observe.FlowInfo{f}))
+	d = c.receive()
+	require.Len(t, d.records, 2)
+	require.Equal(t, uint64(50), binary.BigEndian.Uint64(d.records[0][ieOctetDeltaCount]))
+	require.Equal(t, uint64(1), binary.BigEndian.Uint64(d.records[0][iePacketDeltaCount]))
+
+	f.Sent = 160
+	e.RecordFlow(f)
+	e.send(e.takePending())
+	d = c.receive()
+	require.Len(t, d.records, 1)
+	require.Equal(t, uint64(10), binary.BigEndian.Uint64(d.records[0][ieOctetDeltaCount]))
+	require.Equal(t, []byte{endOfFlow}, d.records[0][ieFlowEndReason])
+}
+
+func TestExporter_Split(t *testing.T) {
+	c := newCollector(t)
+	e := newTestExporter(t, c)
+	for i := range 100 {
+		e.RecordFlow(observe.FlowInfo{ID: uint64(i), Network: observe.UDP, Src: netip.MustParseAddrPort("192.18.0.1:5353"),
+			Dst: netip.MustParseAddrPort("1.1.1.1:53"), Sent: 40})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.Run(ctx, staticFlows(nil)) // Queued records are sent on return.
+
+	received := 0
+	for received < 100 {
+		received += len(c.receive().records)
+	}
+	require.Equal(t, 100, received)
+}
+
+type staticFlows []observe.FlowInfo
+
+func (s staticFlows) Flows() []observe.FlowInfo {
+	return s
+}
@@ -0,0 +1,119 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	// version is the version of IPFIX message header, NetFlow v10.
+	version = 10
+	// headerSize is the size of IPFIX message header.
+	headerSize = 16
+	// setHeaderSize is the size of a set header: set ID and length.
+	setHeaderSize = 4
+	// templateSetID is the set ID of template sets.
+	templateSetID = 2
+)
+
+// Information elements of the records, RFC 7012.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieFlowEndReason            = 136
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+)
+
+// field is a field specifier of a template: information element and its length.
+type field struct {
+	ie, length uint16
+}
+
+// template describes the layout of data records.
+type template struct {
+	id         uint16
+	fields     []field
+	recordSize int
+}
+
+func newTemplate(id uint16, addrIE [2]uint16, addrLen uint16) template {
+	t := template{id: id, fields: []field{
+		{addrIE[0], addrLen},
+		{addrIE[1], addrLen},
+		{ieSourceTransportPort, 2},
+		{ieDestinationTransportPort, 2},
+		{ieProtocolIdentifier, 1},
+		{ieOctetDeltaCount, 8},
+		{iePacketDeltaCount, 8},
+		{ieFlowStartMilliseconds, 8},
+		{ieFlowEndMilliseconds, 8},
+		{ieFlowEndReason, 1},
+	}}
+	for _, f := range t.fields {
+		t.recordSize += int(f.length)
+	}
+
+	return t
+}
+
+var (
+	templateIPv4 = newTemplate(256, [2]uint16{ieSourceIPv4Address, ieDestinationIPv4Address}, 4)
+	templateIPv6 = newTemplate(257, [2]uint16{ieSourceIPv6Address, ieDestinationIPv6Address}, 16)
+)
+
+// templateFor returns the template of records with addr.
+func templateFor(addr netip.Addr) template {
+	if addr.Unmap().Is4() {
+		return templateIPv4
+	}
+
+	return templateIPv6
+}
+
+// appendTemplates appends the template set with both templates to msg.
+func appendTemplates(msg []byte) []byte {
+	setStart := len(msg)
+	msg = binary.BigEndian.AppendUint16(msg, templateSetID)
+	msg = binary.BigEndian.AppendUint16(msg, 0) // Set length, filled below.
+	for _, t := range []template{templateIPv4, templateIPv6} {
+		msg = binary.BigEndian.AppendUint16(msg, t.id)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(t.fields)))
+		for _, f := range t.fields {
+			msg = binary.BigEndian.AppendUint16(msg, f.ie)
+			msg = binary.BigEndian.AppendUint16(msg, f.length)
+		}
+	}
+	binary.BigEndian.PutUint16(msg[setStart+2:], uint16(len(msg)-setStart))
+
+	return msg
+}
+
+// appendRecord appends r encoded by its template to msg.
+func appendRecord(msg []byte, r record) []byte {
+	src, dst := r.src.Addr().Unmap(), r.dst.Addr().Unmap()
+	if templateFor(src).id == templateIPv4.id {
+		msg = append(msg, src.AsSlice()...)
+		msg = append(msg, dst.AsSlice()...)
+	} else {
+		s, d := src.As16(), dst.As16()
+		msg = append(msg, s[:]...)
+		msg = append(msg, d[:]...)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, r.src.Port())
+	msg = binary.BigEndian.AppendUint16(msg, r.dst.Port())
+	msg = append(msg, r.protocol)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(r.octets))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(r.packets))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(r.start.UnixMilli()))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(r.end.UnixMilli()))
+
+	return append(msg, r.endReason)
+}
@@ -0,0 +1,90 @@
+/*
+Package keyring stores connection links in the keyring of the OS, the Secret Service on Linux (with secret-tool
+of libsecret) and the login keychain on macOS, so that the secret part of a link does not have to be passed
+on the command line, where it is visible in ps output and the shell history.
+
+The link never appears in arguments of the commands run, it is passed over their stdin and stdout.
+When the process runs as root with sudo, the keyring of the user who ran sudo is used.
+*/
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Service is the service attribute of the keyring items, items are told apart by their account name.
+const Service = "goxray-tun"
+
+// ErrNotFound is returned by Get if no link is stored under the name.
+var ErrNotFound = errors.New("link not found in the keyring")
+
+// Get returns the link stored under name.
+func Get(ctx context.Context, name string) (string, error) {
+	cmd, err := getCommand(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	out, err := run(cmd, "")
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("read keyring: %w", err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %w", ErrNotFound, name, err)
+	}
+	link := strings.TrimSpace(out)
+	if link == "" {
+		return "", fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+
+	return link, nil
+}
+
+// Set stores link under name, replacing the one stored before.
+func Set(ctx context.Context, name, link string) error {
+	cmd, stdin, err := setCommand(ctx, name, link)
+	if err != nil {
+		return err
+	}
+	if _, err = run(cmd, stdin); err != nil {
+		return fmt.Errorf("store link %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// Delete removes the link stored under name.
+func Delete(ctx context.Context, name string) error {
+	cmd, err := deleteCommand(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err = run(cmd, ""); err != nil {
+		return fmt.Errorf("delete link %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// run runs cmd as the user who ran sudo, if any, with stdin and returns its stdout.
+// The error includes stderr of the command.
+func run(cmd *exec.Cmd, stdin string) (string, error) {
+	if err := asSudoUser(cmd); err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = strings.NewReader(stdin), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+
+		return "", fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,28 @@
+package keyring
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+func getCommand(ctx context.Context, name string) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, "security", "find-generic-password", "-s", Service, "-a", name, "-w"), nil
+}
+
+// setCommand returns the command storing link. The password of add-generic-password can only be given as
+// an argument, so the command is read by the interactive mode of security from stdin instead.
+func setCommand(ctx context.Context, name, link string) (*exec.Cmd, string, error) {
+	script := "add-generic-password -U -s " + quote(Service) + " -a " + quote(name) + " -w " + quote(link) + "\n"
+
+	return exec.CommandContext(ctx, "security", "-i"), script, nil
+}
+
+func deleteCommand(ctx context.Context, name string) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, "security", "delete-generic-password", "-s", Service, "-a", name), nil
+}
+
+// quote quotes s for the command line of security -i.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,21 @@
+package keyring
+
+import (
+	"context"
+	"os/exec"
+)
+
+func getCommand(ctx context.Context, name string) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, "secret-tool", "lookup", "service", Service, "account", name), nil
+}
+
+// setCommand returns the command storing link, secret-tool reads it from stdin.
+func setCommand(ctx context.Context, name, link string) (*exec.Cmd, string, error) {
+	label := Service + " link " + name
+
+	return exec.CommandContext(ctx, "secret-tool", "store", "--label", label, "service", Service, "account", name), link, nil
+}
+
+func deleteCommand(ctx context.Context, name string) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, "secret-tool", "clear", "service", Service, "account", name), nil
+}
@@ -0,0 +1,53 @@
+package keyring
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSecretTool puts secret-tool keeping one secret in dir in front of PATH, its arguments are logged to args.
+func fakeSecretTool(t *testing.T) (dir string) {
+	t.Helper()
+	dir = t.TempDir()
+	script := `#!/bin/sh
+echo "$@" >> "` + dir + `/args"
+case "$1" in
+store) cat > "` + dir + `/secret" ;;
+lookup) [ -f "` + dir + `/secret" ] && cat "` + dir + `/secret" && echo ;;
+clear) rm -f "` + dir + `/secret" ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret-tool"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SUDO_UID", "")
+
+	return dir
+}
+
+func TestKeyring(t *testing.T) {
+	dir := fakeSecretTool(t)
+	ctx := context.Background()
+	const link = "vless://secret-uuid@example.com:443?security=reality#name"
+
+	_, err := Get(ctx, "default")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, Set(ctx, "default", link))
+	got, err := Get(ctx, "default")
+	require.NoError(t, err)
+	require.Equal(t, link, got)
+
+	require.NoError(t, Delete(ctx, "default"))
+	_, err = Get(ctx, "default")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// The link is passed over stdin only.
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	require.NotContains(t, string(args), "secret-uuid")
+	require.Contains(t, string(args), "store --label goxray-tun link default service goxray-tun account default")
+}
@@ -0,0 +1,27 @@
+//go:build !darwin && !linux
+
+package keyring
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("keyring is supported on Linux and macOS only")
+
+func getCommand(context.Context, string) (*exec.Cmd, error) {
+	return nil, errUnsupported
+}
+
+func setCommand(context.Context, string, string) (*exec.Cmd, string, error) {
+	return nil, "", errUnsupported
+}
+
+func deleteCommand(context.Context, string) (*exec.Cmd, error) {
+	return nil, errUnsupported
+}
+
+func asSudoUser(*exec.Cmd) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build darwin || linux
+
+package keyring
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/goxray/tun/internal/sudo"
+)
+
+// asSudoUser makes cmd run as the user who started the process with sudo, with the keyring of their session.
+// Commands of other processes are left as they are.
+func asSudoUser(cmd *exec.Cmd) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	uid, err := sudo.AsUser(cmd)
+	if errors.Is(err, sudo.ErrNoSudo) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The Secret Service is reached over the session bus of the user.
+	cmd.Env = append(cmd.Env, sudo.SessionBusEnv(uid))
+
+	return nil
+}
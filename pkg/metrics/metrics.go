@@ -0,0 +1,111 @@
+/*
+Package metrics pushes the tunnel metrics to monitoring systems collecting them from the host, e.g. Telegraf
+or Datadog agent over StatsD, or an OpenTelemetry collector over OTLP/HTTP.
+
+Run takes observe.Stats snapshots of the client every interval and passes them as Samples to every Exporter.
+Exporters are pluggable, StatsD and OTLP implement the ones supported out of the box.
+*/
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+const (
+	// DefaultInterval is how often metrics are pushed if no other interval is set.
+	DefaultInterval = 10 * time.Second
+
+	// exportTimeout bounds a single export.
+	exportTimeout = 10 * time.Second
+)
+
+// Kind is the kind of Sample.
+type Kind int
+
+const (
+	Gauge   Kind = iota // Value measured now, e.g. open connections.
+	Counter             // Total growing since the client started, e.g. bytes read.
+)
+
+// Sample is a value of a metric.
+type Sample struct {
+	Name  string // Dot-separated name, e.g. "tcp.active".
+	Kind  Kind
+	Value float64
+}
+
+// Exporter sends samples taken at the same time to a monitoring system.
+type Exporter interface {
+	Export(ctx context.Context, at time.Time, samples []Sample) error
+}
+
+// Run passes samples of src to exporters every interval till ctx is done, failures to export are logged with logger.
+func Run(ctx context.Context, src observe.StatsSource, interval time.Duration, exporters []Exporter, logger *slog.Logger) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case at := <-ticker.C:
+			samples := Samples(src.Stats())
+			for _, e := range exporters {
+				exportCtx, cancel := context.WithTimeout(ctx, exportTimeout)
+				if err := e.Export(exportCtx, at, samples); err != nil {
+					logger.Debug("exporting metrics failed", "exporter", fmt.Sprintf("%T", e), "err", err)
+				}
+				cancel()
+			}
+		}
+	}
+}
+
+// Samples returns the metrics of s. Durations are in seconds.
+func Samples(s observe.Stats) []Sample {
+	samples := []Sample{
+		{"bytes_read", Counter, float64(s.BytesRead)},
+		{"bytes_written", Counter, float64(s.BytesWritten)},
+		{"tcp.active", Gauge, float64(s.ActiveTCP)},
+		{"tcp.peak", Gauge, float64(s.PeakTCP)},
+		{"udp.active", Gauge, float64(s.ActiveUDP)},
+		{"udp.peak", Gauge, float64(s.PeakUDP)},
+		{"memory.sys", Gauge, float64(s.Footprint.Sys)},
+		{"memory.heap_in_use", Gauge, float64(s.Footprint.HeapInUse)},
+		{"goroutines", Gauge, float64(s.Footprint.Goroutines)},
+	}
+	samples = append(samples, latencySamples("first_packet", s.FirstPacket)...)
+	if s.Quality.Probes > 0 {
+		samples = append(samples,
+			Sample{"quality.probes", Counter, float64(s.Quality.Probes)},
+			Sample{"quality.jitter_seconds", Gauge, s.Quality.Jitter.Seconds()},
+			Sample{"quality.loss", Gauge, s.Quality.Loss},
+		)
+		samples = append(samples, latencySamples("quality.rtt", s.Quality.RTT)...)
+	}
+
+	return samples
+}
+
+// latencySamples returns quantiles of l named after prefix, none if nothing was observed.
+func latencySamples(prefix string, l observe.Latency) []Sample {
+	if l.Count == 0 {
+		return nil
+	}
+
+	return []Sample{
+		{prefix + ".count", Counter, float64(l.Count)},
+		{prefix + ".p50_seconds", Gauge, l.P50.Seconds()},
+		{prefix + ".p90_seconds", Gauge, l.P90.Seconds()},
+		{prefix + ".p99_seconds", Gauge, l.P99.Seconds()},
+		{prefix + ".max_seconds", Gauge, l.Max.Seconds()},
+	}
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestSamples(t *testing.T) {
+	samples := Samples(observe.Stats{BytesRead: 100, ActiveTCP: 2})
+	require.Contains(t, samples, Sample{"bytes_read", Counter, 100})
+	require.Contains(t, samples, Sample{"tcp.active", Gauge, 2})
+	for _, s := range samples {
+		require.False(t, strings.HasPrefix(s.Name, "quality."), "probes are disabled")
+		require.False(t, strings.HasPrefix(s.Name, "first_packet."), "nothing was observed")
+	}
+
+	samples = Samples(observe.Stats{FirstPacket: observe.Latency{Count: 3, P50: 250 * time.Millisecond}})
+	require.Contains(t, samples, Sample{"first_packet.count", Counter, 3})
+	require.Contains(t, samples, Sample{"first_packet.p50_seconds", Gauge, 0.25})
+}
+
+func TestStatsD(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	s, err := NewStatsD(server.LocalAddr().String(), "goxray.")
+	require.NoError(t, err)
+	defer s.Close()
+
+	receive := func() string {
+		t.Helper()
+		buf := make([]byte, maxStatsDDatagram)
+		require.NoError(t, server.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := server.ReadFrom(buf)
+		require.NoError(t, err)
+
+		return string(buf[:n])
+	}
+
+	require.NoError(t, s.Export(context.Background(), time.Now(), []Sample{
+		{"bytes_read", Counter, 100}, {"tcp.active", Gauge, 2}, {"quality.loss", Gauge, 0.25},
+	}))
+	require.Equal(t, "goxray.bytes_read:100|c\ngoxray.tcp.active:2|g\ngoxray.quality.loss:0.25|g", receive())
+
+	// Counters are sent as increments since the previous export.
+	require.NoError(t, s.Export(context.Background(), time.Now(), []Sample{{"bytes_read", Counter, 150}}))
+	require.Equal(t, "goxray.bytes_read:50|c", receive())
+
+	// Samples are split across datagrams.
+	many := make([]Sample, 200)
+	for i := range many {
+		many[i] = Sample{"tcp.active", Gauge, 1}
+	}
+	require.NoError(t, s.Export(context.Background(), time.Now(), many))
+	lines := 0
+	for lines < len(many) {
+		msg := receive()
+		require.LessOrEqual(t, len(msg), maxStatsDDatagram)
+		lines += strings.Count(msg, "\n") + 1
+	}
+	require.Equal(t, len(many), lines)
+}
+
+func TestOTLP(t *testing.T) {
+	requests := make(chan map[string]any, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/metrics", r.URL.Path)
+		var req map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests <- req
+	}))
+	defer collector.Close()
+
+	o, err := NewOTLP(OTLPOptions{Endpoint: collector.URL})
+	require.NoError(t, err)
+	at := time.Unix(1700000000, 0)
+	require.NoError(t, o.Export(context.Background(), at, []Sample{{"bytes_read", Counter, 100}, {"tcp.active", Gauge, 2}}))
+
+	b, err := json.Marshal((<-requests)["resourceMetrics"].([]any)[0].(map[string]any)["scopeMetrics"].([]any)[0].(map[string]any)["metrics"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"name": "bytes_read", "sum": {"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": [
+			{"startTimeUnixNano": "`+otlpTime(o.started)+`", "timeUnixNano": "1700000000000000000", "asDouble": 100}]}},
+		{"name": "tcp.active", "gauge": {"dataPoints": [{"timeUnixNano": "1700000000000000000", "asDouble": 2}]}}
+	]`, string(b))
+}
+
+func otlpTime(t time.Time) string {
+	b, _ := json.Marshal(t.UnixNano())
+
+	return string(b)
+}
+
+// recordingExporter keeps the samples of every export.
+type recordingExporter struct {
+	mu      sync.Mutex
+	exports [][]Sample
+}
+
+func (e *recordingExporter) Export(_ context.Context, _ time.Time, samples []Sample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.exports = append(e.exports, samples)
+
+	return nil
+}
+
+type statsFunc func() observe.Stats
+
+func (f statsFunc) Stats() observe.Stats { return f() }
+
+func TestRun(t *testing.T) {
+	var first, second recordingExporter
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(ctx, statsFunc(func() observe.Stats { return observe.Stats{BytesRead: 1} }), 10*time.Millisecond,
+			[]Exporter{&first, &second}, slog.New(slog.DiscardHandler))
+	}()
+
+	require.Eventually(t, func() bool {
+		second.mu.Lock()
+		defer second.mu.Unlock()
+
+		return len(second.exports) >= 2
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+	require.Contains(t, first.exports[0], Sample{"bytes_read", Counter, 1})
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/goxray/tun/pkg/otlp"
+)
+
+// metricsPath is the OTLP/HTTP path of metrics, it is added to endpoints without a path.
+const metricsPath = "/v1/metrics"
+
+// OTLPOptions configure OTLP.
+type OTLPOptions struct {
+	// Endpoint is the OTLP/HTTP endpoint of the collector, e.g. "http://localhost:4318".
+	// The metrics path /v1/metrics is added if Endpoint has no path.
+	Endpoint string
+	// Headers are sent with every request, e.g. the API key of a hosted collector.
+	Headers map[string]string
+	// ServiceName is the service.name resource attribute (default: otlp.DefaultServiceName).
+	ServiceName string
+}
+
+// OTLP exports samples to an OpenTelemetry collector over OTLP/HTTP with JSON encoding.
+// Gauges are sent as gauges, counters as cumulative monotonic sums since OTLP was created.
+type OTLP struct {
+	opts    OTLPOptions
+	client  *otlp.Client
+	started time.Time
+}
+
+// NewOTLP creates OTLP sending to opts.Endpoint.
+func NewOTLP(opts OTLPOptions) (*OTLP, error) {
+	client, err := otlp.NewClient(opts.Endpoint, metricsPath, opts.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OTLP{opts: opts, client: client, started: time.Now()}, nil
+}
+
+// Export implements Exporter.
+func (o *OTLP) Export(ctx context.Context, at time.Time, samples []Sample) error {
+	metrics := make([]otlpMetric, 0, len(samples))
+	for _, s := range samples {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		m := otlpMetric{Name: s.Name}
+		point := otlpDataPoint{TimeUnixNano: otlp.UnixNano(at), AsDouble: s.Value}
+		if s.Kind == Counter {
+			point.StartTimeUnixNano = otlp.UnixNano(o.started)
+			m.Sum = &otlpSum{AggregationTemporality: aggregationCumulative, IsMonotonic: true,
+				DataPoints: []otlpDataPoint{point}}
+		} else {
+			m.Gauge = &otlpGauge{DataPoints: []otlpDataPoint{point}}
+		}
+		metrics = append(metrics, m)
+	}
+
+	return o.client.Post(ctx, otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlp.ServiceResource(o.opts.ServiceName),
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlp.Scope{Name: otlp.ScopeName}, Metrics: metrics}},
+	}}})
+}
+
+// OTLP JSON encoding of ExportMetricsServiceRequest.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlp.Resource      `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlp.Scope   `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	StartTimeUnixNano string  `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string  `json:"timeUnixNano"`
+	AsDouble          float64 `json:"asDouble"`
+}
+
+// aggregationCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE of OTLP.
+const aggregationCumulative = 2
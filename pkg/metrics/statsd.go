@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxStatsDDatagram keeps datagrams within a single unfragmented packet on common links.
+const maxStatsDDatagram = 1432
+
+// StatsD exports samples to a StatsD server over UDP, e.g. Telegraf or Datadog agent.
+// Gauges are sent as they are, counters as increments since the previous export.
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+
+	mu   sync.Mutex
+	sent map[string]float64 // Counter values sent so far, by name.
+}
+
+// NewStatsD creates StatsD sending to addr as host:port. Prefix is prepended to the metric names, e.g. "goxray.".
+func NewStatsD(addr, prefix string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial statsd: %w", err)
+	}
+
+	return &StatsD{conn: conn, prefix: prefix, sent: make(map[string]float64)}, nil
+}
+
+// Export implements Exporter.
+func (s *StatsD) Export(_ context.Context, _ time.Time, samples []Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var msg []byte
+	var errs []error
+	flush := func() {
+		if len(msg) == 0 {
+			return
+		}
+		if _, err := s.conn.Write(bytes.TrimSuffix(msg, []byte("\n"))); err != nil {
+			errs = append(errs, err)
+		}
+		msg = msg[:0]
+	}
+	for _, sample := range samples {
+		value, typ := sample.Value, "g"
+		if sample.Kind == Counter {
+			value, typ = sample.Value-s.sent[sample.Name], "c"
+			if value < 0 { // The counter started over, e.g. another client was passed to Run.
+				value = sample.Value
+			}
+			s.sent[sample.Name] = sample.Value
+		}
+		line := fmt.Sprintf("%s%s:%s|%s\n", s.prefix, sample.Name, strconv.FormatFloat(value, 'f', -1, 64), typ)
+		if len(msg)+len(line) > maxStatsDDatagram {
+			flush()
+		}
+		msg = append(msg, line...)
+	}
+	flush()
+	if len(errs) > 0 {
+		return fmt.Errorf("send statsd metrics: %w", errs[0])
+	}
+
+	return nil
+}
+
+// Close closes the socket of the exporter.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
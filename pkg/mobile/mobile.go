@@ -0,0 +1,179 @@
+/*
+Package mobile provides bindings of the client for Android and iOS apps, built with gomobile:
+
+	gomobile bind -target=android github.com/goxray/tun/pkg/mobile
+
+The app creates the TUN device (VpnService on Android, NEPacketTunnelProvider on iOS) and passes its
+file descriptor to Tunnel.Start, routing stays under control of the platform. The API only uses types
+supported by gomobile, structured values are passed as JSON strings.
+*/
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/xtls/xray-core/transport/internet"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// Listener receives events of the Tunnel, see observe.EventType for the list of events.
+//
+// OnEvent is called synchronously, implementations must not block.
+type Listener interface {
+	OnEvent(event string, attrsJSON string)
+}
+
+// SocketProtector excludes sockets of XRay connections from the VPN, so that they do not loop
+// through the TUN device, e.g. with Android VpnService.protect.
+type SocketProtector interface {
+	Protect(fd int) bool
+}
+
+// options is the JSON configuration accepted by NewTunnel.
+type options struct {
+	LogLevel              string `json:"log_level"` // debug, info, warn or error (default: info).
+	UDPTimeoutSeconds     int    `json:"udp_timeout_seconds"`
+	MaxUDPSessions        int    `json:"max_udp_sessions"`
+	MaxTCPConnections     int    `json:"max_tcp_connections"`
+	TCPIdleTimeoutSeconds int    `json:"tcp_idle_timeout_seconds"`
+}
+
+// config converts options to client.Config.
+func (o options) config(listener Listener) (client.Config, error) {
+	var level slog.Level
+	if o.LogLevel != "" {
+		if err := level.UnmarshalText([]byte(o.LogLevel)); err != nil {
+			return client.Config{}, fmt.Errorf("invalid log level %q: %w", o.LogLevel, err)
+		}
+	}
+
+	// Routes are managed by the platform, so the gateway is not needed and is not discovered.
+	gateway := net.IPv4zero
+	cfg := client.Config{
+		GatewayIP:         &gateway,
+		Logger:            slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})),
+		UDPTimeout:        time.Duration(o.UDPTimeoutSeconds) * time.Second,
+		MaxUDPSessions:    o.MaxUDPSessions,
+		MaxTCPConnections: o.MaxTCPConnections,
+		TCPIdleTimeout:    time.Duration(o.TCPIdleTimeoutSeconds) * time.Second,
+	}
+	if listener != nil {
+		cfg.Observer = observe.ObserverFunc(func(e observe.Event) {
+			attrs, _ := json.Marshal(e.Attrs)
+			listener.OnEvent(string(e.Type), string(attrs))
+		})
+	}
+
+	return cfg, nil
+}
+
+// Tunnel pipes traffic of the TUN device created by the app through the XRay server.
+type Tunnel struct {
+	client *client.Client
+}
+
+// NewTunnel creates Tunnel configured with optionsJSON (empty for defaults), events are passed to listener
+// if it is not nil. Recognised options are log_level, udp_timeout_seconds, max_udp_sessions,
+// max_tcp_connections and tcp_idle_timeout_seconds, see client.Config for details.
+func NewTunnel(optionsJSON string, listener Listener) (*Tunnel, error) {
+	var opts options
+	if optionsJSON != "" {
+		if err := json.Unmarshal([]byte(optionsJSON), &opts); err != nil {
+			return nil, fmt.Errorf("parse options: %w", err)
+		}
+	}
+
+	cfg, err := opts.config(listener)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.NewClientWithOpts(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+
+	return &Tunnel{client: c}, nil
+}
+
+// Start connects to the server of the link and pipes traffic of the TUN device fd through it.
+// The Tunnel takes ownership of fd.
+func (t *Tunnel) Start(fd int, link string) error {
+	return t.client.ConnectWithTUN(fd, link)
+}
+
+// Stop disconnects the Tunnel and closes the TUN device.
+func (t *Tunnel) Stop() error {
+	return t.client.Disconnect(context.Background())
+}
+
+// StatusJSON returns client.Status encoded as JSON.
+func (t *Tunnel) StatusJSON() string {
+	b, _ := json.Marshal(t.client.Status())
+
+	return string(b)
+}
+
+// ValidateLink parses the link and resolves its server, it returns client.LinkInfo encoded as JSON.
+func ValidateLink(link string) (string, error) {
+	info, err := client.ValidateLink(link)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return "", fmt.Errorf("encode link info: %w", err)
+	}
+
+	return string(b), nil
+}
+
+var (
+	protector    atomic.Pointer[SocketProtector]
+	registerOnce sync.Once
+	registerErr  error
+)
+
+// SetSocketProtector sets p to protect every socket dialed by XRay, it must be set before Tunnel.Start
+// on Android. Passing nil stops protecting new sockets.
+func SetSocketProtector(p SocketProtector) error {
+	protector.Store(&p)
+	registerOnce.Do(func() {
+		registerErr = internet.RegisterDialerController(protectSocket)
+	})
+
+	return registerErr
+}
+
+// errProtect is returned for XRay sockets SocketProtector failed to protect.
+var errProtect = errors.New("socket protection failed")
+
+// protectSocket passes descriptor of the socket being dialed to SocketProtector.
+func protectSocket(_, _ string, conn syscall.RawConn) error {
+	p := protector.Load()
+	if p == nil || *p == nil {
+		return nil
+	}
+
+	var ok bool
+	if err := conn.Control(func(fd uintptr) { ok = (*p).Protect(int(fd)) }); err != nil {
+		return fmt.Errorf("control socket: %w", err)
+	}
+	if !ok {
+		return errProtect
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package mobile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+type listenerFunc func(event, attrsJSON string)
+
+func (f listenerFunc) OnEvent(event, attrsJSON string) {
+	f(event, attrsJSON)
+}
+
+func TestOptionsConfig(t *testing.T) {
+	var got []string
+	cfg, err := options{LogLevel: "debug", UDPTimeoutSeconds: 5}.config(listenerFunc(func(event, attrs string) {
+		got = append(got, event, attrs)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "5s", cfg.UDPTimeout.String())
+	require.True(t, cfg.GatewayIP.IsUnspecified())
+
+	cfg.Observer.Observe(observe.NewEvent(observe.EventFlowRejected, "dst", "1.1.1.1:443"))
+	require.Equal(t, []string{"flow_rejected", `{"dst":"1.1.1.1:443"}`}, got)
+
+	_, err = options{LogLevel: "loud"}.config(nil)
+	require.Error(t, err)
+
+	_, err = NewTunnel("{", nil)
+	require.ErrorContains(t, err, "parse options")
+}
+
+func TestValidateLink(t *testing.T) {
+	info, err := ValidateLink("trojan://password@1.2.3.4:8443?sni=example.com")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"protocol":"trojan","server":"1.2.3.4:8443","server_ip":"1.2.3.4","security":"tls","network":"tcp","sni":"example.com"}`, info)
+}
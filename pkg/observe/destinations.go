@@ -0,0 +1,124 @@
+package observe
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// maxDestinations bounds the number of addresses FlowTable keeps totals of closed flows for.
+// Once it is reached, the address with the least traffic is forgotten to make room for a new one.
+const maxDestinations = 4096
+
+// Destination is the traffic exchanged with a destination since the FlowTable was created, see FlowTable.TopDestinations.
+type Destination struct {
+	Host     string       `json:"host,omitempty"` // Host name of the addresses from NameCache, empty if unknown.
+	Addrs    []netip.Addr `json:"addrs"`          // Addresses of the destination, several if they share Host.
+	Sent     int64        `json:"sent"`           // Bytes sent to the destination.
+	Received int64        `json:"received"`       // Bytes received from the destination.
+	Flows    int          `json:"flows"`          // Flows opened to the destination, closed ones included.
+}
+
+// Bytes returns the bytes sent and received.
+func (d Destination) Bytes() int64 {
+	return d.Sent + d.Received
+}
+
+// String formats the destination as its host name, falling back to the address, with the traffic totals.
+func (d Destination) String() string {
+	name := d.Host
+	if name == "" && len(d.Addrs) > 0 {
+		name = d.Addrs[0].String()
+	}
+
+	return fmt.Sprintf("%s sent %d received %d flows %d", name, d.Sent, d.Received, d.Flows)
+}
+
+// addDestination adds traffic of the flow removed from the table to the totals of its address, t.mu must be held.
+func (t *FlowTable) addDestination(f *Flow) {
+	addr := f.Dst.Addr()
+	d, ok := t.dests[addr]
+	if !ok {
+		if len(t.dests) >= maxDestinations {
+			t.dropSmallestDestination()
+		}
+		d = &Destination{Addrs: []netip.Addr{addr}}
+		t.dests[addr] = d
+	}
+	d.Sent += f.sent.Load()
+	d.Received += f.received.Load()
+	d.Flows++
+}
+
+// dropSmallestDestination forgets the address with the least traffic, t.mu must be held.
+func (t *FlowTable) dropSmallestDestination() {
+	var smallest netip.Addr
+	least := int64(-1)
+	for addr, d := range t.dests {
+		if least < 0 || d.Bytes() < least {
+			smallest, least = addr, d.Bytes()
+		}
+	}
+	delete(t.dests, smallest)
+}
+
+// TopDestinations returns up to n destinations which exchanged the most bytes through the tunnel,
+// active flows included, n <= 0 returns all of them. Addresses with the same host name in names
+// are merged into one destination, names may be nil.
+func (t *FlowTable) TopDestinations(n int, names NameCache) []Destination {
+	t.mu.Lock()
+	byAddr := make(map[netip.Addr]Destination, len(t.dests))
+	for addr, d := range t.dests {
+		byAddr[addr] = *d
+	}
+	for _, f := range t.flows {
+		addr := f.Dst.Addr()
+		d, ok := byAddr[addr]
+		if !ok {
+			d = Destination{Addrs: []netip.Addr{addr}}
+		}
+		d.Sent += f.sent.Load()
+		d.Received += f.received.Load()
+		d.Flows++
+		byAddr[addr] = d
+	}
+	t.mu.Unlock()
+
+	byHost := make(map[string]int)
+	dests := make([]Destination, 0, len(byAddr))
+	for addr, d := range byAddr {
+		if names != nil {
+			d.Host, _ = names.Lookup(addr)
+		}
+		i, ok := byHost[d.Host]
+		if d.Host == "" || !ok {
+			if d.Host != "" {
+				byHost[d.Host] = len(dests)
+			}
+			dests = append(dests, d)
+
+			continue
+		}
+		dests[i].Addrs = append(dests[i].Addrs, addr)
+		dests[i].Sent += d.Sent
+		dests[i].Received += d.Received
+		dests[i].Flows += d.Flows
+	}
+
+	slices.SortFunc(dests, func(a, b Destination) int {
+		if c := cmp.Compare(b.Bytes(), a.Bytes()); c != 0 {
+			return c
+		}
+
+		return a.Addrs[0].Compare(b.Addrs[0])
+	})
+	for _, d := range dests {
+		slices.SortFunc(d.Addrs, netip.Addr.Compare)
+	}
+	if n > 0 && len(dests) > n {
+		dests = dests[:n]
+	}
+
+	return dests
+}
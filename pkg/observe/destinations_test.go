@@ -0,0 +1,81 @@
+package observe
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticNames map[netip.Addr]string
+
+func (n staticNames) Lookup(addr netip.Addr) (string, bool) {
+	host, ok := n[addr]
+	return host, ok
+}
+
+type recordedFlows []FlowInfo
+
+func (r *recordedFlows) RecordFlow(f FlowInfo) {
+	*r = append(*r, f)
+}
+
+func TestTopDestinations(t *testing.T) {
+	table := NewFlowTable()
+	var recorded recordedFlows
+	table.SetRecorder(&recorded)
+	src := netip.MustParseAddrPort("192.18.0.1:50000")
+	github1 := netip.MustParseAddrPort("140.82.121.3:443")
+	github2 := netip.MustParseAddrPort("140.82.121.4:443")
+	dns := netip.MustParseAddrPort("1.1.1.1:53")
+
+	transfer := func(network Network, dst netip.AddrPort, sent, received int) *Flow {
+		f, ok := table.Reserve(network, src, dst, 0)
+		require.True(t, ok)
+		local, remote := net.Pipe()
+		conn := table.TrackConn(local, f)
+		go func() {
+			_, _ = remote.Write(make([]byte, received))
+			_, _ = remote.Read(make([]byte, sent))
+		}()
+		_, err := conn.Read(make([]byte, received))
+		require.NoError(t, err)
+		_, err = conn.Write(make([]byte, sent))
+		require.NoError(t, err)
+
+		return f
+	}
+
+	table.Remove(transfer(TCP, github1, 100, 1000))
+	table.Remove(transfer(TCP, github2, 50, 500))
+	table.Remove(transfer(UDP, dns, 40, 80))
+	active := transfer(TCP, github1, 10, 20)
+	require.Equal(t, int64(10), active.Info().Sent)
+	require.Equal(t, int64(20), active.Info().Received)
+	require.Equal(t, int64(1), active.Info().PacketsSent)
+	require.Equal(t, int64(1), active.Info().PacketsReceived)
+	require.Len(t, recorded, 3)
+	require.Equal(t, dns, recorded[2].Dst)
+	require.Equal(t, int64(40), recorded[2].Sent)
+	require.Equal(t, int64(80), recorded[2].Received)
+
+	dests := table.TopDestinations(0, nil)
+	require.Equal(t, []Destination{
+		{Addrs: []netip.Addr{github1.Addr()}, Sent: 110, Received: 1020, Flows: 2},
+		{Addrs: []netip.Addr{github2.Addr()}, Sent: 50, Received: 500, Flows: 1},
+		{Addrs: []netip.Addr{dns.Addr()}, Sent: 40, Received: 80, Flows: 1},
+	}, dests)
+
+	names := staticNames{github1.Addr(): "github.com", github2.Addr(): "github.com"}
+	dests = table.TopDestinations(1, names)
+	require.Equal(t, []Destination{
+		{Host: "github.com", Addrs: []netip.Addr{github1.Addr(), github2.Addr()}, Sent: 160, Received: 1520, Flows: 3},
+	}, dests)
+	require.Equal(t, "github.com sent 160 received 1520 flows 3", dests[0].String())
+
+	table.Remove(active)
+	table.Remove(active) // Removed already, not recorded again.
+	require.Len(t, table.TopDestinations(0, names), 2)
+	require.Len(t, recorded, 4)
+}
@@ -0,0 +1,452 @@
+package observe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Network is the transport protocol of a Flow.
+type Network uint8
+
+const (
+	TCP Network = iota
+	UDP
+)
+
+func (n Network) String() string {
+	switch n {
+	case TCP:
+		return "tcp"
+	case UDP:
+		return "udp"
+	default:
+		return fmt.Sprintf("network(%d)", n)
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (n Network) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (n *Network) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "tcp":
+		*n = TCP
+	case "udp":
+		*n = UDP
+	default:
+		return fmt.Errorf("unknown network %q", text)
+	}
+
+	return nil
+}
+
+// FlowTable keeps track of every flow going through the tunnel.
+type FlowTable struct {
+	mu     sync.Mutex
+	seq    uint64
+	flows  map[uint64]*Flow
+	active map[Network]int
+	peak   map[Network]int
+	dests  map[netip.Addr]*Destination // Traffic of removed flows by destination address.
+	record FlowRecorder                // Receives removed flows, may be nil.
+
+	firstPacket LatencyWindow
+
+	lastRead  atomic.Int64 // Unix nanoseconds of the last data read from a tracked connection.
+	lastWrite atomic.Int64 // Unix nanoseconds of the last data written to a tracked connection.
+}
+
+// Flow is a single TCP connection or UDP session going through the tunnel.
+type Flow struct {
+	ID      uint64
+	Network Network
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+	Started time.Time
+
+	lastSeen atomic.Int64 // Unix nanoseconds of the last read or write.
+	sent     atomic.Int64 // Bytes written to the connection.
+	received atomic.Int64 // Bytes read from the connection.
+	// Writes and reads with data, i.e. datagrams of UDP sessions.
+	packetsSent, packetsReceived atomic.Int64
+
+	process     atomic.Pointer[Process]
+	procChecked atomic.Bool // Whether process lookup was done, see FlowTable.ResolveProcesses.
+
+	mu     sync.Mutex
+	closed bool
+	closer func() error
+}
+
+// FlowInfo is a point in time copy of Flow.
+type FlowInfo struct {
+	ID       uint64
+	Network  Network
+	Src      netip.AddrPort
+	Dst      netip.AddrPort
+	Started  time.Time
+	LastSeen time.Time
+	Sent     int64 // Bytes sent to Dst.
+	Received int64 // Bytes received from Dst.
+	// PacketsSent and PacketsReceived count datagrams of UDP sessions. TCP segments are not seen by the
+	// client, writes and reads of TCP connections are counted instead.
+	PacketsSent     int64
+	PacketsReceived int64
+
+	Host    string // Host name of Dst from NameCache, empty if unknown.
+	Service string // Well-known service name of Dst port, empty if unknown.
+	// Process owning the flow, nil if unknown, see FlowTable.ResolveProcesses.
+	Process *Process
+}
+
+// NewFlowTable creates an empty FlowTable.
+func NewFlowTable() *FlowTable {
+	return &FlowTable{
+		flows:  make(map[uint64]*Flow),
+		active: make(map[Network]int),
+		peak:   make(map[Network]int),
+		dests:  make(map[netip.Addr]*Destination),
+	}
+}
+
+// Reserve registers new flow before it is dialed.
+// It returns false if there are already limit flows of the same network, limit <= 0 means no limit.
+//
+// The connection must be attached to the reserved flow with TrackConn or TrackPacketConn once dialed,
+// or the flow must be removed if dial fails.
+func (t *FlowTable) Reserve(network Network, src, dst netip.AddrPort, limit int) (*Flow, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if limit > 0 && t.active[network] >= limit {
+		return nil, false
+	}
+
+	t.seq++
+	f := &Flow{
+		ID:      t.seq,
+		Network: network,
+		Src:     src,
+		Dst:     dst,
+		Started: time.Now(),
+	}
+	f.touch()
+	t.flows[f.ID] = f
+	t.active[network]++
+	t.peak[network] = max(t.peak[network], t.active[network])
+
+	return f, true
+}
+
+// SetRecorder makes r receive every flow removed from the table from now on, nil stops it.
+func (t *FlowTable) SetRecorder(r FlowRecorder) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.record = r
+}
+
+// Remove unregisters the flow, it does not close it. The flow is passed to the FlowRecorder, if any.
+func (t *FlowTable) Remove(f *Flow) {
+	t.mu.Lock()
+	if _, ok := t.flows[f.ID]; !ok {
+		t.mu.Unlock()
+
+		return
+	}
+	delete(t.flows, f.ID)
+	t.active[f.Network]--
+	t.addDestination(f)
+	record := t.record
+	t.mu.Unlock()
+
+	if record != nil {
+		record.RecordFlow(f.Info())
+	}
+}
+
+// Count returns number of active flows of the given network.
+func (t *FlowTable) Count(network Network) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.active[network]
+}
+
+// Peak returns the highest number of simultaneously active flows of the given network.
+func (t *FlowTable) Peak(network Network) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.peak[network]
+}
+
+// ObserveFirstPacket records the time a new TCP connection took from its first packet seen on the TUN device
+// until the first data from the server arrived. XRay confirms socks connections before reaching the server,
+// so the first data is the earliest sign the server was reached, connections closed before it are not recorded.
+func (t *FlowTable) ObserveFirstPacket(d time.Duration) {
+	t.firstPacket.Observe(d)
+}
+
+// FirstPacket summarizes the latest first-packet latencies, see ObserveFirstPacket.
+func (t *FlowTable) FirstPacket() Latency {
+	return t.firstPacket.Summary()
+}
+
+// Idlest returns the flow of the given network with the oldest activity, or nil if there are none.
+func (t *FlowTable) Idlest(network Network) *Flow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var found *Flow
+	for _, f := range t.flows {
+		if f.Network != network {
+			continue
+		}
+		if found == nil || f.lastSeen.Load() < found.lastSeen.Load() {
+			found = f
+		}
+	}
+
+	return found
+}
+
+// IdleFor returns flows of the given network with no activity for at least d.
+func (t *FlowTable) IdleFor(network Network, d time.Duration) []*Flow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var found []*Flow
+	for _, f := range t.flows {
+		if f.Network == network && f.Idle() >= d {
+			found = append(found, f)
+		}
+	}
+
+	return found
+}
+
+// ActiveSince reports whether a flow of the given network had activity after since.
+func (t *FlowTable) ActiveSince(network Network, since time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, f := range t.flows {
+		if f.Network == network && f.lastSeen.Load() > since.UnixNano() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LastReadAt returns time data was last read from any tracked connection, i.e. received through the tunnel,
+// or zero time if nothing was read yet.
+func (t *FlowTable) LastReadAt() time.Time {
+	return unixTime(t.lastRead.Load())
+}
+
+// LastWriteAt returns time data was last written to any tracked connection, i.e. sent through the tunnel,
+// or zero time if nothing was written yet.
+func (t *FlowTable) LastWriteAt() time.Time {
+	return unixTime(t.lastWrite.Load())
+}
+
+func unixTime(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nsec)
+}
+
+// Snapshot returns copies of all active flows.
+func (t *FlowTable) Snapshot() []FlowInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	infos := make([]FlowInfo, 0, len(t.flows))
+	for _, f := range t.flows {
+		infos = append(infos, f.Info())
+	}
+
+	return infos
+}
+
+// CloseAll closes and unregisters every tracked flow.
+func (t *FlowTable) CloseAll() {
+	t.mu.Lock()
+	flows := make([]*Flow, 0, len(t.flows))
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	t.mu.Unlock()
+
+	for _, f := range flows {
+		t.Remove(f)
+		_ = f.Close()
+	}
+}
+
+// Info returns a point in time copy of the flow.
+func (f *Flow) Info() FlowInfo {
+	return FlowInfo{
+		ID:       f.ID,
+		Network:  f.Network,
+		Src:      f.Src,
+		Dst:      f.Dst,
+		Started:  f.Started,
+		LastSeen: time.Unix(0, f.lastSeen.Load()),
+		Sent:     f.sent.Load(),
+		Received: f.received.Load(),
+		Service:  ServiceName(f.Network, f.Dst.Port()),
+		Process:  f.process.Load(),
+
+		PacketsSent:     f.packetsSent.Load(),
+		PacketsReceived: f.packetsReceived.Load(),
+	}
+}
+
+// Idle returns the time passed since the last activity on the flow.
+func (f *Flow) Idle() time.Duration {
+	return time.Since(time.Unix(0, f.lastSeen.Load()))
+}
+
+// Close closes the underlying connection, it is safe to call Close multiple times.
+func (f *Flow) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.closer == nil {
+		return nil
+	}
+
+	return f.closer()
+}
+
+func (f *Flow) touch() {
+	f.lastSeen.Store(time.Now().UnixNano())
+}
+
+// attach sets closer for the flow. If the flow was closed while dialing, closer is called right away.
+func (f *Flow) attach(closer func() error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closer = closer
+	if f.closed {
+		_ = closer()
+	}
+}
+
+// trackedConn updates flow activity on every read and write and unregisters the flow on Close.
+type trackedConn struct {
+	net.Conn
+
+	flow  *Flow
+	table *FlowTable
+}
+
+// TrackConn attaches c to the reserved flow f.
+func (t *FlowTable) TrackConn(c net.Conn, f *Flow) net.Conn {
+	f.attach(c.Close)
+
+	return &trackedConn{Conn: c, flow: f, table: t}
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.flow.touch()
+		c.flow.received.Add(int64(n))
+		c.flow.packetsReceived.Add(1)
+		c.table.lastRead.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.flow.touch()
+		c.flow.sent.Add(int64(n))
+		c.flow.packetsSent.Add(1)
+		c.table.lastWrite.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.table.Remove(c.flow)
+
+	return c.flow.Close()
+}
+
+// CloseWrite half-closes the connection, if it supports it, so that tracking does not keep the end of data
+// from reaching the peer. It returns errors.ErrUnsupported otherwise.
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return errors.ErrUnsupported
+}
+
+// trackedPacketConn updates flow activity on every packet and unregisters the flow on Close.
+type trackedPacketConn struct {
+	net.PacketConn
+
+	flow  *Flow
+	table *FlowTable
+}
+
+// TrackPacketConn attaches pc to the reserved flow f.
+func (t *FlowTable) TrackPacketConn(pc net.PacketConn, f *Flow) net.PacketConn {
+	f.attach(pc.Close)
+
+	return &trackedPacketConn{PacketConn: pc, flow: f, table: t}
+}
+
+func (c *trackedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if n > 0 {
+		c.flow.touch()
+		c.flow.received.Add(int64(n))
+		c.flow.packetsReceived.Add(1)
+		c.table.lastRead.Store(time.Now().UnixNano())
+	}
+
+	return n, addr, err
+}
+
+func (c *trackedPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(p, addr)
+	if n > 0 {
+		c.flow.touch()
+		c.flow.sent.Add(int64(n))
+		c.flow.packetsSent.Add(1)
+		c.table.lastWrite.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (c *trackedPacketConn) Close() error {
+	c.table.Remove(c.flow)
+
+	return c.flow.Close()
+}
@@ -0,0 +1,66 @@
+package observe
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencyWindowSize is the number of the latest samples LatencyWindow summarizes.
+const latencyWindowSize = 1024
+
+// Latency summarizes the latest samples of a latency.
+type Latency struct {
+	Count int // Samples observed in total.
+	// Quantiles and maximum of the latest samples, zero if none were observed.
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// LatencyWindow keeps the latest samples of a latency. It is safe for concurrent use,
+// the zero value is ready to use.
+type LatencyWindow struct {
+	mu      sync.Mutex
+	samples [latencyWindowSize]time.Duration
+	count   int
+}
+
+// Observe adds sample d, replacing the oldest one once the window is full.
+func (w *LatencyWindow) Observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.count%latencyWindowSize] = d
+	w.count++
+}
+
+// Summary returns quantiles of the samples in the window.
+func (w *LatencyWindow) Summary() Latency {
+	w.mu.Lock()
+	sorted := slices.Clone(w.samples[:min(w.count, latencyWindowSize)])
+	count := w.count
+	w.mu.Unlock()
+
+	return summarize(sorted, count)
+}
+
+// summarize returns quantiles of samples, sorting them in place, with count of samples observed in total.
+func summarize(samples []time.Duration, count int) Latency {
+	if len(samples) == 0 {
+		return Latency{Count: count}
+	}
+	slices.Sort(samples)
+	quantile := func(q float64) time.Duration {
+		return samples[int(q*float64(len(samples)-1))]
+	}
+
+	return Latency{
+		Count: count,
+		P50:   quantile(0.5),
+		P90:   quantile(0.9),
+		P99:   quantile(0.99),
+		Max:   samples[len(samples)-1],
+	}
+}
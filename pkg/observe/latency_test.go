@@ -0,0 +1,32 @@
+package observe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyWindow(t *testing.T) {
+	var w LatencyWindow
+	require.Equal(t, Latency{}, w.Summary())
+
+	for i := 1; i <= 100; i++ {
+		w.Observe(time.Duration(i) * time.Millisecond)
+	}
+	require.Equal(t, Latency{
+		Count: 100,
+		P50:   50 * time.Millisecond,
+		P90:   90 * time.Millisecond,
+		P99:   99 * time.Millisecond,
+		Max:   100 * time.Millisecond,
+	}, w.Summary())
+
+	// Old samples leave the window.
+	for range latencyWindowSize {
+		w.Observe(time.Millisecond)
+	}
+	s := w.Summary()
+	require.Equal(t, 100+latencyWindowSize, s.Count)
+	require.Equal(t, time.Millisecond, s.Max)
+}
@@ -0,0 +1,157 @@
+package observe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogRecord is a log record kept by LogRing.
+type LogRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	Attrs   string    `json:"attrs,omitempty"` // Attributes formatted as key=value pairs.
+}
+
+func (r LogRecord) String() string {
+	s := fmt.Sprintf("%s %-5s %s", r.Time.Format(time.TimeOnly), r.Level, r.Message)
+	if r.Attrs != "" {
+		s += " " + r.Attrs
+	}
+
+	return s
+}
+
+// logBuffer is the ring of records shared by LogRing and the handlers derived from it.
+type logBuffer struct {
+	mu      sync.Mutex
+	records []LogRecord
+	next    int    // Index of records the next record is stored at.
+	full    bool   // All of records are filled.
+	total   uint64 // Records stored so far, the sequence number of the latest one.
+}
+
+// LogRing is slog.Handler keeping the latest records, e.g. to show them in a status UI, and passing
+// them on to the next handler. Records at Info level and above are kept even if the next handler drops them.
+type LogRing struct {
+	next   slog.Handler
+	buf    *logBuffer
+	prefix string // Attributes added with WithAttrs, formatted.
+	group  string // Prefix of attribute keys added with WithGroup, with a trailing dot.
+}
+
+// NewLogRing returns LogRing keeping size latest records passed on to next.
+func NewLogRing(next slog.Handler, size int) *LogRing {
+	return &LogRing{next: next, buf: &logBuffer{records: make([]LogRecord, max(size, 1))}}
+}
+
+// Enabled implements slog.Handler.
+func (h *LogRing) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler, it keeps r and passes it to the next handler if it is enabled there.
+func (h *LogRing) Handle(ctx context.Context, r slog.Record) error {
+	var attrs strings.Builder
+	attrs.WriteString(h.prefix)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&attrs, h.group, a)
+
+		return true
+	})
+
+	h.buf.mu.Lock()
+	h.buf.records[h.buf.next] = LogRecord{Time: r.Time, Level: r.Level.String(), Message: r.Message, Attrs: attrs.String()}
+	h.buf.next = (h.buf.next + 1) % len(h.buf.records)
+	h.buf.full = h.buf.full || h.buf.next == 0
+	h.buf.total++
+	h.buf.mu.Unlock()
+
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler, the returned handler shares the records with h.
+func (h *LogRing) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var prefix strings.Builder
+	prefix.WriteString(h.prefix)
+	for _, a := range attrs {
+		appendAttr(&prefix, h.group, a)
+	}
+
+	return &LogRing{next: h.next.WithAttrs(attrs), buf: h.buf, prefix: prefix.String(), group: h.group}
+}
+
+// WithGroup implements slog.Handler, the returned handler shares the records with h.
+func (h *LogRing) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &LogRing{next: h.next.WithGroup(name), buf: h.buf, prefix: h.prefix, group: h.group + name + "."}
+}
+
+// Recent returns up to n of the latest records, oldest first. n <= 0 returns all that are kept.
+func (h *LogRing) Recent(n int) []LogRecord {
+	h.buf.mu.Lock()
+	defer h.buf.mu.Unlock()
+
+	return h.buf.recent(n)
+}
+
+// Since returns records kept which were stored after the one with sequence number seq, oldest first,
+// and the sequence number of the latest record to pass next time, e.g. to follow the log.
+// Since(0) returns all that are kept.
+func (h *LogRing) Since(seq uint64) ([]LogRecord, uint64) {
+	h.buf.mu.Lock()
+	defer h.buf.mu.Unlock()
+
+	if seq >= h.buf.total {
+		return nil, h.buf.total
+	}
+
+	return h.buf.recent(int(min(h.buf.total-seq, uint64(len(h.buf.records))))), h.buf.total
+}
+
+// recent returns up to n of the latest records, b.mu must be held.
+func (b *logBuffer) recent(n int) []LogRecord {
+	records := append([]LogRecord(nil), b.records[:b.next]...)
+	if b.full {
+		records = append(append([]LogRecord(nil), b.records[b.next:]...), records...)
+	}
+	if n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+
+	return records
+}
+
+// appendAttr appends a as space separated key=value to b, groups are flattened into dotted keys.
+func appendAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, group, ga)
+		}
+
+		return
+	}
+
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	fmt.Fprintf(b, "%s%s=%v", group, a.Key, a.Value.Any())
+}
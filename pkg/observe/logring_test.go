@@ -0,0 +1,69 @@
+package observe
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRing(t *testing.T) {
+	var out bytes.Buffer
+	ring := NewLogRing(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelError}), 3)
+	logger := slog.New(ring)
+
+	logger.Debug("not kept")
+	logger.Info("connected", "server", "example.com:443")
+	logger.With("subsystem", "dns").WithGroup("probe").Warn("restarted", "failures", 2, slog.Group("err", "msg", "timeout"))
+	logger.Error("failed", "err", "refused")
+	require.Equal(t, 1, bytes.Count(out.Bytes(), []byte("\n")), "only errors are passed on")
+
+	records := ring.Recent(0)
+	require.Len(t, records, 3)
+	require.Equal(t, "INFO", records[0].Level)
+	require.Equal(t, "connected", records[0].Message)
+	require.Equal(t, "server=example.com:443", records[0].Attrs)
+	require.Equal(t, "subsystem=dns probe.failures=2 probe.err.msg=timeout", records[1].Attrs)
+	require.Contains(t, records[2].String(), "ERROR failed err=refused")
+
+	// The oldest records leave the ring.
+	logger.Info("disconnected")
+	records = ring.Recent(2)
+	require.Len(t, records, 2)
+	require.Equal(t, "failed", records[0].Message)
+	require.Equal(t, "disconnected", records[1].Message)
+}
+
+func TestLogRing_Since(t *testing.T) {
+	ring := NewLogRing(slog.DiscardHandler, 3)
+	logger := slog.New(ring)
+
+	records, seq := ring.Since(0)
+	require.Empty(t, records)
+	require.Zero(t, seq)
+
+	logger.Info("first")
+	logger.Info("second")
+	records, seq = ring.Since(0)
+	require.Len(t, records, 2)
+	require.Equal(t, uint64(2), seq)
+
+	records, seq = ring.Since(seq)
+	require.Empty(t, records)
+	require.Equal(t, uint64(2), seq)
+
+	logger.Info("third")
+	records, seq = ring.Since(seq)
+	require.Len(t, records, 1)
+	require.Equal(t, "third", records[0].Message)
+
+	// Records left the ring are skipped.
+	for _, msg := range []string{"4", "5", "6", "7"} {
+		logger.Info(msg)
+	}
+	records, seq = ring.Since(seq)
+	require.Len(t, records, 3)
+	require.Equal(t, "5", records[0].Message)
+	require.Equal(t, uint64(7), seq)
+}
@@ -0,0 +1,104 @@
+package observe
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// IOMetrics wraps io.ReadWriteCloser with simple metrics. It is safe to read metrics concurrently with I/O.
+type IOMetrics struct {
+	io.ReadWriteCloser
+
+	nRead    atomic.Int64
+	nWritten atomic.Int64
+	traffic  *Traffic // Totals the bytes are also added to, nil if none.
+
+	lastRead  atomic.Int64 // Unix nanoseconds of the last successful read.
+	lastWrite atomic.Int64 // Unix nanoseconds of the last successful write.
+}
+
+// NewIOMetrics starts counting bytes going through rw.
+func NewIOMetrics(rw io.ReadWriteCloser) *IOMetrics {
+	m := &IOMetrics{ReadWriteCloser: rw}
+	now := time.Now().UnixNano()
+	m.lastRead.Store(now)
+	m.lastWrite.Store(now)
+
+	return m
+}
+
+// BytesRead returns number of bytes successfully read.
+func (s *IOMetrics) BytesRead() int {
+	return int(s.nRead.Load())
+}
+
+// BytesWritten returns number of bytes successfully written.
+func (s *IOMetrics) BytesWritten() int {
+	return int(s.nWritten.Load())
+}
+
+// LastReadAt returns time of the last successful read, or creation time if nothing was read yet.
+func (s *IOMetrics) LastReadAt() time.Time {
+	return time.Unix(0, s.lastRead.Load())
+}
+
+// LastWriteAt returns time of the last successful write, or creation time if nothing was written yet.
+func (s *IOMetrics) LastWriteAt() time.Time {
+	return time.Unix(0, s.lastWrite.Load())
+}
+
+func (s *IOMetrics) Read(p []byte) (n int, err error) {
+	n, err = s.ReadWriteCloser.Read(p)
+	if err == nil {
+		s.nRead.Add(int64(n))
+		if s.traffic != nil {
+			s.traffic.read.Add(int64(n))
+		}
+		s.lastRead.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (s *IOMetrics) Write(p []byte) (n int, err error) {
+	n, err = s.ReadWriteCloser.Write(p)
+	if err == nil {
+		s.nWritten.Add(int64(n))
+		if s.traffic != nil {
+			s.traffic.written.Add(int64(n))
+		}
+		s.lastWrite.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (s *IOMetrics) Close() error {
+	return s.ReadWriteCloser.Close()
+}
+
+// Traffic accumulates bytes of successive devices, so that the totals survive replacing a device,
+// e.g. when it is reopened or on reconnects. It is safe for concurrent use, the zero value is ready to use.
+type Traffic struct {
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+// Wrap starts counting bytes going through rw, adding them to the totals.
+func (t *Traffic) Wrap(rw io.ReadWriteCloser) *IOMetrics {
+	m := NewIOMetrics(rw)
+	m.traffic = t
+
+	return m
+}
+
+// BytesRead returns number of bytes read from all wrapped devices.
+func (t *Traffic) BytesRead() int {
+	return int(t.read.Load())
+}
+
+// BytesWritten returns number of bytes written to all wrapped devices.
+func (t *Traffic) BytesWritten() int {
+	return int(t.written.Load())
+}
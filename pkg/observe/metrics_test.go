@@ -0,0 +1,59 @@
+package observe
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// loopDevice returns the written data on the following reads.
+type loopDevice struct {
+	bytes.Buffer
+
+	closed bool
+}
+
+func (d *loopDevice) Close() error {
+	d.closed = true
+
+	return nil
+}
+
+func TestMetrics(t *testing.T) {
+	device := &loopDevice{}
+	rwc := NewIOMetrics(device)
+
+	sumRead, sumWrite := 0, 0
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf("data: %d", i))
+		n, err := rwc.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+		sumWrite += len(data)
+
+		buf := make([]byte, len(data)+10)
+		n, err = rwc.Read(buf)
+		require.NoError(t, err)
+		sumRead += n
+	}
+
+	require.NoError(t, rwc.Close())
+	require.True(t, device.closed)
+	require.Equal(t, sumRead, rwc.BytesRead())
+	require.Equal(t, sumWrite, rwc.BytesWritten())
+}
+
+func TestTraffic(t *testing.T) {
+	var traffic Traffic
+	for range 2 { // The second device replaces the first one, e.g. after reopening.
+		rwc := traffic.Wrap(&loopDevice{})
+		_, _ = rwc.Write(make([]byte, 5))
+		_, _ = rwc.Read(make([]byte, 3))
+		require.Equal(t, 3, rwc.BytesRead())
+	}
+
+	require.Equal(t, 6, traffic.BytesRead())
+	require.Equal(t, 10, traffic.BytesWritten())
+}
@@ -0,0 +1,52 @@
+package observe
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// NameCache maps addresses to host names already known to the application, e.g. from DNS responses.
+//
+// Implementations must answer from memory only, Lookup is called on every flow snapshot and must not
+// do network requests.
+type NameCache interface {
+	Lookup(addr netip.Addr) (host string, ok bool)
+}
+
+// services lists names of well-known ports, by network.
+var services = map[Network]map[uint16]string{
+	TCP: {
+		21: "ftp", 22: "ssh", 23: "telnet", 25: "smtp", 53: "dns", 80: "http", 110: "pop3",
+		143: "imap", 443: "https", 465: "smtps", 587: "submission", 853: "dns-over-tls",
+		993: "imaps", 995: "pop3s", 1080: "socks", 3306: "mysql", 3389: "rdp", 5222: "xmpp",
+		5432: "postgresql", 6379: "redis", 8080: "http-alt", 8443: "https-alt",
+	},
+	UDP: {
+		53: "dns", 67: "dhcp", 68: "dhcp", 123: "ntp", 443: "quic", 500: "isakmp", 853: "dns-over-quic",
+		1900: "ssdp", 3478: "stun", 4500: "ipsec-nat-t", 5353: "mdns", 51820: "wireguard",
+	},
+}
+
+// ServiceName returns name of the well-known service on the port, or empty string if the port is not known.
+func ServiceName(network Network, port uint16) string {
+	return services[network][port]
+}
+
+// String formats the flow as "network host:port (service)", falling back to the address when the host
+// is unknown and omitting unknown service. Known owning process is appended as "name[pid]".
+func (i FlowInfo) String() string {
+	dst := i.Dst.String()
+	if i.Host != "" {
+		dst = fmt.Sprintf("%s:%d", i.Host, i.Dst.Port())
+	}
+	if i.Service != "" {
+		dst += " (" + i.Service + ")"
+	}
+
+	s := i.Network.String() + " " + dst
+	if i.Process != nil {
+		s += fmt.Sprintf(" %s[%d]", i.Process.Name, i.Process.PID)
+	}
+
+	return s
+}
@@ -0,0 +1,24 @@
+package observe
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlowInfoString(t *testing.T) {
+	table := NewFlowTable()
+	src := netip.MustParseAddrPort("192.18.0.1:50000")
+
+	f, _ := table.Reserve(TCP, src, netip.MustParseAddrPort("140.82.121.4:443"), 0)
+	info := f.Info()
+	require.Equal(t, "https", info.Service)
+	require.Equal(t, "tcp 140.82.121.4:443 (https)", info.String())
+
+	info.Host = "github.com"
+	require.Equal(t, "tcp github.com:443 (https)", info.String())
+
+	f, _ = table.Reserve(UDP, src, netip.MustParseAddrPort("10.0.0.1:40000"), 0)
+	require.Equal(t, "udp 10.0.0.1:40000", f.Info().String())
+}
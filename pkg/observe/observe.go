@@ -0,0 +1,178 @@
+/*
+Package observe implements the instrumentation model shared by the client and its consumers.
+
+It provides traffic metrics for the TUN device, the table of flows going through the tunnel
+and events emitted by the client. Exporters can be plugged in by implementing Observer
+for events, FlowRecorder for closed flows and by polling a StatsSource for metrics.
+*/
+package observe
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of Event.
+type EventType string
+
+const (
+	EventConnected      EventType = "connected"       // Client has connected to the server.
+	EventDisconnected   EventType = "disconnected"    // Client has disconnected from the server.
+	EventFlowRejected   EventType = "flow_rejected"   // New flow was rejected, e.g. by connection limit.
+	EventFlowEvicted    EventType = "flow_evicted"    // Flow was closed to make room for a new one.
+	EventFlowReaped     EventType = "flow_reaped"     // Flow was closed due to inactivity.
+	EventFDPressure     EventType = "fd_pressure"     // Open file descriptors are close to the limit.
+	EventTUNReopened    EventType = "tun_reopened"    // Wedged TUN device was replaced with a new one.
+	EventRouteRestored  EventType = "route_restored"  // Route removed by other software was installed again.
+	EventAlert          EventType = "alert"           // Alert rule fired, see package alert.
+	EventGatewayChanged EventType = "gateway_changed" // Default gateway changed, e.g. after roaming.
+	EventServerSwitched EventType = "server_switched" // Connection was moved to another server.
+	EventRestarted      EventType = "restarted"       // Failing subsystem was restarted, see client.Config.HealthCheckInterval.
+	EventFailover       EventType = "failover"        // Active outbound was changed by the failover policy, see package failover.
+	EventMTUChanged     EventType = "mtu_changed"     // TUN device was reopened with a lower path MTU, see client.Config.PathMTUProbe.
+	EventTunnelStalled  EventType = "tunnel_stalled"  // Data kept being sent through the tunnel with nothing received, see client.Config.TunnelStallTimeout.
+	EventDegraded       EventType = "degraded"        // New flows are refused as the server is unreachable, see client.Config.BreakerThreshold.
+	EventRecovered      EventType = "recovered"       // Server is reachable again after EventDegraded.
+)
+
+// Event is a notable change in the client state.
+type Event struct {
+	Type  EventType
+	Time  time.Time
+	Attrs map[string]any // Event specific details, e.g. "dst" of the flow.
+}
+
+// NewEvent creates Event of type t happening now. Attrs are set from key-value pairs kv.
+func NewEvent(t EventType, kv ...any) Event {
+	e := Event{Type: t, Time: time.Now(), Attrs: make(map[string]any, len(kv)/2)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if key, ok := kv[i].(string); ok {
+			e.Attrs[key] = kv[i+1]
+		}
+	}
+
+	return e
+}
+
+// Observer receives events emitted by the client.
+//
+// Observe is called synchronously, implementations must not block.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc is an adapter to use ordinary functions as Observer.
+type ObserverFunc func(e Event)
+
+// Observe calls f(e).
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// Observers fans out events to every Observer in the list.
+type Observers []Observer
+
+// Observe passes e to every Observer.
+func (o Observers) Observe(e Event) {
+	for _, observer := range o {
+		observer.Observe(e)
+	}
+}
+
+// Broadcaster passes events to subscribers which come and go, e.g. streams of a management API.
+// Events are dropped for a subscriber which does not keep up, the others are never delayed.
+// Zero value is ready to use, nil Broadcaster drops all events.
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Observe passes e to every subscriber with room for it.
+func (b *Broadcaster) Observe(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving events with room for size of them, and the function to unsubscribe.
+// The channel is not closed on unsubscribe.
+func (b *Broadcaster) Subscribe(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[chan Event]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Stats is a snapshot of the tunnel metrics.
+type Stats struct {
+	BytesRead    int // Bytes read from the TUN device.
+	BytesWritten int // Bytes written to the TUN device.
+
+	ActiveTCP int // TCP connections currently open.
+	PeakTCP   int // The highest number of simultaneous TCP connections.
+	ActiveUDP int // UDP sessions currently open.
+	PeakUDP   int // The highest number of simultaneous UDP sessions.
+
+	// FirstPacket is the time TCP connections take from their first packet seen on the TUN device until
+	// the first data from the server, it includes dialing the server through XRay. Connections with no data
+	// from the server, e.g. failed ones, are not counted, see FlowTable.ObserveFirstPacket.
+	FirstPacket Latency
+	// Quality is the round-trip time, jitter and loss of probes through the tunnel, zero unless they are enabled,
+	// see client.Config.QualityProbeInterval.
+	Quality Quality
+
+	Footprint Footprint // Memory use and its estimates for the current configuration.
+}
+
+// Footprint reports memory of the process and estimates the tunnel share of it, to size deployments
+// on memory constrained hosts like routers. Estimates are in bytes.
+type Footprint struct {
+	Startup    uint64 // Memory obtained from the OS by the Go runtime once connected.
+	Sys        uint64 // Memory obtained from the OS by the Go runtime now.
+	HeapInUse  uint64 // Bytes in in-use heap spans.
+	Goroutines int    // Number of goroutines.
+
+	PerTCPFlow    int // Estimated memory held by every open TCP connection.
+	PerUDPFlow    int // Estimated memory held by every open UDP session.
+	BufferPool    int // Relay buffers taken from the pool by open flows.
+	ShapingQueues int // Packets queued by traffic shaping at most, 0 if shaping is off.
+	// Budget is the estimated steady-state memory with all flows allowed by the connection limits open,
+	// 0 if TCP connections or UDP sessions are not limited.
+	Budget uint64
+}
+
+// StatsSource provides Stats snapshots, it is implemented by client.Client.
+type StatsSource interface {
+	Stats() Stats
+}
+
+// FlowSource provides the flows currently going through the tunnel, it is implemented by client.Client.
+type FlowSource interface {
+	Flows() []FlowInfo
+}
+
+// FlowRecorder receives flows once they are removed from FlowTable, with their final counters,
+// e.g. to export flow records, see FlowTable.SetRecorder.
+//
+// RecordFlow is called synchronously when the flow is closed, implementations must not block.
+type FlowRecorder interface {
+	RecordFlow(f FlowInfo)
+}
@@ -0,0 +1,31 @@
+package observe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcaster(t *testing.T) {
+	var nilB *Broadcaster
+	require.NotPanics(t, func() { nilB.Observe(Event{Type: EventConnected}) })
+
+	var b Broadcaster
+	b.Observe(Event{Type: EventConnected}) // No subscribers.
+
+	ch1, cancel1 := b.Subscribe(1)
+	ch2, cancel2 := b.Subscribe(2)
+	defer cancel2()
+
+	b.Observe(Event{Type: EventConnected})
+	b.Observe(Event{Type: EventDisconnected}) // Dropped for ch1 which is full.
+	require.Equal(t, EventConnected, (<-ch1).Type)
+	require.Empty(t, ch1)
+	require.Equal(t, EventConnected, (<-ch2).Type)
+	require.Equal(t, EventDisconnected, (<-ch2).Type)
+
+	cancel1()
+	b.Observe(Event{Type: EventConnected})
+	require.Empty(t, ch1)
+	require.Len(t, ch2, 1)
+}
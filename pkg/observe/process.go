@@ -0,0 +1,41 @@
+package observe
+
+import (
+	"net/netip"
+)
+
+// Process is the local process owning a flow.
+type Process struct {
+	PID  int
+	Name string
+}
+
+// ResolveProcesses looks up the local processes owning flows not resolved yet.
+// Each flow is looked up once, flows from other hosts (e.g. shared over LAN) stay without a process.
+//
+// Lookup is only supported on Linux, elsewhere it is a no-op.
+func (t *FlowTable) ResolveProcesses() {
+	pending := make(map[Network][]*Flow)
+	t.mu.Lock()
+	for _, f := range t.flows {
+		if !f.procChecked.Load() {
+			pending[f.Network] = append(pending[f.Network], f)
+		}
+	}
+	t.mu.Unlock()
+
+	for network, flows := range pending {
+		srcs := make([]netip.AddrPort, 0, len(flows))
+		for _, f := range flows {
+			srcs = append(srcs, f.Src)
+		}
+
+		found := lookupProcesses(network, srcs)
+		for _, f := range flows {
+			if p, ok := found[f.Src]; ok {
+				f.process.Store(&p)
+			}
+			f.procChecked.Store(true)
+		}
+	}
+}
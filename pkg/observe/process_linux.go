@@ -0,0 +1,128 @@
+package observe
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procNetFiles lists socket tables of the network in /proc/net.
+var procNetFiles = map[Network][]string{
+	TCP: {"/proc/net/tcp", "/proc/net/tcp6"},
+	UDP: {"/proc/net/udp", "/proc/net/udp6"},
+}
+
+// lookupProcesses finds processes owning local sockets bound to srcs.
+// Socket inodes are matched against /proc/net, then owners are found among /proc/<pid>/fd links.
+func lookupProcesses(network Network, srcs []netip.AddrPort) map[netip.AddrPort]Process {
+	sockets := make(map[netip.AddrPort]string)
+	for _, path := range procNetFiles[network] {
+		readSocketTable(path, sockets)
+	}
+
+	// Sockets not bound to an address, e.g. unconnected UDP, are matched by port only.
+	wanted := make(map[string][]netip.AddrPort)
+	for _, src := range srcs {
+		inode, ok := sockets[src]
+		if !ok {
+			inode, ok = sockets[netip.AddrPortFrom(netip.IPv4Unspecified(), src.Port())]
+		}
+		if !ok {
+			inode, ok = sockets[netip.AddrPortFrom(netip.IPv6Unspecified(), src.Port())]
+		}
+		if ok {
+			wanted[inode] = append(wanted[inode], src)
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	found := make(map[netip.AddrPort]Process)
+	pids, _ := filepath.Glob("/proc/[0-9]*")
+	for _, dir := range pids {
+		fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+		if err != nil {
+			continue // Process is gone or belongs to another user.
+		}
+
+		var p *Process
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			addrs, ok := wanted[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]
+			if !ok {
+				continue
+			}
+			if p == nil {
+				p = readProcess(dir)
+			}
+			for _, addr := range addrs {
+				found[addr] = *p
+			}
+		}
+	}
+
+	return found
+}
+
+// readSocketTable adds local addresses of the sockets in /proc/net table at path to inodes.
+func readSocketTable(path string, inodes map[netip.AddrPort]string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Scan() // Skip header.
+	for sc.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 || fields[9] == "0" {
+			continue
+		}
+		if addr, ok := parseProcAddr(fields[1]); ok {
+			inodes[addr] = fields[9]
+		}
+	}
+}
+
+// parseProcAddr parses address like "0100007F:1F90", where IP is hex encoded
+// as 32-bit words in host (little endian) byte order and port is big endian.
+func parseProcAddr(s string) (netip.AddrPort, bool) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	raw, err := hex.DecodeString(ipHex)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return netip.AddrPort{}, false
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(raw[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	ip, _ := netip.AddrFromSlice(raw)
+
+	return netip.AddrPortFrom(ip.Unmap(), uint16(port)), true
+}
+
+// readProcess reads the name of the process from its /proc directory.
+func readProcess(dir string) *Process {
+	pid, _ := strconv.Atoi(filepath.Base(dir))
+	comm, _ := os.ReadFile(filepath.Join(dir, "comm"))
+
+	return &Process{PID: pid, Name: strings.TrimSpace(string(comm))}
+}
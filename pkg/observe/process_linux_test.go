@@ -0,0 +1,43 @@
+package observe
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveProcesses(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	table := NewFlowTable()
+	src := conn.LocalAddr().(*net.TCPAddr).AddrPort()
+	f, _ := table.Reserve(TCP, src, ln.Addr().(*net.TCPAddr).AddrPort(), 0)
+	other, _ := table.Reserve(TCP, netip.MustParseAddrPort("10.1.1.1:1"), src, 0)
+
+	table.ResolveProcesses()
+	require.NotNil(t, f.Info().Process)
+	require.Equal(t, os.Getpid(), f.Info().Process.PID)
+	require.Nil(t, other.Info().Process)
+}
+
+func TestParseProcAddr(t *testing.T) {
+	addr, ok := parseProcAddr("0100007F:1F90")
+	require.True(t, ok)
+	require.Equal(t, "127.0.0.1:8080", addr.String())
+
+	addr, ok = parseProcAddr("00000000000000000000000001000000:0035")
+	require.True(t, ok)
+	require.Equal(t, "[::1]:53", addr.String())
+
+	_, ok = parseProcAddr("bogus")
+	require.False(t, ok)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package observe
+
+import (
+	"net/netip"
+)
+
+// lookupProcesses is not supported on this platform.
+func lookupProcesses(Network, []netip.AddrPort) map[netip.AddrPort]Process {
+	return nil
+}
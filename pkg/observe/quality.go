@@ -0,0 +1,93 @@
+package observe
+
+import (
+	"sync"
+	"time"
+)
+
+// qualityWindowSize is the number of the latest probes QualityWindow summarizes.
+const qualityWindowSize = 100
+
+// Quality summarizes the latest probes of the round trip through the tunnel.
+type Quality struct {
+	Probes int           // Probes sent in total.
+	Last   time.Duration // Round-trip time of the latest answered probe, zero if none were answered.
+	// RTT are quantiles of round-trip times of the latest answered probes, its Count is the total of answered ones.
+	RTT Latency
+	// Jitter is the mean difference of round-trip times of consecutive answered probes among the latest ones.
+	Jitter time.Duration
+	Loss   float64 // Share of the latest probes which were not answered, from 0 to 1.
+}
+
+// probeResult is a probe kept by QualityWindow.
+type probeResult struct {
+	rtt  time.Duration
+	lost bool
+}
+
+// QualityWindow keeps the latest probes of the round trip through the tunnel. It is safe for concurrent use,
+// the zero value is ready to use.
+type QualityWindow struct {
+	mu       sync.Mutex
+	probes   [qualityWindowSize]probeResult
+	count    int
+	answered int
+	last     time.Duration
+}
+
+// Observe adds a probe answered in rtt, replacing the oldest one once the window is full.
+func (w *QualityWindow) Observe(rtt time.Duration) {
+	w.add(probeResult{rtt: rtt})
+}
+
+// Lost adds a probe which was not answered.
+func (w *QualityWindow) Lost() {
+	w.add(probeResult{lost: true})
+}
+
+func (w *QualityWindow) add(p probeResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.probes[w.count%qualityWindowSize] = p
+	w.count++
+	if !p.lost {
+		w.answered++
+		w.last = p.rtt
+	}
+}
+
+// Summary returns RTT, jitter and loss of the probes in the window.
+func (w *QualityWindow) Summary() Quality {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	q := Quality{Probes: w.count, Last: w.last}
+	n := min(w.count, qualityWindowSize)
+	if n == 0 {
+		return q
+	}
+
+	var rtts []time.Duration
+	var lost int
+	var jitter time.Duration
+	for i := w.count - n; i < w.count; i++ { // Oldest first, for the differences of consecutive probes.
+		p := w.probes[i%qualityWindowSize]
+		if p.lost {
+			lost++
+
+			continue
+		}
+		if len(rtts) > 0 {
+			jitter += (p.rtt - rtts[len(rtts)-1]).Abs()
+		}
+		rtts = append(rtts, p.rtt)
+	}
+	if len(rtts) > 1 {
+		q.Jitter = jitter / time.Duration(len(rtts)-1)
+	}
+	q.RTT = summarize(rtts, w.answered)
+	q.Loss = float64(lost) / float64(n)
+
+	return q
+}
@@ -0,0 +1,36 @@
+package observe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQualityWindow(t *testing.T) {
+	var w QualityWindow
+	require.Equal(t, Quality{}, w.Summary())
+
+	w.Observe(10 * time.Millisecond)
+	w.Lost()
+	w.Observe(30 * time.Millisecond)
+	w.Observe(20 * time.Millisecond)
+	require.Equal(t, Quality{
+		Probes: 4,
+		Last:   20 * time.Millisecond,
+		RTT:    Latency{Count: 3, P50: 20 * time.Millisecond, P90: 20 * time.Millisecond, P99: 20 * time.Millisecond, Max: 30 * time.Millisecond},
+		Jitter: 15 * time.Millisecond,
+		Loss:   0.25,
+	}, w.Summary())
+
+	// Old probes leave the window.
+	for range qualityWindowSize {
+		w.Lost()
+	}
+	q := w.Summary()
+	require.Equal(t, 4+qualityWindowSize, q.Probes)
+	require.Equal(t, 20*time.Millisecond, q.Last)
+	require.Equal(t, float64(1), q.Loss)
+	require.Equal(t, 3, q.RTT.Count)
+	require.Zero(t, q.RTT.Max)
+}
@@ -0,0 +1,163 @@
+/*
+Package otlp implements the parts of OpenTelemetry protocol shared by the exporters of traces and metrics:
+the JSON encoding of attributes and resources, and sending requests to a collector over OTLP/HTTP.
+*/
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultServiceName is the service.name resource attribute of exported data if no other is set.
+	DefaultServiceName = "goxray"
+	// ScopeName is the instrumentation scope of exported data.
+	ScopeName = "github.com/goxray/tun"
+
+	// exportTimeout bounds a single request to the collector.
+	exportTimeout = 10 * time.Second
+)
+
+// Client sends requests to an OTLP/HTTP collector.
+type Client struct {
+	endpoint string
+	headers  map[string]string
+	http     *http.Client
+}
+
+// NewClient creates Client posting to endpoint, e.g. "http://localhost:4318". The signal path, e.g. /v1/traces,
+// is added if endpoint has no path. Headers are sent with every request, e.g. the API key of a hosted collector.
+func NewClient(endpoint, path string, headers map[string]string) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint %q must be an http or https URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = path
+	}
+
+	return &Client{endpoint: u.String(), headers: headers, http: &http.Client{Timeout: exportTimeout}}, nil
+}
+
+// Endpoint returns the URL requests are posted to.
+func (c *Client) Endpoint() string {
+	return c.endpoint
+}
+
+// Post sends v encoded as JSON to the collector.
+func (c *Client) Post(ctx context.Context, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+		return fmt.Errorf("collector replied %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+// Resource is the entity producing exported data.
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ServiceResource returns Resource of the service name, DefaultServiceName if name is empty.
+func ServiceResource(name string) Resource {
+	if name == "" {
+		name = DefaultServiceName
+	}
+
+	return Resource{Attributes: []KeyValue{Attribute("service.name", name)}}
+}
+
+// Scope is the instrumentation scope of exported data.
+type Scope struct {
+	Name string `json:"name"`
+}
+
+// KeyValue is an attribute.
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue is the value of an attribute, only one of the fields is set. 64-bit integers are strings in JSON.
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// Attribute encodes v as the value of its OTLP type, values of other types are formatted as strings.
+func Attribute(key string, v any) KeyValue {
+	var value AnyValue
+	switch v := v.(type) {
+	case bool:
+		value.BoolValue = &v
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		i := fmt.Sprint(v)
+		value.IntValue = &i
+	case float32:
+		value = doubleValue(float64(v))
+	case float64:
+		value = doubleValue(v)
+	case time.Duration:
+		s := v.String()
+		value.StringValue = &s
+	case error:
+		s := v.Error()
+		value.StringValue = &s
+	default:
+		s := fmt.Sprint(v)
+		value.StringValue = &s
+	}
+
+	return KeyValue{Key: key, Value: value}
+}
+
+// doubleValue encodes f as OTLP double, or as string if JSON has no encoding of it.
+func doubleValue(f float64) AnyValue {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+
+		return AnyValue{StringValue: &s}
+	}
+
+	return AnyValue{DoubleValue: &f}
+}
+
+// UnixNano returns t as nanoseconds since the epoch, encoded as string like other 64-bit integers.
+func UnixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,59 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttribute(t *testing.T) {
+	encode := func(v any) string {
+		b, err := json.Marshal(Attribute("k", v).Value)
+		require.NoError(t, err)
+
+		return string(b)
+	}
+	require.JSONEq(t, `{"stringValue": "tun"}`, encode("tun"))
+	require.JSONEq(t, `{"boolValue": true}`, encode(true))
+	require.JSONEq(t, `{"intValue": "1080"}`, encode(1080))
+	require.JSONEq(t, `{"doubleValue": 0.5}`, encode(0.5))
+	require.JSONEq(t, `{"stringValue": "NaN"}`, encode(math.NaN()), "JSON has no NaN")
+	require.JSONEq(t, `{"stringValue": "1.5s"}`, encode(1500*time.Millisecond))
+	require.JSONEq(t, `{"stringValue": "failed"}`, encode(errors.New("failed")))
+}
+
+func TestClient(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"http://localhost:4318":                 "http://localhost:4318/v1/traces",
+		"http://localhost:4318/":                "http://localhost:4318/v1/traces",
+		"https://collector.example/otlp/traces": "https://collector.example/otlp/traces",
+	} {
+		c, err := NewClient(endpoint, "/v1/traces", nil)
+		require.NoError(t, err)
+		require.Equal(t, want, c.Endpoint())
+	}
+	_, err := NewClient("localhost:4318", "/v1/traces", nil)
+	require.Error(t, err)
+	_, err = NewClient("", "/v1/traces", nil)
+	require.Error(t, err)
+
+	status := http.StatusOK
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		http.Error(w, "quota exceeded", status)
+	}))
+	defer collector.Close()
+	c, err := NewClient(collector.URL, "/v1/metrics", map[string]string{"X-Api-Key": "secret"})
+	require.NoError(t, err)
+	require.NoError(t, c.Post(context.Background(), ServiceResource("")))
+	status = http.StatusTooManyRequests
+	require.ErrorContains(t, c.Post(context.Background(), ServiceResource("")), "quota exceeded")
+}
@@ -0,0 +1,206 @@
+/*
+Package privsep implements privilege separation for the client.
+
+The process starts a route helper, which keeps root privileges and only changes the routing table
+on request, and then drops its own privileges to an unprivileged user, keeping the open TUN device.
+*/
+package privsep
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/goxray/core/network/route"
+)
+
+// HelperArg is the argument the executable is started with to run as route helper, see StartRouteHelper.
+const HelperArg = "route-helper"
+
+// helperFD is the descriptor of the connection to the parent in the helper process.
+const helperFD = 3
+
+// request is a routing change sent to the helper.
+type request struct {
+	Delete bool       `json:"delete"`
+	Opts   route.Opts `json:"opts"`
+}
+
+// response is the result of a routing change, Error is empty on success.
+type response struct {
+	Error string `json:"error"`
+}
+
+// RouteHelper changes routes through the privileged helper process, it is used as client.Config.RouteTable.
+type RouteHelper struct {
+	mu   sync.Mutex
+	conn io.ReadWriteCloser
+	enc  *json.Encoder
+	dec  *json.Decoder
+	cmd  *exec.Cmd
+}
+
+// StartRouteHelper starts the current executable with HelperArg as route helper.
+// The executable must call ServeRouteHelper when started with it.
+func StartRouteHelper() (*RouteHelper, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("find executable: %w", err)
+	}
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("socketpair: %w", err)
+	}
+	parent, child := os.NewFile(uintptr(fds[0]), "route-helper"), os.NewFile(uintptr(fds[1]), "route-helper-child")
+	defer child.Close()
+
+	cmd := exec.Command(exe, HelperArg)
+	cmd.ExtraFiles = []*os.File{child} // Becomes helperFD in the helper.
+	cmd.Stderr = os.Stderr
+	if err = cmd.Start(); err != nil {
+		_ = parent.Close()
+
+		return nil, fmt.Errorf("start route helper: %w", err)
+	}
+
+	h := newRouteHelper(parent)
+	h.cmd = cmd
+
+	return h, nil
+}
+
+func newRouteHelper(conn io.ReadWriteCloser) *RouteHelper {
+	return &RouteHelper{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(bufio.NewReader(conn))}
+}
+
+// Add adds route through the helper.
+func (h *RouteHelper) Add(opts route.Opts) error {
+	return h.do(request{Opts: opts})
+}
+
+// Delete deletes route through the helper.
+func (h *RouteHelper) Delete(opts route.Opts) error {
+	return h.do(request{Delete: true, Opts: opts})
+}
+
+// Close stops the helper.
+func (h *RouteHelper) Close() error {
+	err := h.conn.Close()
+	if h.cmd != nil {
+		err = errors.Join(err, h.cmd.Wait())
+	}
+
+	return err
+}
+
+func (h *RouteHelper) do(req request) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.enc.Encode(req); err != nil {
+		return fmt.Errorf("send to route helper: %w", err)
+	}
+	var resp response
+	if err := h.dec.Decode(&resp); err != nil {
+		return fmt.Errorf("receive from route helper: %w", err)
+	}
+	if resp.Error != "" {
+		// Errors of route package are only distinguished by message, so it is passed as is.
+		return errors.New(resp.Error)
+	}
+
+	return nil
+}
+
+// ServeRouteHelper runs the route helper started by StartRouteHelper till the parent process exits.
+func ServeRouteHelper() error {
+	conn := os.NewFile(helperFD, "route-helper")
+	if conn == nil {
+		return errors.New("route helper connection is missing")
+	}
+	defer conn.Close()
+
+	return serveRoutes(conn, route.Add, route.Delete)
+}
+
+// serveRoutes applies routing changes received from conn with add and del till conn is closed.
+func serveRoutes(conn io.ReadWriter, add, del func(route.Opts) error) error {
+	dec, enc := json.NewDecoder(bufio.NewReader(conn)), json.NewEncoder(conn)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("receive request: %w", err)
+		}
+
+		normalize(&req.Opts)
+		apply := add
+		if req.Delete {
+			apply = del
+		}
+		var resp response
+		if err := apply(req.Opts); err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(resp); err != nil {
+			return fmt.Errorf("send response: %w", err)
+		}
+	}
+}
+
+// normalize converts IPv4 addresses decoded from JSON in 16-byte form back to 4-byte form.
+func normalize(opts *route.Opts) {
+	if ip4 := opts.Gateway.To4(); ip4 != nil {
+		opts.Gateway = ip4
+	}
+	for _, r := range opts.Routes {
+		if ip4 := r.IP.To4(); ip4 != nil && len(r.Mask) == net.IPv4len {
+			r.IP = ip4
+		}
+	}
+}
+
+// DropPrivileges switches the process to the user with name or numeric ID, and to its primary group.
+// Supplementary groups are cleared.
+func DropPrivileges(name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return fmt.Errorf("lookup user %q: %w", name, err)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("parse uid: %w", err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("parse gid: %w", err)
+	}
+
+	// Group must be changed first, it can not be changed after the user.
+	if err = syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err = syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err = syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+
+	return nil
+}
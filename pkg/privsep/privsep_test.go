@@ -0,0 +1,36 @@
+package privsep
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteHelper(t *testing.T) {
+	parent, child := net.Pipe()
+	var added, deleted []route.Opts
+	served := make(chan error, 1)
+	go func() {
+		served <- serveRoutes(child, func(o route.Opts) error {
+			added = append(added, o)
+			return nil
+		}, func(o route.Opts) error {
+			deleted = append(deleted, o)
+			return errors.New("failed to update route: file exists")
+		})
+	}()
+
+	h := newRouteHelper(parent)
+	opts := route.Opts{Gateway: net.IPv4(192, 168, 1, 1).To4(), Routes: []*route.Addr{route.MustParseAddr("1.2.3.4/32")}}
+
+	require.NoError(t, h.Add(opts))
+	require.EqualError(t, h.Delete(route.Opts{IfName: "utun5", Routes: opts.Routes}), "failed to update route: file exists")
+
+	require.NoError(t, h.Close())
+	require.NoError(t, <-served)
+	require.Equal(t, []route.Opts{opts}, added)
+	require.Equal(t, "utun5", deleted[0].IfName)
+}
@@ -0,0 +1,169 @@
+/*
+Package rotate rotates the client among several profiles (connection links), once per session
+or on a schedule, preferring the profile which carried the least traffic so far.
+*/
+package rotate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// Profile is a connection link to rotate to.
+type Profile struct {
+	Name string // Shown in logs instead of the link, which contains credentials.
+	Link string
+}
+
+// key identifies the profile in the usage file without storing credentials.
+func (p Profile) key() string {
+	sum := sha256.Sum256([]byte(p.Link))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// Switcher moves the connection to another link, it is implemented by client.Client.
+type Switcher interface {
+	SwitchLink(link string) error
+}
+
+// Rotator picks profiles and balances the traffic among them.
+type Rotator struct {
+	profiles  []Profile
+	usagePath string
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	usage   map[string]int // Bytes carried by every profile.
+	current int            // Index of the profile in use, -1 before the first Next.
+	counted int            // Traffic total of the StatsSource already accounted.
+}
+
+// New creates Rotator of profiles. Usage is kept in the JSON file usagePath, so that it is balanced
+// across sessions, empty path keeps it in memory only.
+func New(profiles []Profile, usagePath string, logger *slog.Logger) (*Rotator, error) {
+	if len(profiles) == 0 {
+		return nil, errors.New("no profiles to rotate")
+	}
+
+	r := &Rotator{profiles: profiles, usagePath: usagePath, logger: logger, usage: make(map[string]int), current: -1}
+	if usagePath == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(usagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read usage: %w", err)
+	}
+	if err = json.Unmarshal(data, &r.usage); err != nil {
+		logger.Warn("usage of profiles is corrupted, starting over", "err", err)
+		r.usage = make(map[string]int)
+	}
+
+	return r, nil
+}
+
+// Next selects the least used profile other than the current one, if there is a choice.
+func (r *Rotator) Next() Profile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next := -1
+	for i, p := range r.profiles {
+		if i == r.current && len(r.profiles) > 1 {
+			continue
+		}
+		if next == -1 || r.usage[p.key()] < r.usage[r.profiles[next].key()] {
+			next = i
+		}
+	}
+	r.current = next
+
+	return r.profiles[next]
+}
+
+// Run switches sw to the next profile every interval till ctx is done, accounting traffic of src
+// to the profile in use. With zero interval the profile is kept for the whole session and only its usage
+// is accounted. Next must be called before to select the initial profile.
+// Usage is saved after every switch and when Run returns.
+func (r *Rotator) Run(ctx context.Context, sw Switcher, src observe.StatsSource, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	defer r.save()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.account(src.Stats())
+
+			return
+		case <-tick:
+		}
+
+		r.account(src.Stats())
+		r.mu.Lock()
+		prev := r.current
+		r.mu.Unlock()
+
+		p := r.Next()
+		if err := sw.SwitchLink(p.Link); err != nil {
+			r.logger.Warn("rotating profile failed", "profile", p.Name, "err", err)
+			r.mu.Lock()
+			r.current = prev
+			r.mu.Unlock()
+
+			continue
+		}
+		r.logger.Info("rotated profile", "profile", p.Name)
+		r.save()
+	}
+}
+
+// account adds traffic since the previous call to the current profile.
+func (r *Rotator) account(stats observe.Stats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := stats.BytesRead + stats.BytesWritten
+	if r.current >= 0 && total >= r.counted {
+		r.usage[r.profiles[r.current].key()] += total - r.counted
+	}
+	r.counted = total
+}
+
+// save writes usage to the usage file.
+func (r *Rotator) save() {
+	if r.usagePath == "" {
+		return
+	}
+
+	r.mu.Lock()
+	data, err := json.Marshal(r.usage)
+	r.mu.Unlock()
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(r.usagePath), 0o700); err == nil {
+			err = os.WriteFile(r.usagePath, data, 0o600)
+		}
+	}
+	if err != nil {
+		r.logger.Warn("saving usage of profiles failed", "err", err)
+	}
+}
@@ -0,0 +1,80 @@
+package rotate
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// fakeClient carries traffic[i] bytes after i-th switch.
+type fakeClient struct {
+	mu       sync.Mutex
+	links    []string
+	stats    observe.Stats
+	traffic  []int
+	switched chan struct{}
+}
+
+func (f *fakeClient) SwitchLink(link string) error {
+	f.mu.Lock()
+	if len(f.links) < len(f.traffic) {
+		f.stats.BytesWritten += f.traffic[len(f.links)]
+	}
+	f.links = append(f.links, link)
+	f.mu.Unlock()
+	select {
+	case f.switched <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (f *fakeClient) Stats() observe.Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.stats
+}
+
+func TestRotator(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	usage := filepath.Join(t.TempDir(), "usage.json")
+	profiles := []Profile{{Name: "a", Link: "vless://a"}, {Name: "b", Link: "vless://b"}, {Name: "c", Link: "vless://c"}}
+
+	r, err := New(profiles, usage, logger)
+	require.NoError(t, err)
+	require.Equal(t, "a", r.Next().Name)
+
+	cl := &fakeClient{switched: make(chan struct{}, 1), stats: observe.Stats{BytesRead: 100}, traffic: []int{50}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx, cl, cl, 10*time.Millisecond)
+		close(done)
+	}()
+
+	<-cl.switched // a carried 100 bytes, b is the least used.
+	<-cl.switched // b carried 50 bytes, c is the least used.
+	cancel()
+	<-done
+	require.Equal(t, []string{"vless://b", "vless://c"}, cl.links[:2])
+
+	// The next session starts with the least used profile.
+	r, err = New(profiles, usage, logger)
+	require.NoError(t, err)
+	require.Equal(t, "c", r.Next().Name)
+}
+
+func TestNew_NoProfiles(t *testing.T) {
+	_, err := New(nil, "", slog.Default())
+	require.Error(t, err)
+}
@@ -0,0 +1,10 @@
+package sharelink
+
+import (
+	"context"
+	"os/exec"
+)
+
+func clipboardCommands(ctx context.Context) ([]*exec.Cmd, error) {
+	return []*exec.Cmd{exec.CommandContext(ctx, "pbpaste")}, nil
+}
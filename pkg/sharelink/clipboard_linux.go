@@ -0,0 +1,15 @@
+package sharelink
+
+import (
+	"context"
+	"os/exec"
+)
+
+// clipboardCommands returns commands printing the clipboard of Wayland and X11 sessions.
+func clipboardCommands(ctx context.Context) ([]*exec.Cmd, error) {
+	return []*exec.Cmd{
+		exec.CommandContext(ctx, "wl-paste", "--no-newline"),
+		exec.CommandContext(ctx, "xclip", "-selection", "clipboard", "-out"),
+		exec.CommandContext(ctx, "xsel", "--clipboard", "--output"),
+	}, nil
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !linux
+
+package sharelink
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+func clipboardCommands(context.Context) ([]*exec.Cmd, error) {
+	return nil, errors.New("clipboard is supported on Linux and macOS only")
+}
+
+func asSudoUser(*exec.Cmd) error {
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Package sharelink imports connection links the way they are shared with mobile apps: from a QR code image,
+e.g. a screenshot of the code shown by a provider, and from the clipboard.
+
+QR codes are decoded with zbarimg of the zbar tools. The clipboard is read with pbpaste on macOS and with
+wl-paste, xclip or xsel on Linux. When the process runs as root with sudo, the clipboard of the user who ran
+sudo is read. Links are never included in errors.
+*/
+package sharelink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// zbarNoCode is the exit code of zbarimg when the image has no code in it.
+const zbarNoCode = 4
+
+// ErrNoLink is returned if the QR code or the clipboard holds no connection link.
+var ErrNoLink = errors.New("no connection link found")
+
+// FromQR returns the connection link encoded in a QR code of the image at path, e.g. PNG or JPEG.
+func FromQR(ctx context.Context, path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("qr code image: %w", err)
+	}
+
+	out, err := run(exec.CommandContext(ctx, "zbarimg", "--quiet", "--raw", "-Sdisable", "-Sqrcode.enable", path))
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) && exitErr.ExitCode() == zbarNoCode {
+		return "", fmt.Errorf("%w: no QR code in %s", ErrNoLink, path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("decode qr code: %w", err)
+	}
+
+	return pick(out, "QR code")
+}
+
+// FromClipboard returns the connection link copied to the clipboard. The clipboard tools are tried in order,
+// the first one which reads the clipboard is used.
+func FromClipboard(ctx context.Context) (string, error) {
+	cmds, err := clipboardCommands(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var errs []error
+	for _, cmd := range cmds {
+		if err = asSudoUser(cmd); err != nil {
+			return "", err
+		}
+		out, err := run(cmd)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		return pick(out, "clipboard")
+	}
+
+	return "", fmt.Errorf("read clipboard: %w", errors.Join(errs...))
+}
+
+// pick returns the first line of text which looks like a link, scheme://... The text itself is left out
+// of the error, it may be a secret copied by accident.
+func pick(text, where string) (string, error) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if u, err := url.Parse(line); err == nil && u.Scheme != "" && strings.Contains(line, "://") {
+			return line, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w in the %s", ErrNoLink, where)
+}
+
+// run runs cmd and returns its stdout, the error includes stderr of the command.
+func run(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+
+		return "", fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+
+	return stdout.String(), nil
+}
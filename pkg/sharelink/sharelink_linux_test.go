@@ -0,0 +1,63 @@
+package sharelink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTool puts an executable name running script in front of PATH.
+func fakeTool(t *testing.T, name, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SUDO_UID", "")
+}
+
+func TestFromQR(t *testing.T) {
+	ctx := context.Background()
+	img := filepath.Join(t.TempDir(), "screenshot.png")
+	require.NoError(t, os.WriteFile(img, []byte("png"), 0o600))
+
+	fakeTool(t, "zbarimg", `echo "vless://uuid@example.com:443?security=tls#name"`)
+	link, err := FromQR(ctx, img)
+	require.NoError(t, err)
+	require.Equal(t, "vless://uuid@example.com:443?security=tls#name", link)
+
+	fakeTool(t, "zbarimg", "exit 4")
+	_, err = FromQR(ctx, img)
+	require.ErrorIs(t, err, ErrNoLink)
+
+	_, err = FromQR(ctx, filepath.Join(t.TempDir(), "missing.png"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFromClipboard(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("PATH", "")
+
+	// wl-paste fails outside of Wayland sessions, xclip is tried next.
+	fakeTool(t, "wl-paste", `echo "Failed to connect to a Wayland server" >&2; exit 1`)
+	fakeTool(t, "xclip", `printf '\n  trojan://secret@example.com:443#name  \n'`)
+	link, err := FromClipboard(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "trojan://secret@example.com:443#name", link)
+
+	fakeTool(t, "xclip", `echo "password123"`)
+	_, err = FromClipboard(ctx)
+	require.ErrorIs(t, err, ErrNoLink)
+	require.NotContains(t, err.Error(), "password123")
+}
+
+func TestPick(t *testing.T) {
+	link, err := pick("text\nss://YWVz@1.2.3.4:8388#x\nvless://a@b:1", "clipboard")
+	require.NoError(t, err)
+	require.Equal(t, "ss://YWVz@1.2.3.4:8388#x", link)
+
+	_, err = pick("", "clipboard")
+	require.ErrorIs(t, err, ErrNoLink)
+}
@@ -0,0 +1,37 @@
+//go:build darwin || linux
+
+package sharelink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/goxray/tun/internal/sudo"
+)
+
+// asSudoUser makes cmd run as the user who started the process with sudo, in their graphical session.
+// Commands of other processes are left as they are.
+func asSudoUser(cmd *exec.Cmd) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	uid, err := sudo.AsUser(cmd)
+	if errors.Is(err, sudo.ErrNoSudo) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Wayland clients connect to the socket in the runtime directory of the user, sudo drops both variables.
+	runtimeDir := fmt.Sprintf("/run/user/%d", uid)
+	if _, err = os.Stat(runtimeDir); os.Getenv("XDG_RUNTIME_DIR") == "" && err == nil {
+		cmd.Env = append(cmd.Env, "XDG_RUNTIME_DIR="+runtimeDir)
+		if os.Getenv("WAYLAND_DISPLAY") == "" {
+			cmd.Env = append(cmd.Env, "WAYLAND_DISPLAY=wayland-0")
+		}
+	}
+
+	return nil
+}
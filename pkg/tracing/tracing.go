@@ -0,0 +1,305 @@
+/*
+Package tracing records spans of the client operations, e.g. the steps of connecting and the dials of flows,
+and exports them to an OpenTelemetry collector over OTLP/HTTP with JSON encoding, so that slow connects
+can be diagnosed with real timings.
+
+Spans are started with Tracer.Start, or Span.Start for nested steps, and queued once ended. Tracer.Run
+sends them to the collector in batches. Methods of nil Tracer and nil Span do nothing, so the code
+being traced does not check whether tracing is enabled.
+*/
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/goxray/tun/pkg/otlp"
+)
+
+const (
+	// flushInterval is how often ended spans are sent.
+	flushInterval = 5 * time.Second
+	// maxPending bounds the spans waiting to be sent, newer ones are dropped when it is reached.
+	maxPending = 4096
+	// maxBatch is the number of spans sent in one request.
+	maxBatch = 512
+	// tracesPath is the OTLP/HTTP path of traces, it is added to endpoints without a path.
+	tracesPath = "/v1/traces"
+)
+
+// Options configure Tracer.
+type Options struct {
+	// Endpoint is the OTLP/HTTP endpoint of the collector, e.g. "http://localhost:4318".
+	// The traces path /v1/traces is added if Endpoint has no path.
+	Endpoint string
+	// Headers are sent with every request, e.g. the API key of a hosted collector.
+	Headers map[string]string
+	// ServiceName is the service.name resource attribute (default: otlp.DefaultServiceName).
+	ServiceName string
+}
+
+// Tracer queues ended spans and sends them to the collector with Run.
+type Tracer struct {
+	opts   Options
+	client *otlp.Client
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending []SpanData
+	dropped int // Spans dropped since it was last logged.
+}
+
+// NewTracer creates Tracer exporting spans to opts.Endpoint, failures to send are logged with logger.
+func NewTracer(opts Options, logger *slog.Logger) (*Tracer, error) {
+	client, err := otlp.NewClient(opts.Endpoint, tracesPath, opts.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tracer{opts: opts, client: client, logger: logger}, nil
+}
+
+// Start starts a root span of a new trace. Key-value pairs kv are set as attributes of the span.
+func (t *Tracer) Start(name string, kv ...any) *Span {
+	if t == nil {
+		return nil
+	}
+
+	s := &Span{tracer: t, data: SpanData{Name: name, Start: time.Now()}}
+	_, _ = rand.Read(s.data.TraceID[:])
+	_, _ = rand.Read(s.data.SpanID[:])
+	s.Set(kv...)
+
+	return s
+}
+
+// Run sends ended spans to the collector till ctx is done. Spans queued by then are sent before it returns.
+func (t *Tracer) Run(ctx context.Context) {
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.send(t.takePending())
+
+			return
+		case <-flush.C:
+			t.send(t.takePending())
+		}
+	}
+}
+
+// queue adds ended span s to the ones to send.
+func (t *Tracer) queue(s SpanData) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.pending) >= maxPending {
+		t.dropped++
+
+		return
+	}
+	t.pending = append(t.pending, s)
+}
+
+// takePending returns queued spans and logs the ones dropped.
+func (t *Tracer) takePending() []SpanData {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.dropped > 0 {
+		t.logger.Warn("trace spans dropped, the collector is not keeping up", "spans", t.dropped)
+		t.dropped = 0
+	}
+	spans := t.pending
+	t.pending = nil
+
+	return spans
+}
+
+// send sends spans in batches of maxBatch.
+func (t *Tracer) send(spans []SpanData) {
+	for len(spans) > 0 {
+		n := min(len(spans), maxBatch)
+		if err := t.client.Post(context.Background(), t.request(spans[:n])); err != nil {
+			t.logger.Debug("exporting trace spans failed", "endpoint", t.client.Endpoint(), "spans", n, "err", err)
+		}
+		spans = spans[n:]
+	}
+}
+
+// request returns OTLP JSON encoding of spans.
+func (t *Tracer) request(spans []SpanData) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.otlp())
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlp.ServiceResource(t.opts.ServiceName),
+		ScopeSpans: []otlpScopeSpans{{Scope: otlp.Scope{Name: otlp.ScopeName}, Spans: encoded}},
+	}}}
+}
+
+// SpanData is a span as it is exported.
+type SpanData struct {
+	Name     string
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte // Zero for root spans.
+	Start    time.Time
+	End      time.Time
+	Attrs    []Attr
+	Err      string // Error the operation failed with, empty if it succeeded.
+}
+
+// Attr is an attribute of a span.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Span is an operation being timed, e.g. a step of connecting.
+// It is safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Start starts a span nested in s.
+func (s *Span) Start(name string, kv ...any) *Span {
+	if s == nil {
+		return nil
+	}
+
+	child := &Span{tracer: s.tracer, data: SpanData{Name: name, Start: time.Now(), TraceID: s.data.TraceID,
+		ParentID: s.data.SpanID}}
+	_, _ = rand.Read(child.data.SpanID[:])
+	child.Set(kv...)
+
+	return child
+}
+
+// Set sets attributes of the span from key-value pairs kv, attributes set before are replaced.
+func (s *Span) Set(kv ...any) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		attr := Attr{Key: key, Value: kv[i+1]}
+		if j := s.attr(key); j >= 0 {
+			s.data.Attrs[j] = attr
+		} else {
+			s.data.Attrs = append(s.data.Attrs, attr)
+		}
+	}
+}
+
+// attr returns the index of attribute key, or -1. s.mu must be held.
+func (s *Span) attr(key string) int {
+	for i, a := range s.data.Attrs {
+		if a.Key == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// End ends the span and queues it for export, err is the error the operation failed with, or nil.
+// Only the first call has effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Err = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.queue(data)
+}
+
+// OTLP JSON encoding of ExportTraceServiceRequest, IDs are hex strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlp.Resource    `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlp.Scope `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Span kind and status code of OTLP.
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+func (s SpanData) otlp() otlpSpan {
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.SpanID[:]),
+		Name:              s.Name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: otlp.UnixNano(s.Start),
+		EndTimeUnixNano:   otlp.UnixNano(s.End),
+	}
+	if s.ParentID != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+	}
+	for _, a := range s.Attrs {
+		span.Attributes = append(span.Attributes, otlp.Attribute(a.Key, a.Value))
+	}
+	if s.Err != "" {
+		span.Status = &otlpStatus{Code: statusCodeError, Message: s.Err}
+	}
+
+	return span
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/otlp"
+)
+
+func TestNewTracer(t *testing.T) {
+	_, err := NewTracer(Options{Endpoint: "localhost:4318"}, slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *Tracer
+	span := tr.Start("connect")
+	require.Nil(t, span)
+	require.NotPanics(t, func() {
+		child := span.Start("xray.start")
+		child.Set("server", "example.com")
+		child.End(errors.New("failed"))
+		span.End(nil)
+	})
+}
+
+func TestTracer(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		var req otlpRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests <- req
+	}))
+	defer collector.Close()
+
+	tr, err := NewTracer(Options{Endpoint: collector.URL, Headers: map[string]string{"X-Api-Key": "secret"}},
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+
+	root := tr.Start("connect", "engine", "tun")
+	child := root.Start("xray.start", "port", 1080)
+	child.Set("port", 1081, "ready", true, "took", time.Second)
+	child.End(errors.New("inbound not listening"))
+	child.End(nil) // Ignored.
+	root.End(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tr.Run(ctx) // Queued spans are sent before it returns.
+
+	var req otlpRequest
+	select {
+	case req = <-requests:
+	default:
+		require.FailNow(t, "no spans exported")
+	}
+	require.Len(t, req.ResourceSpans, 1)
+	require.Equal(t, "service.name", req.ResourceSpans[0].Resource.Attributes[0].Key)
+	require.Equal(t, otlp.DefaultServiceName, *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+
+	gotChild, gotRoot := spans[0], spans[1]
+	require.Equal(t, "xray.start", gotChild.Name)
+	require.Equal(t, "connect", gotRoot.Name)
+	require.Len(t, gotRoot.TraceID, 32)
+	require.Len(t, gotRoot.SpanID, 16)
+	require.Empty(t, gotRoot.ParentSpanID)
+	require.Nil(t, gotRoot.Status)
+	require.Equal(t, gotRoot.TraceID, gotChild.TraceID)
+	require.Equal(t, gotRoot.SpanID, gotChild.ParentSpanID)
+	require.NotEqual(t, gotRoot.SpanID, gotChild.SpanID)
+	require.Equal(t, &otlpStatus{Code: statusCodeError, Message: "inbound not listening"}, gotChild.Status)
+
+	require.Len(t, gotChild.Attributes, 3, "attributes set again are replaced")
+	require.Equal(t, "port", gotChild.Attributes[0].Key)
+	require.Equal(t, "1081", *gotChild.Attributes[0].Value.IntValue)
+	require.True(t, *gotChild.Attributes[1].Value.BoolValue)
+	require.Equal(t, "1s", *gotChild.Attributes[2].Value.StringValue)
+	require.LessOrEqual(t, gotChild.StartTimeUnixNano, gotChild.EndTimeUnixNano)
+}
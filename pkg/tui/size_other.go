@@ -0,0 +1,8 @@
+//go:build !darwin && !linux
+
+package tui
+
+// terminalSize returns the default terminal size, it is not queried on this platform.
+func terminalSize() (width, height int) {
+	return 80, 24
+}
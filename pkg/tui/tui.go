@@ -0,0 +1,208 @@
+/*
+Package tui implements a live terminal view of a running client: its state, server, traffic rates,
+active connections and recent log records, refreshed over the control socket.
+
+It draws with plain ANSI escape sequences, any terminal emulator with cursor movement shows it.
+*/
+package tui
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/control"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+// DefaultInterval is how often the view is refreshed if Options.Interval is not set.
+const DefaultInterval = time.Second
+
+// ANSI escape sequences.
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l" // Switch to the alternate screen and hide the cursor.
+	exitAltScreen  = "\x1b[?25h\x1b[?1049l"
+	home           = "\x1b[H"
+	clearLine      = "\x1b[K"
+	clearBelow     = "\x1b[J"
+)
+
+// Options configure Run.
+type Options struct {
+	Socket   string        // Path of the control socket of the client.
+	Interval time.Duration // How often the view is refreshed (default: 1s).
+}
+
+// snapshot is the client state shown by a frame.
+type snapshot struct {
+	status client.Status
+	stats  observe.Stats
+	flows  []observe.FlowInfo
+	logs   []observe.LogRecord
+	err    error // Why the client could not be queried, the rest is empty then.
+}
+
+// Run draws the view to w, a terminal, till ctx is done. The client not running is shown in the view,
+// it is queried again on the next refresh.
+func Run(ctx context.Context, w io.Writer, opts Options) error {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if _, err := io.WriteString(w, enterAltScreen); err != nil {
+		return err
+	}
+	defer func() { _, _ = io.WriteString(w, exitAltScreen) }()
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		width, height := terminalSize()
+		frame := render(fetch(ctx, opts.Socket, height), width, height)
+		footer := fmt.Sprintf("refreshed every %s from %s, Ctrl+C to quit", opts.Interval, opts.Socket)
+		var b strings.Builder
+		b.WriteString(home)
+		for _, line := range append(frame, "", footer) {
+			b.WriteString(truncate(line, width))
+			b.WriteString(clearLine + "\r\n")
+		}
+		b.WriteString(clearBelow)
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch queries the client over the control socket, logs as many as may fit the screen.
+func fetch(ctx context.Context, socket string, height int) snapshot {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var s snapshot
+	if s.status, s.err = control.GetStatus(ctx, socket); s.err != nil {
+		return s
+	}
+	if s.stats, s.err = control.GetStats(ctx, socket); s.err != nil {
+		return s
+	}
+	if s.flows, s.err = control.GetFlows(ctx, socket); s.err != nil {
+		return s
+	}
+	s.logs, s.err = control.GetLogs(ctx, socket, height)
+
+	return s
+}
+
+// render returns the lines of the frame showing s for a screen of height lines, the footer excluded.
+func render(s snapshot, width, height int) []string {
+	if s.err != nil {
+		return []string{"goxray-tun  UNREACHABLE", "", "client is not running or the control socket is not accessible:", s.err.Error()}
+	}
+
+	st := s.status
+	lines := []string{fmt.Sprintf("goxray-tun  %s", strings.ToUpper(string(st.State)))}
+	if st.State == client.StateConnected {
+		lines[0] += fmt.Sprintf("  %s %s  up %s", st.Protocol, st.Server,
+			time.Duration(st.Uptime*float64(time.Second)).Round(time.Second))
+		lines = append(lines, fmt.Sprintf("tun %s %s  gateway %s", st.TUNName, st.TUNAddress, st.Gateway))
+	} else {
+		lines = append(lines, fmt.Sprintf("gateway %s", st.Gateway))
+	}
+	if len(st.DNS) > 0 {
+		lines[len(lines)-1] += "  dns " + strings.Join(st.DNS, ", ")
+	}
+	// Packets read from the TUN device are sent by the apps, written ones are received.
+	lines = append(lines, fmt.Sprintf("download %s/s  upload %s/s  total %s down, %s up",
+		size(int64(st.WriteRate)), size(int64(st.ReadRate)), size(int64(st.BytesWritten)), size(int64(st.BytesRead))))
+	if q := s.stats.Quality; q.Probes > 0 {
+		lines = append(lines, fmt.Sprintf("rtt %s  p90 %s  jitter %s  loss %.0f%%", q.Last.Round(time.Millisecond),
+			q.RTT.P90.Round(time.Millisecond), q.Jitter.Round(time.Millisecond), q.Loss*100))
+	}
+	lines = append(lines, fmt.Sprintf("connections %d tcp, %d udp  peak %d tcp, %d udp",
+		s.stats.ActiveTCP, s.stats.ActiveUDP, s.stats.PeakTCP, s.stats.PeakUDP))
+
+	// The rest of the screen, but the blank line and the footer, is shared by connections and logs.
+	room := max(height-len(lines)-2, 0)
+	logRoom := min(len(s.logs)+2, room/2)
+	flowRoom := room - logRoom
+
+	if flowRoom > 2 {
+		lines = append(lines, "", "CONNECTIONS")
+		lines = append(lines, flowLines(s.flows, flowRoom-2)...)
+	}
+	if logRoom > 2 {
+		lines = append(lines, "", "RECENT LOG")
+		logs := s.logs[max(len(s.logs)-(logRoom-2), 0):]
+		for _, r := range logs {
+			lines = append(lines, r.String())
+		}
+	}
+
+	return lines
+}
+
+// flowLines returns up to n lines of flows, the busiest first.
+func flowLines(flows []observe.FlowInfo, n int) []string {
+	flows = slices.Clone(flows)
+	slices.SortFunc(flows, func(a, b observe.FlowInfo) int {
+		return cmp.Or(cmp.Compare(b.Sent+b.Received, a.Sent+a.Received), cmp.Compare(a.ID, b.ID))
+	})
+
+	var lines []string
+	for i, f := range flows {
+		if i == n-1 && len(flows) > n {
+			lines = append(lines, fmt.Sprintf("... %d more", len(flows)-i))
+
+			break
+		}
+		dst := f.Dst.String()
+		if f.Host != "" {
+			dst = fmt.Sprintf("%s:%d", f.Host, f.Dst.Port())
+		}
+		line := fmt.Sprintf("%-3s %-40s %9s down %9s up", f.Network, dst, size(f.Received), size(f.Sent))
+		if f.Process != nil {
+			line += "  " + f.Process.Name
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// size formats n bytes with a binary unit.
+func size(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// truncate cuts line to width runes.
+func truncate(line string, width int) string {
+	if width <= 0 {
+		return line
+	}
+	runes := []rune(line)
+	if len(runes) <= width {
+		return line
+	}
+
+	return string(runes[:width])
+}
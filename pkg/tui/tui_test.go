@@ -0,0 +1,59 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/observe"
+)
+
+func TestRender(t *testing.T) {
+	s := snapshot{
+		status: client.Status{
+			State: client.StateConnected, Server: "example.com:443", Protocol: "vless", Uptime: 3723,
+			TUNName: "tun0", TUNAddress: "192.18.0.1", Gateway: "192.168.1.1", DNS: []string{"1.1.1.1"},
+			ReadRate: 2048, WriteRate: 3 << 20, BytesRead: 1 << 20, BytesWritten: 5 << 30,
+		},
+		stats: observe.Stats{ActiveTCP: 12, PeakTCP: 40, ActiveUDP: 1, PeakUDP: 3},
+	}
+	for i := range 20 {
+		s.flows = append(s.flows, observe.FlowInfo{
+			ID: uint64(i), Network: observe.TCP, Dst: netip.MustParseAddrPort("140.82.121.4:443"), Received: int64(i) << 10,
+		})
+	}
+	s.flows[19].Host, s.flows[19].Process = "github.com", &observe.Process{Name: "git"}
+	for i := range 30 {
+		s.logs = append(s.logs, observe.LogRecord{Time: time.Unix(0, 0), Level: "INFO", Message: fmt.Sprintf("line %d", i)})
+	}
+
+	lines := render(s, 100, 24)
+	require.Len(t, lines, 22, "the frame fills the screen but the footer")
+	require.Equal(t, "goxray-tun  CONNECTED  vless example.com:443  up 1h2m3s", lines[0])
+	require.Equal(t, "tun tun0 192.18.0.1  gateway 192.168.1.1  dns 1.1.1.1", lines[1])
+	require.Equal(t, "download 3.0 MiB/s  upload 2.0 KiB/s  total 5.0 GiB down, 1.0 MiB up", lines[2])
+	require.Equal(t, "connections 12 tcp, 1 udp  peak 40 tcp, 3 udp", lines[3])
+
+	text := strings.Join(lines, "\n")
+	require.Contains(t, text, "tcp github.com:443")
+	require.Contains(t, text, "19.0 KiB down       0 B up  git")
+	require.Contains(t, text, "more")
+	require.Contains(t, text, "line 29", "the latest log records are shown")
+	require.NotContains(t, text, "line 0")
+
+	lines = render(snapshot{err: errors.New("connection refused")}, 80, 24)
+	require.Equal(t, "goxray-tun  UNREACHABLE", lines[0])
+	require.Equal(t, "connection refused", lines[len(lines)-1])
+}
+
+func TestSize(t *testing.T) {
+	require.Equal(t, "1023 B", size(1023))
+	require.Equal(t, "1.5 KiB", size(1536))
+	require.Equal(t, "2.0 GiB", size(2<<30))
+}
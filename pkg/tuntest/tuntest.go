@@ -0,0 +1,136 @@
+/*
+Package tuntest provides an in-memory TUN device for testing code built on the client without root
+privileges or real devices.
+
+Device is passed to Client.ConnectDevice in place of a TUN device. Packets injected with Inject are
+read by the client as if applications sent them, and packets the client writes back are captured for
+Capture:
+
+	dev := tuntest.NewDevice()
+	err := cl.ConnectDevice(dev, link)
+	...
+	err = dev.Inject(ctx, syn)       // IPv4 or IPv6 packet.
+	reply, err := dev.Capture(ctx)   // E.g. SYN-ACK of the TCP/IP stack of the client.
+*/
+package tuntest
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// queueSize is the number of packets queued in each direction.
+const queueSize = 512
+
+// Device is an in-memory TUN device, an io.ReadWriteCloser carrying one IP packet per Read and Write.
+// It is safe for concurrent use.
+type Device struct {
+	in      chan []byte // Injected, read by the client.
+	out     chan []byte // Written by the client, captured.
+	dropped atomic.Int64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ io.ReadWriteCloser = (*Device)(nil)
+
+// NewDevice creates Device.
+func NewDevice() *Device {
+	return &Device{
+		in:     make(chan []byte, queueSize),
+		out:    make(chan []byte, queueSize),
+		closed: make(chan struct{}),
+	}
+}
+
+// Read reads the next injected packet, it blocks till one is injected or the device is closed.
+// Packets longer than p are truncated, like reads of a TUN device.
+func (d *Device) Read(p []byte) (int, error) {
+	if d.isClosed() {
+		return 0, os.ErrClosed
+	}
+
+	select {
+	case packet := <-d.in:
+		return copy(p, packet), nil
+	case <-d.closed:
+		return 0, os.ErrClosed
+	}
+}
+
+// Write captures a copy of packet p. Like a TUN device it never blocks, packets are dropped
+// while the capture queue is full, see Dropped.
+func (d *Device) Write(p []byte) (int, error) {
+	if d.isClosed() {
+		return 0, os.ErrClosed
+	}
+
+	select {
+	case d.out <- append([]byte(nil), p...):
+	default:
+		d.dropped.Add(1)
+	}
+
+	return len(p), nil
+}
+
+// Close closes the device, blocked Read, Inject and Capture return os.ErrClosed. Packets still queued are discarded.
+func (d *Device) Close() error {
+	d.closeOnce.Do(func() { close(d.closed) })
+
+	return nil
+}
+
+// isClosed reports whether Close was called. It is checked first, as select picks a ready queue
+// and a closed device at random.
+func (d *Device) isClosed() bool {
+	select {
+	case <-d.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Inject queues packet to be read by the client, it blocks while the queue is full.
+// It returns os.ErrClosed if the device is closed, or the error of ctx once it is done.
+func (d *Device) Inject(ctx context.Context, packet []byte) error {
+	if d.isClosed() {
+		return os.ErrClosed
+	}
+
+	select {
+	case d.in <- append([]byte(nil), packet...):
+		return nil
+	case <-d.closed:
+		return os.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Capture returns the next packet written by the client, it blocks till one is written.
+// It returns os.ErrClosed if the device is closed, or the error of ctx once it is done.
+func (d *Device) Capture(ctx context.Context) ([]byte, error) {
+	if d.isClosed() {
+		return nil, os.ErrClosed
+	}
+
+	select {
+	case packet := <-d.out:
+		return packet, nil
+	case <-d.closed:
+		return nil, os.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Dropped returns the number of packets written by the client and dropped because nobody captured them.
+func (d *Device) Dropped() int {
+	return int(d.dropped.Load())
+}
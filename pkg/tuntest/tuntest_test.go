@@ -0,0 +1,59 @@
+package tuntest
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevice(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dev := NewDevice()
+
+	packet := []byte{0x45, 1, 2, 3}
+	require.NoError(t, dev.Inject(ctx, packet))
+	packet[1] = 9 // Injected packets are copied.
+	buf := make([]byte, 64)
+	n, err := dev.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x45, 1, 2, 3}, buf[:n])
+
+	require.NoError(t, dev.Inject(ctx, []byte{0x45, 1, 2, 3}))
+	n, err = dev.Read(buf[:2])
+	require.NoError(t, err)
+	require.Equal(t, 2, n, "long packets are truncated")
+
+	n, err = dev.Write([]byte{0x60, 4, 5})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	captured, err := dev.Capture(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x60, 4, 5}, captured)
+
+	for range queueSize + 2 {
+		_, err = dev.Write([]byte{0x45})
+		require.NoError(t, err, "writes never block")
+	}
+	require.Equal(t, 2, dev.Dropped())
+
+	canceled, cancelNow := context.WithCancel(ctx)
+	cancelNow()
+	_, err = NewDevice().Capture(canceled)
+	require.ErrorIs(t, err, context.Canceled)
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := dev.Read(buf)
+		read <- err
+	}()
+	require.NoError(t, dev.Close())
+	require.NoError(t, dev.Close(), "closing twice does nothing")
+	require.ErrorIs(t, <-read, os.ErrClosed)
+	_, err = dev.Write([]byte{0x45})
+	require.ErrorIs(t, err, os.ErrClosed)
+	require.ErrorIs(t, dev.Inject(ctx, []byte{0x45}), os.ErrClosed)
+}